@@ -1,18 +1,202 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // DatabaseAdapter defines the interface for database-specific operations
 type DatabaseAdapter interface {
 	Connect(connectionString string) (*sql.DB, error)
-	GetTableList(db *sql.DB) ([]string, error)
-	GetTableSchema(db *sql.DB, tableName string) (TableSchema, error)
-	CompareTableDataByChecksum(sourceDB, targetDB *sql.DB, tableName string, schema TableSchema) (bool, error)
-	CompareRowCounts(sourceDB, targetDB *sql.DB, tableName string) (int, int, error)
+	GetTableList(ctx context.Context, db *sql.DB) ([]string, error)
+	GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error)
+	GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error)
+	GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error)
+	GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error)
+	GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error)
+	GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error)
+	TableChecksum(ctx context.Context, db *sql.DB, tableName string, schema TableSchema) (string, sql.NullString, error)
+	CountRows(ctx context.Context, db *sql.DB, tableName string) (int, error)
+	// EstimateRows returns the number of rows the database's statistics
+	// estimate a table has, without counting them, or -1 if it has none.
+	// Row filters don't apply to it.
+	EstimateRows(ctx context.Context, db *sql.DB, tableName string) (int, error)
+	// EstimateDistinct returns the number of distinct values of a column the
+	// database's statistics estimate, or -1 if they have none for it
+	EstimateDistinct(ctx context.Context, db *sql.DB, tableName, column string) (int64, error)
+	// StreamRows returns the rows of a key range ordered by the key, only
+	// the first limit of them if limit is positive. Without key columns it
+	// returns all rows, in no particular order.
+	StreamRows(ctx context.Context, db *sql.DB, tableName string, columns []string, orderBy []string, chunk Chunk, limit int) (*sql.Rows, error)
+	SampleRows(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, percent float64) (*sql.Rows, error)
+	GetChunkBoundary(ctx context.Context, db *sql.DB, tableName string, keyColumns []string, after []interface{}, chunkSize int) ([]interface{}, error)
+	// ChunkChecksums returns the checksums of consecutive chunks, computed
+	// in one query grouped by chunk where the database can hash rows, so
+	// only the checksums cross the network
+	ChunkChecksums(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, chunks []Chunk) ([]ChunkChecksum, error)
 	GetConnectStringFromURL(url string) string
+	SetPassword(connectionString, password string) (string, error)
+	IsTransientError(err error) bool
+	QuoteIdentifier(name string) string
+	QuoteTable(tableName string) string
+	QuoteLiteral(value interface{}) string
+
+	// SetRowFilter restricts the rows the data queries read from a table to
+	// those matching a condition, e.g. to leave out soft-deleted rows.
+	// RowSource is what they read from: the table, or its matching rows.
+	SetRowFilter(tableName, condition string)
+	RowSource(tableName string) string
+
+	// ValueDigest returns an expression of a column's values that replaces
+	// those longer than threshold bytes with their size and SHA-256 digest,
+	// or "" if the column's type holds no large values. SetColumnExpression
+	// makes the row reads of a table, StreamRows and SampleRows, read a
+	// column with such an expression.
+	ValueDigest(column ColumnSchema, threshold int) string
+	SetColumnExpression(tableName, column, expression string)
+
+	// SetBinaryKeyOrder makes the data queries of a table order and bound a
+	// text key column by its bytes, the order compareKeyValues compares keys
+	// in, rather than by its collation. KeyOrder returns the expression the
+	// queries order a key column by, the quoted column if it has none.
+	SetBinaryKeyOrder(tableName string, column ColumnSchema)
+	KeyOrder(tableName, column string) string
+
+	// Capabilities reports what the database supports, so comparisons
+	// with another engine leave out what only one side has
+	Capabilities() Capabilities
+
+	// BeginSnapshot makes the reads through db see the database as of one
+	// point in time, on up to connections connections at once, until the
+	// returned function ends the snapshot. lock holds off writes while the
+	// snapshot begins, where it can't be shared between connections.
+	BeginSnapshot(ctx context.Context, db *sql.DB, connections int, lock bool) (func() error, error)
+
+	// ReplicationPosition returns how far the database is in its
+	// replication stream: a MySQL GTID set or binlog file and position, or
+	// a PostgreSQL LSN. ReplicaCaughtUp reports whether a replica applied
+	// the changes up to a position of its primary. ReplicaSource returns
+	// the host a replica replicates from, or "" if db isn't a replica.
+	ReplicationPosition(ctx context.Context, db *sql.DB) (string, error)
+	ReplicaCaughtUp(ctx context.Context, db *sql.DB, position string) (bool, error)
+	ReplicaSource(ctx context.Context, db *sql.DB) (string, error)
+
+	// ChangeFeed sends the rows of the tables in schemas that change in the
+	// database from now on, as its change log has them, until ctx is
+	// cancelled. dsn is db's connection string with its password.
+	ChangeFeed(ctx context.Context, db *sql.DB, dsn string, schemas map[string]TableSchema) (<-chan RowChange, error)
+
+	// ServerLoad returns how busy the database server is, see monitorLoad
+	ServerLoad(ctx context.Context, db *sql.DB) (ServerLoad, error)
+}
+
+// ServerLoad is how busy a database server is
+type ServerLoad struct {
+	// Statements running on the server at the moment, including the
+	// comparison's own
+	ActiveSessions int
+
+	// How far behind its primary the server is, if it's a replica
+	Replica    bool
+	ReplicaLag time.Duration
+}
+
+// Capabilities are the features of a database engine the comparison
+// depends on
+type Capabilities struct {
+	Engine string
+
+	// ServerChecksums is set if the database hashes rows itself, rather
+	// than the adapter reading them to hash them
+	ServerChecksums bool
+
+	// Snapshots is set if BeginSnapshot is supported, Replication if
+	// ReplicationPosition, ReplicaCaughtUp and ReplicaSource are
+	Snapshots   bool
+	Replication bool
+	ChangeFeeds bool // ChangeFeed is supported
+	ServerLoad  bool // ServerLoad is supported
+
+	Schemas    bool // schemas within a database, like PostgreSQL's
+	Sequences  bool
+	Routines   bool
+	Types      bool // user-defined types
+	Privileges bool
+
+	// MaxIdentifierLength is the longest table or column name, 0 if
+	// there's no limit
+	MaxIdentifierLength int
+}
+
+// columnExpressions are the expressions given to SetColumnExpression, by
+// table and column
+type columnExpressions map[string]map[string]string
+
+func (e *columnExpressions) set(tableName, column, expression string) {
+	if *e == nil {
+		*e = make(columnExpressions)
+	}
+	if (*e)[tableName] == nil {
+		(*e)[tableName] = make(map[string]string)
+	}
+	(*e)[tableName][column] = expression
+}
+
+// selectList returns the quoted columns of a table for a SELECT, those with
+// an expression read with it under their name
+func (e columnExpressions) selectList(tableName string, columns []string, quote func(string) string) string {
+	list := quoteList(columns, quote)
+	expressions := e[tableName]
+	if len(expressions) == 0 {
+		return list
+	}
+	selected := make([]string, len(columns))
+	for i, col := range columns {
+		selected[i] = quote(col)
+		if expression, ok := expressions[col]; ok {
+			selected[i] = expression + " AS " + quote(col)
+		}
+	}
+	return strings.Join(selected, ", ")
+}
+
+// keyOrder returns the expression of a table's key column in e, the
+// quoted column if it has none
+func (e columnExpressions) keyOrder(tableName, column string, quote func(string) string) string {
+	if expression, ok := e[tableName][column]; ok {
+		return expression
+	}
+	return quote(column)
+}
+
+// keyOrders returns a function quoting the key columns of a table as the
+// adapter orders them, see KeyOrder, for ORDER BY and key range conditions
+func keyOrders(adapter DatabaseAdapter, tableName string) func(string) string {
+	return func(column string) string {
+		return adapter.KeyOrder(tableName, column)
+	}
+}
+
+// sampleBuckets is the number of buckets SampleRows hashes primary keys
+// into, a sample of p percent is the rows in the first p*sampleBuckets/100
+const sampleBuckets = 1000000
+
+// sampleThreshold returns the number of buckets in a sample of percent
+func sampleThreshold(percent float64) int64 {
+	return int64(percent * sampleBuckets / 100)
+}
+
+// connectReader opens a pool of a database the comparison reads, which
+// reads MySQL's TIMESTAMP columns in UTC. Pools running statements the
+// user wrote, like the query subcommand's, are opened with Connect.
+func connectReader(adapter DatabaseAdapter, dsn string) (*sql.DB, error) {
+	if mysqlAdapter, ok := adapter.(*MySQLAdapter); ok {
+		return mysqlAdapter.connectUTC(dsn)
+	}
+	return adapter.Connect(dsn)
 }
 
 // GetAdapter returns the appropriate adapter for the given database type
@@ -28,3 +212,48 @@ func GetAdapter(dbType string) (DatabaseAdapter, error) {
 		return nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
 }
+
+// cloneAdapter returns a copy of a configured adapter without the row
+// filters and column expressions set by its comparison, for another
+// comparison reading the same database at the same time
+func cloneAdapter(adapter DatabaseAdapter) DatabaseAdapter {
+	switch a := adapter.(type) {
+	case *MySQLAdapter:
+		clone := *a
+		clone.filters, clone.expressions, clone.keyOrders = nil, nil, nil
+		return &clone
+	case *PostgreSQLAdapter:
+		clone := *a
+		clone.filters, clone.expressions, clone.keyOrders = nil, nil, nil
+		return &clone
+	case *SQLiteAdapter:
+		return &SQLiteAdapter{}
+	case *DumpAdapter:
+		clone := *a
+		clone.SQLiteAdapter = SQLiteAdapter{}
+		return &clone
+	}
+	return adapter
+}
+
+// rowFilters are the conditions given to SetRowFilter, by table
+type rowFilters map[string]string
+
+func (f *rowFilters) set(tableName, condition string) {
+	if *f == nil {
+		*f = make(rowFilters)
+	}
+	(*f)[tableName] = condition
+}
+
+// source returns the quoted table, or a derived table of its rows matching
+// the table's filter. Whole-table checksums don't read from it: tables that
+// are equal have equal filtered rows, so they still tell when the rows
+// need no comparison.
+func (f rowFilters) source(quotedTable, tableName string) string {
+	condition, ok := f[tableName]
+	if !ok {
+		return quotedTable
+	}
+	return fmt.Sprintf("(SELECT * FROM %s WHERE %s) filtered_rows", quotedTable, condition)
+}
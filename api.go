@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// apiTokenEnv is the environment variable holding the token the server
+// requires of HTTP requests
+const apiTokenEnv = "MUDROCK_API_TOKEN"
+
+// routes returns the server's HTTP API. Runs started through it are
+// cancelled with ctx. The dashboard pages are served alongside it.
+//
+//	POST /comparisons               start a job now, body {"job": "name"}
+//	GET  /comparisons?job=name      list runs, newest first
+//	GET  /comparisons/{id}          a run with its summary
+//	GET  /comparisons/{id}/diffs    a run's differences, ?table=name for one table's
+func (s *server) routes(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /comparisons", func(w http.ResponseWriter, r *http.Request) {
+		s.handleStart(ctx, w, r)
+	})
+	mux.HandleFunc("GET /comparisons", s.handleList)
+	mux.HandleFunc("GET /comparisons/{id}", s.handleGet)
+	mux.HandleFunc("GET /comparisons/{id}/diffs", s.handleDiffs)
+
+	// The dashboard
+	mux.HandleFunc("GET /{$}", s.handleIndexPage)
+	mux.HandleFunc("GET /jobs/{name}", s.handleJobPage)
+	mux.HandleFunc("GET /runs/{id}", s.handleRunPage)
+	var handler http.Handler = mux
+	if s.token != "" {
+		handler = requireToken(s.token, handler)
+	}
+	return sameOrigin(s.hosts, handler)
+}
+
+// maxRequestBody is the largest request body the API reads
+const maxRequestBody = 64 << 10
+
+// allowedHosts returns the Host headers requests to a server listening on
+// listen may have: its address as given and as listened on, and the host
+// of the dashboard's URL. A server listening on every interface is reached
+// by any name, nil allows all.
+func allowedHosts(listen string, addr net.Addr, baseURL string) []string {
+	host, _, err := net.SplitHostPort(listen)
+	if err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+		return nil
+	}
+	hosts := []string{listen, addr.String()}
+	if _, port, err := net.SplitHostPort(addr.String()); err == nil && loopbackAddress(listen) {
+		hosts = append(hosts, net.JoinHostPort("localhost", port))
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		hosts = append(hosts, u.Host)
+	}
+	return hosts
+}
+
+// sameOrigin refuses requests to other hosts than the server's, which a
+// web page reaches through DNS rebinding, and requests that web pages of
+// other origins send, e.g. a form posting to the API
+func sameOrigin(hosts []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hosts != nil && !slices.Contains(hosts, r.Host) {
+			writeError(w, http.StatusForbidden, "unknown host '"+r.Host+"'")
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, "requests from '"+origin+"' aren't allowed")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireToken only passes on requests with the token, as a bearer token
+// or, for browsers, as the password of basic authentication
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, given, ok = r.BasicAuth()
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mudrockdbcompare"`)
+			writeError(w, http.StatusUnauthorized, "missing or wrong token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loopbackAddress reports whether a listen address only accepts
+// connections from the same host
+func loopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *server) handleStart(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "the request body must be application/json")
+		return
+	}
+	var request struct{ Job string }
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	for _, job := range s.jobs {
+		if job.Name != request.Job {
+			continue
+		}
+		run, started := s.start(ctx, job)
+		if !started {
+			writeError(w, http.StatusConflict, "job '"+job.Name+"' is already running")
+			return
+		}
+		w.Header().Set("Location", "/comparisons/"+run.ID)
+		writeJSON(w, http.StatusAccepted, run)
+		return
+	}
+	writeError(w, http.StatusNotFound, "unknown job '"+request.Job+"'")
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	runs := s.store.list(r.URL.Query().Get("job"))
+	// The list is an overview, the summaries are fetched per run
+	for i := range runs {
+		runs[i].Summary = nil
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *server) handleGet(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.store.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown comparison '"+r.PathValue("id")+"'")
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (s *server) handleDiffs(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.store.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown comparison '"+r.PathValue("id")+"'")
+		return
+	}
+	if run.Summary == nil {
+		writeError(w, http.StatusConflict, "comparison '"+run.ID+"' has no results, it is "+run.Status)
+		return
+	}
+
+	table := r.URL.Query().Get("table")
+	diffs := []Difference{}
+	for _, diff := range run.Summary.Differences() {
+		if table == "" || diff.Table == table {
+			diffs = append(diffs, diff)
+		}
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct{ Error string }{message})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// errApplyAborted is returned by a confirm function to stop applying and
+// roll back everything applied so far
+var errApplyAborted = errors.New("aborted")
+
+// Apply runs the reconciliation statements against the target database in
+// one transaction. MySQL commits each DDL statement on its own, so there the
+// DDL runs first outside of it, each statement recorded as committed once it
+// ran, and the transaction holds the rows' statements only. confirm is
+// asked before each table's step and can skip it, or abort with an error to
+// roll back. Destructive steps are skipped unless allowDestructive is set,
+// and with a table's deletes skipped so are its inserts, which could
+// collide with the rows left. Each statement, skipped step and the outcome
+// are recorded to audit, failing to record one rolls back. It returns the
+// number of statements run, or with an error the number committed anyway,
+// MySQL's DDL.
+func (r *Reconciliation) Apply(ctx context.Context, db *sql.DB, allowDestructive bool, confirm func(ReconcileStep) (bool, error), audit *auditLog) (applied int, err error) {
+	if err := audit.record(AuditStarted, "", "", 0, nil); err != nil {
+		return 0, fmt.Errorf("audit log: %w", err)
+	}
+	autocommitDDL := r.adapter.Capabilities().Engine == "mysql"
+
+	// The transaction begins with the first statement it holds, after
+	// MySQL's DDL.
+	var tx *sql.Tx
+	committed := 0
+	defer func() {
+		if err != nil {
+			if tx != nil {
+				tx.Rollback()
+			}
+			applied, err = committed, errors.Join(err, audit.record(AuditRolledBack, "", "", 0, err))
+		}
+	}()
+
+	skippedDeletes := make(map[string]bool)
+	for _, step := range r.Steps() {
+		if step.Destructive && !allowDestructive {
+			slog.Warn("Skipping drops and deletes, pass --allow-destructive to apply them", "table", step.Table, "statements", len(step.Statements))
+			if err := audit.record(AuditSkipped, step.Table, "", 0, errors.New("drops and deletes need --allow-destructive")); err != nil {
+				return 0, fmt.Errorf("audit log: %w", err)
+			}
+			skippedDeletes[step.Table] = true
+			continue
+		}
+		if step.Inserts > 0 && skippedDeletes[step.Table] {
+			slog.Warn("Skipping inserts, the table's deletes weren't applied", "table", step.Table, "statements", step.Inserts)
+			if err := audit.record(AuditSkipped, step.Table, "", 0, errors.New("inserts need the table's deletes")); err != nil {
+				return 0, fmt.Errorf("audit log: %w", err)
+			}
+			step.Statements, step.Inserts = step.Statements[:len(step.Statements)-step.Inserts], 0
+			if len(step.Statements) == 0 {
+				continue
+			}
+		}
+
+		ok, err := confirm(step)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			if err := audit.record(AuditSkipped, step.Table, "", 0, errors.New("declined")); err != nil {
+				return 0, fmt.Errorf("audit log: %w", err)
+			}
+			skippedDeletes[step.Table] = step.Destructive || skippedDeletes[step.Table]
+			continue
+		}
+
+		autocommit := autocommitDDL && step.Schema
+		if !autocommit && tx == nil {
+			if tx, err = db.BeginTx(ctx, nil); err != nil {
+				return 0, err
+			}
+		}
+		for _, stmt := range step.Statements {
+			var result sql.Result
+			if autocommit {
+				result, err = db.ExecContext(ctx, stmt)
+			} else {
+				result, err = tx.ExecContext(ctx, stmt)
+			}
+			var rows int64
+			if err == nil {
+				rows, _ = result.RowsAffected()
+			}
+			if auditErr := audit.record(AuditStatement, step.Table, stmt, rows, err); auditErr != nil {
+				return 0, errors.Join(err, fmt.Errorf("audit log: %w", auditErr))
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", stmt, err)
+			}
+			if autocommit {
+				committed++
+				if err := audit.record(AuditCommitted, step.Table, stmt, 0, nil); err != nil {
+					return 0, fmt.Errorf("audit log: %w", err)
+				}
+			}
+		}
+		applied += len(step.Statements)
+	}
+
+	if tx == nil {
+		return applied, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if auditErr := audit.record(AuditCommitted, "", "", int64(applied-committed), nil); auditErr != nil {
+		slog.Warn("Failed to record the commit in the audit log", "error", auditErr)
+	}
+	return applied, nil
+}
+
+// maxPromptStatements caps how many of a step's statements are shown when
+// asking for confirmation
+const maxPromptStatements = 20
+
+// stepPrompter asks on the terminal whether to apply each step
+type stepPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	all bool // the user answered "all"
+}
+
+func newStepPrompter() *stepPrompter {
+	return &stepPrompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+}
+
+func (p *stepPrompter) confirm(step ReconcileStep) (bool, error) {
+	if p.all {
+		return true, nil
+	}
+
+	fmt.Fprintf(p.out, "\n-- %s\n", step.Table)
+	for i, stmt := range step.Statements {
+		if i == maxPromptStatements {
+			fmt.Fprintf(p.out, "-- ... and %d more\n", len(step.Statements)-i)
+			break
+		}
+		fmt.Fprintln(p.out, stmt)
+	}
+
+	for {
+		fmt.Fprintf(p.out, "Apply %d statements to '%s'? [y]es, [n]o, [a]ll, [q]uit: ", len(step.Statements), step.Table)
+		line, err := p.in.ReadString('\n')
+		if err == io.EOF {
+			return false, errApplyAborted
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		case "a", "all":
+			p.all = true
+			return true, nil
+		case "q", "quit":
+			return false, errApplyAborted
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/user"
+	"time"
+)
+
+// defaultAuditLog is the file --apply records the changes it makes to
+const defaultAuditLog = "mudrockdbcompare-audit.jsonl"
+
+// Audit log actions
+const (
+	AuditStarted    = "started"     // applying began
+	AuditStatement  = "statement"   // a statement ran on the target, or failed
+	AuditSkipped    = "skipped"     // a table's statements weren't applied, Error says why
+	AuditCommitted  = "committed"   // the statements that ran were committed, or with Statement set MySQL committed that DDL on its own
+	AuditRolledBack = "rolled back" // the statements that ran in the transaction were rolled back, Error says why
+)
+
+// AuditEntry is a line of the audit log: something --apply did to a
+// target. The entries of one apply share a Run. Success is false for a
+// statement that failed, a table that wasn't applied and a rollback.
+type AuditEntry struct {
+	Time      time.Time
+	Run       string
+	User      string // the user running mudrockdbcompare
+	Host      string // the machine it ran on
+	Target    string // the database changed
+	Action    string
+	Table     string `json:",omitempty"`
+	Statement string `json:",omitempty"`
+	Rows      int64  `json:",omitempty"` // rows the statement changed
+	Success   bool
+	Error     string `json:",omitempty"`
+}
+
+// auditLog appends what --apply does to a file of JSON lines, each written
+// through before the next change is made, so that the file has every
+// change even if mudrockdbcompare is killed. A nil auditLog records
+// nothing.
+type auditLog struct {
+	f     *os.File
+	entry AuditEntry // the fields every entry of the run has
+}
+
+// openAuditLog opens the audit log of changes to target, creating it if it
+// doesn't exist
+func openAuditLog(path, target string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	entry := AuditEntry{Run: hex.EncodeToString(id), Target: target, User: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+	entry.Host, _ = os.Hostname()
+	return &auditLog{f: f, entry: entry}, nil
+}
+
+// record appends an entry of action to the log, for applying to stop when
+// it fails
+func (a *auditLog) record(action, table, statement string, rows int64, err error) error {
+	if a == nil {
+		return nil
+	}
+	entry := a.entry
+	entry.Time = time.Now().UTC()
+	entry.Action, entry.Table, entry.Statement, entry.Rows = action, table, statement, rows
+	entry.Success = err == nil
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
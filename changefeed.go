@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RowChange is a row of a table changed in the source, by its primary key
+// values as the change log has them, in TableSchema.PrimaryKeys order. A
+// failing change feed sends its error as the last change.
+type RowChange struct {
+	Table string
+	Key   []string
+	Err   error
+}
+
+// pendingRow is a changed row waiting to be verified on the target
+type pendingRow struct {
+	key     []string
+	changed time.Time // when it last changed
+}
+
+// VerifyChanges reads the rows changed in the source from its change feed
+// as they're written, and verifies they're the same on the target,
+// reporting the rows that still differ lag after their last change. It
+// runs until ctx is cancelled or the feed fails. dsn is the source's
+// connection string with its password, for feeds reading the change log
+// over their own connection.
+func (c *Comparison) VerifyChanges(ctx context.Context, dsn string, lag time.Duration) error {
+	capabilities := c.Adapter.Capabilities()
+	if !capabilities.ChangeFeeds {
+		return fmt.Errorf("change feeds aren't supported on %s", capabilities.Engine)
+	}
+	summary, err := c.Introspect(ctx)
+	if err != nil {
+		return err
+	}
+	c.endSnapshots()
+	schemas := make(map[string]TableSchema)
+	for _, tableName := range summary.CommonTables {
+		if len(summary.SourceSchemas[tableName].PrimaryKeys) > 0 {
+			schemas[tableName] = summary.SourceSchemas[tableName]
+		}
+	}
+
+	feed, err := c.Adapter.ChangeFeed(ctx, c.SourceDB, dsn, schemas)
+	if err != nil {
+		return fmt.Errorf("failed to start the change feed: %w", err)
+	}
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Verifying the rows changed in %d tables as they're written...", len(schemas))})
+
+	pending := make(map[string]map[string]*pendingRow)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-feed:
+			if !ok {
+				return nil
+			}
+			if change.Err != nil {
+				return fmt.Errorf("change feed: %w", change.Err)
+			}
+			if pending[change.Table] == nil {
+				pending[change.Table] = make(map[string]*pendingRow)
+			}
+			pending[change.Table][strings.Join(change.Key, "\x00")] = &pendingRow{key: change.Key, changed: time.Now()}
+		case <-ticker.C:
+			for tableName, rows := range pending {
+				if err := c.verifyChangedRows(ctx, summary, tableName, rows, lag); err != nil {
+					c.emit(Event{Type: EventWarning, Table: tableName, Message: "Couldn't verify the changed rows of " + tableName, Err: err})
+				}
+				if len(rows) == 0 {
+					delete(pending, tableName)
+				}
+			}
+		}
+	}
+}
+
+// verifyChangedRows compares changed rows of a table on both sides, up to
+// maxRowDifferences at a time so every differing row is kept. Rows that are
+// the same, or that still differ lag after they changed, which are
+// reported, are removed from rows.
+func (c *Comparison) verifyChangedRows(ctx context.Context, summary ComparisonSummary, tableName string, rows map[string]*pendingRow, lag time.Duration) error {
+	sourceSchema, targetSchema := summary.SourceSchemas[tableName], summary.TargetSchemas[tableName]
+	columns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		return err
+	}
+	keyIndexes := make([]int, len(sourceSchema.PrimaryKeys))
+	for i, pk := range sourceSchema.PrimaryKeys {
+		keyIndexes[i] = indexOf(columns, pk)
+	}
+	values := valueColumns(columns, sourceSchema, targetSchema, c.Options)
+
+	ids := make([]string, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), maxRowDifferences)]
+		ids = ids[len(batch):]
+		keys := make([][]string, len(batch))
+		for i, id := range batch {
+			keys[i] = rows[id].key
+		}
+
+		source, err := readKeyedRows(ctx, c.Adapter, c.SourceDB, tableName, columns, sourceSchema.PrimaryKeys, keys)
+		if err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+		target, err := readKeyedRows(ctx, c.targetAdapter(), c.TargetDB, tableName, columns, sourceSchema.PrimaryKeys, keys)
+		if err != nil {
+			source.close()
+			return fmt.Errorf("target: %w", err)
+		}
+		result := RowDiffResult{Table: tableName, PrimaryKey: sourceSchema.PrimaryKeys}
+		err = mergeRows(source, target, columns, keyIndexes, values, &result, nil)
+		source.close()
+		target.close()
+		if err != nil {
+			return err
+		}
+
+		// The differing rows are reported once they had lag to replicate,
+		// the others are verified again. Keys whose text differs from the
+		// change log's, like timestamps, can't be told apart, those rows
+		// are reported once every row of the batch had lag to replicate.
+		differing := make(map[string]bool)
+		diverged := RowDiffResult{Table: tableName, PrimaryKey: sourceSchema.PrimaryKeys}
+		report := func(diff RowDifference) {
+			switch diff.Kind {
+			case RowInserted:
+				diverged.Inserted++
+			case RowDeleted:
+				diverged.Deleted++
+			case RowChanged:
+				diverged.Changed++
+			}
+			diverged.addRow(diff)
+		}
+		var unmatched []RowDifference
+		for _, diff := range result.Rows {
+			id := strings.Join(diff.PrimaryKey, "\x00")
+			row, ok := rows[id]
+			if !ok {
+				unmatched = append(unmatched, diff)
+				continue
+			}
+			differing[id] = true
+			if time.Since(row.changed) >= lag {
+				report(diff)
+				delete(rows, id)
+			}
+		}
+		recent := false
+		for _, id := range batch {
+			if row, ok := rows[id]; ok && !differing[id] && time.Since(row.changed) < lag {
+				recent = true
+			}
+		}
+		if len(unmatched) == 0 || !recent {
+			for _, diff := range unmatched {
+				report(diff)
+			}
+			for _, id := range batch {
+				if !differing[id] {
+					delete(rows, id)
+				}
+			}
+		}
+		if diverged.HasDifferences() {
+			c.emit(Event{Type: EventDifferenceFound, Table: tableName, Rows: &diverged})
+		}
+	}
+	return nil
+}
+
+// readKeyedRows reads the rows of a table with the given primary keys,
+// ordered by key
+func readKeyedRows(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName string, columns, keyColumns []string, keys [][]string) (*rowCursor, error) {
+	quote := adapter.QuoteIdentifier
+	tuples := make([]string, len(keys))
+	for i, key := range keys {
+		literals := make([]string, len(key))
+		for j, value := range key {
+			literals[j] = adapter.QuoteLiteral(value)
+		}
+		tuples[i] = strings.Join(literals, ", ")
+		if len(keyColumns) > 1 {
+			tuples[i] = "(" + tuples[i] + ")"
+		}
+	}
+	keyList := quoteList(keyColumns, quote)
+	if len(keyColumns) > 1 {
+		keyList = "(" + keyList + ")"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s) ORDER BY %s",
+		quoteList(columns, quote), adapter.RowSource(tableName), keyList, strings.Join(tuples, ", "), quoteList(keyColumns, keyOrders(adapter, tableName)))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &rowCursor{rows: rows, values: make([]interface{}, len(columns))}, nil
+}
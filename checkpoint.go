@@ -0,0 +1,471 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tableCheckpoint is the result of a table whose comparison finished
+type tableCheckpoint struct {
+	RowCounts  *struct{ Source, Target int } `json:",omitempty"`
+	Estimated  bool                          `json:",omitempty"` // the row counts are estimates
+	Rows       *RowDiffResult                `json:",omitempty"`
+	Chunks     *ChunkResult                  `json:",omitempty"`
+	Stats      []Difference                  `json:",omitempty"`
+	Suppressed []SuppressedDifference        `json:",omitempty"`
+}
+
+// tableProgress is the part of a table's comparison finished before it was
+// interrupted, so a resumed comparison continues inside the table: the
+// table's chunks and those whose checksums were compared, then the key
+// ranges compared row by row and their differences
+type tableProgress struct {
+	cp *checkpoint
+
+	Chunks      chunkBounds  `json:",omitempty"` // nil until the table was split
+	Checksummed map[int]bool `json:",omitempty"` // position of a chunk compared -> whether it differs
+	Ranges      chunkBounds  `json:",omitempty"` // nil until they were chosen
+	Compared    map[int]bool `json:",omitempty"` // positions of the ranges compared
+	Rows        RowDiffResult
+
+	err error // first error writing the checkpoint file
+}
+
+// checkpoint records the tables a comparison has finished in a file, so an
+// interrupted comparison can be resumed without comparing them again, and
+// the chunks finished of the tables it was comparing, to resume inside them
+type checkpoint struct {
+	path string // empty to only keep them in memory
+	mu   sync.Mutex
+
+	Source   string // SHA-256 of the source's connection string without its password
+	Target   string
+	Options  string // the comparison's options as JSON, resuming needs the same
+	Tables   map[string]tableCheckpoint
+	Progress map[string]*tableProgress `json:",omitempty"`
+}
+
+// openCheckpoint starts a checkpoint file for a comparison. With resume, the
+// tables finished by a previous run of the same comparison are read from
+// it; without, or if it doesn't exist, it starts empty.
+func openCheckpoint(path string, c *Comparison, resume bool) (*checkpoint, error) {
+	// Timeouts, retries, page sizes, parallelism, throttling, memory limits
+	// and table sizes don't change the results, a resumed run may use other
+	// ones
+	comparable := c.Options
+	comparable.QueryTimeout, comparable.TableTimeout, comparable.Retry = 0, 0, RetryPolicy{}
+	comparable.PageSize, comparable.Parallel = 0, 0
+	comparable.MaxQPS, comparable.SleepBetweenChunks, comparable.MaxActiveSessions, comparable.MaxReplicaLag = 0, 0, 0, 0
+	comparable.MaxMemory, comparable.TableSizes = 0, false
+	options, err := json.Marshal(comparable)
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{path: path, Source: connectionHash(c.SourceConnStr), Target: connectionHash(c.TargetConnStr), Options: string(options),
+		Tables: map[string]tableCheckpoint{}, Progress: map[string]*tableProgress{}}
+	if !resume {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var previous checkpoint
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if previous.Source != cp.Source || previous.Target != cp.Target || previous.Options != cp.Options {
+		return nil, fmt.Errorf("%s is the checkpoint of a comparison of other databases or with other options", path)
+	}
+	if previous.Tables != nil {
+		cp.Tables = previous.Tables
+	}
+	for tableName, progress := range previous.Progress {
+		progress.cp = cp
+		cp.Progress[tableName] = progress
+	}
+	return cp, nil
+}
+
+// connectionHash identifies the database of a connection string without
+// keeping its credentials
+func connectionHash(connStr string) string {
+	sum := sha256.Sum256([]byte(redactConnStr(connStr)))
+	return hex.EncodeToString(sum[:])
+}
+
+// restore adds the results of a table finished by a previous run to the
+// summary, and reports whether there was one
+func (cp *checkpoint) restore(summary *ComparisonSummary, tableName string) bool {
+	if cp == nil {
+		return false
+	}
+	table, ok := cp.Tables[tableName]
+	if !ok {
+		return false
+	}
+	if table.RowCounts != nil {
+		summary.DifferentRowCounts[tableName] = *table.RowCounts
+		if table.Estimated {
+			if summary.EstimatedRowCounts == nil {
+				summary.EstimatedRowCounts = make(map[string]bool)
+			}
+			summary.EstimatedRowCounts[tableName] = true
+		}
+	}
+	if table.Rows != nil {
+		summary.RowDifferences[tableName] = *table.Rows
+	}
+	if table.Chunks != nil {
+		summary.ChunkDifferences[tableName] = *table.Chunks
+	}
+	if len(table.Stats) > 0 {
+		summary.StatDifferences[tableName] = table.Stats
+	}
+	if table.RowCounts != nil || table.Rows != nil || table.Chunks != nil || len(table.Stats) > 0 {
+		summary.addDifferentTable(tableName)
+	}
+	summary.Suppressed = append(summary.Suppressed, table.Suppressed...)
+	return true
+}
+
+// record saves the results of a finished table, with the differences
+// suppressed while comparing it, to the checkpoint file
+func (cp *checkpoint) record(summary *ComparisonSummary, tableName string, suppressed []SuppressedDifference) error {
+	if cp == nil {
+		return nil
+	}
+	var table tableCheckpoint
+	if counts, ok := summary.DifferentRowCounts[tableName]; ok {
+		table.RowCounts = &counts
+		table.Estimated = summary.EstimatedRowCounts[tableName]
+	}
+	if rows, ok := summary.RowDifferences[tableName]; ok {
+		table.Rows = &rows
+	}
+	if chunks, ok := summary.ChunkDifferences[tableName]; ok {
+		table.Chunks = &chunks
+	}
+	table.Stats = summary.StatDifferences[tableName]
+	table.Suppressed = suppressed
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Tables[tableName] = table
+	delete(cp.Progress, tableName)
+	return cp.save()
+}
+
+// save writes the checkpoint file, with cp.mu held
+func (cp *checkpoint) save() error {
+	if cp.path == "" {
+		return nil
+	}
+
+	// Replace the file in one step, so an interruption can't leave half of it
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cp.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(cp.path+".tmp", cp.path)
+}
+
+// progress returns the progress of a table the checkpoint keeps, empty if
+// its comparison didn't start yet, and nil without a checkpoint
+func (cp *checkpoint) progress(tableName string) *tableProgress {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	progress, ok := cp.Progress[tableName]
+	if !ok {
+		progress = &tableProgress{cp: cp}
+		cp.Progress[tableName] = progress
+	}
+	return progress
+}
+
+// update changes the progress with cp.mu held and writes the checkpoint
+// file, keeping the first error writing it. The progress is then left out
+// of the file, which the rest of the checkpoint is still written to.
+func (p *tableProgress) update(change func()) {
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+	change()
+	if err := p.cp.save(); err != nil {
+		for tableName, progress := range p.cp.Progress {
+			if progress == p {
+				delete(p.cp.Progress, tableName)
+			}
+		}
+		if p.err == nil {
+			p.err = err
+		}
+	}
+}
+
+// chunks returns the chunks the table was split into, or split calls split
+// and records them
+func (p *tableProgress) chunks(split func() ([]Chunk, error)) ([]Chunk, error) {
+	if p == nil {
+		return split()
+	}
+	p.cp.mu.Lock()
+	chunks := p.Chunks
+	p.cp.mu.Unlock()
+	if chunks != nil {
+		return chunks, nil
+	}
+	chunks, err := split()
+	if err == nil {
+		p.update(func() { p.Chunks = chunks })
+	}
+	return chunks, err
+}
+
+// checksummed reports whether the checksums of the chunk at position i
+// were compared, and whether they differ
+func (p *tableProgress) checksummed(i int) (compared, different bool) {
+	if p == nil {
+		return false, false
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+	different, compared = p.Checksummed[i]
+	return compared, different
+}
+
+// checksumsCompared records that the checksums of the chunks at these
+// positions were compared, and whether each differs
+func (p *tableProgress) checksumsCompared(positions []int, different []bool) {
+	if p == nil {
+		return
+	}
+	p.update(func() {
+		if p.Checksummed == nil {
+			p.Checksummed = make(map[int]bool)
+		}
+		for _, i := range positions {
+			p.Checksummed[i] = different[i]
+		}
+	})
+}
+
+// ranges returns the key ranges whose rows are compared, or choose calls
+// choose and records them
+func (p *tableProgress) ranges(choose func() ([]Chunk, error)) ([]Chunk, error) {
+	if p == nil {
+		return choose()
+	}
+	p.cp.mu.Lock()
+	ranges := p.Ranges
+	p.cp.mu.Unlock()
+	if ranges != nil {
+		return ranges, nil
+	}
+	ranges, err := choose()
+	if err == nil {
+		p.update(func() { p.Ranges = ranges })
+	}
+	return ranges, err
+}
+
+// rangeCompared reports whether the rows of the range at position i were
+// compared
+func (p *tableProgress) rangeCompared(i int) bool {
+	if p == nil {
+		return false
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+	return p.Compared[i]
+}
+
+// comparedRows returns the differences of the ranges compared
+func (p *tableProgress) comparedRows() RowDiffResult {
+	if p == nil {
+		return RowDiffResult{}
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+	return p.Rows
+}
+
+// finishRange records the differences of the range at position i
+func (p *tableProgress) finishRange(i int, result RowDiffResult) {
+	if p == nil {
+		return
+	}
+	p.update(func() {
+		if p.Compared == nil {
+			p.Compared = make(map[int]bool)
+		}
+		p.Compared[i] = true
+		p.Rows.add(result)
+	})
+}
+
+// saveError returns the first error writing the checkpoint file while
+// recording the progress
+func (p *tableProgress) saveError() error {
+	if p == nil {
+		return nil
+	}
+	p.cp.mu.Lock()
+	defer p.cp.mu.Unlock()
+	return p.err
+}
+
+// chunkBounds are chunks as kept in the checkpoint file. Their key values
+// keep their types, so a resumed comparison binds them like the ones read.
+type chunkBounds []Chunk
+
+// savedKeyValue is a key value in the checkpoint file
+type savedKeyValue struct {
+	Type  string
+	Value string `json:",omitempty"`
+}
+
+type savedChunk struct {
+	Index        int
+	Lower, Upper []savedKeyValue
+}
+
+func (b chunkBounds) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	saved := make([]savedChunk, len(b))
+	for i, chunk := range b {
+		saved[i].Index = chunk.Index
+		for _, bound := range []struct {
+			values []interface{}
+			saved  *[]savedKeyValue
+		}{{chunk.Lower, &saved[i].Lower}, {chunk.Upper, &saved[i].Upper}} {
+			if bound.values == nil {
+				continue
+			}
+			*bound.saved = make([]savedKeyValue, len(bound.values))
+			for j, value := range bound.values {
+				var err error
+				if (*bound.saved)[j], err = saveKeyValue(value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return json.Marshal(saved)
+}
+
+func (b *chunkBounds) UnmarshalJSON(data []byte) error {
+	var saved []savedChunk
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	if saved == nil {
+		*b = nil
+		return nil
+	}
+	chunks := make(chunkBounds, len(saved))
+	for i, s := range saved {
+		chunks[i].Index = s.Index
+		for _, bound := range []struct {
+			saved  []savedKeyValue
+			values *[]interface{}
+		}{{s.Lower, &chunks[i].Lower}, {s.Upper, &chunks[i].Upper}} {
+			if bound.saved == nil {
+				continue
+			}
+			*bound.values = make([]interface{}, len(bound.saved))
+			for j, value := range bound.saved {
+				var err error
+				if (*bound.values)[j], err = value.restore(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	*b = chunks
+	return nil
+}
+
+// saveKeyValue converts a key value read by a driver to its type and text
+func saveKeyValue(value interface{}) (savedKeyValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return savedKeyValue{Type: "null"}, nil
+	case int64:
+		return savedKeyValue{Type: "int", Value: strconv.FormatInt(v, 10)}, nil
+	case uint64:
+		return savedKeyValue{Type: "uint", Value: strconv.FormatUint(v, 10)}, nil
+	case float64:
+		return savedKeyValue{Type: "float", Value: strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case bool:
+		return savedKeyValue{Type: "bool", Value: strconv.FormatBool(v)}, nil
+	case string:
+		return savedKeyValue{Type: "string", Value: v}, nil
+	case []byte:
+		return savedKeyValue{Type: "bytes", Value: base64.StdEncoding.EncodeToString(v)}, nil
+	case time.Time:
+		return savedKeyValue{Type: "time", Value: v.Format(time.RFC3339Nano)}, nil
+	}
+	return savedKeyValue{}, fmt.Errorf("key value of type %T can't be kept in the checkpoint", value)
+}
+
+// restore converts a key value of the checkpoint file back to its type
+func (v savedKeyValue) restore() (interface{}, error) {
+	switch v.Type {
+	case "null":
+		return nil, nil
+	case "int":
+		return strconv.ParseInt(v.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(v.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(v.Value, 64)
+	case "bool":
+		return strconv.ParseBool(v.Value)
+	case "string":
+		return v.Value, nil
+	case "bytes":
+		return base64.StdEncoding.DecodeString(v.Value)
+	case "time":
+		return time.Parse(time.RFC3339Nano, v.Value)
+	}
+	return nil, fmt.Errorf("key value of unknown type %q in the checkpoint", v.Type)
+}
+
+// finish removes the checkpoint file once every table was compared,
+// there's nothing left to resume. Tables that failed or timed out are
+// compared again by a resumed run.
+func (cp *checkpoint) finish(summary ComparisonSummary) error {
+	if cp == nil {
+		return nil
+	}
+	for _, tableName := range summary.CommonTables {
+		if _, ok := cp.Tables[tableName]; !ok {
+			return nil
+		}
+	}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
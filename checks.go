@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+)
+
+// maxCheckRows bounds the rows a check may return, its results are held in
+// memory. A check returning more fails.
+const maxCheckRows = 10000
+
+// Check is a named query run on both databases whose results must match,
+// like revenue per month or orders per status. TargetSQL replaces SQL on
+// the target, for databases of different types. Rows are compared in the
+// order returned with Ordered, sorted otherwise, and numbers may differ by
+// Tolerance relative to the larger one. Sorted rows are paired in order, so
+// rows whose numbers differ within Tolerance only pair up when the columns
+// before them tell the rows apart, like a GROUP BY key listed first.
+type Check struct {
+	Name      string
+	SQL       string
+	TargetSQL string
+	Ordered   bool
+	Tolerance float64
+}
+
+// loadChecks reads a checks file. It is YAML, limited to a list of entries
+// with the keys name, sql, target-sql, ordered and tolerance, optionally
+// under a top-level "checks" key:
+//
+//	checks:
+//	  - name: revenue per month
+//	    sql: "SELECT month, SUM(total) FROM invoices GROUP BY month"
+//	    tolerance: 0.0001
+//	  - name: orders per status
+//	    sql: "SELECT status, COUNT(*) FROM orders GROUP BY status"
+func loadChecks(filename string) ([]Check, error) {
+	entries, err := readYAMLList(filename, "checks")
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []Check{}
+	names := make(map[string]bool)
+	for i, entry := range entries {
+		var check Check
+		for _, key := range entry.Keys {
+			value := entry.Fields[key]
+			switch key {
+			case "name":
+				check.Name = value
+			case "sql":
+				check.SQL = value
+			case "target-sql":
+				check.TargetSQL = value
+			case "ordered":
+				check.Ordered, err = strconv.ParseBool(value)
+			case "tolerance":
+				check.Tolerance, err = strconv.ParseFloat(value, 64)
+				if err == nil && check.Tolerance < 0 {
+					err = fmt.Errorf("can't be negative")
+				}
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key '%s', expected name, sql, target-sql, ordered or tolerance", filename, entry.Lines[key], key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid %s: %w", filename, entry.Lines[key], key, err)
+			}
+		}
+		switch {
+		case check.Name == "" || check.SQL == "":
+			return nil, fmt.Errorf("%s: check %d needs a name and sql", filename, i+1)
+		case names[check.Name]:
+			return nil, fmt.Errorf("%s: duplicate check '%s'", filename, check.Name)
+		}
+		names[check.Name] = true
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// checkResult is the result set of a check's query, values formatted
+type checkResult struct {
+	columns []string
+	rows    [][]string
+}
+
+// runCheckQuery runs a check's query in a read-only transaction, which
+// SQLite doesn't enforce, and reads its result. On a pool reading in UTC,
+// see connectReader, it runs in the server's time zone, for NOW() to compare
+// with DATETIME columns as the user wrote it to.
+func runCheckQuery(ctx context.Context, db *sql.DB, query string) (checkResult, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return checkResult{}, err
+	}
+	defer conn.Close()
+	if s, err := poolSession(db); err == nil && s.readsUTC() && explainRuns(ctx) {
+		if _, err := conn.ExecContext(ctx, "SET time_zone = @@GLOBAL.time_zone"); err != nil {
+			return checkResult{}, err
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SET time_zone = '+00:00'"); err != nil {
+				// Not back to reading in UTC, don't reuse it
+				conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+		}()
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return checkResult{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return checkResult{}, err
+	}
+	defer rows.Close()
+
+	var result checkResult
+	if result.columns, err = rows.Columns(); err != nil {
+		return checkResult{}, err
+	}
+	values := make([]interface{}, len(result.columns))
+	pointers := make([]interface{}, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(result.rows) == maxCheckRows {
+			return checkResult{}, fmt.Errorf("returned more than %d rows", maxCheckRows)
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return checkResult{}, err
+		}
+		row := make([]string, len(values))
+		for i, value := range values {
+			row[i] = formatValue(value)
+		}
+		result.rows = append(result.rows, row)
+	}
+	return result, rows.Err()
+}
+
+// checkDifference compares the results of a check on both sides and
+// describes how they differ, ok is true if they match
+func checkDifference(check Check, source, target checkResult) (message string, sourceRow, targetRow string, ok bool) {
+	if len(source.columns) != len(target.columns) {
+		return fmt.Sprintf("returned %d columns in source but %d in target", len(source.columns), len(target.columns)), "", "", false
+	}
+	if len(source.rows) != len(target.rows) {
+		return fmt.Sprintf("returned %d rows in source but %d in target", len(source.rows), len(target.rows)), "", "", false
+	}
+
+	// With as many rows on both sides, each source row that doesn't match
+	// leaves a target row that doesn't either
+	differing := 0
+	for _, diff := range diffResults(source.rows, target.rows, check.Ordered, check.Tolerance) {
+		if diff.source != nil {
+			differing++
+			if sourceRow == "" {
+				sourceRow = formatResultRow(diff.source)
+			}
+		}
+		if diff.target != nil && targetRow == "" {
+			targetRow = formatResultRow(diff.target)
+		}
+	}
+	if differing == 0 {
+		return "", "", "", true
+	}
+	return fmt.Sprintf("returned different results: %d of %d rows differ, first: source=%s, target=%s",
+		differing, len(source.rows), sourceRow, targetRow), sourceRow, targetRow, false
+}
+
+// runChecks runs the checks on both databases and records those whose
+// results differ, or that fail, in the summary
+func (c *Comparison) runChecks(ctx context.Context, summary *ComparisonSummary) {
+	for _, check := range c.Options.Checks {
+		if ctx.Err() != nil {
+			return
+		}
+		targetSQL := cmp.Or(check.TargetSQL, check.SQL)
+
+		var source, target checkResult
+		err := c.retry(ctx, "check "+check.Name, func() (err error) {
+			if source, err = runCheckQuery(ctx, c.SourceDB, check.SQL); err != nil {
+				return fmt.Errorf("source: %w", err)
+			}
+			if target, err = runCheckQuery(ctx, c.TargetDB, targetSQL); err != nil {
+				return fmt.Errorf("target: %w", err)
+			}
+			return nil
+		})
+
+		diff := Difference{ObjectType: "check", ObjectName: check.Name, Kind: DiffModified, Property: "result"}
+		if err != nil {
+			diff.Message = fmt.Sprintf("Check '%s' failed on the %v", check.Name, err)
+		} else {
+			message, sourceRow, targetRow, ok := checkDifference(check, source, target)
+			if ok {
+				continue
+			}
+			diff.Source, diff.Target = sourceRow, targetRow
+			diff.Message = fmt.Sprintf("Check '%s' %s", check.Name, message)
+		}
+		if c.suppress(summary, diff) {
+			continue
+		}
+		summary.CheckDifferences = append(summary.CheckDifferences, diff)
+		c.emit(Event{Type: EventDifferenceFound, Message: diff.Message})
+	}
+}
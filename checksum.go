@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// ChecksumManifest holds the row counts and checksums of a database's
+// tables, to verify a database against later without the original, e.g. a
+// restored backup against the checksums taken at backup time
+type ChecksumManifest struct {
+	Type      string // database type, as given on the command line
+	CreatedAt time.Time
+	ChunkSize int `json:",omitempty"` // tables with a primary key have per-chunk checksums if set
+	Tables    map[string]TableChecksums
+
+	// The session settings the checksums depend on, e.g. the time zone
+	// timestamps are hashed in, which verify sets too
+	Settings map[string]string `json:",omitempty"`
+}
+
+// checksumSettings are the session settings of each adapter that change
+// how the checksums' queries render values
+func checksumSettings(adapter DatabaseAdapter) []string {
+	switch adapter.(type) {
+	case *MySQLAdapter:
+		return []string{"time_zone", "character_set_connection", "collation_connection"}
+	case *PostgreSQLAdapter:
+		return []string{"TimeZone", "DateStyle", "IntervalStyle", "extra_float_digits", "bytea_output"}
+	}
+	return nil
+}
+
+// readSettings reads the checksumSettings of a database's sessions
+func readSettings(ctx context.Context, adapter DatabaseAdapter, db *sql.DB) (map[string]string, error) {
+	names := checksumSettings(adapter)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	settings := make(map[string]string, len(names))
+	for _, name := range names {
+		query := "SELECT current_setting(" + adapter.QuoteLiteral(name) + ")"
+		if _, ok := adapter.(*MySQLAdapter); ok {
+			query = "SELECT @@SESSION." + name
+		}
+		var value string
+		if err := db.QueryRowContext(ctx, query).Scan(&value); err != nil {
+			return nil, fmt.Errorf("session setting %s: %w", name, err)
+		}
+		settings[name] = value
+	}
+	return settings, nil
+}
+
+// pinSettings makes the connections of a pool run with the settings a
+// manifest was exported with
+func pinSettings(adapter DatabaseAdapter, db *sql.DB, settings map[string]string) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	session, err := poolSession(db)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statements := make([]string, len(names))
+	for i, name := range names {
+		statements[i] = "SET SESSION " + name + " = " + adapter.QuoteLiteral(settings[name])
+	}
+	session.start(statements, false)
+	return nil
+}
+
+// TableChecksums are the checksums of one table. Checksum is the
+// whole-table checksum, empty if the database couldn't compute one, Chunks
+// the per-chunk checksums of the Columns.
+type TableChecksums struct {
+	Rows     int
+	Method   string
+	Checksum string          `json:",omitempty"`
+	Columns  []string        `json:",omitempty"`
+	Chunks   []ManifestChunk `json:",omitempty"`
+}
+
+// ManifestChunk is the checksum of a chunk, with its primary key range
+type ManifestChunk struct {
+	Lower []interface{}
+	Upper []interface{}
+	ChunkChecksum
+}
+
+// exportChecksums computes the manifest of a database
+func exportChecksums(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, dbType string, chunkSize int) (ChecksumManifest, error) {
+	manifest := ChecksumManifest{Type: dbType, CreatedAt: time.Now().UTC(), ChunkSize: chunkSize, Tables: make(map[string]TableChecksums)}
+
+	settings, err := readSettings(ctx, adapter, db)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Settings = settings
+
+	tables, err := adapter.GetTableList(ctx, db)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to get tables: %w", err)
+	}
+	schemas, err := getAllTableSchemas(ctx, adapter, db, tables)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to get schemas: %w", err)
+	}
+
+	for _, table := range tables {
+		slog.Info("Computing checksums", "table", table)
+		schema := schemas[table]
+		var checksums TableChecksums
+		if checksums.Rows, err = adapter.CountRows(ctx, db, table); err != nil {
+			return manifest, fmt.Errorf("table %s: row count: %w", table, err)
+		}
+		var checksum sql.NullString
+		if checksums.Method, checksum, err = adapter.TableChecksum(ctx, db, table, schema); err != nil {
+			return manifest, fmt.Errorf("table %s: %s: %w", table, checksums.Method, err)
+		}
+		checksums.Checksum = checksum.String
+
+		if chunkSize > 0 && len(schema.PrimaryKeys) > 0 {
+			if checksums.Columns, err = rowComparisonColumns(schema, schema); err != nil {
+				return manifest, fmt.Errorf("table %s: %w", table, err)
+			}
+			chunks, err := getChunks(ctx, adapter, db, table, schema.PrimaryKeys, chunkSize)
+			if err != nil {
+				return manifest, fmt.Errorf("table %s: chunks: %w", table, err)
+			}
+			for _, batch := range chunkBatches(chunks, chunksPerQuery) {
+				sums, err := adapter.ChunkChecksums(ctx, db, table, checksums.Columns, schema.PrimaryKeys, batch)
+				if err != nil {
+					return manifest, fmt.Errorf("table %s: chunks %d-%d: %w", table, batch[0].Index, batch[len(batch)-1].Index, err)
+				}
+				for i, chunk := range batch {
+					checksums.Chunks = append(checksums.Chunks, ManifestChunk{
+						Lower: manifestBound(chunk.Lower), Upper: manifestBound(chunk.Upper), ChunkChecksum: sums[i]})
+				}
+			}
+		}
+		manifest.Tables[table] = checksums
+	}
+	return manifest, nil
+}
+
+// manifestBound makes a chunk bound read from the database survive JSON:
+// text read as bytes is kept as a string rather than base64
+func manifestBound(values []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	bound := make([]interface{}, len(values))
+	for i, value := range values {
+		if b, ok := value.([]byte); ok && utf8.Valid(b) {
+			value = string(b)
+		}
+		bound[i] = value
+	}
+	return bound
+}
+
+func writeManifest(path string, manifest ChecksumManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readManifest reads a manifest, with integer chunk bounds as int64 so
+// large keys keep their precision
+func readManifest(path string) (ChecksumManifest, error) {
+	var manifest ChecksumManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, table := range manifest.Tables {
+		for i := range table.Chunks {
+			table.Chunks[i].Lower = numberBound(table.Chunks[i].Lower)
+			table.Chunks[i].Upper = numberBound(table.Chunks[i].Upper)
+		}
+		manifest.Tables[name] = table
+	}
+	return manifest, nil
+}
+
+func numberBound(values []interface{}) []interface{} {
+	for i, value := range values {
+		if number, ok := value.(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				values[i] = n
+			} else if f, err := number.Float64(); err == nil {
+				values[i] = f
+			}
+		}
+	}
+	return values
+}
+
+// verifyChecksums compares a database against a manifest, as if the
+// manifest were the source: tables are missing or extra, their row counts
+// differ or their data does, in the chunks that differ or, without chunks,
+// in the one chunk covering the whole table
+func verifyChecksums(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, manifest ChecksumManifest) (ComparisonSummary, error) {
+	summary := ComparisonSummary{
+		DifferentRowCounts: make(map[string]struct{ Source, Target int }),
+		ChunkDifferences:   make(map[string]ChunkResult),
+		SkippedTables:      make(map[string]string),
+	}
+
+	tables, err := adapter.GetTableList(ctx, db)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get tables: %w", err)
+	}
+	live := make(map[string]bool)
+	for _, table := range tables {
+		live[table] = true
+		if _, ok := manifest.Tables[table]; !ok {
+			summary.ExtraTables = append(summary.ExtraTables, table)
+		}
+	}
+	for table := range manifest.Tables {
+		if live[table] {
+			summary.CommonTables = append(summary.CommonTables, table)
+		} else {
+			summary.MissingTables = append(summary.MissingTables, table)
+		}
+	}
+	sort.Strings(summary.MissingTables)
+	sort.Strings(summary.ExtraTables)
+	sort.Strings(summary.CommonTables)
+
+	for _, table := range summary.CommonTables {
+		if ctx.Err() != nil {
+			summary.Interrupted = true
+			break
+		}
+		slog.Info("Verifying checksums", "table", table)
+		if err := verifyTable(ctx, adapter, db, &summary, table, manifest.Tables[table]); err != nil {
+			slog.Warn("Failed to verify the table's checksums, skipping it", "table", table, "error", err)
+			summary.skipTable(table, "error", err)
+		}
+	}
+	return summary, nil
+}
+
+// verifyTable compares a table with its checksums in the manifest
+func verifyTable(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, summary *ComparisonSummary, table string, expected TableChecksums) error {
+	schema, err := adapter.GetTableSchema(ctx, db, table)
+	if err != nil {
+		return inPhase("schema", "", err)
+	}
+	summary.TotalTablesChecked++
+
+	rows, err := adapter.CountRows(ctx, db, table)
+	if err != nil {
+		return inPhase("row counts", "", err)
+	}
+	if rows != expected.Rows {
+		summary.DifferentRowCounts[table] = struct{ Source, Target int }{expected.Rows, rows}
+		summary.addDifferentTable(table)
+	}
+
+	result := ChunkResult{Table: table, PrimaryKey: schema.PrimaryKeys, TotalChunks: 1}
+	if len(expected.Chunks) > 0 {
+		result.TotalChunks = len(expected.Chunks)
+		chunks := make([]Chunk, len(expected.Chunks))
+		for i, chunk := range expected.Chunks {
+			chunks[i] = Chunk{Index: i, Lower: chunk.Lower, Upper: chunk.Upper}
+		}
+		for _, batch := range chunkBatches(chunks, chunksPerQuery) {
+			sums, err := adapter.ChunkChecksums(ctx, db, table, expected.Columns, schema.PrimaryKeys, batch)
+			if err != nil {
+				return inPhase("chunk checksums", "", fmt.Errorf("chunks %d-%d: %w", batch[0].Index, batch[len(batch)-1].Index, err))
+			}
+			for i, bounds := range batch {
+				if sums[i] != expected.Chunks[bounds.Index].ChunkChecksum {
+					result.DifferentChunks = append(result.DifferentChunks, bounds)
+				}
+			}
+		}
+	} else {
+		_, checksum, err := adapter.TableChecksum(ctx, db, table, schema)
+		if err != nil {
+			return inPhase("checksums", "", err)
+		}
+		if expected.Checksum == "" || !checksum.Valid {
+			summary.SkippedTables[table] = "no " + expected.Method + " to verify, only the row count was"
+			return nil
+		}
+		if checksum.String != expected.Checksum {
+			result.DifferentChunks = []Chunk{{}}
+		}
+	}
+	if result.HasDifferences() {
+		summary.ChunkDifferences[table] = result
+		summary.addDifferentTable(table)
+	}
+	return nil
+}
+
+func printChecksumUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("Usage: mudrockdbcompare checksum export [options] --out FILE [db-type] [connection-string]")
+		fmt.Println("       mudrockdbcompare checksum verify [options] FILE [connection-string]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare checksum export --chunk-size 10000 --out manifest.json mysql \"user:password@localhost:3306/dbname\"")
+		fmt.Println("  mudrockdbcompare checksum verify manifest.json \"user:password@restored:3306/dbname\"")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+}
+
+// runChecksum runs the checksum subcommand: export writes the checksums of
+// a database's tables to a manifest, verify compares a database against one
+// and exits with status 3 when they differ
+func runChecksum(args []string) {
+	fs := flag.NewFlagSet("checksum", flag.ExitOnError)
+	out := fs.String("out", "", "with export, the file to write the manifest to")
+	chunkSize := fs.Int("chunk-size", 0, "with export, also checksum primary key ranges of this many rows, so verify can tell which differ (0 disables)")
+	logLevel := fs.String("log-level", "info", "log verbosity: debug (includes every SQL statement), info or warn")
+	logFormat := fs.String("log-format", "text", "log format: text or json")
+	promptPasswords := fs.Bool("prompt-passwords", false, "ask for the password instead of reading it from the connection string or "+targetPasswordEnv)
+	noColor := fs.Bool("no-color", false, "with verify, don't color the output, which is colored by default when it's a terminal")
+	fs.Usage = printChecksumUsage(fs)
+
+	if len(args) == 0 || (args[0] != "export" && args[0] != "verify") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 || (command == "export" && *out == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *chunkSize < 0 {
+		fmt.Fprintln(os.Stderr, "--chunk-size can't be negative")
+		os.Exit(2)
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setupColor(*noColor)
+
+	// export reads a database of the given type, verify one of the
+	// manifest's type
+	dbType := fs.Arg(0)
+	var manifest ChecksumManifest
+	if command == "verify" {
+		var err error
+		if manifest, err = readManifest(fs.Arg(0)); err != nil {
+			fatal("Failed to read the manifest", err)
+		}
+		dbType = manifest.Type
+	}
+	adapter, err := GetAdapter(dbType)
+	if err != nil {
+		fatal("Invalid database type", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	db, connStr := connectDatabase(ctx, adapter, fs.Arg(1), *promptPasswords)
+	defer db.Close()
+	if err := pinSettings(adapter, db, manifest.Settings); err != nil {
+		fatal("Failed to set the manifest's session settings", err)
+	}
+
+	if command == "export" {
+		manifest, err := exportChecksums(ctx, adapter, db, dbType, *chunkSize)
+		if err != nil {
+			fatal("Failed to compute checksums", err)
+		}
+		if err := writeManifest(*out, manifest); err != nil {
+			fatal("Failed to write the manifest", err)
+		}
+		slog.Info("Saved checksum manifest", "file", *out, "tables", len(manifest.Tables))
+		return
+	}
+
+	summary, err := verifyChecksums(ctx, adapter, db, manifest)
+	if err != nil {
+		fatal("Failed to verify checksums", err)
+	}
+	info, err := GetDatabaseInfo(ctx, adapter, db, connStr, false)
+	if err != nil {
+		slog.Warn("Couldn't collect full database info", "error", err)
+	}
+
+	fmt.Println("\n=== Database Information ===")
+	fmt.Printf("Source: manifest %s, taken %s, Tables: %d\n", fs.Arg(0), manifest.CreatedAt.Format(time.RFC3339), len(manifest.Tables))
+	fmt.Printf("Target: %s, Database: %s, Tables: %d, Size: %s\n",
+		info.Host, info.DatabaseName, info.TableCount, formatSize(info.TotalSize))
+	if summary.HasDataDifferences() {
+		fmt.Println("\n=== Data Differences ===")
+		for _, table := range summary.DifferentTables {
+			if result, ok := summary.ChunkDifferences[table]; ok {
+				printChunkDifferences(result)
+			}
+		}
+	}
+	printSummary(summary)
+
+	if summary.HasSchemaDifferences() || summary.HasRowCountDifferences() || summary.HasDataDifferences() {
+		slog.Warn("The database doesn't match the manifest")
+		os.Exit(3)
+	}
+	if summary.Interrupted || len(summary.Errors) > 0 {
+		os.Exit(1)
+	}
+}
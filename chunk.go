@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// keyRangeCondition builds a WHERE clause restricting the key columns to the
+// chunk's range, using row value comparison so composite keys work as well.
+// It returns an empty string for a chunk without bounds.
+func keyRangeCondition(keyColumns []string, chunk Chunk, quote func(string) string, placeholder func(int) string) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	bound := func(op string, values []interface{}) {
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = placeholder(len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s) %s (%s)",
+			quoteList(keyColumns, quote), op, strings.Join(placeholders, ", ")))
+	}
+
+	if chunk.Lower != nil {
+		bound(">", chunk.Lower)
+	}
+	if chunk.Upper != nil {
+		bound("<=", chunk.Upper)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// chunksPerQuery is the number of consecutive chunks whose checksums are
+// computed in one query
+const chunksPerQuery = 100
+
+// chunkBatches splits chunks into runs of up to size
+func chunkBatches(chunks []Chunk, size int) [][]Chunk {
+	batches := [][]Chunk{}
+	for len(chunks) > size {
+		batches = append(batches, chunks[:size])
+		chunks = chunks[size:]
+	}
+	if len(chunks) > 0 {
+		batches = append(batches, chunks)
+	}
+	return batches
+}
+
+// chunkNumber builds a CASE expression numbering a row by the one of the
+// consecutive chunks its key falls in, from 0, and a WHERE clause
+// restricting the rows to the chunks, with their arguments in that order
+func chunkNumber(keyColumns []string, chunks []Chunk, quote func(string) string, placeholder func(int) string) (string, string, []interface{}) {
+	keys := quoteList(keyColumns, quote)
+	args := []interface{}{}
+	whens := make([]string, 0, len(chunks))
+	for i, chunk := range chunks[:len(chunks)-1] {
+		placeholders := make([]string, len(chunk.Upper))
+		for j, value := range chunk.Upper {
+			args = append(args, value)
+			placeholders[j] = placeholder(len(args))
+		}
+		whens = append(whens, fmt.Sprintf("WHEN (%s) <= (%s) THEN %d", keys, strings.Join(placeholders, ", "), i))
+	}
+	number := fmt.Sprintf("%d", len(chunks)-1)
+	if len(whens) > 0 {
+		number = fmt.Sprintf("CASE %s ELSE %d END", strings.Join(whens, " "), len(chunks)-1)
+	}
+
+	span := Chunk{Lower: chunks[0].Lower, Upper: chunks[len(chunks)-1].Upper}
+	offset := len(args)
+	where, whereArgs := keyRangeCondition(keyColumns, span, quote, func(n int) string { return placeholder(offset + n) })
+	return number, where, append(args, whereArgs...)
+}
+
+// scanChunkChecksums reads the rows of a query grouped by chunk: the
+// chunk's number, its row count and hash. Chunks without rows have none.
+func scanChunkChecksums(rows *sql.Rows, count int) ([]ChunkChecksum, error) {
+	defer rows.Close()
+	checksums := make([]ChunkChecksum, count)
+	for rows.Next() {
+		var i int
+		var checksum ChunkChecksum
+		if err := rows.Scan(&i, &checksum.Rows, &checksum.Hash); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= count {
+			return nil, fmt.Errorf("checksum of unknown chunk %d", i)
+		}
+		checksums[i] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// keyValue converts a key value read from a row to a parameter of its
+// column type. The MySQL driver returns integers read without parameters
+// as text, which MySQL compares with a BIGINT column as floats, losing the
+// precision of values above 2^53.
+func keyValue(value interface{}, dataType string) interface{} {
+	text, ok := value.([]byte)
+	if !ok || !isIntegerType(dataType) {
+		return value
+	}
+	if n, err := strconv.ParseInt(string(text), 10, 64); err == nil {
+		return n
+	}
+	if n, err := strconv.ParseUint(string(text), 10, 64); err == nil {
+		return n
+	}
+	return value
+}
+
+// scanChunkBoundary reads the key values of a boundary row, returning nil
+// when the table has no more rows
+func scanChunkBoundary(row *sql.Row, keyCount int) ([]interface{}, error) {
+	values := make([]interface{}, keyCount)
+	dest := make([]interface{}, keyCount)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return values, nil
+}
+
+// getChunks splits a table into primary key ranges of roughly chunkSize rows.
+// The first and last chunks are open-ended so rows outside the source's key
+// range still land in a chunk when the same ranges are applied to the target.
+func getChunks(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName string, keyColumns []string, chunkSize int) ([]Chunk, error) {
+	chunks := []Chunk{}
+	var lower []interface{}
+
+	for {
+		upper, err := adapter.GetChunkBoundary(ctx, db, tableName, keyColumns, lower, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, Chunk{Index: len(chunks), Lower: lower, Upper: upper})
+		if upper == nil {
+			return chunks, nil
+		}
+		lower = upper
+	}
+}
+
+// forEachChunk calls fn for every chunk, for up to parallel chunks at the
+// same time, each then querying on its own connection, pausing after each
+// chunk as the throttle carried by ctx says. It stops at the first error
+// and returns it.
+func forEachChunk(ctx context.Context, chunks []Chunk, parallel int, fn func(ctx context.Context, i int, chunk Chunk) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, max(parallel, 1))
+	for i, chunk := range chunks {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			err := fn(ctx, i, chunk)
+			if err == nil {
+				err = pauseAfterChunk(ctx)
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// compareTableChunks computes per-chunk checksums on both sides, of up to
+// parallel chunks at the same time, and returns the chunks whose checksums
+// differ. The chunks and those compared are recorded in progress, the ones
+// it already has aren't compared again.
+func compareTableChunks(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, sourceSchema, targetSchema TableSchema, chunkSize, parallel int, progress *tableProgress) (ChunkResult, error) {
+	result := ChunkResult{Table: sourceSchema.Name, PrimaryKey: sourceSchema.PrimaryKeys}
+
+	columns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		return result, err
+	}
+
+	chunks, err := progress.chunks(func() ([]Chunk, error) {
+		return getChunks(ctx, sourceAdapter, sourceDB, sourceSchema.Name, sourceSchema.PrimaryKeys, chunkSize)
+	})
+	if err != nil {
+		return result, inPhase("chunk checksums", "source", err)
+	}
+	result.TotalChunks = len(chunks)
+
+	// Only the chunks not compared yet, in runs of consecutive ones, the
+	// checksums of a run are numbered by the chunks' upper bounds
+	different := make([]bool, len(chunks))
+	var runs [][]Chunk
+	pending := 0
+	for i, chunk := range chunks {
+		if compared, differs := progress.checksummed(i); compared {
+			different[i] = differs
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1][len(runs[n-1])-1].Index == i-1 {
+			runs[n-1] = append(runs[n-1], chunk)
+		} else {
+			runs = append(runs, []Chunk{chunk})
+		}
+		pending++
+	}
+
+	// Checksum the chunks in runs, each run's in one query per side, and
+	// in at least as many runs as there are parallel connections
+	var batches [][]Chunk
+	for _, run := range runs {
+		batches = append(batches, chunkBatches(run, min(chunksPerQuery, max(1, (pending+parallel-1)/parallel)))...)
+	}
+	spans := make([]Chunk, len(batches))
+	for i, batch := range batches {
+		spans[i] = Chunk{Index: i, Lower: batch[0].Lower, Upper: batch[len(batch)-1].Upper}
+	}
+
+	err = forEachChunk(ctx, spans, parallel, func(ctx context.Context, i int, span Chunk) error {
+		batch := batches[i]
+		first, last := batch[0].Index, batch[len(batch)-1].Index
+		sourceChecksums, err := sourceAdapter.ChunkChecksums(ctx, sourceDB, sourceSchema.Name, columns, sourceSchema.PrimaryKeys, batch)
+		if err != nil {
+			return inPhase("chunk checksums", "source", fmt.Errorf("source chunks %d-%d: %w", first, last, err))
+		}
+
+		targetChecksums, err := targetAdapter.ChunkChecksums(ctx, targetDB, targetSchema.Name, columns, sourceSchema.PrimaryKeys, batch)
+		if err != nil {
+			return inPhase("chunk checksums", "target", fmt.Errorf("target chunks %d-%d: %w", first, last, err))
+		}
+
+		positions := make([]int, len(batch))
+		for j, chunk := range batch {
+			different[chunk.Index] = sourceChecksums[j] != targetChecksums[j]
+			positions[j] = chunk.Index
+		}
+		progress.checksumsCompared(positions, different)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for i, chunk := range chunks {
+		if different[i] {
+			result.DifferentChunks = append(result.DifferentChunks, chunk)
+		}
+	}
+	return result, nil
+}
+
+// compareTableChecksums compares the whole-table checksums of both sides.
+// A checksum that isn't available on either side can't prove the data is
+// equal.
+func compareTableChecksums(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, tableName string, schema TableSchema) (ChecksumResult, error) {
+	result := ChecksumResult{Table: tableName}
+
+	var err error
+	result.Method, result.SourceChecksum, err = sourceAdapter.TableChecksum(ctx, sourceDB, tableName, schema)
+	if err != nil {
+		return result, inPhase("checksums", "source", fmt.Errorf("source %s: %w", result.Method, err))
+	}
+	_, result.TargetChecksum, err = targetAdapter.TableChecksum(ctx, targetDB, tableName, schema)
+	if err != nil {
+		return result, inPhase("checksums", "target", fmt.Errorf("target %s: %w", result.Method, err))
+	}
+
+	result.Different = !result.SourceChecksum.Valid || !result.TargetChecksum.Valid ||
+		result.SourceChecksum.String != result.TargetChecksum.String
+	return result, nil
+}
+
+// HasDifferences reports whether any chunk differs
+func (r ChunkResult) HasDifferences() bool {
+	return len(r.DifferentChunks) > 0
+}
+
+// rangeString renders a chunk's key range, e.g. "(id=100, id=200]"
+func (r ChunkResult) rangeString(chunk Chunk) string {
+	bound := func(values []interface{}, open string) string {
+		if values == nil {
+			return open
+		}
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = fmt.Sprintf("%s=%s", r.PrimaryKey[i], formatValue(value))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	return fmt.Sprintf("(%s, %s]", bound(chunk.Lower, "start"), bound(chunk.Upper, "end"))
+}
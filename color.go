@@ -0,0 +1,55 @@
+package main
+
+import "os"
+
+// ANSI colors of the console output, diff-style from the target's point of
+// view: red for what it lacks, green for what it has extra, yellow for
+// what differs
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// useColor is whether the output is colored, set by setupColor
+var useColor bool
+
+// setupColor enables colors when stdout is a terminal, unless noColor is
+// set or the NO_COLOR environment variable is (https://no-color.org)
+func setupColor(noColor bool) {
+	useColor = !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+}
+
+func colored(color, s string) string {
+	if !useColor || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// kindColor returns the color of a difference or row difference kind
+func kindColor(kind string) string {
+	switch kind {
+	case DiffMissing, RowDeleted:
+		return colorRed
+	case DiffExtra, RowInserted:
+		return colorGreen
+	case DiffModified, RowChanged:
+		return colorYellow
+	}
+	return ""
+}
+
+// planColor returns the color of a plan action
+func planColor(op string) string {
+	switch op {
+	case PlanAdd:
+		return colorGreen
+	case PlanDrop:
+		return colorRed
+	case PlanChange:
+		return colorYellow
+	}
+	return ""
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-func compareDatabases(sourceSchemas, targetSchemas map[string]TableSchema) ([]string, []string, []string, map[string][]string) {
+func compareDatabases(sourceSchemas, targetSchemas map[string]TableSchema, options CompareOptions) ([]string, []string, []string, map[string][]Difference) {
 	missingTables := []string{}
 	extraTables := []string{}
 	commonTables := []string{}
-	schemaDifferences := make(map[string][]string)
+	schemaDifferences := make(map[string][]Difference)
 
 	// Check for tables in source but not in target
 	for tableName := range sourceSchemas {
@@ -19,7 +27,7 @@ func compareDatabases(sourceSchemas, targetSchemas map[string]TableSchema) ([]st
 		}
 
 		// Table exists in both, compare schema
-		hasDiffs, diffs := compareTableSchema(tableName, sourceSchemas[tableName], targetSchemas[tableName])
+		hasDiffs, diffs := compareTableSchema(tableName, sourceSchemas[tableName], targetSchemas[tableName], options)
 		if hasDiffs {
 			schemaDifferences[tableName] = diffs
 		}
@@ -36,9 +44,10 @@ func compareDatabases(sourceSchemas, targetSchemas map[string]TableSchema) ([]st
 	return missingTables, extraTables, commonTables, schemaDifferences
 }
 
-func compareTableSchema(tableName string, sourceSchema, targetSchema TableSchema) (bool, []string) {
-	hasDifferences := false
-	differences := []string{}
+func compareTableSchema(tableName string, sourceSchema, targetSchema TableSchema, options CompareOptions) (bool, []Difference) {
+	differences := []Difference{}
+
+	differences = append(differences, compareTableOptions(tableName, sourceSchema.Options, targetSchema.Options, options)...)
 
 	// Compare columns
 	sourceColumns := make(map[string]ColumnSchema)
@@ -54,19 +63,64 @@ func compareTableSchema(tableName string, sourceSchema, targetSchema TableSchema
 	// Check for columns in source but not in target
 	for colName, sourceCol := range sourceColumns {
 		if targetCol, exists := targetColumns[colName]; !exists {
-			differences = append(differences, fmt.Sprintf("Column '%s.%s' exists in source but not in target", tableName, colName))
-			hasDifferences = true
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffMissing,
+				Message: fmt.Sprintf("Column '%s.%s' exists in source but not in target", tableName, colName),
+			})
 		} else {
 			// Compare column properties
-			if sourceCol.DataType != targetCol.DataType {
-				differences = append(differences, fmt.Sprintf("Column '%s.%s' has different data type: source='%s', target='%s'",
-					tableName, colName, sourceCol.DataType, targetCol.DataType))
-				hasDifferences = true
+			if !sameColumnType(sourceCol, targetCol, options) {
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+					Property: "data type", Source: sourceCol.DataType, Target: targetCol.DataType,
+					Message: fmt.Sprintf("Column '%s.%s' has different data type: source='%s', target='%s'",
+						tableName, colName, sourceCol.DataType, targetCol.DataType),
+				})
 			}
 			if sourceCol.Nullable != targetCol.Nullable {
-				differences = append(differences, fmt.Sprintf("Column '%s.%s' has different nullable property: source='%s', target='%s'",
-					tableName, colName, sourceCol.Nullable, targetCol.Nullable))
-				hasDifferences = true
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+					Property: "nullable", Source: sourceCol.Nullable, Target: targetCol.Nullable,
+					Message: fmt.Sprintf("Column '%s.%s' has different nullable property: source='%s', target='%s'",
+						tableName, colName, sourceCol.Nullable, targetCol.Nullable),
+				})
+			}
+			// Identity columns default to the next value of a sequence in
+			// Postgres and have no default in MySQL
+			if !sourceCol.AutoIdentity && !targetCol.AutoIdentity &&
+				normalizeDefault(sourceCol.Default.String) != normalizeDefault(targetCol.Default.String) {
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+					Property: "default", Source: sourceCol.Default.String, Target: targetCol.Default.String,
+					Message: fmt.Sprintf("Column '%s.%s' has different default: source='%s', target='%s'",
+						tableName, colName, sourceCol.Default.String, targetCol.Default.String),
+				})
+			}
+			if sourceCol.AutoIdentity != targetCol.AutoIdentity {
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+					Property: "auto identity", Source: fmt.Sprint(sourceCol.AutoIdentity), Target: fmt.Sprint(targetCol.AutoIdentity),
+					Message: fmt.Sprintf("Column '%s.%s' has different auto identity: source=%v, target=%v",
+						tableName, colName, sourceCol.AutoIdentity, targetCol.AutoIdentity),
+				})
+			}
+			if !options.IgnoreCollation {
+				if sourceCol.Charset != targetCol.Charset {
+					differences = append(differences, Difference{
+						Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+						Property: "charset", Source: sourceCol.Charset, Target: targetCol.Charset,
+						Message: fmt.Sprintf("Column '%s.%s' has different charset: source='%s', target='%s'",
+							tableName, colName, sourceCol.Charset, targetCol.Charset),
+					})
+				}
+				if sourceCol.Collation != targetCol.Collation {
+					differences = append(differences, Difference{
+						Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffModified,
+						Property: "collation", Source: sourceCol.Collation, Target: targetCol.Collation,
+						Message: fmt.Sprintf("Column '%s.%s' has different collation: source='%s', target='%s'",
+							tableName, colName, sourceCol.Collation, targetCol.Collation),
+					})
+				}
 			}
 			// Compare other properties as needed
 		}
@@ -75,23 +129,306 @@ func compareTableSchema(tableName string, sourceSchema, targetSchema TableSchema
 	// Check for columns in target but not in source
 	for colName := range targetColumns {
 		if _, exists := sourceColumns[colName]; !exists {
-			differences = append(differences, fmt.Sprintf("Column '%s.%s' exists in target but not in source", tableName, colName))
-			hasDifferences = true
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "column", ObjectName: colName, Kind: DiffExtra,
+				Message: fmt.Sprintf("Column '%s.%s' exists in target but not in source", tableName, colName),
+			})
 		}
 	}
 
+	if options.StrictColumnOrder {
+		differences = append(differences, compareColumnOrder(tableName, sourceSchema.Columns, targetSchema.Columns)...)
+	}
+
 	// Compare primary keys
 	if !compareStringSlices(sourceSchema.PrimaryKeys, targetSchema.PrimaryKeys) {
-		differences = append(differences, fmt.Sprintf("Table '%s' has different primary keys: source=%v, target=%v",
-			tableName, sourceSchema.PrimaryKeys, targetSchema.PrimaryKeys))
-		hasDifferences = true
+		differences = append(differences, Difference{
+			Table: tableName, ObjectType: "primary key", Kind: DiffModified, Property: "columns",
+			Source: strings.Join(sourceSchema.PrimaryKeys, ", "), Target: strings.Join(targetSchema.PrimaryKeys, ", "),
+			Message: fmt.Sprintf("Table '%s' has different primary keys: source=%v, target=%v",
+				tableName, sourceSchema.PrimaryKeys, targetSchema.PrimaryKeys),
+		})
+	}
+
+	differences = append(differences, compareUniqueConstraints(tableName, sourceSchema.UniqueConstraints, targetSchema.UniqueConstraints)...)
+	differences = append(differences, compareIndexes(tableName, withoutConstraintIndexes(sourceSchema), withoutConstraintIndexes(targetSchema))...)
+	differences = append(differences, compareForeignKeys(tableName, sourceSchema.ForeignKeys, targetSchema.ForeignKeys)...)
+
+	return len(differences) > 0, differences
+}
+
+// sameColumnType reports whether two columns have the same type. A type
+// is the same under any of its aliases, e.g. int4, integer and serial or
+// bool and MySQL's tinyint(1), and integers of any display width. A type
+// without a length or precision, as Postgres reports them, matches the type
+// with any, and the type equivalences make other types equal.
+func sameColumnType(sourceCol, targetCol ColumnSchema, options CompareOptions) bool {
+	sourceType, targetType := sourceCol.DataType, targetCol.DataType
+	if sourceType == targetType || equivalentTypes(options.TypeEquivalences, sourceType, targetType) {
+		return true
+	}
+	sourceName, sourceSize, sourceRest := normalizeColumnType(sourceType)
+	targetName, targetSize, targetRest := normalizeColumnType(targetType)
+	return sourceName == targetName && sourceRest == targetRest &&
+		(sourceSize == targetSize || sourceSize == "" || targetSize == "")
+}
+
+// columnTypeAliases maps the names engines give the same type to one of
+// them. MySQL's datetime is a timestamp without a time zone in Postgres.
+var columnTypeAliases = map[string]string{
+	"int": "integer", "int4": "integer", "serial": "integer", "serial4": "integer",
+	"int8": "bigint", "bigserial": "bigint", "serial8": "bigint",
+	"int2": "smallint", "smallserial": "smallint", "serial2": "smallint",
+	"bool":              "boolean",
+	"character varying": "varchar", "character": "char", "bpchar": "char",
+	"decimal": "numeric", "dec": "numeric", "fixed": "numeric",
+	"double": "double precision", "float8": "double precision", "float4": "real",
+	"datetime": "timestamp", "timestamp without time zone": "timestamp",
+	"timestamp with time zone": "timestamptz", "time without time zone": "time",
+	"time with time zone": "timetz",
+}
+
+// normalizeColumnType splits a column type into its name under
+// columnTypeAliases, its length or precision and what follows, like
+// unsigned. Integers lose their display width, e.g. MySQL's int(11).
+func normalizeColumnType(dataType string) (name, size, rest string) {
+	name = strings.ToLower(strings.Join(strings.Fields(dataType), " "))
+	if open := strings.Index(name, "("); open >= 0 {
+		if end := strings.Index(name[open:], ")"); end >= 0 {
+			size = strings.ReplaceAll(name[open+1:open+end], " ", "")
+			name, rest = strings.TrimSpace(name[:open]), strings.TrimSpace(name[open+end+1:])
+		}
+	}
+	// Postgres writes the time zone after the precision, timestamp(3) with time zone
+	if strings.HasSuffix(rest, "time zone") {
+		name, rest = name+" "+rest, ""
+	}
+	if name == "tinyint" && size == "1" && rest == "" {
+		return "boolean", "", ""
+	}
+	if alias, ok := columnTypeAliases[name]; ok {
+		name = alias
+	}
+	switch name {
+	case "integer", "bigint", "smallint", "mediumint", "tinyint":
+		size = ""
+	}
+	return name, size, rest
+}
+
+// postgresCast matches a cast at the end of a Postgres default, e.g.
+// ::character varying or ::text[]
+var postgresCast = regexp.MustCompile(`(?i)::[a-z_ ]+(\([0-9, ]*\))?(\[\])*$`)
+
+// currentTime matches the dialects' spellings of the current date or time,
+// with an optional precision
+var currentTime = regexp.MustCompile(`^(now|current_timestamp|localtimestamp|transaction_timestamp|curdate|current_date|curtime|current_time|datetime\('now'\)|date\('now'\)|time\('now'\))(\((\d*)\))?$`)
+
+var currentTimeNames = map[string]string{
+	"now": "current_timestamp", "localtimestamp": "current_timestamp", "transaction_timestamp": "current_timestamp",
+	"datetime('now')": "current_timestamp", "curdate": "current_date", "date('now')": "current_date",
+	"curtime": "current_time", "time('now')": "current_time",
+}
+
+// normalizeDefault returns a column default in a form comparable across
+// dialects: without Postgres casts, enclosing parentheses or quotes, with the
+// current time spelt one way and numbers and booleans as their value. A NULL
+// default is no default.
+func normalizeDefault(value string) string {
+	value = strings.TrimSpace(value)
+	for {
+		trimmed := strings.TrimSpace(postgresCast.ReplaceAllString(value, ""))
+		if enclosed(trimmed) {
+			trimmed = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+		}
+		if trimmed == value {
+			break
+		}
+		value = trimmed
+	}
+
+	lower := strings.ToLower(value)
+	if m := currentTime.FindStringSubmatch(lower); m != nil {
+		name := m[1]
+		if alias, ok := currentTimeNames[name]; ok {
+			name = alias
+		}
+		if m[3] != "" {
+			name += "(" + m[3] + ")"
+		}
+		return name
+	}
+	switch lower {
+	case "null":
+		return ""
+	case "true":
+		return "1"
+	case "false":
+		return "0"
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	} else if len(lower) >= 3 && strings.HasPrefix(lower, "b'") && lower[len(lower)-1] == '\'' {
+		// MySQL bit literal, e.g. b'1'
+		if n, err := strconv.ParseUint(lower[2:len(lower)-1], 2, 64); err == nil {
+			return strconv.FormatUint(n, 10)
+		}
+	}
+	if r, ok := new(big.Rat).SetString(value); ok {
+		return r.RatString()
+	}
+	return value
+}
+
+// enclosed reports whether a value is wrapped in a pair of parentheses
+func enclosed(value string) bool {
+	if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+		return false
+	}
+	depth := 0
+	for i, c := range value {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i < len(value)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+func compareTableOptions(tableName string, sourceOptions, targetOptions TableOptions, compareOptions CompareOptions) []Difference {
+	differences := []Difference{}
+	options := []struct {
+		property       string
+		source, target string
+	}{
+		{"engine", sourceOptions.Engine, targetOptions.Engine},
+		{"charset", sourceOptions.Charset, targetOptions.Charset},
+		{"collation", sourceOptions.Collation, targetOptions.Collation},
+		{"row format", sourceOptions.RowFormat, targetOptions.RowFormat},
+	}
+
+	for _, option := range options {
+		if compareOptions.IgnoreCollation && (option.property == "charset" || option.property == "collation") {
+			continue
+		}
+		if option.source != option.target {
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "table", ObjectName: tableName, Kind: DiffModified,
+				Property: option.property, Source: option.source, Target: option.target,
+				Message: fmt.Sprintf("Table '%s' has different %s: source='%s', target='%s'",
+					tableName, option.property, option.source, option.target),
+			})
+		}
+	}
+
+	return differences
+}
+
+// compareColumnOrder reports columns present in both tables at different
+// positions. Positions count the columns in table order, as SELECT * does.
+func compareColumnOrder(tableName string, sourceColumns, targetColumns []ColumnSchema) []Difference {
+	differences := []Difference{}
+	targetPositions := make(map[string]int)
+	for i, col := range targetColumns {
+		targetPositions[col.Name] = i + 1
+	}
+
+	for i, col := range sourceColumns {
+		targetPosition, exists := targetPositions[col.Name]
+		if !exists || targetPosition == i+1 {
+			continue
+		}
+		differences = append(differences, Difference{
+			Table: tableName, ObjectType: "column", ObjectName: col.Name, Kind: DiffModified,
+			Property: "position", Source: fmt.Sprint(i + 1), Target: fmt.Sprint(targetPosition),
+			Message: fmt.Sprintf("Column '%s.%s' has different position: source=%d, target=%d",
+				tableName, col.Name, i+1, targetPosition),
+		})
+	}
+
+	return differences
+}
+
+// compareUniqueConstraints matches constraints by their columns, since
+// generated constraint names often differ between databases
+func compareUniqueConstraints(tableName string, sourceConstraints, targetConstraints []UniqueConstraintSchema) []Difference {
+	differences := []Difference{}
+	sourceConstraintMap := make(map[string]UniqueConstraintSchema)
+	for _, constraint := range sourceConstraints {
+		sourceConstraintMap[strings.Join(constraint.Columns, ", ")] = constraint
+	}
+	targetConstraintMap := make(map[string]UniqueConstraintSchema)
+	for _, constraint := range targetConstraints {
+		targetConstraintMap[strings.Join(constraint.Columns, ", ")] = constraint
+	}
+
+	for columns, sourceConstraint := range sourceConstraintMap {
+		targetConstraint, exists := targetConstraintMap[columns]
+		if !exists {
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "unique constraint", ObjectName: sourceConstraint.Name, Kind: DiffMissing,
+				Message: fmt.Sprintf("Unique constraint '%s' on columns (%s) exists in source but not in target for table '%s'",
+					sourceConstraint.Name, columns, tableName),
+			})
+		} else if sourceConstraint.Name != targetConstraint.Name {
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "unique constraint", ObjectName: sourceConstraint.Name, Kind: DiffModified,
+				Property: "name", Source: sourceConstraint.Name, Target: targetConstraint.Name,
+				Message: fmt.Sprintf("Unique constraint on columns (%s) has different names in table '%s': source='%s', target='%s'",
+					columns, tableName, sourceConstraint.Name, targetConstraint.Name),
+			})
+		}
+	}
+
+	for columns, targetConstraint := range targetConstraintMap {
+		if _, exists := sourceConstraintMap[columns]; !exists {
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "unique constraint", ObjectName: targetConstraint.Name, Kind: DiffExtra,
+				Message: fmt.Sprintf("Unique constraint '%s' on columns (%s) exists in target but not in source for table '%s'",
+					targetConstraint.Name, columns, tableName),
+			})
+		}
 	}
 
-	return hasDifferences, differences
+	return differences
+}
+
+// withoutConstraintIndexes returns the indexes of a table that don't back
+// a unique constraint, those are compared by compareUniqueConstraints
+func withoutConstraintIndexes(schema TableSchema) []IndexSchema {
+	backing := make(map[string]bool)
+	for _, constraint := range schema.UniqueConstraints {
+		backing[constraint.Index] = true
+	}
+
+	indexes := []IndexSchema{}
+	for _, idx := range schema.Indexes {
+		if !backing[idx.Name] {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
 }
 
-func compareIndexes(tableName string, sourceIndexes, targetIndexes []IndexSchema) bool {
-	hasDifferences := false
+// addUniqueConstraintColumn adds a column to the unique constraint name,
+// which is created if it isn't the last one. Columns must come grouped
+// by constraint and in order.
+func (s *TableSchema) addUniqueConstraintColumn(name, index, column string) {
+	n := len(s.UniqueConstraints)
+	if n == 0 || s.UniqueConstraints[n-1].Name != name {
+		s.UniqueConstraints = append(s.UniqueConstraints, UniqueConstraintSchema{Name: name, Index: index})
+		n++
+	}
+	s.UniqueConstraints[n-1].Columns = append(s.UniqueConstraints[n-1].Columns, column)
+}
+
+func compareIndexes(tableName string, sourceIndexes, targetIndexes []IndexSchema) []Difference {
+	differences := []Difference{}
 	// Create maps of indexes by name and column for more efficient comparison
 	sourceIndexMap := make(map[string]map[string]IndexSchema)
 	targetIndexMap := make(map[string]map[string]IndexSchema)
@@ -117,27 +454,43 @@ func compareIndexes(tableName string, sourceIndexes, targetIndexes []IndexSchema
 			for col := range sourceIdx {
 				columns = append(columns, col)
 			}
-			fmt.Printf("Index '%s' on columns %v exists in source but not in target for table '%s'\n",
-				name, columns, tableName)
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "index", ObjectName: name, Kind: DiffMissing,
+				Message: fmt.Sprintf("Index '%s' on columns %v exists in source but not in target for table '%s'",
+					name, columns, tableName),
+			})
 			continue
 		}
 
 		// Index exists in both, compare columns
 		for col, srcIdxCol := range sourceIdx {
 			if _, exists := targetIndexMap[name][col]; !exists {
-				fmt.Printf("Column '%s' of index '%s' exists in source but not in target for table '%s'\n",
-					col, name, tableName)
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "index", ObjectName: name, Kind: DiffModified,
+					Property: "columns", Source: col,
+					Message: fmt.Sprintf("Column '%s' of index '%s' exists in source but not in target for table '%s'",
+						col, name, tableName),
+				})
 			} else if srcIdxCol.NonUnique != targetIndexMap[name][col].NonUnique {
-				fmt.Printf("Index '%s' on column '%s' has different uniqueness in table '%s': "+
-					"source=%v, target=%v\n", name, col, tableName,
-					srcIdxCol.NonUnique == 0, targetIndexMap[name][col].NonUnique == 0)
+				sourceUnique := srcIdxCol.NonUnique == 0
+				targetUnique := targetIndexMap[name][col].NonUnique == 0
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "index", ObjectName: name, Kind: DiffModified,
+					Property: "unique", Source: fmt.Sprint(sourceUnique), Target: fmt.Sprint(targetUnique),
+					Message: fmt.Sprintf("Index '%s' on column '%s' has different uniqueness in table '%s': "+
+						"source=%v, target=%v", name, col, tableName, sourceUnique, targetUnique),
+				})
 			}
 		}
 
 		for col := range targetIndexMap[name] {
 			if _, exists := sourceIdx[col]; !exists {
-				fmt.Printf("Column '%s' of index '%s' exists in target but not in source for table '%s'\n",
-					col, name, tableName)
+				differences = append(differences, Difference{
+					Table: tableName, ObjectType: "index", ObjectName: name, Kind: DiffModified,
+					Property: "columns", Target: col,
+					Message: fmt.Sprintf("Column '%s' of index '%s' exists in target but not in source for table '%s'",
+						col, name, tableName),
+				})
 			}
 		}
 	}
@@ -149,16 +502,19 @@ func compareIndexes(tableName string, sourceIndexes, targetIndexes []IndexSchema
 			for col := range targetIdx {
 				columns = append(columns, col)
 			}
-			fmt.Printf("Index '%s' on columns %v exists in target but not in source for table '%s'\n",
-				name, columns, tableName)
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "index", ObjectName: name, Kind: DiffExtra,
+				Message: fmt.Sprintf("Index '%s' on columns %v exists in target but not in source for table '%s'",
+					name, columns, tableName),
+			})
 		}
 	}
 
-	return hasDifferences
+	return differences
 }
 
-func compareForeignKeys(tableName string, sourceFKs, targetFKs []ForeignKeySchema) bool {
-	hasDifferences := false
+func compareForeignKeys(tableName string, sourceFKs, targetFKs []ForeignKeySchema) []Difference {
+	differences := []Difference{}
 	sourceFKMap := make(map[string]ForeignKeySchema)
 	targetFKMap := make(map[string]ForeignKeySchema)
 
@@ -176,32 +532,450 @@ func compareForeignKeys(tableName string, sourceFKs, targetFKs []ForeignKeySchem
 	// Check for foreign keys in source but not in target
 	for key, fk := range sourceFKMap {
 		if _, exists := targetFKMap[key]; !exists {
-			fmt.Printf("Foreign key '%s' from '%s.%s' to '%s.%s' exists in source but not in target\n",
-				fk.Name, tableName, fk.ColumnName, fk.ReferencedTable, fk.ReferencedColumn)
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "foreign key", ObjectName: fk.Name, Kind: DiffMissing,
+				Message: fmt.Sprintf("Foreign key '%s' from '%s.%s' to '%s.%s' exists in source but not in target",
+					fk.Name, tableName, fk.ColumnName, fk.ReferencedTable, fk.ReferencedColumn),
+			})
 		}
 	}
 
 	// Check for foreign keys in target but not in source
 	for key, fk := range targetFKMap {
 		if _, exists := sourceFKMap[key]; !exists {
-			fmt.Printf("Foreign key '%s' from '%s.%s' to '%s.%s' exists in target but not in source\n",
-				fk.Name, tableName, fk.ColumnName, fk.ReferencedTable, fk.ReferencedColumn)
+			differences = append(differences, Difference{
+				Table: tableName, ObjectType: "foreign key", ObjectName: fk.Name, Kind: DiffExtra,
+				Message: fmt.Sprintf("Foreign key '%s' from '%s.%s' to '%s.%s' exists in target but not in source",
+					fk.Name, tableName, fk.ColumnName, fk.ReferencedTable, fk.ReferencedColumn),
+			})
 		}
 	}
 
-	return hasDifferences
+	return differences
 }
 
-func getAllTableSchemas(adapter DatabaseAdapter, db *sql.DB, tables []string) (map[string]TableSchema, error) {
+func compareViews(sourceViews, targetViews []ViewSchema) []Difference {
+	differences := []Difference{}
+	targetViewMap := make(map[string]ViewSchema)
+	for _, view := range targetViews {
+		targetViewMap[view.Name] = view
+	}
+
+	sourceViewMap := make(map[string]ViewSchema)
+	for _, view := range sourceViews {
+		sourceViewMap[view.Name] = view
+
+		targetView, exists := targetViewMap[view.Name]
+		if !exists {
+			differences = append(differences, Difference{
+				ObjectType: "view", ObjectName: view.Name, Kind: DiffMissing,
+				Message: fmt.Sprintf("View '%s' exists in source but not in target", view.Name),
+			})
+		} else if normalizeDefinition(view.Definition) != normalizeDefinition(targetView.Definition) {
+			differences = append(differences, Difference{
+				ObjectType: "view", ObjectName: view.Name, Kind: DiffModified,
+				Property: "definition", Source: view.Definition, Target: targetView.Definition,
+				Message: fmt.Sprintf("View '%s' has a different definition", view.Name),
+			})
+		}
+	}
+
+	for _, view := range targetViews {
+		if _, exists := sourceViewMap[view.Name]; !exists {
+			differences = append(differences, Difference{
+				ObjectType: "view", ObjectName: view.Name, Kind: DiffExtra,
+				Message: fmt.Sprintf("View '%s' exists in target but not in source", view.Name),
+			})
+		}
+	}
+
+	return differences
+}
+
+// routineLabel capitalizes a routine's type to start a message with, e.g.
+// Procedure
+func routineLabel(routineType string) string {
+	routineType = cmp.Or(routineType, "routine")
+	return strings.ToUpper(routineType[:1]) + routineType[1:]
+}
+
+func compareRoutines(sourceRoutines, targetRoutines []RoutineSchema) []Difference {
+	differences := []Difference{}
+	targetRoutineMap := make(map[string]RoutineSchema)
+	for _, routine := range targetRoutines {
+		targetRoutineMap[routine.Type+" "+routine.Name] = routine
+	}
+
+	sourceRoutineMap := make(map[string]RoutineSchema)
+	for _, routine := range sourceRoutines {
+		key := routine.Type + " " + routine.Name
+		sourceRoutineMap[key] = routine
+		label := routineLabel(routine.Type)
+
+		targetRoutine, exists := targetRoutineMap[key]
+		if !exists {
+			differences = append(differences, Difference{
+				ObjectType: routine.Type, ObjectName: routine.Name, Kind: DiffMissing,
+				Message: fmt.Sprintf("%s '%s' exists in source but not in target", label, routine.Name),
+			})
+		} else if normalizeRoutineDefinition(routine.Definition) != normalizeRoutineDefinition(targetRoutine.Definition) {
+			differences = append(differences, Difference{
+				ObjectType: routine.Type, ObjectName: routine.Name, Kind: DiffModified,
+				Property: "definition", Source: routine.Definition, Target: targetRoutine.Definition,
+				Message: fmt.Sprintf("%s '%s' has a different definition", label, routine.Name),
+			})
+		}
+	}
+
+	for _, routine := range targetRoutines {
+		if _, exists := sourceRoutineMap[routine.Type+" "+routine.Name]; !exists {
+			label := routineLabel(routine.Type)
+			differences = append(differences, Difference{
+				ObjectType: routine.Type, ObjectName: routine.Name, Kind: DiffExtra,
+				Message: fmt.Sprintf("%s '%s' exists in target but not in source", label, routine.Name),
+			})
+		}
+	}
+
+	return differences
+}
+
+// compareSequences compares sequence settings and, if compareValues is set,
+// reports current values that differ by more than tolerance. Auto-increment
+// counters only exist once rows were inserted, so a counter missing on one
+// side is neither reported nor compared; the table itself is compared
+// separately.
+func compareSequences(sourceSequences, targetSequences []SequenceSchema, compareValues bool, tolerance int64) []Difference {
+	differences := []Difference{}
+	sourceSequenceMap := make(map[string]SequenceSchema)
+	for _, sequence := range sourceSequences {
+		sourceSequenceMap[sequence.Name] = sequence
+	}
+	targetSequenceMap := make(map[string]SequenceSchema)
+	for _, sequence := range targetSequences {
+		targetSequenceMap[sequence.Name] = sequence
+	}
+
+	for name, sourceSequence := range sourceSequenceMap {
+		targetSequence, exists := targetSequenceMap[name]
+		if !exists && sourceSequence.Table == "" {
+			differences = append(differences, Difference{
+				ObjectType: "sequence", ObjectName: name, Kind: DiffMissing,
+				Message: fmt.Sprintf("Sequence '%s' exists in source but not in target", name),
+			})
+			continue
+		}
+
+		settings := []struct {
+			property       string
+			source, target int64
+		}{
+			{"increment", sourceSequence.Increment, targetSequence.Increment},
+			{"min value", sourceSequence.MinValue, targetSequence.MinValue},
+			{"max value", sourceSequence.MaxValue, targetSequence.MaxValue},
+		}
+		for _, setting := range settings {
+			if exists && setting.source != setting.target {
+				differences = append(differences, Difference{
+					ObjectType: "sequence", ObjectName: name, Kind: DiffModified,
+					Property: setting.property, Source: fmt.Sprint(setting.source), Target: fmt.Sprint(setting.target),
+					Message: fmt.Sprintf("Sequence '%s' has different %s: source=%d, target=%d",
+						name, setting.property, setting.source, setting.target),
+				})
+			}
+		}
+
+		if compareValues && exists {
+			differences = append(differences, compareSequenceValues(name, sourceSequence.CurrentValue, targetSequence.CurrentValue, tolerance)...)
+		}
+	}
+
+	for name, targetSequence := range targetSequenceMap {
+		if _, exists := sourceSequenceMap[name]; exists {
+			continue
+		}
+		if targetSequence.Table == "" {
+			differences = append(differences, Difference{
+				ObjectType: "sequence", ObjectName: name, Kind: DiffExtra,
+				Message: fmt.Sprintf("Sequence '%s' exists in target but not in source", name),
+			})
+		}
+	}
+
+	return differences
+}
+
+func compareSequenceValues(name string, source, target, tolerance int64) []Difference {
+	gap := target - source
+	if gap >= -tolerance && gap <= tolerance {
+		return nil
+	}
+
+	direction := "ahead of"
+	if gap < 0 {
+		direction, gap = "behind", -gap
+	}
+	return []Difference{{
+		ObjectType: "sequence", ObjectName: name, Kind: DiffModified,
+		Property: "current value", Source: fmt.Sprint(source), Target: fmt.Sprint(target),
+		Message: fmt.Sprintf("Sequence '%s' in target is %s source by %d: source=%d, target=%d",
+			name, direction, gap, source, target),
+	}}
+}
+
+func compareTypes(sourceTypes, targetTypes []TypeSchema) []Difference {
+	differences := []Difference{}
+	targetTypeMap := make(map[string]TypeSchema)
+	for _, t := range targetTypes {
+		targetTypeMap[t.Kind+" "+t.Name] = t
+	}
+
+	sourceTypeMap := make(map[string]TypeSchema)
+	for _, sourceType := range sourceTypes {
+		key := sourceType.Kind + " " + sourceType.Name
+		sourceTypeMap[key] = sourceType
+		label := strings.ToUpper(sourceType.Kind[:1]) + sourceType.Kind[1:]
+
+		targetType, exists := targetTypeMap[key]
+		if !exists {
+			differences = append(differences, Difference{
+				ObjectType: sourceType.Kind, ObjectName: sourceType.Name, Kind: DiffMissing,
+				Message: fmt.Sprintf("%s '%s' exists in source but not in target", label, sourceType.Name),
+			})
+			continue
+		}
+
+		if sourceType.Kind == "enum" {
+			differences = append(differences, compareEnumLabels(sourceType.Name, sourceType.Labels, targetType.Labels)...)
+			continue
+		}
+
+		properties := []struct {
+			property       string
+			source, target string
+		}{
+			{"base type", sourceType.BaseType, targetType.BaseType},
+			{"not null", fmt.Sprint(sourceType.NotNull), fmt.Sprint(targetType.NotNull)},
+			{"default", sourceType.Default, targetType.Default},
+			{"constraints", sourceType.Constraints, targetType.Constraints},
+		}
+		for _, property := range properties {
+			if property.property == "default" && normalizeDefault(property.source) == normalizeDefault(property.target) {
+				continue
+			}
+			if property.source != property.target {
+				differences = append(differences, Difference{
+					ObjectType: sourceType.Kind, ObjectName: sourceType.Name, Kind: DiffModified,
+					Property: property.property, Source: property.source, Target: property.target,
+					Message: fmt.Sprintf("%s '%s' has different %s: source='%s', target='%s'",
+						label, sourceType.Name, property.property, property.source, property.target),
+				})
+			}
+		}
+	}
+
+	for _, targetType := range targetTypes {
+		if _, exists := sourceTypeMap[targetType.Kind+" "+targetType.Name]; !exists {
+			label := strings.ToUpper(targetType.Kind[:1]) + targetType.Kind[1:]
+			differences = append(differences, Difference{
+				ObjectType: targetType.Kind, ObjectName: targetType.Name, Kind: DiffExtra,
+				Message: fmt.Sprintf("%s '%s' exists in target but not in source", label, targetType.Name),
+			})
+		}
+	}
+
+	return differences
+}
+
+// compareEnumLabels reports labels missing from either side, and labels
+// present in both that sort in a different order
+func compareEnumLabels(name string, sourceLabels, targetLabels []string) []Difference {
+	differences := []Difference{}
+	var missing, extra, sourceCommon, targetCommon []string
+	for _, label := range sourceLabels {
+		if contains(targetLabels, label) {
+			sourceCommon = append(sourceCommon, label)
+		} else {
+			missing = append(missing, label)
+		}
+	}
+	for _, label := range targetLabels {
+		if contains(sourceLabels, label) {
+			targetCommon = append(targetCommon, label)
+		} else {
+			extra = append(extra, label)
+		}
+	}
+
+	if len(missing) > 0 {
+		differences = append(differences, Difference{
+			ObjectType: "enum", ObjectName: name, Kind: DiffModified,
+			Property: "labels", Source: strings.Join(missing, ", "),
+			Message: fmt.Sprintf("Enum '%s' has labels in source but not in target: %s", name, strings.Join(missing, ", ")),
+		})
+	}
+	if len(extra) > 0 {
+		differences = append(differences, Difference{
+			ObjectType: "enum", ObjectName: name, Kind: DiffModified,
+			Property: "labels", Target: strings.Join(extra, ", "),
+			Message: fmt.Sprintf("Enum '%s' has labels in target but not in source: %s", name, strings.Join(extra, ", ")),
+		})
+	}
+	if !reflect.DeepEqual(sourceCommon, targetCommon) {
+		differences = append(differences, Difference{
+			ObjectType: "enum", ObjectName: name, Kind: DiffModified,
+			Property: "label order", Source: strings.Join(sourceCommon, ", "), Target: strings.Join(targetCommon, ", "),
+			Message: fmt.Sprintf("Enum '%s' has labels in a different order: source=(%s), target=(%s)",
+				name, strings.Join(sourceCommon, ", "), strings.Join(targetCommon, ", ")),
+		})
+	}
+
+	return differences
+}
+
+// comparePrivileges reports, per grantee and table, the privileges granted
+// on only one side. With ignoreHost, MySQL grantees are compared by user
+// name, leaving out the host they connect from.
+func comparePrivileges(sourcePrivileges, targetPrivileges []PrivilegeSchema, ignoreHost bool) []Difference {
+	differences := []Difference{}
+	if ignoreHost {
+		sourcePrivileges = withoutGranteeHosts(sourcePrivileges)
+		targetPrivileges = withoutGranteeHosts(targetPrivileges)
+	}
+	granted := func(privileges []PrivilegeSchema) map[PrivilegeSchema]bool {
+		set := make(map[PrivilegeSchema]bool)
+		for _, privilege := range privileges {
+			set[privilege] = true
+		}
+		return set
+	}
+	sourceGranted := granted(sourcePrivileges)
+	targetGranted := granted(targetPrivileges)
+
+	// Group the privileges missing on one side by grantee and table
+	type grant struct{ grantee, table string }
+	onlyIn := func(privileges []PrivilegeSchema, other map[PrivilegeSchema]bool) ([]grant, map[grant][]string) {
+		var order []grant
+		missing := make(map[grant][]string)
+		for _, privilege := range privileges {
+			if other[privilege] {
+				continue
+			}
+			key := grant{privilege.Grantee, privilege.Table}
+			if _, seen := missing[key]; !seen {
+				order = append(order, key)
+			}
+			if !contains(missing[key], privilege.Privilege) {
+				missing[key] = append(missing[key], privilege.Privilege)
+			}
+		}
+		return order, missing
+	}
+
+	describe := func(g grant) string {
+		if g.table == "" {
+			return "the database"
+		}
+		return fmt.Sprintf("table '%s'", g.table)
+	}
+
+	order, missing := onlyIn(sourcePrivileges, targetGranted)
+	for _, g := range order {
+		list := strings.Join(missing[g], ", ")
+		differences = append(differences, Difference{
+			Table: g.table, ObjectType: "privilege", ObjectName: g.grantee, Kind: DiffMissing, Source: list,
+			Message: fmt.Sprintf("Grantee %s has %s on %s in source but not in target", g.grantee, list, describe(g)),
+		})
+	}
+
+	order, extra := onlyIn(targetPrivileges, sourceGranted)
+	for _, g := range order {
+		list := strings.Join(extra[g], ", ")
+		differences = append(differences, Difference{
+			Table: g.table, ObjectType: "privilege", ObjectName: g.grantee, Kind: DiffExtra, Target: list,
+			Message: fmt.Sprintf("Grantee %s has %s on %s in target but not in source", g.grantee, list, describe(g)),
+		})
+	}
+
+	return differences
+}
+
+// withoutGranteeHosts returns privileges with the host of MySQL grantees,
+// 'user'@'host', left out. Other grantees are returned as they are.
+func withoutGranteeHosts(privileges []PrivilegeSchema) []PrivilegeSchema {
+	result := make([]PrivilegeSchema, len(privileges))
+	for i, privilege := range privileges {
+		if at := strings.LastIndex(privilege.Grantee, "@'"); at > 0 && strings.HasSuffix(privilege.Grantee, "'") {
+			privilege.Grantee = privilege.Grantee[:at]
+		}
+		result[i] = privilege
+	}
+	return result
+}
+
+// tableErrors are the tables getAllTableSchemas couldn't read, with why
+type tableErrors map[string]error
+
+func (e tableErrors) Error() string {
+	tables := e.tables()
+	messages := make([]string, len(tables))
+	for i, table := range tables {
+		messages[i] = fmt.Sprintf("table %s: %v", table, e[table])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// tables returns the tables of e in order
+func (e tableErrors) tables() []string {
+	tables := make([]string, 0, len(e))
+	for table := range e {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (e tableErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// getAllTableSchemas reads the schemas of the tables. A table whose schema
+// can't be read doesn't stop the others: it's left out of the schemas, and
+// the error is a tableErrors of all such tables.
+func getAllTableSchemas(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tables []string) (map[string]TableSchema, error) {
 	schemas := make(map[string]TableSchema)
+	failed := make(tableErrors)
 
 	for _, table := range tables {
-		schema, err := adapter.GetTableSchema(db, table)
+		if err := validateIdentifier(table); err != nil {
+			failed[table] = err
+			continue
+		}
+		schema, err := adapter.GetTableSchema(ctx, db, table)
 		if err != nil {
-			return nil, err
+			if ctx.Err() != nil {
+				return schemas, err
+			}
+			failed[table] = err
+			continue
+		}
+		for _, col := range schema.Columns {
+			if err = validateIdentifier(col.Name); err != nil {
+				failed[table] = fmt.Errorf("column: %w", err)
+				break
+			}
+		}
+		if err == nil {
+			schemas[table] = schema
 		}
-		schemas[table] = schema
 	}
 
+	if len(failed) > 0 {
+		return schemas, failed
+	}
 	return schemas, nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Environment variables holding the passwords, so they don't have to be
+// part of the connection strings on the command line
+const (
+	sourcePasswordEnv = "MUDROCK_SOURCE_PASSWORD"
+	targetPasswordEnv = "MUDROCK_TARGET_PASSWORD"
+	basePasswordEnv   = "MUDROCK_BASE_PASSWORD"
+)
+
+// applyPassword replaces the password in connStr with one typed at the
+// terminal when prompt is set, or else with the one in envVar. connStr is
+// returned unchanged if neither provides a password.
+func applyPassword(adapter DatabaseAdapter, connStr, side, envVar string, prompt bool) (string, error) {
+	password := os.Getenv(envVar)
+	if prompt {
+		var err error
+		password, err = readPassword(fmt.Sprintf("%s password: ", side))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if password == "" {
+		return connStr, nil
+	}
+	return adapter.SetPassword(connStr, password)
+}
+
+// passwordOption matches the password of key=value connection strings
+var passwordOption = regexp.MustCompile(`(^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactConnStr returns a connection string without its password: the
+// user info of a URL or of a MySQL DSN, or the password key of key=value
+// pairs. Paths, like SQLite's, are returned unchanged.
+func redactConnStr(connStr string) string {
+	if strings.Contains(connStr, "://") {
+		if u, err := url.Parse(connStr); err == nil {
+			if u.User != nil {
+				u.User = url.User(u.User.Username())
+			}
+			query := u.Query()
+			if query.Has("password") {
+				query.Del("password")
+				u.RawQuery = query.Encode()
+			}
+			return u.String()
+		}
+	}
+	if passwordOption.MatchString(connStr) {
+		return strings.TrimSpace(passwordOption.ReplaceAllString(connStr, "$1"))
+	}
+	// [user[:password]@][net[(addr)]]/dbname, the password can contain '@'
+	slash := strings.LastIndex(connStr, "/")
+	if slash < 0 {
+		slash = len(connStr)
+	}
+	at := strings.LastIndex(connStr[:slash], "@")
+	if at < 0 {
+		return connStr
+	}
+	user, _, _ := strings.Cut(connStr[:at], ":")
+	return user + connStr[at:]
+}
+
+// readPassword reads a line from the terminal without echoing it
+func readPassword(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", errors.New("--prompt-passwords needs a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week
+type cronSchedule struct {
+	minute, hour, dom, month, dow [64]bool
+
+	// Like cron, a day matches either field when both are restricted
+	domRestricted, dowRestricted bool
+}
+
+// cronShortcuts are the @ forms cron accepts
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// parseCron parses a cron expression such as "*/10 * * * *", "0 3 * * 1-5"
+// or "@daily"
+func parseCron(expr string) (*cronSchedule, error) {
+	if shortcut, ok := cronShortcuts[strings.TrimSpace(expr)]; ok {
+		expr = shortcut
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day month weekday)", expr)
+	}
+
+	s := &cronSchedule{}
+	ranges := []struct {
+		set      *[64]bool
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, field := range fields {
+		if err := parseCronField(field, ranges[i].set, ranges[i].min, ranges[i].max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField sets the values of a comma-separated list of *, N, N-M,
+// each optionally followed by /step
+func parseCronField(field string, set *[64]bool, min, max int) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return fmt.Errorf("invalid value %q", lowPart)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return fmt.Errorf("invalid value %q", highPart)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// matches reports whether the schedule fires in the minute of t
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[t.Month()] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
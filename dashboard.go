@@ -0,0 +1,206 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplates = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"duration": runDuration,
+	"diffCount": func(run Run) int {
+		if run.Summary == nil {
+			return 0
+		}
+		return len(run.Summary.Differences())
+	},
+}).Parse(dashboardHTML))
+
+// dashboardRuns is how many runs the pages list and chart
+const dashboardRuns = 50
+
+// trendColors are the colors of the lines of a trend chart, one per table
+var trendColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// trendChart is an SVG chart of the row count deltas of a job's tables
+// over its runs
+type trendChart struct {
+	Width, Height int
+	Zero          int // y of the zero line
+	Max           int // largest delta, in either direction
+	Lines         []trendLine
+}
+
+// trendLine is one table's line in a trend chart
+type trendLine struct {
+	Table  string
+	Color  string
+	Points string // SVG polyline points
+	Last   int    // delta in the latest run
+}
+
+func runDuration(run Run) string {
+	if run.Finished.IsZero() {
+		return time.Since(run.Started).Round(time.Second).String() + " so far"
+	}
+	return run.Finished.Sub(run.Started).Round(time.Millisecond).String()
+}
+
+// newTrendChart charts the target minus source row counts of every table
+// that differed in any of runs, which are newest first. Runs without a
+// summary are left out.
+func newTrendChart(runs []Run) trendChart {
+	chart := trendChart{Width: 800, Height: 240, Zero: 120}
+	var finished []Run
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Summary != nil {
+			finished = append(finished, runs[i])
+		}
+	}
+
+	deltas := make(map[string][]int)
+	for i, run := range finished {
+		for table, counts := range run.Summary.DifferentRowCounts {
+			if deltas[table] == nil {
+				deltas[table] = make([]int, len(finished))
+			}
+			delta := counts.Target - counts.Source
+			deltas[table][i] = delta
+			chart.Max = max(chart.Max, delta, -delta)
+		}
+	}
+	if chart.Max == 0 {
+		return chart
+	}
+
+	tables := make([]string, 0, len(deltas))
+	for table := range deltas {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	step := float64(chart.Width)
+	if len(finished) > 1 {
+		step = float64(chart.Width) / float64(len(finished)-1)
+	}
+	middle := float64(chart.Zero)
+	for i, table := range tables {
+		var points []string
+		for j, delta := range deltas[table] {
+			y := middle - float64(delta)/float64(chart.Max)*middle
+			points = append(points, fmt.Sprintf("%.1f,%.1f", float64(j)*step, y))
+		}
+		chart.Lines = append(chart.Lines, trendLine{
+			Table:  table,
+			Color:  trendColors[i%len(trendColors)],
+			Points: strings.Join(points, " "),
+			Last:   deltas[table][len(finished)-1],
+		})
+	}
+	return chart
+}
+
+func (s *server) handleIndexPage(w http.ResponseWriter, r *http.Request) {
+	runs := s.store.list("")
+	latest := make(map[string]Run)
+	for _, run := range runs {
+		if _, ok := latest[run.Job]; !ok {
+			latest[run.Job] = run
+		}
+	}
+	renderPage(w, "index", struct {
+		Jobs   []*serveJob
+		Latest map[string]Run
+		Runs   []Run
+	}{s.jobs, latest, runs[:min(len(runs), dashboardRuns)]})
+}
+
+func (s *server) handleJobPage(w http.ResponseWriter, r *http.Request) {
+	var job *serveJob
+	for _, j := range s.jobs {
+		if j.Name == r.PathValue("name") {
+			job = j
+		}
+	}
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	runs := s.store.list(job.Name)
+	runs = runs[:min(len(runs), dashboardRuns)]
+	renderPage(w, "job", struct {
+		Job   *serveJob
+		Runs  []Run
+		Chart trendChart
+	}{job, runs, newTrendChart(runs)})
+}
+
+// rowView is a differing row on the run page, with the source and target
+// values of each differing column side by side
+type rowView struct {
+	Kind   string
+	Key    string
+	Values [][3]string // column, source value, target value
+}
+
+func rowViews(result RowDiffResult) []rowView {
+	views := make([]rowView, len(result.Rows))
+	for i, row := range result.Rows {
+		views[i] = rowView{Kind: row.Kind, Key: result.keyString(row)}
+		for j, col := range row.Columns {
+			value := [3]string{col}
+			if len(row.Source) == len(row.Columns) && len(row.Target) == len(row.Columns) {
+				value[1], value[2] = row.Source[j], row.Target[j]
+			}
+			views[i].Values = append(views[i].Values, value)
+		}
+	}
+	return views
+}
+
+func (s *server) handleRunPage(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.store.get(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Differences grouped by table, in the order Differences sorts them
+	type tableDiffs struct {
+		Table       string
+		Differences []Difference
+		Rows        []rowView
+	}
+	var tables []tableDiffs
+	if run.Summary != nil {
+		for _, diff := range run.Summary.Differences() {
+			if len(tables) == 0 || tables[len(tables)-1].Table != diff.Table {
+				tables = append(tables, tableDiffs{Table: diff.Table})
+			}
+			table := &tables[len(tables)-1]
+			table.Differences = append(table.Differences, diff)
+			if diff.Property == "rows" {
+				table.Rows = rowViews(run.Summary.RowDifferences[diff.Table])
+			}
+		}
+	}
+	renderPage(w, "run", struct {
+		Run    Run
+		Tables []tableDiffs
+	}{run, tables})
+}
+
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, name, data); err != nil {
+		slog.Warn("Failed to render page", "page", name, "error", err)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes in a
+// unified diff
+const diffContext = 3
+
+// tableDDL reconstructs the CREATE TABLE statement of a table, one line per
+// column, key, foreign key and, with inlineIndexes, index. Keys, indexes and
+// foreign keys are sorted by name so the statement only depends on the
+// schema, not on the order the database lists them in. quoteTable quotes
+// the names of the table and the tables its foreign keys reference.
+func tableDDL(schema TableSchema, adapter DatabaseAdapter, quoteTable func(string) string, ignoreCollation, inlineIndexes bool) []string {
+	quote := adapter.QuoteIdentifier
+	var items []string
+	for _, col := range schema.Columns {
+		items = append(items, columnDDL(col, adapter, ignoreCollation))
+	}
+
+	if len(schema.PrimaryKeys) > 0 {
+		items = append(items, "PRIMARY KEY ("+quoteList(schema.PrimaryKeys, quote)+")")
+	}
+
+	constraints := append([]UniqueConstraintSchema{}, schema.UniqueConstraints...)
+	sort.Slice(constraints, func(i, j int) bool { return constraints[i].Name < constraints[j].Name })
+	for _, constraint := range constraints {
+		items = append(items, fmt.Sprintf("CONSTRAINT %s UNIQUE (%s)", quote(constraint.Name), quoteList(constraint.Columns, quote)))
+	}
+
+	if inlineIndexes {
+		for _, idx := range groupIndexes(schema) {
+			keyword := "INDEX"
+			if idx.unique {
+				keyword = "UNIQUE INDEX"
+			}
+			items = append(items, fmt.Sprintf("%s %s (%s)", keyword, quote(idx.name), quoteList(idx.columns, quote)))
+		}
+	}
+
+	// Foreign key columns come one per row, in order
+	var fkNames []string
+	fkColumns := make(map[string][]string)
+	fkReferenced := make(map[string][]string)
+	fkTables := make(map[string]string)
+	for _, fk := range schema.ForeignKeys {
+		if _, seen := fkColumns[fk.Name]; !seen {
+			fkNames = append(fkNames, fk.Name)
+		}
+		fkColumns[fk.Name] = append(fkColumns[fk.Name], fk.ColumnName)
+		fkReferenced[fk.Name] = append(fkReferenced[fk.Name], fk.ReferencedColumn)
+		fkTables[fk.Name] = fk.ReferencedTable
+	}
+	sort.Strings(fkNames)
+	for _, name := range fkNames {
+		items = append(items, fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)", quote(name),
+			quoteList(fkColumns[name], quote), quoteTable(fkTables[name]), quoteList(fkReferenced[name], quote)))
+	}
+
+	lines := []string{"CREATE TABLE " + quoteTable(schema.Name) + " ("}
+	for i, item := range items {
+		if i < len(items)-1 {
+			item += ","
+		}
+		lines = append(lines, "  "+item)
+	}
+
+	closing := ")"
+	options := schema.Options
+	if options.Engine != "" {
+		closing += " ENGINE=" + options.Engine
+	}
+	if !ignoreCollation {
+		if options.Charset != "" {
+			closing += " DEFAULT CHARSET=" + options.Charset
+		}
+		if options.Collation != "" {
+			closing += " COLLATE=" + options.Collation
+		}
+	}
+	if options.RowFormat != "" {
+		closing += " ROW_FORMAT=" + options.RowFormat
+	}
+	return append(lines, closing+";")
+}
+
+// columnDDL is the definition of a column in a CREATE TABLE statement
+func columnDDL(col ColumnSchema, adapter DatabaseAdapter, ignoreCollation bool) string {
+	item := adapter.QuoteIdentifier(col.Name)
+	if col.DataType != "" {
+		item += " " + col.DataType
+	}
+	if col.Generated != "" {
+		storage := "VIRTUAL"
+		if strings.Contains(strings.ToUpper(col.Extra), "STORED GENERATED") {
+			storage = "STORED"
+		}
+		item += " GENERATED ALWAYS AS (" + col.Generated + ") " + storage
+	}
+	if col.Nullable == "NO" {
+		item += " NOT NULL"
+	}
+	if col.Default.Valid {
+		item += " DEFAULT " + columnDefault(col, adapter)
+	}
+	if extra := extraDDL(col.Extra); extra != "" {
+		item += " " + extra
+	} else if col.AutoIdentity {
+		item += " /* auto identity */"
+	}
+	if !ignoreCollation {
+		if col.Charset != "" {
+			item += " CHARACTER SET " + col.Charset
+		}
+		if col.Collation != "" {
+			item += " COLLATE " + col.Collation
+		}
+	}
+	return item
+}
+
+// extraDDL is the DDL of the attributes MySQL lists in a column's Extra:
+// AUTO_INCREMENT, ON UPDATE and INVISIBLE. DEFAULT_GENERATED only says the
+// default is an expression, which the default itself shows, and VIRTUAL or
+// STORED GENERATED go with the expression of a generated column.
+func extraDDL(extra string) string {
+	var attributes []string
+	words := strings.Fields(extra)
+	for i := 0; i < len(words); i++ {
+		switch word := strings.ToUpper(words[i]); word {
+		case "DEFAULT_GENERATED", "VIRTUAL", "STORED", "GENERATED":
+		case "ON":
+			// on update CURRENT_TIMESTAMP(3) runs to the end
+			attributes = append(attributes, "ON "+strings.ToUpper(strings.Join(words[i+1:], " ")))
+			i = len(words)
+		default:
+			attributes = append(attributes, word)
+		}
+	}
+	return strings.Join(attributes, " ")
+}
+
+// columnDefault is the SQL of a column's default. MySQL lists the literal
+// defaults of text and temporal columns unquoted, which are quoted unless
+// they're expressions: DEFAULT_GENERATED, CURRENT_TIMESTAMP or already
+// quoted, cast or called, as PostgreSQL and SQLite list every default.
+func columnDefault(col ColumnSchema, adapter DatabaseAdapter) string {
+	value := col.Default.String
+	if _, ok := adapter.(*MySQLAdapter); !ok || isNumericType(col.DataType) {
+		return value
+	}
+	upper := strings.ToUpper(value)
+	switch {
+	case strings.Contains(strings.ToUpper(col.Extra), "DEFAULT_GENERATED"),
+		upper == "NULL", strings.HasPrefix(upper, "CURRENT_TIMESTAMP"), strings.HasPrefix(upper, "NOW("),
+		strings.HasPrefix(value, "'"), strings.HasPrefix(value, "("),
+		strings.HasPrefix(upper, "B'"), strings.HasPrefix(upper, "X'"), strings.HasPrefix(upper, "0X"):
+		return value
+	}
+	return adapter.QuoteLiteral(value)
+}
+
+// ddlTableQuoter quotes table names in the DDL of schema dumps and diffs:
+// the database or schema of a qualified name apart from the table, but
+// without the one QuoteTable adds for a --schema, so the DDL of a database
+// doesn't depend on its name.
+func ddlTableQuoter(adapter DatabaseAdapter) func(string) string {
+	quote := adapter.QuoteIdentifier
+	var qualified bool
+	switch a := adapter.(type) {
+	case *MySQLAdapter:
+		qualified = a.qualified()
+	case *PostgreSQLAdapter:
+		qualified = a.qualified()
+	}
+	if !qualified {
+		return quote
+	}
+	return func(name string) string {
+		schema, table, ok := splitQualifiedName(name)
+		if !ok {
+			return quote(name)
+		}
+		return quote(schema) + "." + quote(table)
+	}
+}
+
+// tableIndex is an index with its columns, as tableDDL writes it
+type tableIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// groupIndexes collects the columns of the indexes that don't back a key or
+// unique constraint, which come one per row in order, sorted by index name
+func groupIndexes(schema TableSchema) []tableIndex {
+	var indexes []tableIndex
+	positions := make(map[string]int)
+	for _, idx := range withoutConstraintIndexes(schema) {
+		if idx.Name == "PRIMARY" {
+			continue // MySQL's primary key index
+		}
+		i, seen := positions[idx.Name]
+		if !seen {
+			i = len(indexes)
+			positions[idx.Name] = i
+			indexes = append(indexes, tableIndex{name: idx.Name, unique: idx.NonUnique == 0})
+		}
+		indexes[i].columns = append(indexes[i].columns, idx.ColumnName)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].name < indexes[j].name })
+	return indexes
+}
+
+// indexDDL returns the CREATE INDEX statements of a table's indexes, which
+// tableDDL leaves out without inlineIndexes
+func indexDDL(schema TableSchema, adapter DatabaseAdapter, quoteTable func(string) string) []string {
+	var statements []string
+	for _, idx := range groupIndexes(schema) {
+		statements = append(statements, createIndexStatement(idx, quoteTable(schema.Name), adapter.QuoteIdentifier))
+	}
+	return statements
+}
+
+// createIndexStatement is the CREATE INDEX statement of an index of the
+// table named quotedTable
+func createIndexStatement(idx tableIndex, quotedTable string, quote func(string) string) string {
+	keyword := "CREATE INDEX"
+	if idx.unique {
+		keyword = "CREATE UNIQUE INDEX"
+	}
+	return fmt.Sprintf("%s %s ON %s (%s);", keyword, quote(idx.name), quotedTable, quoteList(idx.columns, quote))
+}
+
+// tableDDLDiff is the unified diff of the source and target CREATE TABLE
+// statements. Unless column order matters, the target's columns are put in
+// the source's order first so reordering alone doesn't show. The target's
+// columns take the source's type and default where the comparison holds
+// them equal, so the diff only shows what the summary reports.
+func tableDDLDiff(source, target TableSchema, adapter DatabaseAdapter, options CompareOptions) []string {
+	sourceColumns := make(map[string]ColumnSchema)
+	for _, col := range source.Columns {
+		sourceColumns[col.Name] = col
+	}
+	target.Columns = append([]ColumnSchema{}, target.Columns...)
+	for i, col := range target.Columns {
+		sourceCol, ok := sourceColumns[col.Name]
+		if !ok {
+			continue
+		}
+		if sameColumnType(sourceCol, col, options) {
+			target.Columns[i].DataType = sourceCol.DataType
+		}
+		// Identity columns default to the next value of a sequence in
+		// Postgres and are AUTO_INCREMENT in MySQL
+		if sourceCol.AutoIdentity && col.AutoIdentity {
+			target.Columns[i].Default, target.Columns[i].Extra = sourceCol.Default, sourceCol.Extra
+		} else if !sourceCol.AutoIdentity && !col.AutoIdentity &&
+			normalizeDefault(sourceCol.Default.String) == normalizeDefault(col.Default.String) {
+			target.Columns[i].Default = sourceCol.Default
+		}
+	}
+
+	if !options.StrictColumnOrder {
+		positions := make(map[string]int)
+		for i, col := range source.Columns {
+			positions[col.Name] = i
+		}
+		sort.SliceStable(target.Columns, func(i, j int) bool {
+			pi, iok := positions[target.Columns[i].Name]
+			pj, jok := positions[target.Columns[j].Name]
+			if iok && jok {
+				return pi < pj
+			}
+			return iok && !jok
+		})
+	}
+	quoteTable := ddlTableQuoter(adapter)
+	return unifiedDiff(tableDDL(source, adapter, quoteTable, options.IgnoreCollation, true),
+		tableDDL(target, adapter, quoteTable, options.IgnoreCollation, true), "source", "target")
+}
+
+// unifiedDiff compares two texts line by line and returns the differences
+// in unified diff format, nil if they're equal
+func unifiedDiff(from, to []string, fromName, toName string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:]
+	// and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// The edit script, as lines prefixed with ' ', '-' or '+'
+	var edits []string
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			edits = append(edits, " "+from[i])
+			i++
+			j++
+		case j == len(to) || (i < len(from) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, "-"+from[i])
+			i++
+		default:
+			edits = append(edits, "+"+to[j])
+			j++
+		}
+	}
+
+	// Group the changes with their context into hunks
+	var lines []string
+	fromLine, toLine := 1, 1
+	for start := 0; start < len(edits); {
+		if edits[start][0] == ' ' {
+			start++
+			fromLine++
+			toLine++
+			continue
+		}
+		begin := max(0, start-diffContext)
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*diffContext; end++ {
+			if edits[end][0] == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// Trim the trailing context down to diffContext lines
+		for end > start && edits[end-1][0] == ' ' {
+			end--
+		}
+		end = min(len(edits), end+diffContext)
+
+		hunkFrom, hunkTo := fromLine-(start-begin), toLine-(start-begin)
+		fromCount, toCount := 0, 0
+		for _, edit := range edits[begin:end] {
+			if edit[0] != '+' {
+				fromCount++
+			}
+			if edit[0] != '-' {
+				toCount++
+			}
+		}
+		if lines == nil {
+			lines = []string{"--- " + fromName, "+++ " + toName}
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%d,%d +%d,%d @@", hunkFrom, fromCount, hunkTo, toCount))
+		lines = append(lines, edits[begin:end]...)
+
+		for _, edit := range edits[start:end] {
+			if edit[0] != '+' {
+				fromLine++
+			}
+			if edit[0] != '-' {
+				toLine++
+			}
+		}
+		start = end
+	}
+	return lines
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// DiffRow is a differing row as written by a diffRowsWriter, one JSON object
+// per line. Side is where the row exists: "source" for deleted rows,
+// "target" for inserted ones and "both" for changed ones. Source and Target
+// hold the row's values on each side, only the differing columns' for
+// changed rows.
+type DiffRow struct {
+	Table      string
+	Kind       string
+	Side       string
+	PrimaryKey map[string]string
+	Source     map[string]interface{} `json:",omitempty"`
+	Target     map[string]interface{} `json:",omitempty"`
+}
+
+// diffRowsWriter writes every differing row found by a row diff to a JSON
+// lines file, for repair tooling. Like Reconciliation, a table's rows are
+// dropped again when its row diff is retried or doesn't complete.
+type diffRowsWriter struct {
+	f   *os.File
+	buf *bufio.Writer
+	err error // first write error, returned by Close
+
+	current string // table being written, starting at offset start
+	start   int64
+}
+
+func createDiffRowsWriter(path string) (*diffRowsWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &diffRowsWriter{f: f, buf: bufio.NewWriter(f)}, nil
+}
+
+// table starts the rows of a table, replacing those written by an earlier
+// attempt at it
+func (w *diffRowsWriter) table(schema TableSchema) rowHandler {
+	if w == nil {
+		return nil
+	}
+	if w.current == schema.Name {
+		w.drop(schema.Name)
+	}
+	w.current = schema.Name
+	w.start = w.offset()
+
+	encoder := json.NewEncoder(w.buf)
+	return func(diff RowDifference, columns []string, source, target []interface{}) {
+		if w.err != nil {
+			return
+		}
+		row := DiffRow{Table: schema.Name, Kind: diff.Kind, PrimaryKey: make(map[string]string)}
+		for i, value := range diff.PrimaryKey {
+			row.PrimaryKey[schema.PrimaryKeys[i]] = value
+		}
+		switch diff.Kind {
+		case RowDeleted:
+			row.Side = "source"
+			row.Source = jsonValues(columns, source, columns)
+		case RowInserted:
+			row.Side = "target"
+			row.Target = jsonValues(columns, target, columns)
+		case RowChanged:
+			row.Side = "both"
+			row.Source = jsonValues(columns, source, diff.Columns)
+			row.Target = jsonValues(columns, target, diff.Columns)
+		}
+		w.err = encoder.Encode(row)
+	}
+}
+
+// jsonValues maps the wanted columns to their values. Text read as bytes is
+// kept as a string, other bytes are base64-encoded by encoding/json.
+func jsonValues(columns []string, values []interface{}, wanted []string) map[string]interface{} {
+	m := make(map[string]interface{}, len(wanted))
+	for i, col := range columns {
+		if !contains(wanted, col) {
+			continue
+		}
+		value := values[i]
+		if b, ok := value.([]byte); ok && utf8.Valid(b) {
+			value = string(b)
+		}
+		m[col] = value
+	}
+	return m
+}
+
+// offset returns the position the next row is written at
+func (w *diffRowsWriter) offset() int64 {
+	if w.err != nil {
+		return w.start
+	}
+	if w.err = w.buf.Flush(); w.err != nil {
+		return w.start
+	}
+	var pos int64
+	pos, w.err = w.f.Seek(0, io.SeekCurrent)
+	return pos
+}
+
+// drop removes the rows written for a table whose row diff didn't complete
+// or was suppressed
+func (w *diffRowsWriter) drop(tableName string) {
+	if w == nil || w.current != tableName || w.err != nil {
+		return
+	}
+	w.buf.Reset(w.f)
+	if w.err = w.f.Truncate(w.start); w.err == nil {
+		_, w.err = w.f.Seek(w.start, io.SeekStart)
+	}
+	w.current = ""
+}
+
+func (w *diffRowsWriter) Close() error {
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// distribution is the share of a column's rows in each bucket, by label
+type distribution map[string]float64
+
+// distributionColumns returns the columns of a table matching one of the
+// TABLE.COLUMN glob patterns of --distribution
+func distributionColumns(patterns []string, tableName string, columns []string) []string {
+	var matched []string
+	for _, col := range columns {
+		for _, pattern := range patterns {
+			dot := strings.LastIndex(pattern, ".")
+			if dot < 0 {
+				continue
+			}
+			tableOK, _ := path.Match(pattern[:dot], tableName)
+			columnOK, _ := path.Match(pattern[dot+1:], col)
+			if tableOK && columnOK {
+				matched = append(matched, col)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// divergence is the total variation distance of two distributions: the
+// share of rows that would have to move to another bucket to make them
+// equal, from 0 to 1. It also returns the bucket whose share differs most.
+func divergence(source, target distribution) (float64, string) {
+	labels := make(map[string]bool)
+	for label := range source {
+		labels[label] = true
+	}
+	for label := range target {
+		labels[label] = true
+	}
+	var total, largest float64
+	var bucket string
+	for label := range labels {
+		d := math.Abs(source[label] - target[label])
+		total += d
+		if d > largest || (d == largest && label < bucket) {
+			largest, bucket = d, label
+		}
+	}
+	return total / 2, bucket
+}
+
+// histogram puts the values of a numeric column into buckets of equal width
+// between lo and hi, the same bounds on both sides
+func histogram(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName, column string, lo, hi float64, buckets int) (distribution, error) {
+	quoted := adapter.QuoteIdentifier(column)
+	bucket := "0"
+	if hi > lo {
+		bucket = fmt.Sprintf("FLOOR((%s - %s) * %d / %s)", quoted, strconv.FormatFloat(lo, 'e', -1, 64),
+			buckets, strconv.FormatFloat(hi-lo, 'e', -1, 64))
+	}
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IS NOT NULL GROUP BY %s",
+		bucket, adapter.RowSource(tableName), quoted, bucket)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]float64)
+	width := (hi - lo) / float64(buckets)
+	for rows.Next() {
+		var n float64
+		var count int64
+		if err := rows.Scan(&n, &count); err != nil {
+			return nil, err
+		}
+		// The maximum falls just past the last bucket
+		i := min(int(n), buckets-1)
+		label := fmt.Sprintf("[%s, %s)", strconv.FormatFloat(lo+float64(i)*width, 'g', 6, 64), strconv.FormatFloat(lo+float64(i+1)*width, 'g', 6, 64))
+		if hi == lo {
+			label = strconv.FormatFloat(lo, 'g', -1, 64)
+		}
+		counts[label] += float64(count)
+	}
+	return counts, rows.Err()
+}
+
+// topValues returns the count of the most frequent values of a column,
+// most frequent first
+func topValues(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName, column string, limit int) (map[string]float64, []string, error) {
+	quoted := adapter.QuoteIdentifier(column)
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IS NOT NULL GROUP BY %s ORDER BY COUNT(*) DESC, %s LIMIT %d",
+		quoted, adapter.RowSource(tableName), quoted, quoted, quoted, limit)
+	return valueCounts(ctx, db, query)
+}
+
+// countValues returns how often each of the given values occurs in a
+// column, by the values as the database returns them
+func countValues(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName, column string, values []interface{}) (map[string]float64, error) {
+	if len(values) == 0 {
+		return map[string]float64{}, nil
+	}
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = adapter.QuoteLiteral(value)
+	}
+	quoted := adapter.QuoteIdentifier(column)
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s IN (%s) GROUP BY %s",
+		quoted, adapter.RowSource(tableName), quoted, strings.Join(literals, ", "), quoted)
+	counts, _, err := valueCounts(ctx, db, query)
+	return counts, err
+}
+
+// valueLabel returns the bucket label of a value a database returned,
+// which is the same for the value read from either database: timestamps in
+// UTC, strings as compared in row diffs. literal is the value to look it up
+// in the other database with.
+func valueLabel(values columnValues, value string) (label string, literal interface{}) {
+	switch normalized := values.normalize(value).(type) {
+	case time.Time:
+		return normalized.Format("2006-01-02 15:04:05.999999999"), normalized
+	case string:
+		return normalized, value
+	}
+	return value, value
+}
+
+// labelCounts adds up the counts of values by their labels
+func labelCounts(values columnValues, counts map[string]float64) map[string]float64 {
+	labeled := make(map[string]float64, len(counts))
+	for value, count := range counts {
+		label, _ := valueLabel(values, value)
+		labeled[label] += count
+	}
+	return labeled
+}
+
+// missingValues returns the literals of the values whose labels aren't
+// among counted
+func missingValues(values columnValues, top []string, counted map[string]float64) []interface{} {
+	var missing []interface{}
+	for _, value := range top {
+		if label, literal := valueLabel(values, value); counted[label] == 0 {
+			missing = append(missing, literal)
+		}
+	}
+	return missing
+}
+
+func valueCounts(ctx context.Context, db *sql.DB, query string) (map[string]float64, []string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]float64)
+	var values []string
+	for rows.Next() {
+		var value string
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, nil, err
+		}
+		counts[value] = float64(count)
+		values = append(values, value)
+	}
+	return counts, values, rows.Err()
+}
+
+// columnRange returns the minimum and maximum of a numeric column, ok is
+// false when it only holds NULLs
+func columnRange(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName, column string) (lo, hi float64, ok bool, err error) {
+	quoted := adapter.QuoteIdentifier(column)
+	var lower, upper sql.NullFloat64
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", quoted, quoted, adapter.RowSource(tableName))
+	if err := db.QueryRowContext(ctx, query).Scan(&lower, &upper); err != nil {
+		return 0, 0, false, err
+	}
+	return lower.Float64, upper.Float64, lower.Valid && upper.Valid, nil
+}
+
+// columnDistributions computes the distribution of a column on both sides:
+// a histogram for numbers, the shares of the most frequent values of either
+// side for anything else, labeled alike for both databases. NULLs and the values that aren't among the most
+// frequent get a bucket of their own.
+func (c *Comparison) columnDistributions(ctx context.Context, sourceSchema, targetSchema TableSchema, column string, sourceCount, targetCount int) (distribution, distribution, error) {
+	source, target := distribution{}, distribution{}
+	numeric := false
+	for _, col := range sourceSchema.Columns {
+		if col.Name == column {
+			numeric, _ = statTypes(col.DataType)
+		}
+	}
+
+	if numeric {
+		lo, hi, ok, err := columnRange(ctx, c.Adapter, c.SourceDB, sourceSchema.Name, column)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetLo, targetHi, targetOK, err := columnRange(ctx, c.targetAdapter(), c.TargetDB, targetSchema.Name, column)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok || (targetOK && targetLo < lo) {
+			lo = targetLo
+		}
+		if !ok || (targetOK && targetHi > hi) {
+			hi = targetHi
+		}
+		if ok || targetOK {
+			if source, err = histogram(ctx, c.Adapter, c.SourceDB, sourceSchema.Name, column, lo, hi, c.Options.DistributionBuckets); err != nil {
+				return nil, nil, err
+			}
+			if target, err = histogram(ctx, c.targetAdapter(), c.TargetDB, targetSchema.Name, column, lo, hi, c.Options.DistributionBuckets); err != nil {
+				return nil, nil, err
+			}
+		}
+	} else {
+		values := valueColumns([]string{column}, sourceSchema, targetSchema, c.Options)[0]
+		sourceTop, sourceValues, err := topValues(ctx, c.Adapter, c.SourceDB, sourceSchema.Name, column, c.Options.TopValues)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetTop, targetValues, err := topValues(ctx, c.targetAdapter(), c.TargetDB, targetSchema.Name, column, c.Options.TopValues)
+		if err != nil {
+			return nil, nil, err
+		}
+		sourceLabels, targetLabels := labelCounts(values, sourceTop), labelCounts(values, targetTop)
+
+		// Count the most frequent values of each side on the other one too
+		counts, err := countValues(ctx, c.Adapter, c.SourceDB, sourceSchema.Name, column, missingValues(values, targetValues, sourceLabels))
+		if err != nil {
+			return nil, nil, err
+		}
+		for label, count := range labelCounts(values, counts) {
+			source[strconv.Quote(label)] += count
+		}
+		counts, err = countValues(ctx, c.targetAdapter(), c.TargetDB, targetSchema.Name, column, missingValues(values, sourceValues, targetLabels))
+		if err != nil {
+			return nil, nil, err
+		}
+		for label, count := range labelCounts(values, counts) {
+			target[strconv.Quote(label)] += count
+		}
+		for label, count := range sourceLabels {
+			source[strconv.Quote(label)] += count
+		}
+		for label, count := range targetLabels {
+			target[strconv.Quote(label)] += count
+		}
+	}
+
+	if err := completeDistribution(ctx, c.Adapter, c.SourceDB, sourceSchema.Name, column, source, sourceCount); err != nil {
+		return nil, nil, err
+	}
+	if err := completeDistribution(ctx, c.targetAdapter(), c.TargetDB, targetSchema.Name, column, target, targetCount); err != nil {
+		return nil, nil, err
+	}
+	return source, target, nil
+}
+
+// completeDistribution adds the buckets of NULLs and of the values that
+// weren't counted to the counts of a column, and turns them into shares of
+// its rows
+func completeDistribution(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName, column string, d distribution, rows int) error {
+	var nonNull int64
+	quoted := adapter.QuoteIdentifier(column)
+	query := fmt.Sprintf("SELECT COUNT(%s) FROM %s", quoted, adapter.RowSource(tableName))
+	if err := db.QueryRowContext(ctx, query).Scan(&nonNull); err != nil {
+		return err
+	}
+	counted := 0.0
+	for _, count := range d {
+		counted += count
+	}
+	if other := float64(nonNull) - counted; other > 0 {
+		d["(other values)"] = other
+	}
+	if nulls := float64(rows) - float64(nonNull); nulls > 0 {
+		d["NULL"] = nulls
+	}
+	for label := range d {
+		d[label] /= float64(rows)
+	}
+	return nil
+}
+
+// compareTableDistributions compares the distributions of the columns of a
+// table selected by --distribution, reporting those that diverge by more
+// than DivergenceThreshold
+func (c *Comparison) compareTableDistributions(ctx context.Context, summary *ComparisonSummary, sourceSchema, targetSchema TableSchema, sourceCount, targetCount int) error {
+	tableName := sourceSchema.Name
+	// An empty table has no distribution to compare
+	if sourceCount == 0 || targetCount == 0 {
+		return nil
+	}
+	var common []string
+	for _, col := range sourceSchema.Columns {
+		for _, targetCol := range targetSchema.Columns {
+			if col.Name == targetCol.Name {
+				common = append(common, col.Name)
+			}
+		}
+	}
+
+	for _, column := range distributionColumns(c.Options.Distributions, tableName, common) {
+		var source, target distribution
+		err := c.retry(ctx, "distribution of "+tableName+"."+column, func() (err error) {
+			source, target, err = c.columnDistributions(ctx, sourceSchema, targetSchema, column, sourceCount, targetCount)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", column, err)
+		}
+
+		moved, bucket := divergence(source, target)
+		if moved <= c.Options.DivergenceThreshold {
+			continue
+		}
+		share := func(d distribution) string {
+			return bucket + ": " + strconv.FormatFloat(d[bucket]*100, 'f', 1, 64) + "%"
+		}
+		diff := Difference{
+			Table: tableName, ObjectType: "data", ObjectName: column, Kind: DiffModified,
+			Property: "distribution", Source: share(source), Target: share(target),
+			Message: fmt.Sprintf("Column '%s.%s' has a different distribution: %.1f%% of rows are in other buckets, most in %s: source=%.1f%%, target=%.1f%%",
+				tableName, column, moved*100, bucket, source[bucket]*100, target[bucket]*100),
+		}
+		if c.suppress(summary, diff) {
+			continue
+		}
+		summary.StatDifferences[tableName] = append(summary.StatDifferences[tableName], diff)
+		summary.addDifferentTable(tableName)
+		c.emit(Event{Type: EventDifferenceFound, Table: tableName, Message: diff.Message})
+	}
+	return nil
+}
@@ -0,0 +1,1514 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DumpAdapter reads a SQL dump written by mysqldump or pg_dump as if it were
+// a database of type Dialect. The schema comes from the dump's DDL. Rows from
+// its INSERT and COPY statements are loaded into a temporary SQLite database
+// file, which the data comparisons run against.
+type DumpAdapter struct {
+	SQLiteAdapter
+	Dialect string // "mysql" or "postgres"
+
+	tables    map[string]*TableSchema
+	views     []ViewSchema
+	routines  []RoutineSchema
+	sequences []SequenceSchema
+	types     []TypeSchema
+	counters  map[string]int64 // AUTO_INCREMENT options of MySQL tables
+}
+
+// isDumpFile reports whether a connection string names a SQL dump file
+func isDumpFile(connectionString string) bool {
+	if !strings.HasSuffix(connectionString, ".sql") {
+		return false
+	}
+	info, err := os.Stat(connectionString)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Connect parses the dump file as it reads it and loads its rows into a
+// temporary database file, removed when the pool is closed
+func (a *DumpAdapter) Connect(connectionString string) (*sql.DB, error) {
+	f, err := os.Open(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp("", "mudrockdbcompare-dump-*.db")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	db, err := openDB("sqlite", tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	closeWith(db, func() { os.Remove(tmp.Name()) })
+
+	a.tables = make(map[string]*TableSchema)
+	a.counters = make(map[string]int64)
+	if err := a.load(context.Background(), db, f); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", connectionString, err)
+	}
+	return db, nil
+}
+
+func (a *DumpAdapter) GetTableList(ctx context.Context, db *sql.DB) ([]string, error) {
+	tables := make([]string, 0, len(a.tables))
+	for name := range a.tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+func (a *DumpAdapter) GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error) {
+	schema, ok := a.tables[tableName]
+	if !ok {
+		return TableSchema{Name: tableName}, fmt.Errorf("table '%s' is not in the dump", tableName)
+	}
+	return *schema, nil
+}
+
+func (a *DumpAdapter) GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error) {
+	return a.views, nil
+}
+
+// GetRoutines returns the procedures and functions of mysqldump --routines.
+// pg_dump functions are left out, their names need the argument types.
+func (a *DumpAdapter) GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error) {
+	return a.routines, nil
+}
+
+func (a *DumpAdapter) GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error) {
+	sequences := append([]SequenceSchema(nil), a.sequences...)
+	if a.Dialect == "mysql" {
+		for _, name := range sortedKeys(a.tables) {
+			if counter, ok := autoIncrementCounter(a.tables[name], a.counters[name]); ok {
+				sequences = append(sequences, counter)
+			}
+		}
+	}
+	return sequences, nil
+}
+
+func (a *DumpAdapter) GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error) {
+	return a.types, nil
+}
+
+// Capabilities are those of the dump's database, but for privileges, and
+// routines of pg_dump
+func (a *DumpAdapter) Capabilities() Capabilities {
+	capabilities := Capabilities{Engine: a.Dialect + " dump", Sequences: true}
+	switch a.Dialect {
+	case "mysql":
+		capabilities.Routines = true
+		capabilities.MaxIdentifierLength = 64
+	case "postgres":
+		capabilities.Types = true
+		capabilities.MaxIdentifierLength = 63
+	}
+	return capabilities
+}
+
+// GetPrivileges returns nothing, dumps are usually taken without them
+func (a *DumpAdapter) GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error) {
+	return nil, nil
+}
+
+func sortedKeys(tables map[string]*TableSchema) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// autoIncrementCounter is the AUTO_INCREMENT counter of a MySQL table.
+// mysqldump only writes the next value once rows were inserted.
+func autoIncrementCounter(table *TableSchema, next int64) (SequenceSchema, bool) {
+	for _, col := range table.Columns {
+		if col.AutoIdentity {
+			if next == 0 {
+				next = 1
+			}
+			return SequenceSchema{Name: table.Name, Table: table.Name, CurrentValue: next - 1}, true
+		}
+	}
+	return SequenceSchema{}, false
+}
+
+// load runs the statements of a dump: DDL goes into the parsed schema,
+// rows into the SQLite database
+func (a *DumpAdapter) load(ctx context.Context, db *sql.DB, content io.Reader) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dump := newDumpReader(content, a.Dialect)
+	for {
+		stmt, err := dump.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r := &tokenReader{tokens: lexDump(stmt, a.Dialect == "mysql")}
+		switch {
+		case r.accept("CREATE", "TABLE"), r.accept("CREATE", "UNLOGGED", "TABLE"):
+			r.accept("IF", "NOT", "EXISTS")
+			table := a.createTable(r)
+			if table == nil {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, a.sqliteTable(table)); err != nil {
+				return fmt.Errorf("creating table '%s': %w", table.Name, err)
+			}
+		case r.accept("CREATE", "SEQUENCE"):
+			a.createSequence(r)
+		case r.accept("CREATE", "TYPE"):
+			a.createType(r)
+		case r.accept("CREATE", "DOMAIN"):
+			a.createDomain(r)
+		case r.accept("ALTER", "TABLE"):
+			a.alterTable(r)
+		case r.accept("CREATE", "INDEX"), r.accept("CREATE", "UNIQUE", "INDEX"):
+			a.createIndex(r, strings.EqualFold(r.tokens[1].text, "UNIQUE"))
+		case r.accept("SELECT", "pg_catalog", ".", "setval"), r.accept("SELECT", "setval"):
+			a.setSequenceValue(r)
+		case r.accept("DROP", "TABLE"):
+			// mysqldump creates a placeholder table before each view
+			r.accept("IF", "EXISTS")
+			delete(a.tables, a.readName(r))
+		case r.accept("CREATE"):
+			a.createObject(r, stmt)
+		case r.accept("INSERT"), r.accept("REPLACE"):
+			if err := a.insertRows(ctx, tx, r); err != nil {
+				return err
+			}
+		case r.accept("COPY"):
+			if err := a.copyRows(ctx, tx, r, dump); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range a.tables {
+		a.inheritCollation(table)
+	}
+	return tx.Commit()
+}
+
+// dumpName is the name a table or other object has in the live database:
+// pg_dump qualifies every name with its schema, which is left out for public
+func (a *DumpAdapter) dumpName(schema, name string) string {
+	if schema == "" || (a.Dialect == "postgres" && schema == "public") {
+		return name
+	}
+	return qualifiedName(schema, name)
+}
+
+// readName reads a possibly qualified object name
+func (a *DumpAdapter) readName(r *tokenReader) string {
+	name := r.next().text
+	if r.accept(".") {
+		return a.dumpName(name, r.next().text)
+	}
+	return a.dumpName("", name)
+}
+
+// createTable parses the columns, keys and options of CREATE TABLE
+func (a *DumpAdapter) createTable(r *tokenReader) *TableSchema {
+	table := &TableSchema{Name: a.readName(r)}
+	if r.peek().text != "(" {
+		return nil // CREATE TABLE ... AS, PARTITION OF and the like
+	}
+
+	for _, element := range r.list() {
+		e := &tokenReader{tokens: element}
+		constraint := ""
+		if e.accept("CONSTRAINT") {
+			constraint = e.next().text
+		}
+
+		switch {
+		case e.accept("PRIMARY", "KEY"):
+			a.addPrimaryKey(table, constraint, identifierList(e))
+		case e.accept("UNIQUE"):
+			if !e.accept("KEY") {
+				e.accept("INDEX")
+			}
+			if e.peek().text != "(" {
+				constraint = e.next().text
+			}
+			a.addUnique(table, constraint, identifierList(e))
+		case a.Dialect == "mysql" && (e.accept("KEY") || e.accept("INDEX") || e.accept("FULLTEXT") || e.accept("SPATIAL")):
+			if !e.accept("KEY") {
+				e.accept("INDEX")
+			}
+			name := e.next().text
+			for _, column := range identifierList(e) {
+				table.Indexes = append(table.Indexes, IndexSchema{Name: name, ColumnName: column, NonUnique: 1})
+			}
+		case e.accept("FOREIGN", "KEY"):
+			a.addForeignKey(table, constraint, e)
+		case e.accept("CHECK"), e.accept("EXCLUDE"):
+		default:
+			if constraint == "" {
+				table.Columns = append(table.Columns, a.column(table, e))
+			}
+		}
+	}
+
+	if a.Dialect == "mysql" {
+		a.tableOptions(table, r)
+	}
+	a.tables[table.Name] = table
+	return table
+}
+
+// columnAttributes end the data type of a column definition
+var columnAttributes = map[string]bool{
+	"NOT": true, "NULL": true, "DEFAULT": true, "AUTO_INCREMENT": true, "CHARACTER": true,
+	"CHARSET": true, "COLLATE": true, "COMMENT": true, "PRIMARY": true, "UNIQUE": true,
+	"KEY": true, "GENERATED": true, "AS": true, "ON": true, "REFERENCES": true, "CHECK": true,
+	"CONSTRAINT": true, "INVISIBLE": true, "VISIBLE": true, "SRID": true, "STORAGE": true,
+	"COLUMN_FORMAT": true,
+}
+
+func (a *DumpAdapter) column(table *TableSchema, r *tokenReader) ColumnSchema {
+	col := ColumnSchema{Name: r.next().text, Nullable: "YES"}
+
+	var dataType []dumpToken
+	for depth := 0; !r.done(); {
+		t := r.peek()
+		if depth == 0 && len(dataType) > 0 && t.kind == tokenWord && columnAttributes[strings.ToUpper(t.text)] {
+			break
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		dataType = append(dataType, r.next())
+	}
+	col.DataType = joinTokens(dataType)
+	if a.Dialect == "postgres" {
+		col.DataType = postgresDataType(col.DataType)
+	}
+
+	for !r.done() {
+		switch {
+		case r.accept("NOT", "NULL"):
+			col.Nullable = "NO"
+		case r.accept("DEFAULT"):
+			var value []dumpToken
+			for !r.done() && !(len(value) > 0 && value[len(value)-1].text != "::" &&
+				r.peek().kind == tokenWord && columnAttributes[strings.ToUpper(r.peek().text)]) {
+				value = append(value, r.next())
+			}
+			col.Default = sql.NullString{String: joinTokens(value), Valid: !strings.EqualFold(joinTokens(value), "NULL")}
+			col.AutoIdentity = col.AutoIdentity || strings.HasPrefix(col.Default.String, "nextval(")
+		case r.accept("AUTO_INCREMENT"):
+			col.AutoIdentity = true
+			col.Extra = "auto_increment"
+		case r.accept("GENERATED", "ALWAYS", "AS", "IDENTITY"), r.accept("GENERATED", "BY", "DEFAULT", "AS", "IDENTITY"):
+			col.AutoIdentity = true
+		case r.accept("PRIMARY", "KEY"):
+			a.addPrimaryKey(table, "", []string{col.Name})
+			col.Nullable = "NO"
+		case r.accept("CHARACTER", "SET"), r.accept("CHARSET"):
+			col.Charset = r.next().text
+		case r.accept("COLLATE"):
+			col.Collation = r.next().text
+			if r.accept(".") {
+				col.Collation = r.next().text // pg_catalog."C"
+			}
+		default:
+			r.next()
+		}
+	}
+
+	if contains(table.PrimaryKeys, col.Name) {
+		col.Key = "PRI"
+	}
+	if col.Collation != "" && a.Dialect == "mysql" {
+		col.Charset, _, _ = strings.Cut(col.Collation, "_")
+	}
+	return col
+}
+
+// tableOptions reads the options after a mysqldump CREATE TABLE
+func (a *DumpAdapter) tableOptions(table *TableSchema, r *tokenReader) {
+	for !r.done() {
+		r.accept("DEFAULT")
+		option := strings.ToUpper(r.next().text)
+		if option == "CHARACTER" {
+			r.accept("SET")
+			option = "CHARSET"
+		}
+		r.accept("=")
+		value := r.next().text
+		switch option {
+		case "ENGINE":
+			table.Options.Engine = value
+		case "CHARSET":
+			table.Options.Charset = value
+		case "COLLATE":
+			table.Options.Collation = value
+		case "ROW_FORMAT":
+			if value != "" {
+				table.Options.RowFormat = strings.ToUpper(value[:1]) + strings.ToLower(value[1:])
+			}
+		case "AUTO_INCREMENT":
+			a.counters[table.Name], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if table.Options.Charset == "" {
+		table.Options.Charset, _, _ = strings.Cut(table.Options.Collation, "_")
+	}
+	// mysqldump leaves out the row format unless it was set, InnoDB has
+	// defaulted to Dynamic since MySQL 5.7.9
+	if table.Options.RowFormat == "" && strings.EqualFold(table.Options.Engine, "InnoDB") {
+		table.Options.RowFormat = "Dynamic"
+	}
+}
+
+// inheritCollation gives the text columns of a MySQL table the table's
+// collation, which mysqldump only writes when the column's differs
+func (a *DumpAdapter) inheritCollation(table *TableSchema) {
+	if a.Dialect != "mysql" {
+		return
+	}
+	for i, col := range table.Columns {
+		if col.Collation != "" || !isMySQLTextType(col.DataType) {
+			continue
+		}
+		if col.Charset == "" || col.Charset == table.Options.Charset {
+			table.Columns[i].Collation = table.Options.Collation
+			table.Columns[i].Charset = table.Options.Charset
+		}
+	}
+}
+
+func isMySQLTextType(dataType string) bool {
+	base, _, _ := strings.Cut(strings.ToLower(dataType), "(")
+	switch strings.TrimSpace(base) {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return true
+	}
+	return false
+}
+
+// postgresTypes are the built-in types, as information_schema.columns
+// names them without their modifiers
+var postgresTypes = map[string]bool{
+	"smallint": true, "integer": true, "bigint": true, "numeric": true, "real": true,
+	"double precision": true, "money": true, "character varying": true, "character": true,
+	"text": true, "bytea": true, "boolean": true, "date": true, "interval": true,
+	"timestamp without time zone": true, "timestamp with time zone": true,
+	"time without time zone": true, "time with time zone": true, "uuid": true, "json": true,
+	"jsonb": true, "xml": true, "inet": true, "cidr": true, "macaddr": true, "macaddr8": true,
+	"bit": true, "bit varying": true, "point": true, "line": true, "lseg": true, "box": true,
+	"path": true, "polygon": true, "circle": true, "tsvector": true, "tsquery": true, "oid": true,
+	"int4range": true, "int8range": true, "numrange": true, "tsrange": true, "tstzrange": true,
+	"daterange": true, "pg_lsn": true, "txid_snapshot": true, "name": true, `"char"`: true,
+}
+
+// postgresDataType turns a type as pg_dump writes it into the data_type of
+// information_schema.columns: timestamp(3) without time zone becomes
+// timestamp without time zone, integer[] becomes ARRAY. PostGIS types keep
+// their name, like PostgreSQLAdapter reads them.
+func postgresDataType(dataType string) string {
+	if strings.HasSuffix(dataType, "]") {
+		return "ARRAY"
+	}
+	var base strings.Builder
+	depth := 0
+	for _, r := range dataType {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case depth == 0:
+			base.WriteRune(r)
+		}
+	}
+	name := strings.Join(strings.Fields(base.String()), " ")
+	name = strings.TrimPrefix(name, "pg_catalog.")
+	if postgresTypes[name] {
+		return name
+	}
+	// The adapter reads PostGIS types by name, like public.geometry
+	if postgis := name[strings.LastIndex(name, ".")+1:]; postgis == "geometry" || postgis == "geography" {
+		return postgis
+	}
+	return "USER-DEFINED"
+}
+
+func (a *DumpAdapter) addPrimaryKey(table *TableSchema, constraint string, columns []string) {
+	name := "PRIMARY"
+	if a.Dialect == "postgres" {
+		name = constraint
+	}
+	for _, column := range columns {
+		table.PrimaryKeys = append(table.PrimaryKeys, column)
+		table.Indexes = append(table.Indexes, IndexSchema{Name: name, ColumnName: column})
+		for i := range table.Columns {
+			if table.Columns[i].Name == column {
+				table.Columns[i].Key = "PRI"
+				table.Columns[i].Nullable = "NO"
+			}
+		}
+	}
+}
+
+func (a *DumpAdapter) addUnique(table *TableSchema, name string, columns []string) {
+	for _, column := range columns {
+		table.Indexes = append(table.Indexes, IndexSchema{Name: name, ColumnName: column})
+		table.addUniqueConstraintColumn(name, name, column)
+	}
+}
+
+// addForeignKey reads FOREIGN KEY (columns) REFERENCES table (columns)
+func (a *DumpAdapter) addForeignKey(table *TableSchema, name string, r *tokenReader) {
+	columns := identifierList(r)
+	if !r.accept("REFERENCES") {
+		return
+	}
+	referenced := a.readName(r)
+	referencedColumns := identifierList(r)
+	for i, column := range columns {
+		if i < len(referencedColumns) {
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKeySchema{
+				Name: name, ColumnName: column, ReferencedTable: referenced, ReferencedColumn: referencedColumns[i],
+			})
+		}
+	}
+}
+
+// alterTable applies the keys and defaults pg_dump adds after loading data
+func (a *DumpAdapter) alterTable(r *tokenReader) {
+	r.accept("IF", "EXISTS")
+	r.accept("ONLY")
+	table, ok := a.tables[a.readName(r)]
+	if !ok {
+		return
+	}
+
+	switch {
+	case r.accept("ADD", "CONSTRAINT"):
+		constraint := r.next().text
+		switch {
+		case r.accept("PRIMARY", "KEY"):
+			a.addPrimaryKey(table, constraint, identifierList(r))
+		case r.accept("UNIQUE"):
+			a.addUnique(table, constraint, identifierList(r))
+		case r.accept("FOREIGN", "KEY"):
+			a.addForeignKey(table, constraint, r)
+		}
+	case r.accept("ALTER", "COLUMN"):
+		column := r.next().text
+		for i := range table.Columns {
+			if table.Columns[i].Name != column {
+				continue
+			}
+			switch {
+			case r.accept("SET", "DEFAULT"):
+				table.Columns[i].Default = sql.NullString{String: joinTokens(r.rest()), Valid: true}
+				table.Columns[i].AutoIdentity = strings.HasPrefix(table.Columns[i].Default.String, "nextval(")
+			case r.accept("ADD", "GENERATED"):
+				table.Columns[i].AutoIdentity = true
+				// Identity columns own a sequence, described in parentheses
+				for !r.done() && r.peek().text != "(" {
+					r.next()
+				}
+				if !r.done() {
+					options := &tokenReader{tokens: flatten(r.list())}
+					if options.accept("SEQUENCE", "NAME") {
+						a.addSequence(a.readName(options), table.Columns[i].DataType, options)
+					}
+				}
+			}
+		}
+	}
+}
+
+// createIndex reads CREATE [UNIQUE] INDEX name ON table [USING method] (columns)
+func (a *DumpAdapter) createIndex(r *tokenReader, unique bool) {
+	name := r.next().text
+	if !r.accept("ON") {
+		return
+	}
+	r.accept("ONLY")
+	table, ok := a.tables[a.readName(r)]
+	if !ok {
+		return
+	}
+	if r.accept("USING") {
+		r.next()
+	}
+	nonUnique := 1
+	if unique {
+		nonUnique = 0
+	}
+	for _, column := range identifierList(r) {
+		table.Indexes = append(table.Indexes, IndexSchema{Name: name, ColumnName: column, NonUnique: nonUnique})
+	}
+}
+
+// createSequence reads pg_dump's CREATE SEQUENCE
+func (a *DumpAdapter) createSequence(r *tokenReader) {
+	r.accept("IF", "NOT", "EXISTS")
+	name := a.readName(r)
+	dataType := "bigint"
+	if r.accept("AS") {
+		dataType = r.next().text
+	}
+	a.addSequence(name, dataType, r)
+}
+
+// addSequence adds a sequence with the given options and PostgreSQL's
+// defaults for the others
+func (a *DumpAdapter) addSequence(name, dataType string, r *tokenReader) {
+	sequence := SequenceSchema{Name: name, Increment: 1, MinValue: 1, MaxValue: 9223372036854775807}
+	switch dataType {
+	case "integer":
+		sequence.MaxValue = 2147483647
+	case "smallint":
+		sequence.MaxValue = 32767
+	}
+
+	var start *int64
+	for !r.done() {
+		switch {
+		case r.accept("INCREMENT"):
+			r.accept("BY")
+			sequence.Increment = r.number()
+		case r.accept("MINVALUE"):
+			sequence.MinValue = r.number()
+		case r.accept("MAXVALUE"):
+			sequence.MaxValue = r.number()
+		case r.accept("START"):
+			r.accept("WITH")
+			value := r.number()
+			start = &value
+		case r.accept("AS"):
+			a.addSequence(name, r.next().text, &tokenReader{tokens: r.rest()})
+			return
+		default:
+			r.next()
+		}
+	}
+
+	if start == nil {
+		start = &sequence.MinValue
+	}
+	sequence.CurrentValue = *start - sequence.Increment
+	a.sequences = append(a.sequences, sequence)
+}
+
+// setSequenceValue reads SELECT pg_catalog.setval('name', value, is_called)
+func (a *DumpAdapter) setSequenceValue(r *tokenReader) {
+	if !r.accept("(") {
+		return
+	}
+	name := r.next().text
+	if schema, table, ok := splitQualifiedName(name); ok {
+		name = a.dumpName(schema, table)
+	}
+	r.accept(",")
+	value := r.number()
+	r.accept(",")
+	called := !r.accept("false")
+
+	for i := range a.sequences {
+		if a.sequences[i].Name == name {
+			a.sequences[i].CurrentValue = value
+			if !called {
+				a.sequences[i].CurrentValue -= a.sequences[i].Increment
+			}
+		}
+	}
+}
+
+// createType reads CREATE TYPE name AS ENUM (labels), other types are ignored
+func (a *DumpAdapter) createType(r *tokenReader) {
+	name := a.readName(r)
+	if !r.accept("AS", "ENUM") {
+		return
+	}
+	enum := TypeSchema{Name: name, Kind: "enum"}
+	for _, label := range r.list() {
+		if len(label) > 0 {
+			enum.Labels = append(enum.Labels, label[0].text)
+		}
+	}
+	a.types = append(a.types, enum)
+}
+
+// createDomain reads CREATE DOMAIN name AS type [DEFAULT ...] [NOT NULL] [CONSTRAINT ... CHECK ...]
+func (a *DumpAdapter) createDomain(r *tokenReader) {
+	domain := TypeSchema{Name: a.readName(r), Kind: "domain"}
+	r.accept("AS")
+
+	var baseType, value []dumpToken
+	var checks []string
+	names := []string{}
+	section := &baseType
+	for !r.done() {
+		switch {
+		case r.accept("DEFAULT"):
+			section = &value
+		case r.accept("NOT", "NULL"):
+			domain.NotNull = true
+			section = nil
+		case r.accept("NULL"):
+			section = nil
+		case r.accept("CONSTRAINT"):
+			names = append(names, r.next().text)
+			section = nil
+		case r.accept("CHECK"):
+			checks = append(checks, "CHECK "+joinTokens(flatten([][]dumpToken{r.group()})))
+			if len(names) < len(checks) {
+				names = append(names, "")
+			}
+			section = nil
+		default:
+			t := r.next()
+			if section != nil {
+				*section = append(*section, t)
+			}
+		}
+	}
+
+	domain.BaseType = joinTokens(baseType)
+	domain.Default = joinTokens(value)
+	// PostgreSQL reports the constraints ordered by name
+	order := make([]int, len(checks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+	for _, i := range order {
+		if domain.Constraints != "" {
+			domain.Constraints += " AND "
+		}
+		domain.Constraints += checks[i]
+	}
+	a.types = append(a.types, domain)
+}
+
+// createObject reads views, and procedures and functions of mysqldump
+func (a *DumpAdapter) createObject(r *tokenReader, statement string) {
+	r.accept("OR", "REPLACE")
+	for !r.done() {
+		t := r.next()
+		if t.kind != tokenWord {
+			continue
+		}
+		switch strings.ToUpper(t.text) {
+		case "VIEW":
+			name := a.readName(r)
+			if r.peek().text == "(" {
+				r.group()
+			}
+			if !r.accept("AS") || r.done() {
+				return
+			}
+			delete(a.tables, name)
+			// mysqldump first creates a placeholder view
+			for i, view := range a.views {
+				if view.Name == name {
+					a.views = append(a.views[:i], a.views[i+1:]...)
+					break
+				}
+			}
+			definition := statement[r.peek().pos:]
+			if a.Dialect == "postgres" {
+				// pg_dump qualifies every name, pg_get_viewdef leaves out the search path
+				definition = withoutPublicSchema(statement, r.rest())
+			}
+			a.views = append(a.views, ViewSchema{Name: name, Definition: definition})
+			return
+		case "PROCEDURE", "FUNCTION":
+			if a.Dialect == "mysql" {
+				a.routines = append(a.routines, RoutineSchema{
+					Name: a.readName(r), Type: strings.ToLower(t.text), Definition: statement,
+				})
+			}
+			return
+		case "TRIGGER", "EVENT", "INDEX", "SCHEMA", "DATABASE", "EXTENSION":
+			return
+		}
+	}
+}
+
+// withoutPublicSchema returns a statement from the first of its tokens on,
+// leaving the public schema out of the names qualified with it. Strings and
+// comments reading public. are kept.
+func withoutPublicSchema(statement string, tokens []dumpToken) string {
+	var b strings.Builder
+	from := tokens[0].pos
+	for i := 0; i+2 < len(tokens); i++ {
+		t := tokens[i]
+		if (t.kind == tokenWord || t.kind == tokenIdentifier) && t.text == "public" &&
+			tokens[i+1].kind == tokenPunctuation && tokens[i+1].text == "." && (i == 0 || tokens[i-1].text != ".") {
+			b.WriteString(statement[from:t.pos])
+			from = tokens[i+2].pos
+		}
+	}
+	b.WriteString(statement[from:])
+	return b.String()
+}
+
+// insertRows loads the rows of INSERT INTO table [(columns)] VALUES (...), ...
+func (a *DumpAdapter) insertRows(ctx context.Context, tx *sql.Tx, r *tokenReader) error {
+	r.accept("IGNORE")
+	r.accept("INTO")
+	table, ok := a.tables[a.readName(r)]
+	if !ok {
+		return nil
+	}
+	columns := columnNames(table)
+	if r.peek().text == "(" {
+		columns = identifierList(r)
+	}
+	if !r.accept("VALUES") {
+		return nil
+	}
+
+	for !r.done() {
+		values := r.list()
+		row := make([]interface{}, len(values))
+		for i, value := range values {
+			row[i] = a.insertValue(table, columns, i, value)
+		}
+		if err := a.insertRow(ctx, tx, table, columns, row); err != nil {
+			return err
+		}
+		if !r.accept(",") {
+			break
+		}
+	}
+	return nil
+}
+
+// insertValue turns a literal into the value to store
+func (a *DumpAdapter) insertValue(table *TableSchema, columns []string, i int, value []dumpToken) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	t := value[0]
+	negative := false
+	if t.text == "-" && len(value) > 1 {
+		negative = true
+		t = value[1]
+	}
+	if t.kind == tokenWord && strings.EqualFold(t.text, "_binary") && len(value) > 1 {
+		t = value[1]
+	}
+
+	switch t.kind {
+	case tokenBytes:
+		return []byte(t.text)
+	case tokenNumber:
+		if negative {
+			return "-" + t.text
+		}
+		return t.text
+	case tokenWord:
+		if strings.EqualFold(t.text, "NULL") {
+			return nil
+		}
+	}
+	if i < len(columns) {
+		return a.columnValue(table, columns[i], t.text)
+	}
+	return t.text
+}
+
+// columnValue converts text into what the live database returns for the
+// column: PostgreSQL booleans and bytea, MySQL binary strings
+func (a *DumpAdapter) columnValue(table *TableSchema, column, text string) interface{} {
+	dataType := ""
+	for _, col := range table.Columns {
+		if col.Name == column {
+			dataType = strings.ToLower(col.DataType)
+		}
+	}
+
+	switch {
+	case dataType == "boolean":
+		switch strings.ToLower(text) {
+		case "t", "true", "1", "y", "yes", "on":
+			return "true"
+		default:
+			return "false"
+		}
+	case dataType == "bytea" && strings.HasPrefix(text, `\x`):
+		if b, err := hex.DecodeString(text[2:]); err == nil {
+			return b
+		}
+	case a.Dialect == "mysql" && (strings.Contains(dataType, "binary") || strings.Contains(dataType, "blob")):
+		return []byte(text)
+	}
+	return text
+}
+
+// copyRows loads the rows following COPY table (columns) FROM stdin
+func (a *DumpAdapter) copyRows(ctx context.Context, tx *sql.Tx, r *tokenReader, dump *dumpReader) error {
+	table, ok := a.tables[a.readName(r)]
+	if !ok {
+		return nil
+	}
+	columns := columnNames(table)
+	if r.peek().text == "(" {
+		columns = identifierList(r)
+	}
+
+	for {
+		line, ok, err := dump.copyRow()
+		if err != nil || !ok {
+			return err
+		}
+		fields := strings.Split(line, "\t")
+		row := make([]interface{}, len(fields))
+		for i, field := range fields {
+			if field == `\N` {
+				continue
+			}
+			if i < len(columns) {
+				row[i] = a.columnValue(table, columns[i], unescapeCopy(field))
+			}
+		}
+		if err := a.insertRow(ctx, tx, table, columns, row); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *DumpAdapter) insertRow(ctx context.Context, tx *sql.Tx, table *TableSchema, columns []string, row []interface{}) error {
+	if len(row) != len(columns) {
+		return fmt.Errorf("table '%s': row has %d values for %d columns", table.Name, len(row), len(columns))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		a.SQLiteAdapter.QuoteTable(table.Name), quoteList(columns, a.SQLiteAdapter.QuoteIdentifier), placeholders)
+	_, err := tx.ExecContext(ctx, query, row...)
+	return err
+}
+
+// sqliteTable creates a table holding the rows of a dumped one. Column
+// affinities keep numbers numeric and everything else as it was written.
+func (a *DumpAdapter) sqliteTable(table *TableSchema) string {
+	columns := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		dataType := strings.ToLower(col.DataType)
+		affinity := "TEXT"
+		switch {
+		case strings.Contains(dataType, "int") && !strings.Contains(dataType, "interval") && !strings.Contains(dataType, "point"):
+			affinity = "INTEGER"
+		case strings.Contains(dataType, "float") || strings.Contains(dataType, "double") || dataType == "real":
+			affinity = "REAL"
+		case strings.Contains(dataType, "blob") || strings.Contains(dataType, "binary") || dataType == "bytea":
+			affinity = "BLOB"
+		}
+		columns[i] = a.SQLiteAdapter.QuoteIdentifier(col.Name) + " " + affinity
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", a.SQLiteAdapter.QuoteTable(table.Name), strings.Join(columns, ", "))
+}
+
+func columnNames(table *TableSchema) []string {
+	names := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// unescapeCopy decodes a field of COPY's text format
+func unescapeCopy(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i+1 == len(field) {
+			b.WriteByte(field[i])
+			continue
+		}
+		i++
+		switch c := field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(field) && end < i+3 && isHexDigit(field[end]) {
+				end++
+			}
+			value, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			b.WriteByte(byte(value))
+			i = end - 1
+		default:
+			if c >= '0' && c <= '7' {
+				end := i
+				for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+					end++
+				}
+				value, _ := strconv.ParseUint(field[i:end], 8, 8)
+				b.WriteByte(byte(value))
+				i = end - 1
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return strings.IndexByte("0123456789abcdefABCDEF", c) >= 0
+}
+
+// dumpReader splits a dump into statements as it reads it. It skips
+// comments, runs the contents of MySQL's versioned /*!50001 ... */
+// comments, follows DELIMITER changes and keeps quoted strings and
+// PostgreSQL's dollar-quoted bodies together. The rows following COPY ...
+// FROM stdin are read with copyRow.
+type dumpReader struct {
+	r         *bufio.Reader
+	dialect   string
+	delimiter string
+	versioned int
+	copying   bool  // the rows of a COPY statement follow
+	err       error // the first read error other than io.EOF
+}
+
+func newDumpReader(r io.Reader, dialect string) *dumpReader {
+	return &dumpReader{r: bufio.NewReaderSize(r, 1<<16), dialect: dialect, delimiter: ";"}
+}
+
+// hasPrefix reports whether the input read next starts with s
+func (d *dumpReader) hasPrefix(s string) bool {
+	b, _ := d.r.Peek(len(s))
+	return string(b) == s
+}
+
+// readByte reads the next byte, false at the end of the input
+func (d *dumpReader) readByte() (byte, bool) {
+	c, err := d.r.ReadByte()
+	if err != nil && err != io.EOF && d.err == nil {
+		d.err = err
+	}
+	return c, err == nil
+}
+
+// skipLine skips the rest of the line, keeping its newline if keepNewline
+// is set
+func (d *dumpReader) skipLine(keepNewline bool) {
+	for {
+		c, ok := d.readByte()
+		if !ok {
+			return
+		}
+		if c == '\n' {
+			if keepNewline {
+				d.r.UnreadByte()
+			}
+			return
+		}
+	}
+}
+
+// next returns the next statement, io.EOF after the last. The rows of a
+// COPY statement that weren't read are skipped.
+func (d *dumpReader) next() (string, error) {
+	for d.copying {
+		if _, _, err := d.copyRow(); err != nil {
+			return "", err
+		}
+	}
+
+	var b strings.Builder
+	blank, lineStart := true, true
+	for {
+		if d.err != nil {
+			return "", d.err
+		}
+		if lineStart && blank && d.hasPrefix("DELIMITER ") {
+			line, err := d.r.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			d.delimiter = strings.TrimSpace(strings.TrimPrefix(line, "DELIMITER "))
+			b.Reset()
+			continue
+		}
+
+		peek, _ := d.r.Peek(3)
+		if len(peek) == 0 {
+			break
+		}
+		c := peek[0]
+		if c != ' ' && c != '\t' && c != '\r' {
+			lineStart = c == '\n'
+		}
+		switch {
+		case c == '\n':
+			d.r.ReadByte()
+			b.WriteByte(c)
+		case c == '\'' || c == '"' || c == '`':
+			d.readQuoted(&b)
+			blank = false
+		case len(peek) >= 2 && string(peek[:2]) == "--" && (len(peek) == 2 || peek[2] == ' ' || peek[2] == '\t' || peek[2] == '\n' || peek[2] == '\r'),
+			c == '#' && d.dialect == "mysql":
+			d.skipLine(true)
+		case string(peek) == "/*!":
+			d.r.Discard(3)
+			for {
+				digit, err := d.r.Peek(1)
+				if err != nil || digit[0] < '0' || digit[0] > '9' {
+					break
+				}
+				d.r.ReadByte()
+			}
+			d.versioned++
+		case d.versioned > 0 && d.hasPrefix("*/"):
+			d.versioned--
+			d.r.Discard(2)
+		case d.hasPrefix("/*"):
+			d.r.Discard(2)
+			for !d.hasPrefix("*/") {
+				if _, ok := d.readByte(); !ok {
+					break
+				}
+			}
+			d.r.Discard(2)
+			b.WriteByte(' ')
+		case c == '$' && d.dialect == "postgres" && d.dollarQuoted(&b):
+			blank = false
+		case d.hasPrefix(d.delimiter):
+			d.r.Discard(len(d.delimiter))
+			stmt := strings.TrimSpace(b.String())
+			b.Reset()
+			blank = true
+			if stmt == "" {
+				continue
+			}
+			if d.dialect == "postgres" && isCopyFromStdin(stmt) {
+				// The rows start on the next line and end with \.
+				d.skipLine(false)
+				d.copying = true
+			}
+			return stmt, nil
+		default:
+			d.r.ReadByte()
+			b.WriteByte(c)
+			blank = blank && (c == ' ' || c == '\t' || c == '\r')
+		}
+	}
+
+	if d.err != nil {
+		return "", d.err
+	}
+	if stmt := strings.TrimSpace(b.String()); stmt != "" {
+		return stmt, nil
+	}
+	return "", io.EOF
+}
+
+// readQuoted copies the quoted string or identifier read next to b
+func (d *dumpReader) readQuoted(b *strings.Builder) {
+	quote, _ := d.readByte()
+	b.WriteByte(quote)
+	for {
+		c, ok := d.readByte()
+		if !ok {
+			return
+		}
+		b.WriteByte(c)
+		switch {
+		case c == '\\' && d.dialect == "mysql" && quote == '\'':
+			if c, ok := d.readByte(); ok {
+				b.WriteByte(c)
+			}
+		case c == quote:
+			if !d.hasPrefix(string(quote)) {
+				return
+			}
+			d.r.ReadByte()
+			b.WriteByte(c)
+		}
+	}
+}
+
+// dollarQuoted copies the $tag$ quoted body read next to b, false if what
+// follows the $ isn't a tag
+func (d *dumpReader) dollarQuoted(b *strings.Builder) bool {
+	peek, _ := d.r.Peek(64)
+	tag := dollarTag(string(peek))
+	if tag == "" {
+		return false
+	}
+	d.r.Discard(len(tag))
+	b.WriteString(tag)
+	start := b.Len()
+	for {
+		c, ok := d.readByte()
+		if !ok {
+			return true
+		}
+		b.WriteByte(c)
+		if b.Len()-start >= len(tag) && strings.HasSuffix(b.String(), tag) {
+			return true
+		}
+	}
+}
+
+// copyRow reads the next row of the COPY statement next returned, false
+// after its last
+func (d *dumpReader) copyRow() (string, bool, error) {
+	if !d.copying {
+		return "", false, nil
+	}
+	line, err := d.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == `\.` || (err == io.EOF && line == "") {
+		d.copying = false
+		return "", false, nil
+	}
+	return line, true, nil
+}
+
+// skipQuoted returns the end of the quoted string or identifier at start
+func skipQuoted(s string, start int, backslashEscapes bool) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && backslashEscapes && quote == '\'':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarTag returns the $tag$ starting s, if any
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isCopyFromStdin(stmt string) bool {
+	fields := strings.Fields(strings.ToUpper(stmt))
+	return len(fields) > 2 && fields[0] == "COPY" && fields[len(fields)-2] == "FROM" && fields[len(fields)-1] == "STDIN"
+}
+
+// Token kinds
+const (
+	tokenWord       = iota // keyword or unquoted identifier
+	tokenIdentifier        // quoted identifier
+	tokenString
+	tokenBytes // 0x... and X'...' literals
+	tokenNumber
+	tokenPunctuation
+)
+
+// dumpToken is a token of a dump statement. text holds identifiers and
+// strings without their quotes.
+type dumpToken struct {
+	kind int
+	text string
+	raw  string
+	pos  int
+}
+
+// lexDump splits a statement into tokens. MySQL strings use backslash escapes.
+func lexDump(s string, backslashEscapes bool) []dumpToken {
+	var tokens []dumpToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case (c == 'x' || c == 'X') && i+1 < len(s) && s[i+1] == '\'':
+			i = skipQuoted(s, i+1, false)
+			b, _ := hex.DecodeString(strings.Trim(s[start+1:i], "'"))
+			tokens = append(tokens, dumpToken{kind: tokenBytes, text: string(b), raw: s[start:i], pos: start})
+		case (c == 'e' || c == 'E') && i+1 < len(s) && s[i+1] == '\'':
+			i = skipQuoted(s, i+1, true)
+			tokens = append(tokens, dumpToken{kind: tokenString, text: unquoteString(s[start+1:i], true), raw: s[start:i], pos: start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+			for i < len(s) && (s[i] == '_' || s[i] == '$' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9' || s[i] >= 0x80) {
+				i++
+			}
+			tokens = append(tokens, dumpToken{kind: tokenWord, text: s[start:i], raw: s[start:i], pos: start})
+		case c == '0' && i+1 < len(s) && (s[i+1] == 'x' || s[i+1] == 'X'):
+			i += 2
+			for i < len(s) && isHexDigit(s[i]) {
+				i++
+			}
+			b, _ := hex.DecodeString(s[start+2 : i])
+			tokens = append(tokens, dumpToken{kind: tokenBytes, text: string(b), raw: s[start:i], pos: start})
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == 'e' || s[i] == 'E' ||
+				(s[i] == '-' || s[i] == '+') && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, dumpToken{kind: tokenNumber, text: s[start:i], raw: s[start:i], pos: start})
+		case c == '\'':
+			i = skipQuoted(s, i, backslashEscapes)
+			tokens = append(tokens, dumpToken{kind: tokenString, text: unquoteString(s[start:i], backslashEscapes), raw: s[start:i], pos: start})
+		case c == '`' || c == '"':
+			i = skipQuoted(s, i, false)
+			text := strings.ReplaceAll(s[start+1:max(start+1, i-1)], string([]byte{c, c}), string(c))
+			tokens = append(tokens, dumpToken{kind: tokenIdentifier, text: text, raw: s[start:i], pos: start})
+		case i+1 < len(s) && isOperator(s[i:i+2]):
+			i += 2
+			tokens = append(tokens, dumpToken{kind: tokenPunctuation, text: s[start:i], raw: s[start:i], pos: start})
+		default:
+			i++
+			tokens = append(tokens, dumpToken{kind: tokenPunctuation, text: s[start:i], raw: s[start:i], pos: start})
+		}
+	}
+	return tokens
+}
+
+// isOperator reports whether s is a two-character operator
+func isOperator(s string) bool {
+	switch s {
+	case "::", ">=", "<=", "<>", "!=", "||":
+		return true
+	}
+	return false
+}
+
+// unquoteString removes the quotes of a string literal and decodes its escapes
+func unquoteString(quoted string, backslashEscapes bool) string {
+	s := quoted[1 : len(quoted)-1]
+	if !backslashEscapes {
+		return strings.ReplaceAll(s, "''", "'")
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				c = 0
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'Z':
+				c = 26
+			case '%', '_':
+				b.WriteByte('\\') // kept, as MySQL does
+				c = s[i]
+			default:
+				c = s[i]
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// joinTokens writes tokens back as SQL, without spaces inside parentheses
+// and around commas: varchar(255), enum('a','b'), numeric(10,2)
+func joinTokens(tokens []dumpToken) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && !strings.Contains("(),[]:.", t.raw) && !strings.Contains("([,:.", tokens[i-1].raw) && t.raw != "::" && tokens[i-1].raw != "::" {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.raw)
+	}
+	return b.String()
+}
+
+// flatten joins the elements of a list back together with commas
+func flatten(elements [][]dumpToken) []dumpToken {
+	var tokens []dumpToken
+	for i, element := range elements {
+		if i > 0 {
+			tokens = append(tokens, dumpToken{kind: tokenPunctuation, text: ",", raw: ","})
+		}
+		tokens = append(tokens, element...)
+	}
+	return tokens
+}
+
+// identifierList reads a parenthesized list of column names. Elements that
+// aren't plain names, like index expressions, are left out; prefix
+// lengths and sort orders are dropped.
+func identifierList(r *tokenReader) []string {
+	var names []string
+	for _, element := range r.list() {
+		if len(element) > 0 && (element[0].kind == tokenWord || element[0].kind == tokenIdentifier) {
+			names = append(names, element[0].text)
+		}
+	}
+	return names
+}
+
+// tokenReader walks through the tokens of a statement
+type tokenReader struct {
+	tokens []dumpToken
+	i      int
+}
+
+func (r *tokenReader) done() bool {
+	return r.i >= len(r.tokens)
+}
+
+func (r *tokenReader) peek() dumpToken {
+	if r.done() {
+		return dumpToken{}
+	}
+	return r.tokens[r.i]
+}
+
+func (r *tokenReader) next() dumpToken {
+	t := r.peek()
+	r.i++
+	return t
+}
+
+func (r *tokenReader) rest() []dumpToken {
+	if r.done() {
+		return nil
+	}
+	rest := r.tokens[r.i:]
+	r.i = len(r.tokens)
+	return rest
+}
+
+// accept consumes the words or punctuation given, case-insensitively, if
+// the next tokens are exactly those
+func (r *tokenReader) accept(words ...string) bool {
+	if r.i+len(words) > len(r.tokens) {
+		return false
+	}
+	for j, word := range words {
+		t := r.tokens[r.i+j]
+		if (t.kind != tokenWord && t.kind != tokenPunctuation) || !strings.EqualFold(t.text, word) {
+			return false
+		}
+	}
+	r.i += len(words)
+	return true
+}
+
+// number reads an optionally negative integer
+func (r *tokenReader) number() int64 {
+	sign := int64(1)
+	if r.accept("-") {
+		sign = -1
+	}
+	value, _ := strconv.ParseInt(r.next().text, 10, 64)
+	return sign * value
+}
+
+// group reads a parenthesized group and returns it with its parentheses
+func (r *tokenReader) group() []dumpToken {
+	start := r.i
+	for depth := 0; !r.done(); {
+		switch r.next().text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	return r.tokens[start:r.i]
+}
+
+// list reads a parenthesized, comma-separated list and returns its elements
+func (r *tokenReader) list() [][]dumpToken {
+	if !r.accept("(") {
+		return nil
+	}
+	var elements [][]dumpToken
+	var element []dumpToken
+	for depth := 0; !r.done(); {
+		t := r.next()
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")" && depth == 0:
+			return append(elements, element)
+		case t.text == ")":
+			depth--
+		case t.text == "," && depth == 0:
+			elements = append(elements, element)
+			element = nil
+			continue
+		}
+		element = append(element, t)
+	}
+	return append(elements, element)
+}
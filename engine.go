@@ -0,0 +1,1211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+var errTableTimeout = errors.New("table timeout exceeded")
+
+// Event types emitted while a comparison runs
+const (
+	EventPhase           = "phase"   // a new phase started, Message describes it
+	EventWarning         = "warning" // something failed but the comparison continues
+	EventTableStarted    = "table_started"
+	EventTableFinished   = "table_finished" // Err is set if the table couldn't be compared or timed out
+	EventDifferenceFound = "difference_found"
+	EventProgress        = "progress" // Percent of the common tables compared so far
+)
+
+// Event reports the progress of a comparison. Only the fields relevant to
+// the event type are set.
+type Event struct {
+	Type    string
+	Table   string
+	Message string
+	Percent int
+	Err     error
+
+	// Set on EventTableFinished: rows read from both databases for the table
+	RowsScanned int64
+
+	// Set on EventDifferenceFound when the difference has details
+	Chunks *ChunkResult
+	Rows   *RowDiffResult
+}
+
+type CompareOptions struct {
+	RowDiff   bool
+	ChunkSize int
+
+	// Primary key ranges of a table compared at the same time, each on its
+	// own connections
+	Parallel int
+
+	// Row diffs compare dates and timestamps as instants in UTC, equal when
+	// they differ by up to TimestampTolerance
+	TimestampTolerance time.Duration
+
+	// Row diffs compare decimals as numbers, rounded to DecimalScale places
+	// if it's positive
+	DecimalScale int
+
+	// Row diffs compare spatial values point by point, equal when each point
+	// is within GeometryTolerance, in the geometry's units, of the other's
+	GeometryTolerance float64
+
+	// Row diffs read the values of large text and binary columns over
+	// DigestThreshold bytes as their size and SHA-256 digest, computed in
+	// the database. Zero reads them whole.
+	DigestThreshold int
+
+	// How row diffs compare strings: StringCompareBinary, the default, byte
+	// by byte, or StringCompareCollation equal when their column's collation
+	// says so, e.g. ignoring case
+	StringCompare string
+
+	// Unicode normalization form, "nfc" or "nfkc", row diffs bring strings
+	// to before comparing them, or "" to compare them as they are
+	UnicodeNormalize string
+
+	// Row diffs ignore trailing whitespace of strings, or only the trailing
+	// spaces CHAR(n) columns are padded with
+	TrimTrailingWhitespace bool
+	IgnoreCharPadding      bool
+
+	// Rows the row diff reads per query with keyset pagination, zero reads
+	// each key range in one query
+	PageSize int
+
+	// Report columns at different positions, which matters to SELECT * and
+	// INSERT without a column list
+	StrictColumnOrder bool
+
+	// Read the sizes of SQLite tables for the database information, which
+	// scans every page of the file. MySQL and PostgreSQL tables always have
+	// theirs, from the catalogs.
+	TableSizes bool
+
+	// Don't report charset and collation differences of tables and columns
+	IgnoreCollation bool
+
+	// Compare the row counts the databases' statistics estimate instead of
+	// counting the rows, reporting them when they differ by more than
+	// ApproxTolerance percent. Counted when a side has no estimate.
+	ApproxCounts    bool
+	ApproxTolerance float64
+
+	// Zero means no timeout. Tables that time out are skipped.
+	QueryTimeout time.Duration
+	TableTimeout time.Duration
+
+	// Bound the load on the databases: send each at most MaxQPS statements
+	// per second, zero for no limit, and pause SleepBetweenChunks after
+	// each chunk, batch of chunk checksums and page of rows
+	MaxQPS             float64
+	SleepBetweenChunks time.Duration
+
+	// Watch the load of the database servers while comparing the data,
+	// slowing down when it's over three quarters of a limit and pausing
+	// while it's over: more than MaxActiveSessions statements running, or
+	// a replica more than MaxReplicaLag behind its primary. Zero for no
+	// limit.
+	MaxActiveSessions int
+	MaxReplicaLag     time.Duration
+
+	// Hold the row hash counts of a table without a primary key in at most
+	// MaxMemory bytes, spilling them to temporary files past it. Zero for
+	// no limit.
+	MaxMemory int64
+
+	Retry RetryPolicy
+
+	// Wait up to WaitForReplica for the target, a replica of the source, to
+	// apply the source's changes up to where it was when the comparison
+	// started. Zero compares right away.
+	WaitForReplica time.Duration
+
+	// Read each database as of one point in time, where the adapter
+	// supports snapshots, rather than each query seeing a later one.
+	// MySQL's connections open their snapshots one after the other, with
+	// ConsistentLock under FLUSH TABLES WITH READ LOCK so that they're of
+	// the same point in time.
+	Consistent     bool
+	ConsistentLock bool
+
+	// Compare the current values of sequences and auto-increment counters,
+	// allowing them to differ by up to SequenceTolerance
+	SequenceValues    bool
+	SequenceTolerance int64
+
+	// Compare the privileges granted on the database and its tables, with
+	// IgnoreGranteeHost by MySQL user name only, so 'app'@'10.%' in one
+	// environment matches 'app'@'%' in another
+	ComparePrivileges bool
+	IgnoreGranteeHost bool
+
+	// Collect the statements that make the target's data match the source
+	// in summary.Reconciliation. Needs RowDiff.
+	Reconcile bool
+
+	// Accepted differences, moved to summary.Suppressed
+	Suppressions []Suppression
+
+	// Tables, and TABLE/COLUMN columns, of an ignore file, left out of the
+	// comparison altogether: their schemas and data aren't read
+	Ignore []Suppression
+
+	// Pairs of column types that aren't reported as differing
+	TypeEquivalences []TypeEquivalence
+
+	// Compare only a deterministic sample of each table's rows, either a
+	// percentage or about a number of rows, instead of checksums and row
+	// diffs. The differing rows are reported with the estimated share of
+	// the table that differs.
+	SamplePercent float64
+	SampleRows    int
+
+	// Queries run on both databases after the tables, whose results must
+	// match
+	Checks []Check
+
+	// Columns marking rows as soft-deleted, which are left out of the data
+	// comparisons: COLUMN for every table that has it, TABLE.COLUMN for the
+	// tables matching a glob pattern
+	SoftDeleteColumns []string
+
+	// Compare aggregates of each column: counts, NULLs, minimum, maximum,
+	// sum, average and distinct values. Numbers may differ by
+	// StatsTolerance, relative to the larger one.
+	ColumnStats    bool
+	StatsTolerance float64
+
+	// TABLE.COLUMN glob patterns of the columns whose value distributions
+	// are compared: histograms of DistributionBuckets buckets for numbers,
+	// the shares of the TopValues most frequent values for anything else.
+	// Columns where more than DivergenceThreshold of the rows are in other
+	// buckets are reported.
+	Distributions       []string
+	DistributionBuckets int
+	TopValues           int
+	DivergenceThreshold float64
+}
+
+// validate checks the options' ranges, naming an option wrong like the
+// command line and the server's config do, without the dashes
+func (o CompareOptions) validate() error {
+	negative := []struct {
+		name     string
+		negative bool
+	}{
+		{"chunk-size", o.ChunkSize < 0},
+		{"page-size", o.PageSize < 0},
+		{"timestamp-tolerance", o.TimestampTolerance < 0},
+		{"decimal-scale", o.DecimalScale < 0},
+		{"geometry-tolerance", o.GeometryTolerance < 0},
+		{"digest-threshold", o.DigestThreshold < 0},
+		{"stats-tolerance", o.StatsTolerance < 0},
+		{"approx-tolerance", o.ApproxTolerance < 0},
+		{"max-qps", o.MaxQPS < 0},
+		{"sleep-between-chunks", o.SleepBetweenChunks < 0},
+		{"max-active-sessions", o.MaxActiveSessions < 0},
+		{"max-replica-lag", o.MaxReplicaLag < 0},
+	}
+	if o.Parallel < 1 {
+		return errors.New("parallel must be at least 1")
+	}
+	for _, option := range negative {
+		if option.negative {
+			return fmt.Errorf("%s can't be negative", option.name)
+		}
+	}
+	if o.StringCompare != "" && o.StringCompare != StringCompareBinary && o.StringCompare != StringCompareCollation {
+		return errors.New("string-compare must be binary or collation")
+	}
+	if _, ok := unicodeForms[o.UnicodeNormalize]; !ok && o.UnicodeNormalize != "" {
+		return errors.New("unicode-normalize must be nfc or nfkc")
+	}
+	return nil
+}
+
+// Comparison compares a source and a target database. TargetAdapter is set
+// when the target is read differently from the source: a different type of
+// database (cross-engine mode) or another schema. OnEvent, when set, is
+// called synchronously for every event. The connection strings are only used
+// to describe the databases in the summary.
+type Comparison struct {
+	Adapter       DatabaseAdapter
+	TargetAdapter DatabaseAdapter
+	SourceDB      *sql.DB
+	TargetDB      *sql.DB
+	SourceConnStr string
+	TargetConnStr string
+	Options       CompareOptions
+	OnEvent       func(Event)
+
+	// Receives the differing rows found by the row diff, if set
+	DiffRows *diffRowsWriter
+
+	// Records the finished tables, if set, and has those of the run
+	// resumed
+	Checkpoint *checkpoint
+
+	// The target attached to the source while comparing the data of two
+	// SQLite files
+	attached *attachedSQLite
+
+	// End the snapshots of Consistent, while they're open
+	snapshots []func() error
+
+	// The source's objects, when they were read once for the comparisons
+	// of several targets, see compareTargets
+	source *databaseObjects
+}
+
+// targetAdapter returns the adapter for the target database
+func (c *Comparison) targetAdapter() DatabaseAdapter {
+	if c.TargetAdapter != nil {
+		return c.TargetAdapter
+	}
+	return c.Adapter
+}
+
+// crossEngine reports whether source and target are different types of
+// database. Their checksums can't be compared then, only counts and rows.
+func (c *Comparison) crossEngine() bool {
+	return c.TargetAdapter != nil && reflect.TypeOf(c.TargetAdapter) != reflect.TypeOf(c.Adapter)
+}
+
+// supported reports whether both databases support a feature. Otherwise
+// the feature isn't compared, with a warning if the other one supports it
+// or it was requested.
+func (c *Comparison) supported(feature string, has func(Capabilities) bool, requested bool) bool {
+	source, target := c.Adapter.Capabilities(), c.targetAdapter().Capabilities()
+	if has(source) && has(target) {
+		return true
+	}
+	if requested || has(source) || has(target) {
+		engine := target.Engine
+		if !has(source) {
+			engine = source.Engine
+		}
+		c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("%s aren't supported on %s, not compared", feature, engine)})
+	}
+	return false
+}
+
+// replicationPositions returns how far source and target are in their
+// replication streams, where they can tell. With WaitForReplica it first
+// waits for the target to catch up with the source's position, so a
+// lagging replica isn't reported as differing.
+func (c *Comparison) replicationPositions(ctx context.Context) (string, string) {
+	wait := c.Options.WaitForReplica > 0
+	position := func(name string, adapter DatabaseAdapter, db *sql.DB) string {
+		capabilities := adapter.Capabilities()
+		if !capabilities.Replication {
+			if wait {
+				c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("Replication isn't supported on %s, not waiting for the replica", capabilities.Engine)})
+			}
+			return ""
+		}
+		position, err := adapter.ReplicationPosition(ctx, db)
+		if err != nil && wait {
+			c.emit(Event{Type: EventWarning, Message: "Couldn't read the " + name + "'s replication position, not waiting for the replica", Err: err})
+		}
+		return position
+	}
+
+	source := position("source", c.Adapter, c.SourceDB)
+	if wait && source != "" && !c.crossEngine() && c.TargetDB != c.SourceDB {
+		c.waitForReplica(ctx, source)
+	}
+	wait = false // the target's position is only reported
+	return source, position("target", c.targetAdapter(), c.TargetDB)
+}
+
+// waitForReplica polls the target until it applied the source's changes up
+// to position, for up to WaitForReplica, comparing anyway after that
+func (c *Comparison) waitForReplica(ctx context.Context, position string) {
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Waiting for the replica to reach %s...", position)})
+	deadline := time.Now().Add(c.Options.WaitForReplica)
+	for {
+		caughtUp, err := c.targetAdapter().ReplicaCaughtUp(ctx, c.TargetDB, position)
+		if err != nil {
+			c.emit(Event{Type: EventWarning, Message: "Couldn't check the replica's position, not waiting for it", Err: err})
+			return
+		}
+		if caughtUp {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("The replica didn't reach the source's position within %s, comparing anyway", c.Options.WaitForReplica)})
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// beginSnapshots makes the reads of each database see it as of one point in
+// time. Databases whose adapter doesn't support snapshots are read as they
+// change, with a warning.
+func (c *Comparison) beginSnapshots(ctx context.Context) error {
+	// Up to Parallel key ranges are compared at the same time, each reading
+	// the source and the target, which may be the same database
+	connections := 2 * max(c.Options.Parallel, 1)
+
+	sides := []struct {
+		name    string
+		adapter DatabaseAdapter
+		db      *sql.DB
+	}{{"source", c.Adapter, c.SourceDB}, {"target", c.targetAdapter(), c.TargetDB}}
+	for _, side := range sides {
+		if side.name == "target" && c.TargetDB == c.SourceDB {
+			continue
+		}
+		capabilities := side.adapter.Capabilities()
+		if !capabilities.Snapshots {
+			c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("Consistent snapshots aren't supported on %s, the %s is read as it changes", capabilities.Engine, side.name)})
+			continue
+		}
+		end, err := side.adapter.BeginSnapshot(ctx, side.db, connections, c.Options.ConsistentLock)
+		if err != nil {
+			c.endSnapshots()
+			return fmt.Errorf("%s: %w", side.name, err)
+		}
+		c.snapshots = append(c.snapshots, end)
+	}
+	return nil
+}
+
+// endSnapshots ends the snapshots beginSnapshots began
+func (c *Comparison) endSnapshots() {
+	for _, end := range c.snapshots {
+		if err := end(); err != nil {
+			c.emit(Event{Type: EventWarning, Message: "Couldn't end a consistent snapshot", Err: err})
+		}
+	}
+	c.snapshots = nil
+}
+
+// checkIdentifierLengths warns of source tables and columns with names
+// longer than the target database allows, which can't exist there under
+// the same name
+func (c *Comparison) checkIdentifierLengths(schemas map[string]TableSchema) {
+	target := c.targetAdapter().Capabilities()
+	if !c.crossEngine() || target.MaxIdentifierLength == 0 {
+		return
+	}
+	tooLong := func(name string) bool { return utf8.RuneCountInString(name) > target.MaxIdentifierLength }
+	tableNames := make([]string, 0, len(schemas))
+	for tableName := range schemas {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		schema := schemas[tableName]
+		if tooLong(schema.Name) {
+			c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("Table %s's name is longer than %s allows, %d characters",
+				schema.Name, target.Engine, target.MaxIdentifierLength)})
+		}
+		for _, col := range schema.Columns {
+			if tooLong(col.Name) {
+				c.emit(Event{Type: EventWarning, Message: fmt.Sprintf("Column %s.%s's name is longer than %s allows, %d characters",
+					schema.Name, col.Name, target.Engine, target.MaxIdentifierLength)})
+			}
+		}
+	}
+}
+
+func (c *Comparison) emit(event Event) {
+	if c.OnEvent != nil {
+		c.OnEvent(event)
+	}
+}
+
+// Run introspects both databases and compares their schemas and data
+func (c *Comparison) Run(ctx context.Context) (ComparisonSummary, error) {
+	summary, err := c.Introspect(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	c.CompareData(ctx, &summary)
+	return summary, nil
+}
+
+// Introspect reads the schemas of both databases and compares them. With
+// Consistent, the snapshots it begins last until CompareData ends them, or
+// endSnapshots when the data isn't compared; it ends them itself when it
+// fails.
+func (c *Comparison) Introspect(ctx context.Context) (_ ComparisonSummary, err error) {
+	summary := ComparisonSummary{
+		DifferentRowCounts: make(map[string]struct{ Source, Target int }),
+		RowDifferences:     make(map[string]RowDiffResult),
+		ChunkDifferences:   make(map[string]ChunkResult),
+		StatDifferences:    make(map[string][]Difference),
+		SkippedTables:      make(map[string]string),
+	}
+	ctx = withQueryTimeout(ctx, c.Options.QueryTimeout)
+	ctx = withThrottle(ctx, c.Options)
+
+	sourcePosition, targetPosition := c.replicationPositions(ctx)
+
+	if c.Options.Consistent {
+		c.endSnapshots() // of an earlier run that didn't compare the data
+		if err := c.beginSnapshots(ctx); err != nil {
+			return summary, fmt.Errorf("failed to begin consistent snapshots: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				c.endSnapshots()
+			}
+		}()
+	}
+
+	kinds := c.comparedKinds()
+	source := c.source
+	if source == nil {
+		objects, err := c.readObjects(ctx, "source", c.Adapter, c.SourceDB, c.SourceConnStr, kinds)
+		if err != nil {
+			return summary, err
+		}
+		source = &objects
+	}
+	target, err := c.readObjects(ctx, "target", c.targetAdapter(), c.TargetDB, c.TargetConnStr, kinds)
+	if err != nil {
+		return summary, err
+	}
+	summary.SourceSchemas, summary.TargetSchemas = source.schemas, target.schemas
+	c.skipUnreadTables(&summary, source.failed, target.failed)
+	summary.SourceInfo, summary.TargetInfo = source.info, target.info
+	summary.SourceInfo.ReplicationPosition, summary.TargetInfo.ReplicationPosition = sourcePosition, targetPosition
+
+	summary.MissingTables, summary.ExtraTables, summary.CommonTables, summary.SchemaDifferences =
+		compareDatabases(summary.SourceSchemas, summary.TargetSchemas, c.Options)
+	c.checkIdentifierLengths(summary.SourceSchemas)
+
+	summary.ObjectDifferences = compareViews(source.views, target.views)
+	summary.ObjectDifferences = append(summary.ObjectDifferences, compareRoutines(source.routines, target.routines)...)
+	summary.ObjectDifferences = append(summary.ObjectDifferences, compareSequences(source.sequences, target.sequences,
+		c.Options.SequenceValues, c.Options.SequenceTolerance)...)
+	summary.ObjectDifferences = append(summary.ObjectDifferences, compareTypes(source.types, target.types)...)
+	summary.ObjectDifferences = append(summary.ObjectDifferences, comparePrivileges(source.privileges, target.privileges, c.Options.IgnoreGranteeHost)...)
+
+	if len(c.Options.Suppressions) > 0 {
+		c.suppressTables(&summary)
+		summary.ObjectDifferences = c.suppressAll(&summary, summary.ObjectDifferences)
+	}
+
+	for tableName := range summary.SchemaDifferences {
+		summary.addDifferentTable(tableName)
+	}
+
+	return summary, nil
+}
+
+// skipUnreadTables leaves the tables whose schema couldn't be read on
+// either side out of the comparison, instead of reporting them as missing
+// from that side, and records them as skipped
+func (c *Comparison) skipUnreadTables(summary *ComparisonSummary, sourceFailed, targetFailed tableErrors) {
+	if len(sourceFailed)+len(targetFailed) == 0 {
+		return
+	}
+	// The source's objects may be shared by several comparisons
+	sourceSchemas := make(map[string]TableSchema, len(summary.SourceSchemas))
+	for table, schema := range summary.SourceSchemas {
+		if _, failed := targetFailed[table]; !failed {
+			sourceSchemas[table] = schema
+		}
+	}
+	targetSchemas := make(map[string]TableSchema, len(summary.TargetSchemas))
+	for table, schema := range summary.TargetSchemas {
+		if _, failed := sourceFailed[table]; !failed {
+			targetSchemas[table] = schema
+		}
+	}
+	summary.SourceSchemas, summary.TargetSchemas = sourceSchemas, targetSchemas
+
+	for _, side := range []struct {
+		name   string
+		failed tableErrors
+	}{{"source", sourceFailed}, {"target", targetFailed}} {
+		for _, table := range side.failed.tables() {
+			err := inPhase("schema", side.name, side.failed[table])
+			summary.skipTable(table, "error", err)
+			c.emit(Event{Type: EventWarning, Table: table, Message: fmt.Sprintf("Skipped table %s, couldn't read the %s's schema", table, side.name), Err: err})
+		}
+	}
+}
+
+// objectKinds are the kinds of objects other than tables and views that
+// readObjects reads
+type objectKinds struct {
+	Routines, Sequences, Types, Privileges bool
+}
+
+// comparedKinds returns the kinds of objects both databases support, or
+// that were requested, warning about those one of them doesn't
+func (c *Comparison) comparedKinds() objectKinds {
+	return objectKinds{
+		Routines:   c.supported("Routines", func(caps Capabilities) bool { return caps.Routines }, false),
+		Sequences:  c.supported("Sequences", func(caps Capabilities) bool { return caps.Sequences }, false),
+		Types:      c.supported("User-defined types", func(caps Capabilities) bool { return caps.Types }, false),
+		Privileges: c.Options.ComparePrivileges && c.supported("Privileges", func(caps Capabilities) bool { return caps.Privileges }, true),
+	}
+}
+
+// databaseObjects are the objects of a database Introspect compares
+type databaseObjects struct {
+	schemas    map[string]TableSchema
+	views      []ViewSchema
+	routines   []RoutineSchema
+	sequences  []SequenceSchema
+	types      []TypeSchema
+	privileges []PrivilegeSchema
+	info       DatabaseInfo
+	failed     tableErrors // tables whose schema couldn't be read
+}
+
+// readObjects reads the objects of one side of the comparison, named side
+// in errors and events
+func (c *Comparison) readObjects(ctx context.Context, side string, adapter DatabaseAdapter, db *sql.DB, connStr string, kinds objectKinds) (databaseObjects, error) {
+	var objects databaseObjects
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Getting the %s's tables...", side)})
+	var tables []string
+	err := c.retry(ctx, side+" table list", func() (err error) {
+		tables, err = adapter.GetTableList(ctx, db)
+		return err
+	})
+	if err != nil {
+		return objects, fmt.Errorf("failed to get %s tables: %w", side, err)
+	}
+	tables = slices.DeleteFunc(tables, c.Options.ignoredTable)
+	err = c.retry(ctx, side+" schemas", func() (err error) {
+		objects.schemas, err = getAllTableSchemas(ctx, adapter, db, tables)
+		return err
+	})
+	if errors.As(err, &objects.failed) {
+		err = nil
+	}
+	if err != nil {
+		return objects, fmt.Errorf("failed to get %s schemas: %w", side, err)
+	}
+	for name, schema := range objects.schemas {
+		objects.schemas[name] = c.Options.withoutIgnoredColumns(schema)
+	}
+
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Getting the %s's views and other objects...", side)})
+	err = c.retry(ctx, side+" views", func() (err error) {
+		objects.views, err = adapter.GetViews(ctx, db)
+		return err
+	})
+	if err != nil {
+		return objects, fmt.Errorf("failed to get %s views: %w", side, err)
+	}
+	if kinds.Routines {
+		err = c.retry(ctx, side+" routines", func() (err error) {
+			objects.routines, err = adapter.GetRoutines(ctx, db)
+			return err
+		})
+		if err != nil {
+			return objects, fmt.Errorf("failed to get %s routines: %w", side, err)
+		}
+	}
+	if kinds.Sequences {
+		err = c.retry(ctx, side+" sequences", func() (err error) {
+			objects.sequences, err = adapter.GetSequences(ctx, db)
+			return err
+		})
+		if err != nil {
+			return objects, fmt.Errorf("failed to get %s sequences: %w", side, err)
+		}
+	}
+	if kinds.Types {
+		err = c.retry(ctx, side+" types", func() (err error) {
+			objects.types, err = adapter.GetTypes(ctx, db)
+			return err
+		})
+		if err != nil {
+			return objects, fmt.Errorf("failed to get %s types: %w", side, err)
+		}
+	}
+	if kinds.Privileges {
+		err = c.retry(ctx, side+" privileges", func() (err error) {
+			objects.privileges, err = adapter.GetPrivileges(ctx, db)
+			return err
+		})
+		if err != nil {
+			return objects, fmt.Errorf("failed to get %s privileges: %w", side, err)
+		}
+	}
+
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Collecting %s database information...", side)})
+	objects.info, err = GetDatabaseInfo(ctx, adapter, db, connStr, c.Options.TableSizes)
+	if err != nil {
+		c.emit(Event{Type: EventWarning, Message: "Couldn't collect full " + side + " database info", Err: err})
+	}
+	return objects, nil
+}
+
+// CompareData compares row counts, checksums and, if enabled, rows of the
+// tables that exist in both databases. When ctx is cancelled it stops and
+// marks the summary as interrupted. Tables that fail or exceed a timeout
+// are recorded in SkippedTables and Errors and the comparison moves on.
+func (c *Comparison) CompareData(ctx context.Context, summary *ComparisonSummary) {
+	defer c.monitorLoad(ctx)()
+	ctx = withQueryTimeout(ctx, c.Options.QueryTimeout)
+	ctx = withThrottle(ctx, c.Options)
+	totalTables := len(summary.CommonTables)
+	defer c.endSnapshots()
+
+	if c.crossEngine() && c.Options.ChunkSize > 0 {
+		c.emit(Event{Type: EventWarning, Message: "Checksums can't be compared between different database types, ignoring --chunk-size"})
+	}
+	if c.Options.Reconcile && summary.Reconciliation == nil {
+		summary.Reconciliation = newReconciliation(c.targetAdapter())
+		if c.crossEngine() {
+			c.emit(Event{Type: EventWarning, Message: "Tables can't be created or altered from a different type of database, only rows are reconciled"})
+		} else {
+			summary.Reconciliation.reconcileSchema(summary)
+		}
+	}
+	if c.Options.textNormalized() && !c.Options.RowDiff && c.Options.ChunkSize > 0 {
+		c.emit(Event{Type: EventWarning, Message: "Chunk checksums compare strings byte by byte, --string-compare collation, --unicode-normalize, --trim-trailing-whitespace and --ignore-char-padding only apply with --row-diff"})
+	}
+	c.configureSoftDeletes(summary)
+	c.configureDigests(summary)
+	c.configureKeyOrders(summary)
+	if c.Options.RowDiff {
+		attached, err := c.attachSQLite(ctx)
+		if err != nil {
+			c.emit(Event{Type: EventWarning, Message: "Couldn't attach the target database to the source, comparing rows one by one", Err: err})
+		} else if attached != nil {
+			c.attached = attached
+			defer func() {
+				attached.close()
+				c.attached = nil
+			}()
+		}
+	}
+	if c.Checkpoint != nil && len(c.Checkpoint.Tables) > 0 {
+		c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Resuming, %d tables were already compared", len(c.Checkpoint.Tables))})
+	}
+	lastPercentReported := -1
+
+	for i, tableName := range summary.CommonTables {
+		if ctx.Err() != nil {
+			break
+		}
+		// Tables before this one are complete; the count stops here if cancelled mid-table
+		summary.TotalTablesChecked = i
+
+		// Calculate and report progress
+		currentPercent := (i * 100) / totalTables
+		if currentPercent > lastPercentReported {
+			c.emit(Event{Type: EventProgress, Percent: currentPercent})
+			lastPercentReported = currentPercent
+		}
+
+		if c.Checkpoint.restore(summary, tableName) {
+			c.emit(Event{Type: EventTableFinished, Table: tableName})
+			continue
+		}
+
+		c.emit(Event{Type: EventTableStarted, Table: tableName})
+		suppressed := len(summary.Suppressed)
+		rowsScanned, err := c.compareTableWithTimeout(ctx, summary, tableName)
+		if err == nil {
+			if err := c.Checkpoint.record(summary, tableName, summary.Suppressed[suppressed:]); err != nil {
+				c.emit(Event{Type: EventWarning, Table: tableName, Message: "Failed to write the checkpoint", Err: err})
+			}
+		}
+		c.emit(Event{Type: EventTableFinished, Table: tableName, Err: err, RowsScanned: rowsScanned})
+	}
+
+	if len(c.Options.Checks) > 0 && ctx.Err() == nil {
+		c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Running %d checks", len(c.Options.Checks))})
+		c.runChecks(ctx, summary)
+	}
+
+	summary.Interrupted = ctx.Err() != nil
+	if !summary.Interrupted {
+		summary.TotalTablesChecked = totalTables
+		c.emit(Event{Type: EventProgress, Percent: 100})
+	}
+}
+
+// compareTableWithTimeout runs compareTableData under the table timeout
+// and records the table as skipped if it or one of its queries times out
+func (c *Comparison) compareTableWithTimeout(ctx context.Context, summary *ComparisonSummary, tableName string) (int64, error) {
+	tableCtx := ctx
+	if c.Options.TableTimeout > 0 {
+		var cancel context.CancelFunc
+		tableCtx, cancel = context.WithTimeout(ctx, c.Options.TableTimeout)
+		defer cancel()
+	}
+
+	rowsScanned, err := c.compareTableData(tableCtx, summary, tableName)
+	if err != nil && ctx.Err() == nil && tableCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w (%s): %w", errTableTimeout, c.Options.TableTimeout, err)
+	}
+	if isTimeout(err) {
+		summary.skipTable(tableName, "timeout", err)
+	} else if err != nil && ctx.Err() == nil {
+		summary.skipTable(tableName, "error", err)
+	}
+	return rowsScanned, err
+}
+
+// phaseError is an error of a step of a table's comparison, e.g. reading
+// its row counts, and of one of the databases when only it failed
+type phaseError struct {
+	phase string // "schema", "row counts", "column stats", "distributions", "sample", "chunk checksums", "checksums" or "rows"
+	side  string // "source", "target" or "" for either
+	err   error
+}
+
+func (e *phaseError) Error() string {
+	return e.phase + ": " + e.err.Error()
+}
+
+func (e *phaseError) Unwrap() error {
+	return e.err
+}
+
+// inPhase returns err as an error of a step of a table's comparison, nil
+// if it's nil. An error already of a step, of the database that failed, is
+// returned as it is.
+func inPhase(phase, side string, err error) error {
+	var stepErr *phaseError
+	if err == nil || errors.As(err, &stepErr) {
+		return err
+	}
+	return &phaseError{phase: phase, side: side, err: err}
+}
+
+// isTimeout reports whether err comes from --query-timeout or --table-timeout
+func isTimeout(err error) bool {
+	return errors.Is(err, errQueryTimeout) || errors.Is(err, errTableTimeout)
+}
+
+// compareTableData compares one table and returns the number of rows it covered
+func (c *Comparison) compareTableData(ctx context.Context, summary *ComparisonSummary, tableName string) (int64, error) {
+	sourceSchema := summary.SourceSchemas[tableName]
+	targetSchema := summary.TargetSchemas[tableName]
+
+	// Compare row counts
+	var sourceCount, targetCount int
+	estimated := false
+	err := c.retry(ctx, "row counts of "+tableName, func() (err error) {
+		if c.Options.ApproxCounts {
+			if sourceCount, targetCount, estimated, err = c.estimateRows(ctx, tableName); err != nil || estimated {
+				return inPhase("row counts", "", err)
+			}
+		}
+		if sourceCount, err = c.Adapter.CountRows(ctx, c.SourceDB, tableName); err != nil {
+			return inPhase("row counts", "source", err)
+		}
+		targetCount, err = c.targetAdapter().CountRows(ctx, c.TargetDB, tableName)
+		return inPhase("row counts", "target", err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	var rowsScanned int64
+	countsMatch := sourceCount == targetCount
+	if estimated {
+		countsMatch = c.Options.withinApproxTolerance(sourceCount, targetCount)
+	} else {
+		rowsScanned = int64(sourceCount) + int64(targetCount)
+	}
+
+	rowCountDifference := rowCountDifference(tableName, sourceCount, targetCount, estimated)
+	if !countsMatch && !c.suppress(summary, rowCountDifference) {
+		summary.DifferentRowCounts[tableName] = struct{ Source, Target int }{sourceCount, targetCount}
+		if estimated {
+			if summary.EstimatedRowCounts == nil {
+				summary.EstimatedRowCounts = make(map[string]bool)
+			}
+			summary.EstimatedRowCounts[tableName] = true
+		}
+		summary.addDifferentTable(tableName)
+		c.emit(Event{Type: EventDifferenceFound, Table: tableName, Message: rowCountDifference.Message})
+	}
+
+	if c.Options.ColumnStats {
+		if err := c.compareTableStats(ctx, summary, sourceSchema, targetSchema); err != nil {
+			return rowsScanned, inPhase("column stats", "", err)
+		}
+	}
+
+	if len(c.Options.Distributions) > 0 {
+		if err := c.compareTableDistributions(ctx, summary, sourceSchema, targetSchema, sourceCount, targetCount); err != nil {
+			return rowsScanned, inPhase("distributions", "", err)
+		}
+	}
+
+	if c.Options.SamplePercent > 0 || c.Options.SampleRows > 0 {
+		reason := c.unsampleable(sourceSchema, targetSchema)
+		if reason == "" {
+			return rowsScanned, inPhase("sample", "", c.compareTableSample(ctx, summary, sourceSchema, targetSchema, sourceCount))
+		}
+		c.emit(Event{Type: EventWarning, Table: tableName, Message: fmt.Sprintf("Table '%s' can't be sampled, %s, comparing all its rows", tableName, reason)})
+	}
+
+	unkeyed := len(sourceSchema.PrimaryKeys) == 0 && len(targetSchema.PrimaryKeys) == 0
+
+	// The chunks compared are recorded in the checkpoint, so the comparison
+	// resumes inside the table if it's interrupted
+	progress := c.Checkpoint.progress(tableName)
+	defer func() {
+		if err := progress.saveError(); err != nil {
+			c.emit(Event{Type: EventWarning, Table: tableName, Message: "Failed to write the checkpoint", Err: err})
+		}
+	}()
+	var chunks []Chunk
+	if c.Options.ChunkSize > 0 && len(sourceSchema.PrimaryKeys) > 0 && !c.crossEngine() {
+		var chunkResult ChunkResult
+		err := c.retry(ctx, "chunk checksums of "+tableName, func() (err error) {
+			chunkResult, err = compareTableChunks(ctx, c.Adapter, c.targetAdapter(), c.SourceDB, c.TargetDB, sourceSchema, targetSchema, c.Options.ChunkSize, c.Options.Parallel, progress)
+			return err
+		})
+		if err != nil {
+			return rowsScanned, inPhase("chunk checksums", "", err)
+		}
+		if !chunkResult.HasDifferences() {
+			return rowsScanned, nil
+		}
+
+		// Checksums compare strings byte by byte, when row diffs normalize
+		// them only the rows of the differing chunks tell whether the table
+		// differs
+		if !c.Options.textNormalized() || !c.Options.RowDiff {
+			chunkDifference := chunkDifference(chunkResult)
+			if c.suppress(summary, chunkDifference) {
+				return rowsScanned, nil
+			}
+			summary.ChunkDifferences[tableName] = chunkResult
+			summary.addDifferentTable(tableName)
+			c.emit(Event{Type: EventDifferenceFound, Table: tableName, Chunks: &chunkResult, Message: chunkDifference.Message})
+		}
+
+		// Only the differing chunks need to be compared row by row
+		chunks = chunkResult.DifferentChunks
+		if !c.Options.RowDiff {
+			return rowsScanned, nil
+		}
+	} else {
+		// Tables without a primary key can't be split into chunks, with
+		// --chunk-size their rows are compared as multisets instead
+		if !c.Options.RowDiff && !(unkeyed && c.Options.ChunkSize > 0) {
+			return rowsScanned, nil
+		}
+
+		// Only drill into rows when the counts or the checksum say the data
+		// differs. Tables without a primary key are compared right away, the
+		// checksums can't tell duplicates apart or order their rows reliably.
+		if countsMatch && !c.crossEngine() && !unkeyed {
+			var checksum ChecksumResult
+			err := c.retry(ctx, "checksums of "+tableName, func() (err error) {
+				checksum, err = compareTableChecksums(ctx, c.Adapter, c.targetAdapter(), c.SourceDB, c.TargetDB, tableName, sourceSchema)
+				return err
+			})
+			if err != nil {
+				return rowsScanned, inPhase("checksums", "", err)
+			}
+			if !checksum.Different {
+				return rowsScanned, nil
+			}
+			if !c.Options.textNormalized() {
+				c.emit(Event{Type: EventDifferenceFound, Table: tableName,
+					Message: fmt.Sprintf("Table '%s' has different data (%s differs)", tableName, checksum.Method)})
+			}
+		}
+	}
+
+	var rowResult RowDiffResult
+	err = c.retry(ctx, "rows of "+tableName, func() (err error) {
+		if unkeyed {
+			rowResult, err = compareUnkeyedRows(ctx, c.Adapter, c.targetAdapter(), c.SourceDB, c.TargetDB, sourceSchema, targetSchema, c.Options)
+			return err
+		}
+		// A retry starts the table's statements over
+		onRow := rowHandlers(summary.Reconciliation.table(targetSchema), c.DiffRows.table(targetSchema))
+		// The ranges of an interrupted comparison are kept, to resume it
+		// with those not compared yet
+		ranges, err := progress.ranges(func() ([]Chunk, error) {
+			ranges := chunks
+			if ranges == nil && c.attached == nil && c.Options.Parallel > 1 && len(sourceSchema.PrimaryKeys) > 0 && !c.crossEngine() {
+				// Split the table into a range per connection. Across engines
+				// the source's key values may not bind on the target.
+				rangeSize := max(1, (sourceCount+c.Options.Parallel-1)/c.Options.Parallel)
+				ranges, err := getChunks(ctx, c.Adapter, c.SourceDB, tableName, sourceSchema.PrimaryKeys, rangeSize)
+				return ranges, inPhase("rows", "source", err)
+			}
+			if ranges == nil {
+				ranges = []Chunk{{}}
+			}
+			return ranges, nil
+		})
+		if err != nil {
+			return err
+		}
+		if c.attached != nil {
+			rowResult, err = compareAttachedRows(ctx, c.attached, sourceSchema, targetSchema, ranges, c.Options, onRow, progress)
+			return err
+		}
+		rowResult, err = compareTableRows(ctx, c.Adapter, c.targetAdapter(), c.SourceDB, c.TargetDB, sourceSchema, targetSchema, ranges, c.Options, onRow, progress)
+		return err
+	})
+	if err != nil {
+		summary.Reconciliation.drop(tableName)
+		c.DiffRows.drop(tableName)
+		return rowsScanned, inPhase("rows", "", err)
+	}
+
+	if !rowResult.HasDifferences() {
+		return rowsScanned, nil
+	}
+
+	rowDifference := rowDifference(rowResult)
+	if c.suppress(summary, rowDifference) {
+		summary.Reconciliation.drop(tableName)
+		c.DiffRows.drop(tableName)
+		return rowsScanned, nil
+	}
+	summary.RowDifferences[tableName] = rowResult
+	summary.addDifferentTable(tableName)
+	c.emit(Event{Type: EventDifferenceFound, Table: tableName, Rows: &rowResult, Message: rowDifference.Message})
+	if rowResult.NoPrimaryKey && (summary.Reconciliation != nil || c.DiffRows != nil) {
+		c.emit(Event{Type: EventWarning, Table: tableName,
+			Message: fmt.Sprintf("Table '%s' has no primary key, its differing rows can't be reconciled or written to --diff-rows-out", tableName)})
+	}
+
+	return rowsScanned, nil
+}
+
+// unsampleable returns why the rows of a table can't be sampled, empty if
+// they can. Samples are picked by hashing the primary key as text, which
+// the engines only write alike for integers and text.
+func (c *Comparison) unsampleable(sourceSchema, targetSchema TableSchema) string {
+	if len(sourceSchema.PrimaryKeys) == 0 {
+		return "it has no primary key"
+	}
+	if !c.crossEngine() {
+		return ""
+	}
+	for _, schema := range []TableSchema{sourceSchema, targetSchema} {
+		for _, col := range schema.Columns {
+			if slices.Contains(sourceSchema.PrimaryKeys, col.Name) && !isIntegerType(col.DataType) && !isTextType(col.DataType) {
+				return fmt.Sprintf("the engines hash its %s key column %s differently", col.DataType, col.Name)
+			}
+		}
+	}
+	return ""
+}
+
+// compareTableSample compares a sample of the rows of a table, of
+// SampleRows of its sourceCount rows or SamplePercent of them
+func (c *Comparison) compareTableSample(ctx context.Context, summary *ComparisonSummary, sourceSchema, targetSchema TableSchema, sourceCount int) error {
+	tableName := sourceSchema.Name
+	percent := c.Options.SamplePercent
+	if c.Options.SampleRows > 0 {
+		percent = min(100, 100*float64(c.Options.SampleRows)/float64(max(sourceCount, 1)))
+	}
+
+	var result RowDiffResult
+	err := c.retry(ctx, "sample of "+tableName, func() (err error) {
+		onRow := c.DiffRows.table(targetSchema)
+		result, err = compareSampleRows(ctx, c.Adapter, c.targetAdapter(), c.SourceDB, c.TargetDB, sourceSchema, targetSchema, percent, c.Options, onRow)
+		return err
+	})
+	if err != nil {
+		c.DiffRows.drop(tableName)
+		return err
+	}
+	if !result.HasDifferences() {
+		return nil
+	}
+
+	rowDifference := rowDifference(result)
+	if c.suppress(summary, rowDifference) {
+		c.DiffRows.drop(tableName)
+		return nil
+	}
+	summary.RowDifferences[tableName] = result
+	summary.addDifferentTable(tableName)
+	c.emit(Event{Type: EventDifferenceFound, Table: tableName, Rows: &result, Message: rowDifference.Message})
+	return nil
+}
+
+func rowCountDifference(tableName string, sourceCount, targetCount int, estimated bool) Difference {
+	message := fmt.Sprintf("Table '%s' has different row counts: source=%d, target=%d", tableName, sourceCount, targetCount)
+	if estimated {
+		message = fmt.Sprintf("Table '%s' has different estimated row counts: source~%d, target~%d", tableName, sourceCount, targetCount)
+	}
+	return Difference{Table: tableName, ObjectType: "data", Kind: DiffModified,
+		Property: "row count", Source: fmt.Sprint(sourceCount), Target: fmt.Sprint(targetCount), Message: message}
+}
+
+// estimateRows returns the row counts of a table both databases' statistics
+// estimate, and whether they have them. Tables with a row filter, e.g. of
+// soft deletes, are counted instead.
+func (c *Comparison) estimateRows(ctx context.Context, tableName string) (int, int, bool, error) {
+	sides := []struct {
+		adapter DatabaseAdapter
+		db      *sql.DB
+	}{{c.Adapter, c.SourceDB}, {c.targetAdapter(), c.TargetDB}}
+	counts := make([]int, len(sides))
+	for i, side := range sides {
+		if side.adapter.RowSource(tableName) != side.adapter.QuoteTable(tableName) {
+			return 0, 0, false, nil
+		}
+		var err error
+		if counts[i], err = side.adapter.EstimateRows(ctx, side.db, tableName); err != nil || counts[i] < 0 {
+			return 0, 0, false, err
+		}
+	}
+	return counts[0], counts[1], true, nil
+}
+
+// withinApproxTolerance reports whether estimated row counts differ by no
+// more than ApproxTolerance percent of the larger one
+func (o CompareOptions) withinApproxTolerance(sourceCount, targetCount int) bool {
+	delta := math.Abs(float64(sourceCount - targetCount))
+	return delta <= o.ApproxTolerance/100*float64(max(sourceCount, targetCount))
+}
+
+func chunkDifference(result ChunkResult) Difference {
+	return Difference{Table: result.Table, ObjectType: "data", Kind: DiffModified, Property: "chunks",
+		Message: fmt.Sprintf("Table '%s' has different data in %d of %d chunks",
+			result.Table, len(result.DifferentChunks), result.TotalChunks)}
+}
+
+func rowDifference(result RowDiffResult) Difference {
+	message := fmt.Sprintf("Table '%s' has differing rows: %d inserted, %d deleted, %d changed",
+		result.Table, result.Inserted, result.Deleted, result.Changed)
+	if result.NoPrimaryKey {
+		message = fmt.Sprintf("Table '%s' has differing rows: %d inserted, %d deleted, compared as whole rows as it has no primary key",
+			result.Table, result.Inserted, result.Deleted)
+	}
+	if result.SamplePercent > 0 {
+		message = fmt.Sprintf("Table '%s' has differing rows in a %s%% sample: %d inserted, %d deleted, %d changed of %d, an estimated %.2f%% of the table",
+			result.Table, strconv.FormatFloat(result.SamplePercent, 'g', 3, 64), result.Inserted, result.Deleted, result.Changed, result.Compared, 100*result.mismatchRate())
+	}
+	return Difference{Table: result.Table, ObjectType: "data", Kind: DiffModified, Property: "rows", Message: message}
+}
+
+// Differences returns all differences in the summary, of tables, other
+// objects and data, ordered by table
+func (s ComparisonSummary) Differences() []Difference {
+	diffs := []Difference{}
+	for _, table := range s.MissingTables {
+		diffs = append(diffs, Difference{Table: table, ObjectType: "table", ObjectName: table, Kind: DiffMissing,
+			Message: fmt.Sprintf("Table '%s' exists in source but not in target", table)})
+	}
+	for _, table := range s.ExtraTables {
+		diffs = append(diffs, Difference{Table: table, ObjectType: "table", ObjectName: table, Kind: DiffExtra,
+			Message: fmt.Sprintf("Table '%s' exists in target but not in source", table)})
+	}
+	for _, tableDiffs := range s.SchemaDifferences {
+		diffs = append(diffs, tableDiffs...)
+	}
+	for table, counts := range s.DifferentRowCounts {
+		diffs = append(diffs, rowCountDifference(table, counts.Source, counts.Target, s.EstimatedRowCounts[table]))
+	}
+	for _, result := range s.ChunkDifferences {
+		diffs = append(diffs, chunkDifference(result))
+	}
+	for _, result := range s.RowDifferences {
+		diffs = append(diffs, rowDifference(result))
+	}
+	for _, tableDiffs := range s.StatDifferences {
+		diffs = append(diffs, tableDiffs...)
+	}
+	diffs = append(diffs, s.CheckDifferences...)
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].Table != diffs[j].Table {
+			return diffs[i].Table < diffs[j].Table
+		}
+		return diffs[i].Message < diffs[j].Message
+	})
+	return append(diffs, s.ObjectDifferences...)
+}
+
+// HasSchemaDifferences reports whether tables or other objects differ
+func (s ComparisonSummary) HasSchemaDifferences() bool {
+	return len(s.MissingTables)+len(s.ExtraTables)+len(s.SchemaDifferences)+len(s.ObjectDifferences) > 0
+}
+
+// HasRowCountDifferences reports whether any table has a different number of rows
+func (s ComparisonSummary) HasRowCountDifferences() bool {
+	return len(s.DifferentRowCounts) > 0
+}
+
+// HasDataDifferences reports whether rows, chunks or column stats of any
+// table, or the results of checks, differ
+func (s ComparisonSummary) HasDataDifferences() bool {
+	return len(s.RowDifferences)+len(s.ChunkDifferences)+len(s.StatDifferences)+len(s.CheckDifferences) > 0
+}
+
+func (s *ComparisonSummary) addDifferentTable(tableName string) {
+	if !contains(s.DifferentTables, tableName) {
+		s.DifferentTables = append(s.DifferentTables, tableName)
+	}
+}
+
+// skipTable records a table the comparison went on without because of err
+func (s *ComparisonSummary) skipTable(tableName, reason string, err error) {
+	s.SkippedTables[tableName] = reason
+	tableError := TableError{Table: tableName, Reason: reason, Error: err.Error(), SQLError: databaseError(err)}
+	var phase *phaseError
+	if errors.As(err, &phase) {
+		tableError.Phase, tableError.Side = phase.phase, phase.side
+	}
+	s.Errors = append(s.Errors, tableError)
+}
+
+// errorsOf returns the errors that made the comparison skip a table
+func (s ComparisonSummary) errorsOf(tableName string) []TableError {
+	var errs []TableError
+	for _, tableError := range s.Errors {
+		if tableError.Table == tableName {
+			errs = append(errs, tableError)
+		}
+	}
+	return errs
+}
+
+// databaseError returns the message of the error a database returned
+// that err wraps, or "" if it wraps none
+func databaseError(err error) string {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		return mysqlErr.Error()
+	case errors.As(err, &pqErr):
+		return pqErr.Error()
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Error()
+	}
+	return ""
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errExplained is what the statements --explain prints instead of running
+// fail with
+var errExplained = errors.New("not run, explained")
+
+type explainKey struct{}
+
+// explaining is what withExplain adds to a context
+type explaining struct {
+	log *explainLog
+	run bool
+}
+
+// withExplain makes every statement executed with ctx through a pool the
+// log names print to it, and fail with errExplained instead of running
+// unless run is set
+func withExplain(ctx context.Context, log *explainLog, run bool) context.Context {
+	return context.WithValue(ctx, explainKey{}, explaining{log: log, run: run})
+}
+
+// explainRunning returns ctx with the statements it explains running
+func explainRunning(ctx context.Context) context.Context {
+	if e, ok := ctx.Value(explainKey{}).(explaining); ok && !e.run {
+		return withExplain(ctx, e.log, true)
+	}
+	return ctx
+}
+
+// explainRuns reports whether statements executed with ctx run
+func explainRuns(ctx context.Context) bool {
+	e, ok := ctx.Value(explainKey{}).(explaining)
+	return !ok || e.run
+}
+
+// explainStatement prints a statement of a pool's session if ctx explains
+// statements
+func explainStatement(ctx context.Context, s *session, query string, args []driver.NamedValue) {
+	if e, ok := ctx.Value(explainKey{}).(explaining); ok {
+		e.log.print(s, query, args)
+	}
+}
+
+// explainLog prints the statements of the pools it names as SQL a DBA can
+// review, each followed by its bind parameters, under the name of the
+// database it runs on
+type explainLog struct {
+	mu    sync.Mutex
+	out   io.Writer
+	names map[*session]string
+	last  string // the database of the statement printed last
+}
+
+// name names the database of a pool, whose statements it prints from now
+// on. A pool reading both databases is named after both.
+func (l *explainLog) name(db *sql.DB, name string) {
+	s, err := poolSession(db)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.names == nil {
+		l.names = make(map[*session]string)
+	}
+	if existing, ok := l.names[s]; ok && existing != name {
+		name = existing + " and " + name
+	}
+	l.names[s] = name
+}
+
+// section starts a part of the output, which the comparison may run or not
+func (l *explainLog) section(title string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "\n-- === %s ===\n", title)
+	l.last = ""
+}
+
+func (l *explainLog) print(s *session, query string, args []driver.NamedValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name, ok := l.names[s]
+	if !ok {
+		return
+	}
+	if name != l.last {
+		fmt.Fprintf(l.out, "-- %s\n", name)
+		l.last = name
+	}
+	query = strings.TrimSpace(query)
+	if !strings.HasSuffix(query, ";") {
+		query += ";"
+	}
+	fmt.Fprintln(l.out, query)
+	if len(args) > 0 {
+		values := make([]string, len(args))
+		for i, arg := range args {
+			values[i] = fmt.Sprintf("$%d = %s", arg.Ordinal, explainValue(arg.Value))
+		}
+		fmt.Fprintf(l.out, "-- with %s\n", strings.Join(values, ", "))
+	}
+}
+
+// explainValue formats a bind parameter as an SQL literal
+func explainValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return "'" + v.Format(time.RFC3339Nano) + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Explain prints the statements of the comparison to out without comparing
+// the data. The statements reading the databases' schemas run, as which
+// data queries the comparison runs depends on them; those comparing the
+// data of each common table are printed but don't run.
+func (c *Comparison) Explain(ctx context.Context, out io.Writer) error {
+	log := &explainLog{out: out}
+	log.name(c.SourceDB, "source")
+	log.name(c.TargetDB, "target")
+
+	log.section("Reading the schemas, run")
+	ctx = withExplain(ctx, log, true)
+	defer c.endSnapshots()
+	summary, err := c.Introspect(ctx)
+	if err != nil {
+		return err
+	}
+	c.configureSoftDeletes(&summary)
+	c.configureDigests(&summary)
+	c.configureKeyOrders(&summary)
+	if c.Options.RowDiff {
+		attached, err := c.attachSQLite(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to attach the target database to the source: %w", err)
+		}
+		if attached != nil {
+			log.name(attached.db, "source with the target attached")
+			c.attached = attached
+			defer func() {
+				attached.close()
+				c.attached = nil
+			}()
+		}
+	}
+
+	for _, tableName := range summary.CommonTables {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.section(fmt.Sprintf("Comparing the data of %s, not run", tableName))
+		c.explainTable(withExplain(ctx, log, false), summary, tableName)
+	}
+
+	if len(c.Options.Checks) > 0 {
+		log.section("Checks, not run")
+		ctx := withExplain(ctx, log, false)
+		for _, check := range c.Options.Checks {
+			runCheckQuery(ctx, c.SourceDB, check.SQL)
+			runCheckQuery(ctx, c.TargetDB, cmp.Or(check.TargetSQL, check.SQL))
+		}
+	}
+	return nil
+}
+
+// explainTable issues the data queries compareTableData runs on a table,
+// which fail with errExplained once printed. Of queries that depend on what
+// earlier ones return only the first is printed: the first chunk boundary,
+// the checksums and rows of the table as a single chunk, the first page of
+// rows, the range or most frequent values of a distribution, the first
+// statement of the comparison of two attached SQLite files. Steps that only
+// run when the data differs are included.
+func (c *Comparison) explainTable(ctx context.Context, summary ComparisonSummary, tableName string) {
+	sourceSchema, targetSchema := summary.SourceSchemas[tableName], summary.TargetSchemas[tableName]
+	sides := []struct {
+		adapter DatabaseAdapter
+		db      *sql.DB
+		schema  TableSchema
+	}{{c.Adapter, c.SourceDB, sourceSchema}, {c.targetAdapter(), c.TargetDB, targetSchema}}
+	warn := func(err error) {
+		if err != nil && !errors.Is(err, errExplained) {
+			c.emit(Event{Type: EventWarning, Table: tableName, Message: "Couldn't explain the data queries of " + tableName, Err: err})
+		}
+	}
+	each := func(query func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error) {
+		for _, side := range sides {
+			warn(query(side.adapter, side.db, side.schema))
+		}
+	}
+
+	each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+		if c.Options.ApproxCounts && adapter.RowSource(tableName) == adapter.QuoteTable(tableName) {
+			_, err := adapter.EstimateRows(ctx, db, tableName)
+			return err
+		}
+		_, err := adapter.CountRows(ctx, db, tableName)
+		return err
+	})
+
+	columns := commonColumns(sourceSchema, targetSchema)
+	if c.Options.ColumnStats && len(columns) > 0 {
+		each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+			_, err := tableColumnStats(ctx, adapter, db, schema, columns)
+			return err
+		})
+	}
+	for _, column := range distributionColumns(c.Options.Distributions, tableName, columns) {
+		numeric := false
+		for _, col := range sourceSchema.Columns {
+			if col.Name == column {
+				numeric, _ = statTypes(col.DataType)
+			}
+		}
+		each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+			if numeric {
+				_, _, _, err := columnRange(ctx, adapter, db, tableName, column)
+				return err
+			}
+			_, _, err := topValues(ctx, adapter, db, tableName, column, c.Options.TopValues)
+			return err
+		})
+	}
+
+	if (c.Options.SamplePercent > 0 || c.Options.SampleRows > 0) && c.unsampleable(sourceSchema, targetSchema) == "" {
+		rowColumns, err := rowComparisonColumns(sourceSchema, targetSchema)
+		if err != nil {
+			warn(err)
+			return
+		}
+		// The percentage of --sample-rows depends on the row count, which
+		// the statistics estimate
+		percent := c.Options.SamplePercent
+		if c.Options.SampleRows > 0 {
+			rows, err := c.Adapter.EstimateRows(explainRunning(ctx), c.SourceDB, tableName)
+			if err != nil {
+				rows = -1
+			}
+			percent = min(100, 100*float64(c.Options.SampleRows)/float64(max(rows, 1)))
+		}
+		each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+			rows, err := adapter.SampleRows(ctx, db, schema.Name, rowColumns, sourceSchema.PrimaryKeys, percent)
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		})
+		return
+	}
+
+	if len(sourceSchema.PrimaryKeys) == 0 && len(targetSchema.PrimaryKeys) == 0 {
+		if c.Options.RowDiff || c.Options.ChunkSize > 0 {
+			each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+				rows, err := adapter.StreamRows(ctx, db, schema.Name, columns, nil, Chunk{}, 0)
+				if err == nil {
+					rows.Close()
+				}
+				return err
+			})
+		}
+		return
+	}
+
+	if c.Options.ChunkSize > 0 && len(sourceSchema.PrimaryKeys) > 0 && !c.crossEngine() {
+		rowColumns, err := rowComparisonColumns(sourceSchema, targetSchema)
+		if err != nil {
+			warn(err)
+			return
+		}
+		_, err = c.Adapter.GetChunkBoundary(ctx, c.SourceDB, tableName, sourceSchema.PrimaryKeys, nil, c.Options.ChunkSize)
+		warn(err)
+		each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+			_, err := adapter.ChunkChecksums(ctx, db, schema.Name, rowColumns, sourceSchema.PrimaryKeys, []Chunk{{}})
+			return err
+		})
+	} else if c.Options.RowDiff && !c.crossEngine() {
+		each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+			_, _, err := adapter.TableChecksum(ctx, db, tableName, sourceSchema)
+			return err
+		})
+	}
+	if !c.Options.RowDiff {
+		return
+	}
+
+	if c.attached != nil {
+		_, err := compareAttachedRows(ctx, c.attached, sourceSchema, targetSchema, nil, c.Options, nil, nil)
+		warn(err)
+		return
+	}
+	rowColumns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		warn(err)
+		return
+	}
+	each(func(adapter DatabaseAdapter, db *sql.DB, schema TableSchema) error {
+		rows, err := adapter.StreamRows(ctx, db, sourceSchema.Name, rowColumns, sourceSchema.PrimaryKeys, Chunk{}, c.Options.PageSize)
+		if err == nil {
+			rows.Close()
+		}
+		return err
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// canonicalSnapshot removes what changes without the schema changing, the
+// time taken and sequence values, and puts everything in a stable order,
+// so that equal schemas hash the same
+func canonicalSnapshot(snapshot Snapshot) Snapshot {
+	snapshot.CreatedAt = time.Time{}
+
+	tables := make(map[string]TableSchema, len(snapshot.Tables))
+	for name, table := range snapshot.Tables {
+		tables[name] = canonicalTable(table)
+	}
+	snapshot.Tables = tables
+
+	views := make([]ViewSchema, len(snapshot.Views))
+	for i, view := range snapshot.Views {
+		views[i] = ViewSchema{Name: view.Name, Definition: normalizeDefinition(view.Definition)}
+	}
+	snapshot.Views = views
+
+	routines := make([]RoutineSchema, len(snapshot.Routines))
+	for i, routine := range snapshot.Routines {
+		routine.Definition = normalizeRoutineDefinition(routine.Definition)
+		routines[i] = routine
+	}
+	snapshot.Routines = routines
+
+	sequences := make([]SequenceSchema, len(snapshot.Sequences))
+	for i, sequence := range snapshot.Sequences {
+		sequence.CurrentValue = 0
+		sequences[i] = sequence
+	}
+	snapshot.Sequences = sequences
+
+	privileges := append([]PrivilegeSchema(nil), snapshot.Privileges...)
+	sort.Slice(privileges, func(i, j int) bool {
+		return fmt.Sprint(privileges[i]) < fmt.Sprint(privileges[j])
+	})
+	snapshot.Privileges = privileges
+	return snapshot
+}
+
+// canonicalTable sorts the indexes, foreign keys and unique constraints of a
+// table, whose order depends on the database. Columns keep their order.
+func canonicalTable(table TableSchema) TableSchema {
+	table.Indexes = append([]IndexSchema(nil), table.Indexes...)
+	sort.SliceStable(table.Indexes, func(i, j int) bool { return table.Indexes[i].Name < table.Indexes[j].Name })
+	table.ForeignKeys = append([]ForeignKeySchema(nil), table.ForeignKeys...)
+	sort.SliceStable(table.ForeignKeys, func(i, j int) bool { return table.ForeignKeys[i].Name < table.ForeignKeys[j].Name })
+	table.UniqueConstraints = append([]UniqueConstraintSchema(nil), table.UniqueConstraints...)
+	sort.SliceStable(table.UniqueConstraints, func(i, j int) bool {
+		return table.UniqueConstraints[i].Name < table.UniqueConstraints[j].Name
+	})
+	return table
+}
+
+// fingerprint hashes a value's JSON encoding, which orders map keys
+func fingerprint(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// runFingerprint runs the fingerprint subcommand: it prints a hash of the
+// canonical schema of a database and, with --tables, one per table
+func runFingerprint(args []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	perTable := fs.Bool("tables", false, "also print a hash of each table")
+	comparePrivileges := fs.Bool("compare-privileges", false, "include the privileges granted on the database and its tables")
+	promptPasswords := fs.Bool("prompt-passwords", false, "ask for the password instead of reading it from the connection string or "+targetPasswordEnv)
+	logLevel := fs.String("log-level", "warn", "log verbosity: debug (includes every SQL statement), info or warn")
+	fs.Usage = func() {
+		fmt.Println("Usage: mudrockdbcompare fingerprint [options] [db-type] [connection-string]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare fingerprint mysql \"user:password@localhost:3306/dbname\"")
+		fmt.Println("  mudrockdbcompare fingerprint --tables sqlite \"path/to/db.db\"")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := setupLogging(*logLevel, "text"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	dbType := fs.Arg(0)
+	adapter, err := GetAdapter(dbType)
+	if err != nil {
+		fatal("Invalid database type", err)
+	}
+	ctx := context.Background()
+	db, _ := connectDatabase(ctx, adapter, fs.Arg(1), *promptPasswords)
+	defer db.Close()
+
+	snapshot, err := takeSnapshot(ctx, adapter, db, dbType, *comparePrivileges)
+	if err != nil {
+		fatal("Failed to read the schema", err)
+	}
+	snapshot = canonicalSnapshot(snapshot)
+
+	hash, err := fingerprint(snapshot)
+	if err != nil {
+		fatal("Failed to hash the schema", err)
+	}
+	fmt.Println(hash)
+
+	if *perTable {
+		names := make([]string, 0, len(snapshot.Tables))
+		for name := range snapshot.Tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			hash, err := fingerprint(snapshot.Tables[name])
+			if err != nil {
+				fatal("Failed to hash the schema", err)
+			}
+			fmt.Printf("%s  %s\n", hash, name)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// geometryTypes are the spatial column types of MySQL and PostGIS
+var geometryTypes = map[string]bool{
+	"geometry": true, "geography": true, "point": true, "linestring": true, "polygon": true,
+	"multipoint": true, "multilinestring": true, "multipolygon": true,
+	"geometrycollection": true, "geomcollection": true,
+}
+
+func isGeometryType(dataType string) bool {
+	return geometryTypes[strings.ToLower(dataType)]
+}
+
+// geometry is a decoded spatial value. Points, line strings and polygons
+// have their coordinates in rings, one per polygon ring; multi geometries
+// and collections have parts.
+type geometry struct {
+	srid  uint32
+	kind  uint32 // WKB type: 1 point, 2 line string, 3 polygon, 4 to 6 their multi forms, 7 collection
+	dims  int    // coordinates per point, 2 to 4
+	z, m  bool
+	rings [][][]float64
+	parts []geometry
+}
+
+var geometryNames = map[uint32]string{
+	1: "POINT", 2: "LINESTRING", 3: "POLYGON", 4: "MULTIPOINT",
+	5: "MULTILINESTRING", 6: "MULTIPOLYGON", 7: "GEOMETRYCOLLECTION",
+}
+
+// parseGeometry reads a spatial value as the drivers return it: PostGIS
+// hex-encoded EWKB, MySQL's SRID followed by WKB, or plain WKB
+func parseGeometry(v interface{}) (geometry, bool) {
+	var data []byte
+	switch v := v.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return geometry{}, false
+	}
+	if decoded, err := hex.DecodeString(string(data)); err == nil && len(decoded) > 0 {
+		data = decoded
+	}
+
+	if len(data) > 4 && data[4] <= 1 {
+		r := &wkbReader{data: data[4:]}
+		if g, err := r.geometry(); err == nil && r.pos == len(r.data) {
+			g.srid = binary.LittleEndian.Uint32(data)
+			return g, true
+		}
+	}
+	r := &wkbReader{data: data}
+	g, err := r.geometry()
+	return g, err == nil && r.pos == len(r.data)
+}
+
+// wkbReader decodes (E)WKB
+type wkbReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if r.pos+4 > len(r.data) {
+		return 0, fmt.Errorf("truncated WKB")
+	}
+	v := r.order.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+// count reads the number of elements that follow, each at least size bytes
+func (r *wkbReader) count(size int) (int, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if int(n) > (len(r.data)-r.pos)/size {
+		return 0, fmt.Errorf("truncated WKB")
+	}
+	return int(n), nil
+}
+
+func (r *wkbReader) points(dims int) ([][]float64, error) {
+	n, err := r.count(8 * dims)
+	if err != nil {
+		return nil, err
+	}
+	points := make([][]float64, n)
+	for i := range points {
+		if points[i], err = r.point(dims); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+func (r *wkbReader) point(dims int) ([]float64, error) {
+	if r.pos+8*dims > len(r.data) {
+		return nil, fmt.Errorf("truncated WKB")
+	}
+	point := make([]float64, dims)
+	for i := range point {
+		point[i] = math.Float64frombits(r.order.Uint64(r.data[r.pos:]))
+		r.pos += 8
+	}
+	return point, nil
+}
+
+func (r *wkbReader) geometry() (geometry, error) {
+	var g geometry
+	if r.pos >= len(r.data) {
+		return g, fmt.Errorf("truncated WKB")
+	}
+	switch r.data[r.pos] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return g, fmt.Errorf("invalid WKB byte order")
+	}
+	r.pos++
+
+	kind, err := r.uint32()
+	if err != nil {
+		return g, err
+	}
+	// EWKB flags the SRID and dimensions in the high bits, ISO WKB adds
+	// 1000 for Z, 2000 for M and 3000 for both
+	if kind&0x20000000 != 0 {
+		if g.srid, err = r.uint32(); err != nil {
+			return g, err
+		}
+	}
+	g.z, g.m = kind&0x80000000 != 0, kind&0x40000000 != 0
+	kind &^= 0xE0000000
+	switch kind / 1000 {
+	case 1:
+		g.z = true
+	case 2:
+		g.m = true
+	case 3:
+		g.z, g.m = true, true
+	}
+	g.kind = kind % 1000
+	g.dims = 2
+	if g.z {
+		g.dims++
+	}
+	if g.m {
+		g.dims++
+	}
+
+	switch g.kind {
+	case 1:
+		point, err := r.point(g.dims)
+		if err != nil {
+			return g, err
+		}
+		if !math.IsNaN(point[0]) { // POINT EMPTY has NaN coordinates
+			g.rings = [][][]float64{{point}}
+		}
+	case 2:
+		points, err := r.points(g.dims)
+		if err != nil {
+			return g, err
+		}
+		if len(points) > 0 {
+			g.rings = [][][]float64{points}
+		}
+	case 3:
+		n, err := r.count(4)
+		if err != nil {
+			return g, err
+		}
+		for i := 0; i < n; i++ {
+			ring, err := r.points(g.dims)
+			if err != nil {
+				return g, err
+			}
+			g.rings = append(g.rings, ring)
+		}
+	case 4, 5, 6, 7:
+		n, err := r.count(5)
+		if err != nil {
+			return g, err
+		}
+		for i := 0; i < n; i++ {
+			order := r.order
+			part, err := r.geometry()
+			if err != nil {
+				return g, err
+			}
+			r.order = order
+			g.parts = append(g.parts, part)
+		}
+	default:
+		return g, fmt.Errorf("unsupported WKB type %d", g.kind)
+	}
+	return g, nil
+}
+
+// equalGeometries reports whether two geometries have the same type and
+// shape, with every point within tolerance of the other's. SRIDs only
+// count when both sides have one, MySQL's is often left 0.
+func equalGeometries(a, b geometry, tolerance float64) bool {
+	if a.kind != b.kind || a.dims != b.dims || len(a.rings) != len(b.rings) || len(a.parts) != len(b.parts) ||
+		(a.srid != 0 && b.srid != 0 && a.srid != b.srid) {
+		return false
+	}
+	for i := range a.rings {
+		if len(a.rings[i]) != len(b.rings[i]) {
+			return false
+		}
+		for j := range a.rings[i] {
+			var distance float64
+			for k := range a.rings[i][j] {
+				d := a.rings[i][j][k] - b.rings[i][j][k]
+				distance += d * d
+			}
+			if math.Sqrt(distance) > tolerance {
+				return false
+			}
+		}
+	}
+	for i := range a.parts {
+		if !equalGeometries(a.parts[i], b.parts[i], tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// wkt renders a geometry as Well-Known Text, e.g. "POINT(1 2)"
+func (g geometry) wkt() string {
+	name := geometryNames[g.kind]
+	switch {
+	case g.z && g.m:
+		name += " ZM "
+	case g.z:
+		name += " Z "
+	case g.m:
+		name += " M "
+	}
+	return name + g.wktBody()
+}
+
+func (g geometry) wktBody() string {
+	if len(g.rings) == 0 && len(g.parts) == 0 {
+		return " EMPTY"
+	}
+	ring := func(points [][]float64) string {
+		texts := make([]string, len(points))
+		for i, point := range points {
+			coords := make([]string, len(point))
+			for j, c := range point {
+				coords[j] = strconv.FormatFloat(c, 'f', -1, 64)
+			}
+			texts[i] = strings.Join(coords, " ")
+		}
+		return "(" + strings.Join(texts, ", ") + ")"
+	}
+
+	var texts []string
+	switch g.kind {
+	case 1, 2:
+		return ring(g.rings[0])
+	case 3:
+		for _, r := range g.rings {
+			texts = append(texts, ring(r))
+		}
+	case 7:
+		for _, part := range g.parts {
+			texts = append(texts, part.wkt())
+		}
+	default:
+		for _, part := range g.parts {
+			texts = append(texts, part.wktBody())
+		}
+	}
+	return "(" + strings.Join(texts, ", ") + ")"
+}
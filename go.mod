@@ -5,7 +5,9 @@ go 1.23.8
 require (
 	github.com/go-sql-driver/mysql v1.9.2
 	github.com/lib/pq v1.10.9
-	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/term v0.30.0
+	golang.org/x/text v0.24.0
+	modernc.org/sqlite v1.37.0
 )
 
 require (
@@ -20,5 +22,4 @@ require (
 	modernc.org/libc v1.62.1 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.9.1 // indirect
-	modernc.org/sqlite v1.37.0 // indirect
 )
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Per-table results kept in the history
+const (
+	TableIdentical = "identical"
+	TableDifferent = "different"
+	TableMissing   = "missing" // exists in source but not in target
+	TableExtra     = "extra"   // exists in target but not in source
+	TableSkipped   = "skipped"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	job TEXT NOT NULL,
+	started TEXT NOT NULL,
+	finished TEXT NOT NULL,
+	source TEXT NOT NULL,
+	target TEXT NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL,
+	missing_tables INTEGER NOT NULL,
+	extra_tables INTEGER NOT NULL,
+	schema_differences INTEGER NOT NULL,
+	data_differences INTEGER NOT NULL,
+	skipped_tables INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS run_tables (
+	run_id INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	table_name TEXT NOT NULL,
+	result TEXT NOT NULL,
+	source_rows INTEGER,
+	target_rows INTEGER,
+	details TEXT NOT NULL,
+	PRIMARY KEY (run_id, table_name)
+);
+CREATE INDEX IF NOT EXISTS run_tables_table_name ON run_tables (table_name);
+`
+
+// historyStore records every comparison run in a SQLite database
+type historyStore struct {
+	db *sql.DB
+}
+
+// defaultHistoryFile is where the history is kept unless --history says
+// otherwise, empty if there's no config directory
+func defaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mudrockdbcompare", "history.db")
+}
+
+func openHistory(path string) (*historyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// Not through openDB, the history's statements aren't worth logging
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dsn := url.URL{Scheme: "file", Path: abs, RawQuery: "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &historyStore{db: db}, nil
+}
+
+func (h *historyStore) Close() error {
+	return h.db.Close()
+}
+
+// tableResult is one table's result in a run
+type tableResult struct {
+	Table      string
+	Result     string
+	SourceRows sql.NullInt64 // only known when the row counts differ
+	TargetRows sql.NullInt64
+	Details    []string
+}
+
+// tableResults returns the result of every table in a summary, sorted by
+// name. Tables without differences are left out of interrupted runs, which
+// may not have compared them.
+func tableResults(summary ComparisonSummary) []tableResult {
+	results := make(map[string]*tableResult)
+	result := func(table, kind string) *tableResult {
+		r, ok := results[table]
+		if !ok {
+			r = &tableResult{Table: table, Result: kind}
+			results[table] = r
+		}
+		return r
+	}
+
+	for _, table := range summary.MissingTables {
+		result(table, TableMissing)
+	}
+	for _, table := range summary.ExtraTables {
+		result(table, TableExtra)
+	}
+	for _, diff := range summary.Differences() {
+		if diff.Table == "" || diff.ObjectType == "table" {
+			continue
+		}
+		r := result(diff.Table, TableDifferent)
+		r.Details = append(r.Details, diff.Message)
+	}
+	for table, counts := range summary.DifferentRowCounts {
+		r := result(table, TableDifferent)
+		r.SourceRows = sql.NullInt64{Int64: int64(counts.Source), Valid: true}
+		r.TargetRows = sql.NullInt64{Int64: int64(counts.Target), Valid: true}
+	}
+	for table, reason := range summary.SkippedTables {
+		r := result(table, TableSkipped)
+		r.Details = append(r.Details, reason)
+	}
+	if !summary.Interrupted {
+		for _, table := range summary.CommonTables {
+			result(table, TableIdentical)
+		}
+	}
+
+	sorted := make([]tableResult, 0, len(results))
+	for _, r := range results {
+		sorted = append(sorted, *r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Table < sorted[j].Table })
+	return sorted
+}
+
+func databaseDescription(info DatabaseInfo) string {
+	if info.DatabaseName == "" {
+		return info.Host
+	}
+	return info.Host + "/" + info.DatabaseName
+}
+
+// record adds a finished run to the history and returns its ID there
+func (h *historyStore) record(run Run) (int64, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var source, target string
+	var missing, extra, schema, data, skipped int
+	var tables []tableResult
+	if s := run.Summary; s != nil {
+		source, target = databaseDescription(s.SourceInfo), databaseDescription(s.TargetInfo)
+		n := newNotification(run, "")
+		missing, extra, schema, data, skipped = n.MissingTables, n.ExtraTables, n.SchemaDifferences, n.DataDifferences, n.SkippedTables
+		tables = tableResults(*s)
+	}
+
+	res, err := tx.Exec(`INSERT INTO runs (job, started, finished, source, target, status, error,
+		missing_tables, extra_tables, schema_differences, data_differences, skipped_tables)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Job, run.Started.UTC().Format(time.RFC3339), run.Finished.UTC().Format(time.RFC3339), source, target,
+		run.Status, run.Error, missing, extra, schema, data, skipped)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, t := range tables {
+		_, err := tx.Exec(`INSERT INTO run_tables (run_id, table_name, result, source_rows, target_rows, details)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			id, t.Table, t.Result, t.SourceRows, t.TargetRows, strings.Join(t.Details, "\n"))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// recordHistory records a run in the history file, only logging failures
+// to not fail the comparison over it
+func recordHistory(path string, run Run) {
+	h, err := openHistory(path)
+	if err != nil {
+		slog.Warn("Failed to open the history", "file", path, "error", err)
+		return
+	}
+	defer h.Close()
+	id, err := h.record(run)
+	if err != nil {
+		slog.Warn("Failed to record the run in the history", "file", path, "error", err)
+		return
+	}
+	slog.Debug("Recorded the run in the history", "file", path, "id", id)
+}
+
+// prune deletes the runs of each job, command line runs counting as one
+// job, beyond the newest keepRuns and those started more than keepDays
+// ago, either limit being off when 0. With jobsOnly, command line runs are
+// kept, for a server to prune only the runs of its jobs. It returns the
+// number of runs deleted.
+func (h *historyStore) prune(keepRuns, keepDays int, jobsOnly bool) (int64, error) {
+	var pruned int64
+	only := ""
+	if jobsOnly {
+		only = " AND job <> ''"
+	}
+	if keepDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -keepDays).UTC().Format(time.RFC3339)
+		res, err := h.db.Exec("DELETE FROM runs WHERE started < ?"+only, cutoff)
+		if err != nil {
+			return pruned, err
+		}
+		n, _ := res.RowsAffected()
+		pruned += n
+	}
+	if keepRuns > 0 {
+		res, err := h.db.Exec(`DELETE FROM runs WHERE id IN (
+			SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY job ORDER BY id DESC) AS n FROM runs)
+			WHERE n > ?)`+only, keepRuns)
+		if err != nil {
+			return pruned, err
+		}
+		n, _ := res.RowsAffected()
+		pruned += n
+	}
+	return pruned, nil
+}
+
+// historyRun is a run as the history keeps it
+type historyRun struct {
+	ID                                                 int64
+	Job, Started, Finished, Source, Target             string
+	Status, Error                                      string
+	MissingTables, ExtraTables, SchemaDiffs, DataDiffs int
+	SkippedTables                                      int
+	TableResult                                        string // with list --table, that table's result
+}
+
+func (r historyRun) differences() string {
+	if r.Status == RunFailed && r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("%d missing, %d extra, %d schema, %d data, %d skipped",
+		r.MissingTables, r.ExtraTables, r.SchemaDiffs, r.DataDiffs, r.SkippedTables)
+}
+
+const historyRunColumns = `r.id, r.job, r.started, r.finished, r.source, r.target, r.status, r.error,
+	r.missing_tables, r.extra_tables, r.schema_differences, r.data_differences, r.skipped_tables`
+
+// scanHistoryRun scans historyRunColumns followed by a table's result
+func scanHistoryRun(row interface{ Scan(...interface{}) error }) (historyRun, error) {
+	var r historyRun
+	err := row.Scan(&r.ID, &r.Job, &r.Started, &r.Finished, &r.Source, &r.Target, &r.Status, &r.Error,
+		&r.MissingTables, &r.ExtraTables, &r.SchemaDiffs, &r.DataDiffs, &r.SkippedTables, &r.TableResult)
+	return r, err
+}
+
+// list returns the latest runs, newest first, of job if it's set. With a
+// table, only the runs that compared or found it are returned, with its
+// result.
+func (h *historyStore) list(job, table string, limit int) ([]historyRun, error) {
+	query := "SELECT " + historyRunColumns + ", '' FROM runs r WHERE (? = '' OR r.job = ?)"
+	args := []interface{}{job, job}
+	if table != "" {
+		query = "SELECT " + historyRunColumns + ", t.result FROM runs r JOIN run_tables t ON t.run_id = r.id WHERE (? = '' OR r.job = ?) AND t.table_name = ?"
+		args = append(args, table)
+	}
+	rows, err := h.db.Query(query+" ORDER BY r.id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []historyRun
+	for rows.Next() {
+		r, err := scanHistoryRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// show returns a run and its table results, sql.ErrNoRows if there's no
+// such run
+func (h *historyStore) show(id int64) (historyRun, []tableResult, error) {
+	run, err := scanHistoryRun(h.db.QueryRow("SELECT "+historyRunColumns+", '' FROM runs r WHERE r.id = ?", id))
+	if err != nil {
+		return run, nil, err
+	}
+
+	rows, err := h.db.Query(`SELECT table_name, result, source_rows, target_rows, details
+		FROM run_tables WHERE run_id = ? ORDER BY table_name`, id)
+	if err != nil {
+		return run, nil, err
+	}
+	defer rows.Close()
+	var tables []tableResult
+	for rows.Next() {
+		var t tableResult
+		var details string
+		if err := rows.Scan(&t.Table, &t.Result, &t.SourceRows, &t.TargetRows, &details); err != nil {
+			return run, nil, err
+		}
+		if details != "" {
+			t.Details = strings.Split(details, "\n")
+		}
+		tables = append(tables, t)
+	}
+	return run, tables, rows.Err()
+}
+
+// runHistory runs the history subcommand
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	historyFile := fs.String("history", defaultHistoryFile(), "file the history of comparisons is kept in")
+	job := fs.String("job", "", "with list, only list the runs of this server job")
+	table := fs.String("table", "", "with list, only list the runs that compared this table, with its result")
+	limit := fs.Int("limit", 20, "with list, list at most this many runs")
+	keepRuns := fs.Int("keep-runs", 0, "with prune, keep this many of the newest runs of each job, command line runs counting as one job (0 keeps all)")
+	keepDays := fs.Int("keep-days", 0, "with prune, keep the runs of this many days (0 keeps all)")
+	fs.Usage = func() {
+		fmt.Println("Usage: mudrockdbcompare history list [options]")
+		fmt.Println("       mudrockdbcompare history show [options] ID")
+		fmt.Println("       mudrockdbcompare history prune [options]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare history list --table orders")
+		fmt.Println("  mudrockdbcompare history show 42")
+		fmt.Println("  mudrockdbcompare history prune --keep-days 30")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || (args[0] != "list" && args[0] != "show" && args[0] != "prune") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+	if *historyFile == "" {
+		fmt.Fprintln(os.Stderr, "no history file, set --history")
+		os.Exit(2)
+	}
+
+	h, err := openHistory(*historyFile)
+	if err != nil {
+		fatal("Failed to open the history", err)
+	}
+	defer h.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	switch command {
+	case "prune":
+		if fs.NArg() != 0 || (*keepRuns <= 0 && *keepDays <= 0) {
+			fs.Usage()
+			os.Exit(2)
+		}
+		pruned, err := h.prune(*keepRuns, *keepDays, false)
+		if err != nil {
+			fatal("Failed to prune the history", err)
+		}
+		fmt.Printf("Deleted %d runs\n", pruned)
+
+	case "list":
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		runs, err := h.list(*job, *table, *limit)
+		if err != nil {
+			fatal("Failed to read the history", err)
+		}
+
+		header := "ID\tSTARTED\tJOB\tSOURCE\tTARGET\tSTATUS\tDIFFERENCES"
+		if *table != "" {
+			header = "ID\tSTARTED\tJOB\tSOURCE\tTARGET\tSTATUS\t" + strings.ToUpper(*table)
+		}
+		fmt.Fprintln(w, header)
+		for _, r := range runs {
+			last := r.differences()
+			if *table != "" {
+				last = r.TableResult
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Started, cmp.Or(r.Job, "-"), r.Source, r.Target, r.Status, last)
+		}
+
+	case "show":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid run ID '%s'\n", fs.Arg(0))
+			os.Exit(2)
+		}
+		run, tables, err := h.show(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "no run %d in the history\n", id)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal("Failed to read the history", err)
+		}
+
+		fmt.Printf("Run %d", run.ID)
+		if run.Job != "" {
+			fmt.Printf(" of job %s", run.Job)
+		}
+		fmt.Printf(": %s, %s to %s\n", run.Status, run.Started, run.Finished)
+		fmt.Printf("Source: %s\nTarget: %s\n", run.Source, run.Target)
+		fmt.Printf("Differences: %s\n\n", run.differences())
+
+		fmt.Fprintln(w, "TABLE\tRESULT\tSOURCE ROWS\tTARGET ROWS\tDETAILS")
+		for _, t := range tables {
+			sourceRows, targetRows := "-", "-"
+			if t.SourceRows.Valid {
+				sourceRows, targetRows = strconv.FormatInt(t.SourceRows.Int64, 10), strconv.FormatInt(t.TargetRows.Int64, 10)
+			}
+			details := ""
+			if len(t.Details) > 0 {
+				details = t.Details[0]
+				if len(t.Details) > 1 {
+					details += fmt.Sprintf(" (and %d more)", len(t.Details)-1)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Table, t.Result, sourceRows, targetRows, details)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// defaultIgnoreFile is the ignore file read from the current directory
+// unless --ignore-file names another one
+const defaultIgnoreFile = ".dbcompareignore"
+
+// loadIgnoreFile reads an ignore file, with one rule per line like a
+// .gitignore. Table and column rules leave what they match out of the
+// comparison, as CompareOptions.Ignore; pattern rules suppress the
+// differences they match, like an entry of a suppressions file:
+//
+//	# the whole table, a glob pattern
+//	audit_log
+//	tmp_*
+//	# a column, TABLE/COLUMN
+//	users/last_login_*
+//	*/updated_at
+//	# the differences whose message matches a regular expression
+//	~different collation
+//
+// A missing file is no rules if missingOK is set.
+func loadIgnoreFile(filename string, missingOK bool) (ignore, suppressions []Suppression, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if missingOK && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseIgnoreRule(line, fmt.Sprintf("%s:%d", filename, lineNumber))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", filename, lineNumber, err)
+		}
+		if rule.Pattern != "" {
+			suppressions = append(suppressions, rule)
+		} else {
+			ignore = append(ignore, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return ignore, suppressions, nil
+}
+
+// parseIgnoreRule reads a rule of an ignore file, or of --ignore, whose
+// differences are reported as left out for reason
+func parseIgnoreRule(line, reason string) (Suppression, error) {
+	s := Suppression{Reason: reason}
+	switch {
+	case strings.HasPrefix(line, "!"):
+		return s, errors.New("negated rules aren't supported")
+	case strings.HasPrefix(line, "~"):
+		s.Pattern = strings.TrimSpace(line[1:])
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return s, fmt.Errorf("invalid pattern: %w", err)
+		}
+	default:
+		if i := strings.LastIndex(line, "/"); i >= 0 {
+			s.Table, s.Column = line[:i], line[i+1:]
+			if s.Table == "" || s.Column == "" {
+				return s, errors.New("expected TABLE/COLUMN")
+			}
+		} else {
+			s.Table = line
+		}
+	}
+	return s, nil
+}
+
+// ignoredTable reports whether a table rule of Ignore matches a table
+func (o CompareOptions) ignoredTable(tableName string) bool {
+	for _, rule := range o.Ignore {
+		if ok, _ := path.Match(rule.Table, tableName); ok && rule.Column == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutIgnoredColumns returns a table's schema without the columns a
+// column rule of Ignore matches, except those of its primary key, which
+// rows are matched by
+func (o CompareOptions) withoutIgnoredColumns(schema TableSchema) TableSchema {
+	ignored := func(col ColumnSchema) bool {
+		for _, rule := range o.Ignore {
+			if rule.Column == "" || contains(schema.PrimaryKeys, col.Name) {
+				continue
+			}
+			table, _ := path.Match(rule.Table, schema.Name)
+			column, _ := path.Match(rule.Column, col.Name)
+			if table && column {
+				return true
+			}
+		}
+		return false
+	}
+	if !slices.ContainsFunc(schema.Columns, ignored) {
+		return schema
+	}
+	schema.Columns = slices.DeleteFunc(slices.Clone(schema.Columns), ignored)
+	return schema
+}
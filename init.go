@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// initConnectTimeout bounds the connectivity test of each connection
+const initConnectTimeout = 10 * time.Second
+
+// wizard asks the questions of init on the terminal
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks a question and returns the answer, or def if it's empty
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return cmp.Or(strings.TrimSpace(line), def), nil
+}
+
+// askValid asks a question until valid accepts the answer
+func (w *wizard) askValid(question, def string, valid func(string) error) (string, error) {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if err := valid(answer); err != nil {
+			fmt.Fprintln(w.out, colored(colorRed, err.Error()))
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes or no question
+func (w *wizard) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := w.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// askConnection asks for a connection string of the side and tests it,
+// asking again when it fails unless the user keeps it anyway
+func (w *wizard) askConnection(ctx context.Context, side, dbType, passwordEnv string) (string, error) {
+	for {
+		config, err := w.askValid(fmt.Sprintf("%s connection string, dump file or secret", side), "", func(answer string) error {
+			if answer == "" {
+				return errors.New("a connection string is needed")
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(w.out, "Connecting to the %s...\n", strings.ToLower(side))
+		tables, err := testConnection(ctx, config, dbType, side, passwordEnv)
+		if err == nil {
+			fmt.Fprintln(w.out, colored(colorGreen, fmt.Sprintf("Connected, %d tables.", tables)))
+			return config, nil
+		}
+		fmt.Fprintln(w.out, colored(colorRed, fmt.Sprintf("Couldn't connect: %v", err)))
+		keep, err := w.confirm("Keep it anyway", false)
+		if err != nil || keep {
+			return config, err
+		}
+	}
+}
+
+// testConnection connects to a database given like on the command line and
+// returns its number of tables. The password comes from the connection
+// string or passwordEnv.
+func testConnection(ctx context.Context, config, dbType, side, passwordEnv string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, initConnectTimeout)
+	defer cancel()
+	config, err := resolveSecret(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	adapter, err := GetAdapter(dbType)
+	if err != nil {
+		return 0, err
+	}
+	if isDumpFile(config) {
+		adapter = &DumpAdapter{Dialect: dbType}
+	}
+	dsn := adapter.GetConnectStringFromURL(config)
+	if err := sqliteFileExists(adapter, dsn); err != nil {
+		return 0, err
+	}
+	if dsn, err = applyPassword(adapter, dsn, side, passwordEnv, false); err != nil {
+		return 0, err
+	}
+	db, err := adapter.Connect(dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	tables, err := adapter.GetTableList(ctx, db)
+	return len(tables), err
+}
+
+// runInit runs the init subcommand, which asks for the databases, filters
+// and outputs of a comparison and adds them as a profile to the profiles
+// file, for --profile
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	profilesFile := fs.String("profiles", defaultProfilesFile, "YAML file of profiles to add the profile to, created if it doesn't exist")
+	fs.Usage = func() {
+		fmt.Println("Usage: mudrockdbcompare init [options]")
+		fmt.Println("Asks for the databases to compare, testing the connections, filters and outputs, and saves them as a profile to run with --profile.")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "init needs a terminal to ask its questions")
+		os.Exit(2)
+	}
+	setupColor(false)
+	if err := setupLogging("warn", "text"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+	profile, err := w.profile(context.Background(), *profilesFile)
+	if err != nil {
+		fatal("Failed to read the answers", err)
+	}
+	if err := appendProfile(*profilesFile, profile); err != nil {
+		fatal("Failed to write the profile", err)
+	}
+	fmt.Fprintf(w.out, "Added profile '%s' to %s. Run it with:\n  mudrockdbcompare --profile %s\n", profile.Fields["name"], *profilesFile, profile.Fields["name"])
+}
+
+// profile asks for the settings of a new profile of the profiles file
+func (w *wizard) profile(ctx context.Context, profilesFile string) (yamlEntry, error) {
+	profile := yamlEntry{Fields: map[string]string{}}
+	set := func(key, value string) {
+		if value != "" {
+			profile.Keys = append(profile.Keys, key)
+			profile.Fields[key] = value
+		}
+	}
+	oneOf := func(choices ...string) func(string) error {
+		return func(answer string) error {
+			if !contains(choices, answer) {
+				return fmt.Errorf("expected one of: %s", strings.Join(choices, ", "))
+			}
+			return nil
+		}
+	}
+	duration := func(answer string) error {
+		if answer == "" {
+			return nil
+		}
+		_, err := time.ParseDuration(answer)
+		return err
+	}
+
+	name, err := w.askValid("Profile name", "default", func(answer string) error {
+		if !jobName.MatchString(answer) {
+			return errors.New("a name may only contain letters, digits, '.', '_' and '-'")
+		}
+		if _, err := loadProfile(profilesFile, answer); err == nil {
+			return fmt.Errorf("%s already has a profile '%s'", profilesFile, answer)
+		}
+		return nil
+	})
+	if err != nil {
+		return profile, err
+	}
+	set("name", name)
+
+	dbType, err := w.askValid("Source database type (mysql, postgres, sqlite)", "", oneOf("mysql", "postgres", "sqlite"))
+	if err != nil {
+		return profile, err
+	}
+	set("type", dbType)
+	targetType, err := w.askValid("Target database type", dbType, oneOf("mysql", "postgres", "sqlite"))
+	if err != nil {
+		return profile, err
+	}
+	if targetType != dbType {
+		set("target-type", targetType)
+	}
+
+	fmt.Fprintf(w.out, "Leave passwords out of the connection strings, they'd be saved in %s: set %s and %s, or give a vault: or aws-sm: secret.\n",
+		profilesFile, sourcePasswordEnv, targetPasswordEnv)
+	source, err := w.askConnection(ctx, "Source", dbType, sourcePasswordEnv)
+	if err != nil {
+		return profile, err
+	}
+	set("source", source)
+	target, err := w.askConnection(ctx, "Target", targetType, targetPasswordEnv)
+	if err != nil {
+		return profile, err
+	}
+	set("target", target)
+
+	if dbType == "postgres" {
+		schemas, err := w.ask("PostgreSQL schemas to compare, comma-separated", "public")
+		if err != nil {
+			return profile, err
+		}
+		if schemas != "public" {
+			set("schema", schemas)
+		}
+	}
+	var ignored []string
+	tables, err := w.ask("Tables to ignore, comma-separated glob patterns", "")
+	if err != nil {
+		return profile, err
+	}
+	for _, table := range strings.Split(tables, ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			ignored = append(ignored, table)
+		}
+	}
+	set("ignore", strings.Join(ignored, ","))
+	suppress, err := w.askValid("Suppressions file of accepted differences", "", func(answer string) error {
+		if answer == "" {
+			return nil
+		}
+		_, err := loadSuppressions(answer)
+		return err
+	})
+	if err != nil {
+		return profile, err
+	}
+	set("suppress", suppress)
+
+	rowDiff, err := w.confirm("Compare the rows of tables whose data differs, reporting each differing row", true)
+	if err != nil {
+		return profile, err
+	}
+	if rowDiff {
+		set("row-diff", "true")
+		tolerance, err := w.askValid("Tolerance of timestamps, e.g. 1s", "", duration)
+		if err != nil {
+			return profile, err
+		}
+		set("timestamp-tolerance", tolerance)
+	}
+
+	report, err := w.ask("JSON report file", "")
+	if err != nil {
+		return profile, err
+	}
+	set("report", report)
+	reportDir, err := w.ask("Directory of Markdown reports per table", "")
+	if err != nil {
+		return profile, err
+	}
+	set("report-dir", reportDir)
+	failOn, err := w.askValid("Exit with status 3 on differences of (schema, data, rowcount, any or none)", "none", func(answer string) error {
+		_, err := failingDifferences(answer, ComparisonSummary{})
+		return err
+	})
+	if err != nil {
+		return profile, err
+	}
+	if failOn != "none" {
+		set("fail-on", failOn)
+	}
+	return profile, nil
+}
+
+// appendProfile adds a profile to the end of a profiles file, creating it
+// if it doesn't exist
+func appendProfile(filename string, profile yamlEntry) error {
+	var b strings.Builder
+	existing, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || (err == nil && len(strings.TrimSpace(string(existing))) == 0):
+		b.WriteString("profiles:\n")
+	case err != nil:
+		return err
+	case len(existing) > 0 && existing[len(existing)-1] != '\n':
+		b.WriteString("\n")
+	}
+	// Values that YAML could read as something else are quoted
+	plain := regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+	quote := func(s string) string {
+		if plain.MatchString(s) {
+			return s
+		}
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	for i, key := range profile.Keys {
+		prefix := "    "
+		if i == 0 {
+			prefix = "  - "
+		}
+		fmt.Fprintf(&b, "%s%s: %s\n", prefix, key, quote(profile.Fields[key]))
+	}
+	return appendFile(filename, b.String())
+}
+
+func appendFile(filename, text string) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
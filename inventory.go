@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// shard is a source and target pair of an inventory
+type shard struct {
+	Name   string
+	Source string
+	Target string
+}
+
+// loadInventory reads an inventory file, a YAML list of shards under a
+// top-level "shards" key:
+//
+//	shards:
+//	  - name: shard-01
+//	    source: user:password@primary-01:3306/shop
+//	    target: user:password@replica-01:3306/shop
+func loadInventory(filename string) ([]shard, error) {
+	entries, err := readYAMLList(filename, "shards")
+	if err != nil {
+		return nil, err
+	}
+
+	shards := []shard{}
+	names := make(map[string]bool)
+	for i, entry := range entries {
+		var s shard
+		for _, key := range entry.Keys {
+			value := entry.Fields[key]
+			switch key {
+			case "name":
+				s.Name = value
+			case "source":
+				s.Source = value
+			case "target":
+				s.Target = value
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key '%s'", filename, entry.Lines[key], key)
+			}
+		}
+
+		switch {
+		case s.Name == "":
+			return nil, fmt.Errorf("%s: shard %d has no name", filename, i+1)
+		case names[s.Name]:
+			return nil, fmt.Errorf("%s: duplicate shard '%s'", filename, s.Name)
+		case s.Source == "" || s.Target == "":
+			return nil, fmt.Errorf("%s: shard '%s' needs a source and target", filename, s.Name)
+		}
+		names[s.Name] = true
+		shards = append(shards, s)
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("%s: no shards", filename)
+	}
+	return shards, nil
+}
+
+// shardRun is the result of comparing a shard
+type shardRun struct {
+	shard   shard
+	summary ComparisonSummary
+	started time.Time
+	err     error
+}
+
+// compareShards compares the shards, up to parallel at a time. Each shard
+// is read with its own copies of adapter and targetAdapter, or dump
+// adapters of dialect and targetDialect for dump files.
+func compareShards(ctx context.Context, shards []shard, adapter, targetAdapter DatabaseAdapter, dialect, targetDialect string, options CompareOptions, parallel int) []shardRun {
+	runs := make([]shardRun, len(shards))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				runs[i] = shardRun{shard: s, started: time.Now(), err: ctx.Err()}
+				return
+			}
+			defer func() { <-slots }()
+
+			run := shardRun{shard: s, started: time.Now()}
+			run.summary, run.err = compareShard(ctx, s, adapter, targetAdapter, dialect, targetDialect, options)
+			runs[i] = run
+		}()
+	}
+	wg.Wait()
+	return runs
+}
+
+// compareShard connects to a shard's databases and compares them
+func compareShard(ctx context.Context, s shard, adapter, targetAdapter DatabaseAdapter, dialect, targetDialect string, options CompareOptions) (ComparisonSummary, error) {
+	connect := func(base DatabaseAdapter, config, dialect, side, passwordEnv string) (DatabaseAdapter, *sql.DB, string, error) {
+		config, err := resolveSecret(ctx, config)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to resolve %s connection string: %w", side, err)
+		}
+		adapter := cloneAdapter(base)
+		if isDumpFile(config) {
+			adapter = &DumpAdapter{Dialect: dialect}
+		}
+		connStr := adapter.GetConnectStringFromURL(config)
+		dsn, err := applyPassword(adapter, connStr, side, passwordEnv, false)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to read %s password: %w", side, err)
+		}
+		db, err := connectReader(adapter, dsn)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to connect to %s database: %w", side, err)
+		}
+		nameSession(db, s.Name+" "+side)
+		return adapter, db, connStr, nil
+	}
+
+	sourceAdapter, sourceDB, sourceConnStr, err := connect(adapter, s.Source, dialect, "source", sourcePasswordEnv)
+	if err != nil {
+		return ComparisonSummary{}, err
+	}
+	defer sourceDB.Close()
+	targetAdapter, targetDB, targetConnStr, err := connect(targetAdapter, s.Target, targetDialect, "target", targetPasswordEnv)
+	if err != nil {
+		return ComparisonSummary{}, err
+	}
+	defer targetDB.Close()
+
+	comparison := &Comparison{
+		Adapter:       sourceAdapter,
+		TargetAdapter: targetAdapter,
+		SourceDB:      sourceDB,
+		TargetDB:      targetDB,
+		SourceConnStr: sourceConnStr,
+		TargetConnStr: targetConnStr,
+		Options:       options,
+		OnEvent:       labeledEvent("shard", s.Name),
+	}
+	summary, err := comparison.Introspect(ctx)
+	if err != nil {
+		return summary, err
+	}
+	comparison.CompareData(ctx, &summary)
+	return summary, nil
+}
+
+// printShardRollup prints the status of each shard, then each table that
+// differs in any shard with the shards it differs in
+func printShardRollup(runs []shardRun) {
+	fmt.Println("\n=== Shards ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tSTATUS\tTABLES\tDIFFERING TABLES\tOTHER DIFFERENCES")
+	differingShards := make(map[string][]string) // by table
+	statuses := make(map[string]map[string]bool) // by table
+	failed, differing := 0, 0
+	for _, run := range runs {
+		if run.err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\tfailed\t\t\t\n", run.shard.Name)
+			continue
+		}
+
+		dataDifferent := make(map[string]bool)
+		for _, table := range run.summary.dataDifferentTables() {
+			dataDifferent[table] = true
+		}
+		tables := append(append(append([]string{}, run.summary.CommonTables...), run.summary.MissingTables...), run.summary.ExtraTables...)
+		differingTables := 0
+		for _, table := range tables {
+			status := targetStatus(run.summary, table, dataDifferent)
+			if status == "ok" {
+				continue
+			}
+			differingTables++
+			differingShards[table] = append(differingShards[table], run.shard.Name)
+			if statuses[table] == nil {
+				statuses[table] = make(map[string]bool)
+			}
+			statuses[table][status] = true
+		}
+
+		others := len(run.summary.ObjectDifferences) + len(run.summary.CheckDifferences)
+		status := "ok"
+		switch {
+		case run.summary.Interrupted:
+			status = "interrupted"
+		case differingTables > 0 || others > 0:
+			status = "differs"
+		}
+		if status != "ok" {
+			differing++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", run.shard.Name, status, len(tables), differingTables, others)
+	}
+	w.Flush()
+	for _, run := range runs {
+		if run.err != nil {
+			fmt.Println(colored(colorRed, fmt.Sprintf("%s: %v", run.shard.Name, run.err)))
+		}
+	}
+
+	fmt.Println("\n=== Tables ===")
+	if len(differingShards) == 0 {
+		fmt.Println("No table differs in any shard.")
+	} else {
+		tables := make([]string, 0, len(differingShards))
+		for table := range differingShards {
+			tables = append(tables, table)
+		}
+		// The tables differing in the most shards first
+		sort.Slice(tables, func(i, j int) bool {
+			if len(differingShards[tables[i]]) != len(differingShards[tables[j]]) {
+				return len(differingShards[tables[i]]) > len(differingShards[tables[j]])
+			}
+			return tables[i] < tables[j]
+		})
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TABLE\tSHARDS\tDIFFERENCES\tDIFFERING SHARDS")
+		for _, table := range tables {
+			kinds := make([]string, 0, len(statuses[table]))
+			for kind := range statuses[table] {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+			fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\n", table, len(differingShards[table]), len(runs),
+				strings.Join(kinds, ", "), strings.Join(differingShards[table], ", "))
+		}
+		w.Flush()
+	}
+
+	fmt.Printf("\n%d shards: %d ok, %d differ, %d failed\n", len(runs), len(runs)-differing-failed, differing, failed)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs the default logger. Logs go to stderr so stdout
+// only carries the comparison report.
+func setupLogging(level, format string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: use debug, info or warn", level)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, options)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, options)))
+	default:
+		return fmt.Errorf("invalid log format %q: use text or json", format)
+	}
+
+	return nil
+}
+
+// fatal logs an error and exits, replacing log.Fatalf
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// targetRun is the result of comparing the source with one of several
+// targets
+type targetRun struct {
+	comparison *Comparison
+	summary    ComparisonSummary
+	started    time.Time
+	err        error
+}
+
+// connectTargets returns a comparison of the source of c with each of the
+// targets after c's, numbered from 2, which are read like c's target unless
+// they're dump files. Each comparison reads its databases with its own
+// adapters, so they can run at the same time.
+func connectTargets(ctx context.Context, c *Comparison, dbType, targetType string, configs []string, prompt bool) ([]*Comparison, error) {
+	comparisons := make([]*Comparison, len(configs))
+	for i, config := range configs {
+		name := fmt.Sprintf("target %d", i+2)
+		config, err := resolveSecret(ctx, config)
+		if err != nil {
+			return comparisons, fmt.Errorf("failed to resolve the %s connection string: %w", name, err)
+		}
+
+		adapter := cloneAdapter(c.targetAdapter())
+		if isDumpFile(config) {
+			adapter = &DumpAdapter{Dialect: cmp.Or(targetType, dbType)}
+		}
+		connStr := adapter.GetConnectStringFromURL(config)
+		dsn, err := applyPassword(adapter, connStr, fmt.Sprintf("Target %d", i+2), targetPasswordEnv, prompt)
+		if err != nil {
+			return comparisons, fmt.Errorf("failed to read the %s password: %w", name, err)
+		}
+		db, err := connectReader(adapter, dsn)
+		if err != nil {
+			return comparisons, fmt.Errorf("failed to connect to %s: %w", name, err)
+		}
+		nameSession(db, name)
+
+		comparison := *c
+		comparison.Adapter = cloneAdapter(c.Adapter)
+		comparison.TargetAdapter = adapter
+		comparison.TargetDB = db
+		comparison.TargetConnStr = connStr
+		comparison.OnEvent = labeledEvent("target", name)
+		comparisons[i] = &comparison
+	}
+	return comparisons, nil
+}
+
+// labeledEvent returns the consumer of the events of one of several
+// comparisons running at the same time, which logs them labeled with key
+// and name, e.g. "target" and "target 2". Differences are reported once
+// every comparison is done.
+func labeledEvent(key, name string) func(Event) {
+	return func(event Event) {
+		switch event.Type {
+		case EventPhase:
+			slog.Info(event.Message, key, name)
+		case EventWarning:
+			slog.Warn(event.Message, key, name, "error", event.Err)
+		case EventTableFinished:
+			if event.Err != nil {
+				slog.Error("Error comparing table", key, name, "table", event.Table, "error", event.Err)
+			}
+		}
+	}
+}
+
+// compareTargets compares the source with each target at the same time,
+// reading the source's schema once. The comparisons must have the same
+// source, adapter type and options.
+func compareTargets(ctx context.Context, comparisons []*Comparison) []targetRun {
+	runs := make([]targetRun, len(comparisons))
+	first := comparisons[0]
+	sourceCtx := withThrottle(withQueryTimeout(ctx, first.Options.QueryTimeout), first.Options)
+	source, err := first.readObjects(sourceCtx, "source", first.Adapter, first.SourceDB, first.SourceConnStr, first.comparedKinds())
+	if err != nil {
+		for i := range runs {
+			runs[i] = targetRun{comparison: comparisons[i], started: time.Now(), err: err}
+		}
+		return runs
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range comparisons {
+		c.source = &source
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := targetRun{comparison: c, started: time.Now()}
+			run.summary, run.err = c.Introspect(ctx)
+			if run.err == nil {
+				c.CompareData(ctx, &run.summary)
+			}
+			runs[i] = run
+		}()
+	}
+	wg.Wait()
+	return runs
+}
+
+// targetStatus is what differs in a table of a target, for the matrix
+func targetStatus(summary ComparisonSummary, tableName string, dataDifferent map[string]bool) string {
+	switch {
+	case contains(summary.MissingTables, tableName):
+		return DiffMissing
+	case contains(summary.ExtraTables, tableName):
+		return DiffExtra
+	case !contains(summary.CommonTables, tableName):
+		return "-" // in neither database
+	}
+	var differs []string
+	if len(summary.SchemaDifferences[tableName]) > 0 {
+		differs = append(differs, "schema")
+	}
+	if dataDifferent[tableName] {
+		differs = append(differs, "data")
+	}
+	if reason, ok := summary.SkippedTables[tableName]; ok && len(differs) == 0 {
+		return "skipped (" + reason + ")"
+	}
+	if len(differs) == 0 {
+		return "ok"
+	}
+	return strings.Join(differs, "+")
+}
+
+// printTargetRuns prints the schema differences and the summary of each
+// target, then the matrix of all of them
+func printTargetRuns(runs []targetRun, adapter DatabaseAdapter) {
+	for i, run := range runs {
+		if run.err != nil {
+			slog.Error("Failed to compare", "target", fmt.Sprintf("target %d", i+1), "error", run.err)
+			continue
+		}
+		info := run.summary.TargetInfo
+		fmt.Printf("\n=== Target %d: %s, Database: %s, Tables: %d, Size: %s ===\n",
+			i+1, info.Host, info.DatabaseName, info.TableCount, formatSize(info.TotalSize))
+		printSchemaDifferences(run.summary, adapter, run.comparison.Options)
+		printSummary(run.summary)
+	}
+	printTargetMatrix(runs)
+}
+
+// printTargetMatrix prints what differs in each table of each target, one
+// column per target
+func printTargetMatrix(runs []targetRun) {
+	fmt.Println("\n=== Targets ===")
+	tables := make(map[string]bool)
+	for i, run := range runs {
+		info := run.summary.TargetInfo
+		switch {
+		case run.err != nil:
+			fmt.Printf("T%d: failed: %v\n", i+1, run.err)
+		case run.summary.Interrupted:
+			fmt.Printf("T%d: %s, Database: %s (interrupted)\n", i+1, info.Host, info.DatabaseName)
+		default:
+			fmt.Printf("T%d: %s, Database: %s\n", i+1, info.Host, info.DatabaseName)
+		}
+		for table := range run.summary.SourceSchemas {
+			tables[table] = true
+		}
+		for _, table := range run.summary.ExtraTables {
+			tables[table] = true
+		}
+	}
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	fmt.Println("\n=== Comparison Matrix ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "TABLE")
+	for i := range runs {
+		fmt.Fprintf(w, "\tT%d", i+1)
+	}
+	fmt.Fprintln(w)
+	dataDifferent := make([]map[string]bool, len(runs))
+	for i, run := range runs {
+		dataDifferent[i] = make(map[string]bool)
+		for _, table := range run.summary.dataDifferentTables() {
+			dataDifferent[i][table] = true
+		}
+	}
+	for _, table := range names {
+		fmt.Fprint(w, table)
+		for i, run := range runs {
+			status := "-"
+			if run.err == nil {
+				status = targetStatus(run.summary, table, dataDifferent[i])
+			}
+			fmt.Fprint(w, "\t"+status)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprint(w, "(other objects)")
+	for _, run := range runs {
+		fmt.Fprintf(w, "\t%d differ", len(run.summary.ObjectDifferences)+len(run.summary.CheckDifferences))
+	}
+	fmt.Fprintln(w)
+	w.Flush()
+}
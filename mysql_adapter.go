@@ -1,17 +1,64 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-// MySQLAdapter implements DatabaseAdapter for MySQL
-type MySQLAdapter struct{}
+// MySQLAdapter implements DatabaseAdapter for MySQL. It reads the database
+// of the connection, or Database, unless Databases or AllDatabases is set,
+// in which case the connection can be to the server and objects are named
+// "database.name".
+type MySQLAdapter struct {
+	Database     string
+	Databases    []string
+	AllDatabases bool // all databases except the system ones
+
+	filters     rowFilters
+	expressions columnExpressions
+	keyOrders   columnExpressions // set by SetBinaryKeyOrder
+}
 
 func (a *MySQLAdapter) Connect(connectionString string) (*sql.DB, error) {
+	return openDB("mysql", a.normalizeDSN(connectionString))
+}
+
+// connectUTC opens a pool reading TIMESTAMP columns, stored in UTC, in UTC
+// rather than the server's time zone, so they compare equal to
+// PostgreSQL's, unless the connection string sets a time zone
+func (a *MySQLAdapter) connectUTC(connectionString string) (*sql.DB, error) {
+	dsn := a.normalizeDSN(connectionString)
+	if strings.Contains(dsn, "time_zone=") {
+		return openDB("mysql", dsn)
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	db, err := openDB("mysql", dsn+separator+"time_zone=%27%2B00%3A00%27")
+	if err == nil {
+		s, _ := poolSession(db)
+		s.mu.Lock()
+		s.utc = true
+		s.mu.Unlock()
+	}
+	return db, err
+}
+
+// normalizeDSN turns user:password@host:port/dbname into the driver's
+// user:password@tcp(host:port)/dbname form
+func (a *MySQLAdapter) normalizeDSN(connectionString string) string {
 	if !strings.Contains(connectionString, "tcp(") && strings.Contains(connectionString, "@") {
 		parts := strings.SplitN(connectionString, "@", 2)
 		if len(parts) == 2 {
@@ -29,8 +76,17 @@ func (a *MySQLAdapter) Connect(connectionString string) (*sql.DB, error) {
 			}
 		}
 	}
+	return connectionString
+}
 
-	return sql.Open("mysql", connectionString)
+// SetPassword returns the connection string with its password replaced
+func (a *MySQLAdapter) SetPassword(connectionString, password string) (string, error) {
+	config, err := mysql.ParseDSN(a.normalizeDSN(connectionString))
+	if err != nil {
+		return "", err
+	}
+	config.Passwd = password
+	return config.FormatDSN(), nil
 }
 
 func (a *MySQLAdapter) GetConnectStringFromURL(url string) string {
@@ -41,8 +97,86 @@ func (a *MySQLAdapter) GetConnectStringFromURL(url string) string {
 	return url
 }
 
-func (a *MySQLAdapter) GetTableList(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SHOW TABLES")
+// qualified reports whether object names include their database
+func (a *MySQLAdapter) qualified() bool {
+	return a.AllDatabases || len(a.Databases) > 0
+}
+
+// schemaCondition is the SQL condition selecting the compared databases in column
+func (a *MySQLAdapter) schemaCondition(column string) string {
+	switch {
+	case a.AllDatabases:
+		return column + " NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')"
+	case len(a.Databases) > 0:
+		return fmt.Sprintf("%s IN (%s)", column, quoteList(a.Databases, func(s string) string { return a.QuoteLiteral(s) }))
+	case a.Database != "":
+		return column + " = " + a.QuoteLiteral(a.Database)
+	default:
+		return column + " = DATABASE()"
+	}
+}
+
+// tableCondition is the SQL condition selecting a table by its database and
+// name columns, with its arguments
+func (a *MySQLAdapter) tableCondition(schemaColumn, nameColumn, tableName string) (string, []interface{}) {
+	if !a.qualified() {
+		return fmt.Sprintf("%s AND %s = ?", a.schemaCondition(schemaColumn), nameColumn), []interface{}{tableName}
+	}
+	database, name, _ := splitQualifiedName(tableName)
+	return fmt.Sprintf("%s = ? AND %s = ?", schemaColumn, nameColumn), []interface{}{database, name}
+}
+
+// readGenerationExpressions sets the expressions of a table's generated
+// columns, which SHOW COLUMNS only marks as VIRTUAL or STORED GENERATED
+func (a *MySQLAdapter) readGenerationExpressions(ctx context.Context, db *sql.DB, tableName string, columns []ColumnSchema) error {
+	positions := make(map[string]int)
+	for i, col := range columns {
+		extra := strings.ToUpper(col.Extra)
+		if strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED") {
+			positions[col.Name] = i
+		}
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+
+	condition, args := a.tableCondition("TABLE_SCHEMA", "TABLE_NAME", tableName)
+	rows, err := db.QueryContext(ctx, `
+		SELECT COLUMN_NAME, GENERATION_EXPRESSION
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE `+condition, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var expression sql.NullString
+		if err := rows.Scan(&name, &expression); err != nil {
+			return err
+		}
+		if i, ok := positions[name]; ok {
+			columns[i].Generated = expression.String
+		}
+	}
+	return rows.Err()
+}
+
+// qualify returns the name of an object in database as it's reported
+func (a *MySQLAdapter) qualify(database, name string) string {
+	if !a.qualified() {
+		return name
+	}
+	return qualifiedName(database, name)
+}
+
+func (a *MySQLAdapter) GetTableList(ctx context.Context, db *sql.DB) ([]string, error) {
+	// Views are compared separately by GetViews
+	rows, err := db.QueryContext(ctx, `
+		SELECT TABLE_SCHEMA, TABLE_NAME
+		FROM INFORMATION_SCHEMA.TABLES
+		WHERE `+a.schemaCondition("TABLE_SCHEMA")+` AND TABLE_TYPE = 'BASE TABLE'
+	`)
 	if err != nil {
 		return nil, err
 	}
@@ -50,21 +184,42 @@ func (a *MySQLAdapter) GetTableList(db *sql.DB) ([]string, error) {
 
 	var tables []string
 	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
+		var database, tableName string
+		if err := rows.Scan(&database, &tableName); err != nil {
 			return nil, err
 		}
-		tables = append(tables, tableName)
+		tables = append(tables, a.qualify(database, tableName))
 	}
 
 	return tables, nil
 }
 
-func (a *MySQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema, error) {
+func (a *MySQLAdapter) GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error) {
 	tableSchema := TableSchema{Name: tableName}
 
-	// Get columns
-	columns, err := db.Query(fmt.Sprintf("DESCRIBE `%s`", tableName))
+	// Get table options
+	var engine, rowFormat, collation, charset sql.NullString
+	condition, args := a.tableCondition("t.TABLE_SCHEMA", "t.TABLE_NAME", tableName)
+	err := db.QueryRowContext(ctx, `
+		SELECT t.ENGINE, t.ROW_FORMAT, t.TABLE_COLLATION, c.CHARACTER_SET_NAME
+		FROM INFORMATION_SCHEMA.TABLES t
+		LEFT JOIN INFORMATION_SCHEMA.COLLATION_CHARACTER_SET_APPLICABILITY c
+			ON c.COLLATION_NAME = t.TABLE_COLLATION
+		WHERE `+condition+`
+		LIMIT 1
+	`, args...).Scan(&engine, &rowFormat, &collation, &charset)
+	if err != nil {
+		return tableSchema, err
+	}
+	tableSchema.Options = TableOptions{
+		Engine:    engine.String,
+		Charset:   charset.String,
+		Collation: collation.String,
+		RowFormat: rowFormat.String,
+	}
+
+	// Get columns, SHOW FULL COLUMNS adds the collation to what DESCRIBE returns
+	columns, err := db.QueryContext(ctx, "SHOW FULL COLUMNS FROM "+a.QuoteTable(tableName))
 	if err != nil {
 		return tableSchema, err
 	}
@@ -77,16 +232,24 @@ func (a *MySQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema
 		var key string
 		var defaultValue sql.NullString
 		var extra string
+		var collation sql.NullString
+		var privileges, comment string
 
-		if err := columns.Scan(&col.Name, &fieldType, &null, &key, &defaultValue, &extra); err != nil {
+		if err := columns.Scan(&col.Name, &fieldType, &collation, &null, &key, &defaultValue, &extra,
+			&privileges, &comment); err != nil {
 			return tableSchema, err
 		}
 
+		// Collations are named after their charset, e.g. utf8mb4_0900_ai_ci
+		col.Collation = collation.String
+		col.Charset, _, _ = strings.Cut(col.Collation, "_")
+
 		col.DataType = fieldType
 		col.Nullable = null
 		col.Key = key
 		col.Default = defaultValue
 		col.Extra = extra
+		col.AutoIdentity = strings.Contains(strings.ToLower(extra), "auto_increment")
 
 		// Track primary keys
 		if key == "PRI" {
@@ -95,9 +258,25 @@ func (a *MySQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema
 
 		tableSchema.Columns = append(tableSchema.Columns, col)
 	}
+	if err := a.readGenerationExpressions(ctx, db, tableName, tableSchema.Columns); err != nil {
+		return tableSchema, err
+	}
 
-	// Get indexes
-	indexes, err := db.Query(fmt.Sprintf("SHOW INDEX FROM `%s`", tableName))
+	// Get indexes by named columns, SHOW INDEX has different ones across
+	// versions. Functional index parts of MySQL 8.0.13 and later have an
+	// expression instead of a column; 5.7 and MariaDB have no EXPRESSION.
+	condition, args = a.tableCondition("TABLE_SCHEMA", "TABLE_NAME", tableName)
+	query := `
+		SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME, %s
+		FROM INFORMATION_SCHEMA.STATISTICS
+		WHERE ` + condition + `
+		ORDER BY INDEX_NAME, SEQ_IN_INDEX
+	`
+	indexes, err := db.QueryContext(ctx, fmt.Sprintf(query, "EXPRESSION"), args...)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1054 { // ER_BAD_FIELD_ERROR
+		indexes, err = db.QueryContext(ctx, fmt.Sprintf(query, "NULL"), args...)
+	}
 	if err != nil {
 		return tableSchema, err
 	}
@@ -105,38 +284,62 @@ func (a *MySQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema
 
 	for indexes.Next() {
 		var indexSchema IndexSchema
-		var tableName, columnName, indexName string
-		var nonUnique int
-		var temp sql.NullString // For columns we don't need
-
-		// Modified to scan 14 columns instead of 12
-		if err := indexes.Scan(
-			&tableName, &nonUnique, &indexName, &temp, &columnName,
-			&temp, &temp, &temp, &temp, &temp, &temp, &temp,
-			&temp, &temp); err != nil {
+		var columnName, expression sql.NullString
+		if err := indexes.Scan(&indexSchema.Name, &indexSchema.NonUnique, &columnName, &expression); err != nil {
 			return tableSchema, err
 		}
-
-		indexSchema.Name = indexName
-		indexSchema.ColumnName = columnName
-		indexSchema.NonUnique = nonUnique
+		indexSchema.ColumnName = columnName.String
+		if !columnName.Valid {
+			indexSchema.ColumnName = "(" + expression.String + ")"
+		}
 		tableSchema.Indexes = append(tableSchema.Indexes, indexSchema)
 	}
+	if err := indexes.Err(); err != nil {
+		return tableSchema, err
+	}
+
+	// Get unique constraints, each backed by an index of the same name
+	condition, args = a.tableCondition("tc.TABLE_SCHEMA", "tc.TABLE_NAME", tableName)
+	uniqueConstraints, err := db.QueryContext(ctx, `
+		SELECT tc.CONSTRAINT_NAME, kcu.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+		JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE kcu
+			ON kcu.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA
+			AND kcu.TABLE_NAME = tc.TABLE_NAME
+			AND kcu.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+		WHERE
+			`+condition+` AND
+			tc.CONSTRAINT_TYPE = 'UNIQUE'
+		ORDER BY tc.CONSTRAINT_NAME, kcu.ORDINAL_POSITION
+	`, args...)
+	if err != nil {
+		return tableSchema, err
+	}
+	defer uniqueConstraints.Close()
+
+	for uniqueConstraints.Next() {
+		var constraintName, columnName string
+		if err := uniqueConstraints.Scan(&constraintName, &columnName); err != nil {
+			return tableSchema, err
+		}
+		tableSchema.addUniqueConstraintColumn(constraintName, constraintName, columnName)
+	}
 
 	// Get foreign keys
-	foreignKeys, err := db.Query(fmt.Sprintf(`
+	condition, args = a.tableCondition("TABLE_SCHEMA", "TABLE_NAME", tableName)
+	foreignKeys, err := db.QueryContext(ctx, `
 		SELECT
 			CONSTRAINT_NAME,
 			COLUMN_NAME,
+			REFERENCED_TABLE_SCHEMA,
 			REFERENCED_TABLE_NAME,
 			REFERENCED_COLUMN_NAME
 		FROM
 			INFORMATION_SCHEMA.KEY_COLUMN_USAGE
 		WHERE
-			TABLE_SCHEMA = DATABASE() AND
-			TABLE_NAME = '%s' AND
+			`+condition+` AND
 			REFERENCED_TABLE_NAME IS NOT NULL
-	`, tableName))
+	`, args...)
 	if err != nil {
 		return tableSchema, err
 	}
@@ -144,67 +347,564 @@ func (a *MySQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema
 
 	for foreignKeys.Next() {
 		var fk ForeignKeySchema
-		if err := foreignKeys.Scan(&fk.Name, &fk.ColumnName, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
+		var referencedDatabase string
+		if err := foreignKeys.Scan(&fk.Name, &fk.ColumnName, &referencedDatabase, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
 			return tableSchema, err
 		}
+		fk.ReferencedTable = a.qualify(referencedDatabase, fk.ReferencedTable)
 		tableSchema.ForeignKeys = append(tableSchema.ForeignKeys, fk)
 	}
 
 	return tableSchema, nil
 }
 
-func (a *MySQLAdapter) CompareTableDataByChecksum(sourceDB, targetDB *sql.DB, tableName string, schema TableSchema) (bool, error) {
-	// fmt.Printf("Comparing data for table '%s' by checksum...\n", tableName)
+func (a *MySQLAdapter) GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT TABLE_NAME, VIEW_DEFINITION, TABLE_SCHEMA
+		FROM INFORMATION_SCHEMA.VIEWS
+		WHERE `+a.schemaCondition("TABLE_SCHEMA")+`
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// Use MySQL's built-in checksum table function
-	var sourceChecksum, targetChecksum sql.NullInt64
+	var views []ViewSchema
+	for rows.Next() {
+		var view ViewSchema
+		var database string
+		if err := rows.Scan(&view.Name, &view.Definition, &database); err != nil {
+			return nil, err
+		}
+		// MySQL qualifies every table with the database name, which differs between source and target
+		view.Definition = strings.ReplaceAll(view.Definition, "`"+database+"`.", "")
+		view.Name = a.qualify(database, view.Name)
+		views = append(views, view)
+	}
 
-	var tableNameCol string
-	err := sourceDB.QueryRow(fmt.Sprintf("CHECKSUM TABLE `%s`", tableName)).Scan(&tableNameCol, &sourceChecksum)
+	return views, rows.Err()
+}
+
+func (a *MySQLAdapter) GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ROUTINE_SCHEMA, ROUTINE_NAME, LOWER(ROUTINE_TYPE)
+		FROM INFORMATION_SCHEMA.ROUTINES
+		WHERE `+a.schemaCondition("ROUTINE_SCHEMA")+`
+	`)
 	if err != nil {
-		fmt.Printf("Error getting source checksum: %v\n", err)
-		return false, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	var targetTableNameCol string
-	err = targetDB.QueryRow(fmt.Sprintf("CHECKSUM TABLE `%s`", tableName)).Scan(&targetTableNameCol, &targetChecksum)
+	var routines []RoutineSchema
+	var databases []string
+	for rows.Next() {
+		var routine RoutineSchema
+		var database string
+		if err := rows.Scan(&database, &routine.Name, &routine.Type); err != nil {
+			return nil, err
+		}
+		routines = append(routines, routine)
+		databases = append(databases, database)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, routine := range routines {
+		// Procedure/Function, sql_mode, Create Procedure/Function, character_set_client,
+		// collation_connection, Database Collation
+		var name, sqlMode, charset, collation, dbCollation string
+		var definition sql.NullString // NULL without the privileges to see the body
+		query := fmt.Sprintf("SHOW CREATE %s %s", strings.ToUpper(routine.Type), a.QuoteIdentifier(routine.Name))
+		if a.qualified() || a.Database != "" {
+			query = fmt.Sprintf("SHOW CREATE %s %s.%s", strings.ToUpper(routine.Type),
+				a.QuoteIdentifier(databases[i]), a.QuoteIdentifier(routine.Name))
+			routines[i].Name = a.qualify(databases[i], routine.Name)
+		}
+		if err := db.QueryRowContext(ctx, query).Scan(&name, &sqlMode, &definition, &charset, &collation, &dbCollation); err != nil {
+			return nil, err
+		}
+		routines[i].Definition = definition.String
+	}
+
+	return routines, nil
+}
+
+// GetSequences returns the auto-increment counters of the tables
+func (a *MySQLAdapter) GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// MySQL 8 caches AUTO_INCREMENT in information_schema for a day by
+	// default. The variable doesn't exist before 8.0, where nothing is cached.
+	_, err = conn.ExecContext(ctx, "SET SESSION information_schema_stats_expiry = 0")
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1193 { // ER_UNKNOWN_SYSTEM_VARIABLE
+		err = nil
+	} else if err == nil {
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SET SESSION information_schema_stats_expiry = DEFAULT"); err != nil {
+				// Still not caching, don't reuse it
+				conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT TABLE_SCHEMA, TABLE_NAME, AUTO_INCREMENT
+		FROM INFORMATION_SCHEMA.TABLES
+		WHERE `+a.schemaCondition("TABLE_SCHEMA")+` AND AUTO_INCREMENT IS NOT NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []SequenceSchema
+	for rows.Next() {
+		var sequence SequenceSchema
+		var database string
+		var next int64
+		if err := rows.Scan(&database, &sequence.Name, &next); err != nil {
+			return nil, err
+		}
+		sequence.Name = a.qualify(database, sequence.Name)
+		sequence.Table = sequence.Name
+		sequence.CurrentValue = next - 1
+		sequences = append(sequences, sequence)
+	}
+
+	return sequences, rows.Err()
+}
+
+// GetTypes returns nothing, MySQL enums are part of the column data types
+func (a *MySQLAdapter) GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error) {
+	return nil, nil
+}
+
+func (a *MySQLAdapter) Capabilities() Capabilities {
+	return Capabilities{Engine: "mysql", ServerChecksums: true, Snapshots: true, Replication: true, ChangeFeeds: true, ServerLoad: true, Sequences: true,
+		Routines: true, Privileges: true, MaxIdentifierLength: 64}
+}
+
+// BeginSnapshot opens the connections, each with a consistent snapshot
+// transaction. MySQL can't share a snapshot with a later connection, so the
+// pool is limited to those, and shrinks when one is lost. The snapshots
+// begin one after the other, within moments, unless lock holds off writes
+// with FLUSH TABLES WITH READ LOCK meanwhile, so that they're of the same
+// point in time.
+func (a *MySQLAdapter) BeginSnapshot(ctx context.Context, db *sql.DB, connections int, lock bool) (func() error, error) {
+	session, err := poolSession(db)
+	if err != nil {
+		return nil, err
+	}
+	if lock {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+			return nil, fmt.Errorf("FLUSH TABLES WITH READ LOCK, which needs the RELOAD privilege: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "UNLOCK TABLES")
+	}
+
+	end := func() error {
+		session.end()
+		db.SetMaxOpenConns(0)
+		db.SetMaxIdleConns(2) // database/sql's default
+		return nil
+	}
+	session.start([]string{
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	}, false)
+	db.SetMaxIdleConns(connections)
+	db.SetMaxOpenConns(connections + 1)
+	conns := make([]*sql.Conn, 0, connections)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for range connections {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			end()
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	db.SetMaxOpenConns(connections)
+	session.seal(errors.New("all connections of the consistent snapshot were lost"), connections, db.SetMaxOpenConns)
+	return end, nil
+}
+
+// mysqlKillStatement returns the KILL QUERY statement stopping what a
+// connection runs. The driver only closes the connection of a cancelled
+// statement, which MySQL goes on running until it sends rows.
+func mysqlKillStatement(ctx context.Context, conn driver.Conn) (string, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return "", errors.New("the driver can't run queries")
+	}
+	rows, err := queryer.QueryContext(ctx, "SELECT CONNECTION_ID()", nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	id := make([]driver.Value, 1)
+	if err := rows.Next(id); err != nil {
+		return "", err
+	}
+	return "KILL QUERY " + formatValue(id[0]), nil
+}
+
+// binlogPosition matches the binlog file and position ReplicationPosition
+// returns without GTIDs, e.g. binlog.000042:1234
+var binlogPosition = regexp.MustCompile(`^(.+\.\d+):(\d+)$`)
+
+// ReplicationPosition returns the executed GTID set, or the binlog file and
+// position where GTIDs are off
+func (a *MySQLAdapter) ReplicationPosition(ctx context.Context, db *sql.DB) (string, error) {
+	var gtidMode, gtidExecuted string
+	err := db.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_mode, @@GLOBAL.gtid_executed").Scan(&gtidMode, &gtidExecuted)
+	if err == nil && gtidMode == "ON" {
+		return gtidExecuted, nil
+	}
+
+	// SHOW MASTER STATUS is SHOW BINARY LOG STATUS since MySQL 8.2
+	status, err := showStatus(ctx, db, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS")
+	if err != nil {
+		return "", err
+	}
+	if status == nil {
+		return "", errors.New("binary logging is disabled")
+	}
+	return status["File"] + ":" + status["Position"], nil
+}
+
+// ReplicaCaughtUp checks that the replica executed a GTID set, or the
+// changes up to a binlog position of its source
+func (a *MySQLAdapter) ReplicaCaughtUp(ctx context.Context, db *sql.DB, position string) (bool, error) {
+	match := binlogPosition.FindStringSubmatch(position)
+	if match == nil {
+		var caughtUp bool
+		err := db.QueryRowContext(ctx, "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", position).Scan(&caughtUp)
+		return caughtUp, err
+	}
+
+	// The columns were renamed from master to source in MySQL 8.0.22
+	status, err := showStatus(ctx, db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
 	if err != nil {
-		fmt.Printf("Error getting target checksum: %v\n", err)
 		return false, err
 	}
+	if status == nil {
+		return false, errors.New("not a replica")
+	}
+	file := cmp.Or(status["Relay_Source_Log_File"], status["Relay_Master_Log_File"])
+	executed, err := strconv.ParseInt(cmp.Or(status["Exec_Source_Log_Pos"], status["Exec_Master_Log_Pos"]), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("replica status: %w", err)
+	}
+	target, _ := strconv.ParseInt(match[2], 10, 64)
+	// Binlog files are numbered in order, so their names compare like that
+	return file > match[1] || (file == match[1] && executed >= target), nil
+}
 
-	if !sourceChecksum.Valid && !targetChecksum.Valid {
-		fmt.Printf("Checksums not available for table '%s'\n", tableName)
-		return true, nil
+func (a *MySQLAdapter) ReplicaSource(ctx context.Context, db *sql.DB) (string, error) {
+	status, err := showStatus(ctx, db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+	if err != nil || status == nil {
+		return "", err
 	}
+	return cmp.Or(status["Source_Host"], status["Master_Host"]) + ":" + cmp.Or(status["Source_Port"], status["Master_Port"]), nil
+}
 
-	if sourceChecksum.Valid != targetChecksum.Valid {
-		fmt.Printf("Table '%s' has different data (checksum validity differs)\n", tableName)
-		return true, nil
+// ServerLoad counts the threads running a statement, and reads the lag the
+// server reports as a replica
+func (a *MySQLAdapter) ServerLoad(ctx context.Context, db *sql.DB) (ServerLoad, error) {
+	var load ServerLoad
+	var name string
+	if err := db.QueryRowContext(ctx, "SHOW GLOBAL STATUS LIKE 'Threads_running'").Scan(&name, &load.ActiveSessions); err != nil {
+		return load, err
 	}
 
-	if sourceChecksum.Int64 != targetChecksum.Int64 {
-		//fmt.Printf("Table '%s' has different data (checksums: source=%d, target=%d)\n",
-		//	tableName, sourceChecksum.Int64, targetChecksum.Int64)
-		return true, nil // Return true to indicate differences
-	} else {
-		// fmt.Printf("Table '%s' has identical data according to checksum\n", tableName)
-		return false, nil // Return false when no differences
+	status, err := showStatus(ctx, db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+	if err != nil || status == nil {
+		return load, err
+	}
+	// The lag is NULL while replication is stopped, which isn't load
+	if lag, err := strconv.Atoi(cmp.Or(status["Seconds_Behind_Source"], status["Seconds_Behind_Master"])); err == nil {
+		load.Replica, load.ReplicaLag = true, time.Duration(lag)*time.Second
 	}
+	return load, nil
 }
 
-func (a *MySQLAdapter) CompareRowCounts(sourceDB, targetDB *sql.DB, tableName string) (int, int, error) {
-	var sourceCount, targetCount int
+// showStatus returns the columns of the row of a SHOW ... STATUS statement,
+// running the older one if the server doesn't know the first, or nil if
+// it returns no row
+func showStatus(ctx context.Context, db *sql.DB, statement, older string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, statement)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1064 { // ER_PARSE_ERROR
+		rows, err = db.QueryContext(ctx, older)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	status := make(map[string]string, len(columns))
+	for i, column := range columns {
+		status[column] = values[i].String
+	}
+	return status, nil
+}
 
-	sourceRow := sourceDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM `%s`", tableName))
-	if err := sourceRow.Scan(&sourceCount); err != nil {
-		return 0, 0, err
+// GetPrivileges returns the database and table privileges visible to the
+// connected user
+func (a *MySQLAdapter) GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT GRANTEE, TABLE_SCHEMA, '', PRIVILEGE_TYPE
+		FROM INFORMATION_SCHEMA.SCHEMA_PRIVILEGES
+		WHERE `+a.schemaCondition("TABLE_SCHEMA")+`
+		UNION ALL
+		SELECT GRANTEE, TABLE_SCHEMA, TABLE_NAME, PRIVILEGE_TYPE
+		FROM INFORMATION_SCHEMA.TABLE_PRIVILEGES
+		WHERE `+a.schemaCondition("TABLE_SCHEMA")+`
+	`)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	targetRow := targetDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM `%s`", tableName))
-	if err := targetRow.Scan(&targetCount); err != nil {
-		return 0, 0, err
+	var privileges []PrivilegeSchema
+	for rows.Next() {
+		var privilege PrivilegeSchema
+		var database string
+		if err := rows.Scan(&privilege.Grantee, &database, &privilege.Table, &privilege.Privilege); err != nil {
+			return nil, err
+		}
+		// Database privileges are on "database.*" when comparing several
+		if a.qualified() {
+			if privilege.Table == "" {
+				privilege.Table = "*"
+			}
+			privilege.Table = a.qualify(database, privilege.Table)
+		}
+		privileges = append(privileges, privilege)
 	}
 
-	return sourceCount, targetCount, nil
+	return privileges, rows.Err()
+}
+
+// TableChecksum returns MySQL's built-in table checksum, NULL when the
+// storage engine can't compute one
+func (a *MySQLAdapter) TableChecksum(ctx context.Context, db *sql.DB, tableName string, schema TableSchema) (string, sql.NullString, error) {
+	var tableNameCol string
+	var checksum sql.NullString
+	err := db.QueryRowContext(ctx, "CHECKSUM TABLE "+a.QuoteTable(tableName)).Scan(&tableNameCol, &checksum)
+	return "checksum", checksum, err
+}
+
+func (a *MySQLAdapter) CountRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+a.RowSource(tableName)).Scan(&count)
+	return count, err
+}
+
+// EstimateRows reads information_schema.TABLES.TABLE_ROWS, which InnoDB
+// estimates from a sample of the index pages. MySQL 8 caches it for
+// information_schema_stats_expiry seconds, a day by default, so it may
+// predate the latest writes; ANALYZE TABLE refreshes it.
+func (a *MySQLAdapter) EstimateRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	condition, args := a.tableCondition("TABLE_SCHEMA", "TABLE_NAME", tableName)
+	var rows sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE "+condition, args...).Scan(&rows)
+	if err != nil || !rows.Valid {
+		return -1, err
+	}
+	return int(rows.Int64), nil
+}
+
+// EstimateDistinct reads the cardinality InnoDB estimates for the indexes
+// the column comes first in, which it has none for if there are none
+func (a *MySQLAdapter) EstimateDistinct(ctx context.Context, db *sql.DB, tableName, column string) (int64, error) {
+	condition, args := a.tableCondition("TABLE_SCHEMA", "TABLE_NAME", tableName)
+	var distinct sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(CARDINALITY) FROM information_schema.STATISTICS WHERE "+condition+
+		" AND COLUMN_NAME = ? AND SEQ_IN_INDEX = 1", append(args, column)...).Scan(&distinct)
+	if err != nil || !distinct.Valid {
+		return -1, err
+	}
+	return distinct.Int64, nil
+}
+
+// QuoteIdentifier quotes a name, doubling the backticks in it
+func (a *MySQLAdapter) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// QuoteTable quotes a table name, including its database if it has one or
+// isn't the connection's
+func (a *MySQLAdapter) QuoteTable(tableName string) string {
+	if a.Database != "" && !a.qualified() {
+		return a.QuoteIdentifier(a.Database) + "." + a.QuoteIdentifier(tableName)
+	}
+	if !a.qualified() {
+		return a.QuoteIdentifier(tableName)
+	}
+	database, name, _ := splitQualifiedName(tableName)
+	return a.QuoteIdentifier(database) + "." + a.QuoteIdentifier(name)
+}
+
+func (a *MySQLAdapter) SetRowFilter(tableName, condition string) {
+	a.filters.set(tableName, condition)
+}
+
+func (a *MySQLAdapter) RowSource(tableName string) string {
+	return a.filters.source(a.QuoteTable(tableName), tableName)
+}
+
+func (a *MySQLAdapter) SetColumnExpression(tableName, column, expression string) {
+	a.expressions.set(tableName, column, expression)
+}
+
+// SetBinaryKeyOrder orders a text key column by its bytes in utf8mb4, as
+// the driver reads it. Only utf8mb4_0900_bin already does, the older _bin
+// collations pad with spaces. The expression can't use the key's index,
+// so every key range query sorts the rows it reads.
+func (a *MySQLAdapter) SetBinaryKeyOrder(tableName string, column ColumnSchema) {
+	if !isTextType(column.DataType) || column.Collation == "utf8mb4_0900_bin" {
+		return
+	}
+	a.keyOrders.set(tableName, column.Name, fmt.Sprintf("CAST(CONVERT(%s USING utf8mb4) AS BINARY)", a.QuoteIdentifier(column.Name)))
+}
+
+func (a *MySQLAdapter) KeyOrder(tableName, column string) string {
+	return a.keyOrders.keyOrder(tableName, column, a.QuoteIdentifier)
+}
+
+// ValueDigest digests the values of text and blob columns
+func (a *MySQLAdapter) ValueDigest(column ColumnSchema, threshold int) string {
+	t := strings.ToLower(column.DataType)
+	if !strings.HasSuffix(t, "text") && !strings.HasSuffix(t, "blob") {
+		return ""
+	}
+	col := a.QuoteIdentifier(column.Name)
+	return fmt.Sprintf("CASE WHEN OCTET_LENGTH(%s) > %d THEN CONCAT('<', OCTET_LENGTH(%s), ' bytes, sha256 ', SHA2(%s, 256), '>') ELSE %s END",
+		col, threshold, col, col, col)
+}
+
+// QuoteLiteral renders a value read from any database as a MySQL literal.
+// Strings with backslashes, which are escapes unless the server's sql_mode
+// has NO_BACKSLASH_ESCAPES, are written in hex. MySQL has no NaN or
+// infinity, so those are written as strings its strict mode rejects.
+func (a *MySQLAdapter) QuoteLiteral(value interface{}) string {
+	quote := func(s string) string {
+		if strings.Contains(s, `\`) {
+			return "_utf8mb4 X'" + hex.EncodeToString([]byte(s)) + "'"
+		}
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return sqlLiteral(value, quote,
+		func(b []byte) string { return "X'" + hex.EncodeToString(b) + "'" },
+		func(f float64) string { return quote(strconv.FormatFloat(f, 'g', -1, 64)) },
+		"2006-01-02 15:04:05.999999")
+}
+
+func (a *MySQLAdapter) placeholder(int) string {
+	return "?"
+}
+
+func (a *MySQLAdapter) StreamRows(ctx context.Context, db *sql.DB, tableName string, columns []string, orderBy []string, chunk Chunk, limit int) (*sql.Rows, error) {
+	where, args := keyRangeCondition(orderBy, chunk, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s", a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), where)
+	if len(orderBy) > 0 {
+		query += " ORDER BY " + quoteList(orderBy, keyOrders(a, tableName))
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+// SampleRows streams the rows whose primary key falls in the sample, by the
+// first 32 bits of the MD5 of the key values joined with '#', ordered by key.
+// The hash can't use an index, so the server still reads every row.
+func (a *MySQLAdapter) SampleRows(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, percent float64) (*sql.Rows, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE CONV(SUBSTRING(MD5(CONCAT_WS('#', %s)), 1, 8), 16, 10) %% %d < ? ORDER BY %s",
+		a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), quoteList(keyColumns, a.QuoteIdentifier),
+		sampleBuckets, quoteList(keyColumns, keyOrders(a, tableName)))
+	return db.QueryContext(ctx, query, sampleThreshold(percent))
+}
+
+func (a *MySQLAdapter) GetChunkBoundary(ctx context.Context, db *sql.DB, tableName string, keyColumns []string, after []interface{}, chunkSize int) ([]interface{}, error) {
+	where, args := keyRangeCondition(keyColumns, Chunk{Lower: after}, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d",
+		quoteList(keyColumns, a.QuoteIdentifier), a.RowSource(tableName), where, quoteList(keyColumns, keyOrders(a, tableName)), chunkSize-1)
+	return scanChunkBoundary(db.QueryRowContext(ctx, query, args...), len(keyColumns))
+}
+
+func (a *MySQLAdapter) ChunkChecksums(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, chunks []Chunk) ([]ChunkChecksum, error) {
+	// Like pt-table-checksum, hash each row with MD5, with an extra ISNULL()
+	// column so NULL and empty values hash differently. The hashes are summed
+	// rather than XORed, as two copies of a row would cancel out: the sums of
+	// both 32-bit halves of their first 64 bits, which can't overflow.
+	nulls := make([]string, len(columns))
+	for i, col := range columns {
+		nulls[i] = fmt.Sprintf("ISNULL(%s)", a.QuoteIdentifier(col))
+	}
+	rowHash := fmt.Sprintf("MD5(CONCAT_WS('#', %s, CONCAT(%s)))", quoteList(columns, a.QuoteIdentifier), strings.Join(nulls, ", "))
+
+	number, where, args := chunkNumber(keyColumns, chunks, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT chunk, COUNT(*), COALESCE(CONCAT(SUM(CAST(CONV(SUBSTRING(h, 1, 8), 16, 10) AS UNSIGNED)), '-', SUM(CAST(CONV(SUBSTRING(h, 9, 8), 16, 10) AS UNSIGNED))), '') FROM (SELECT %s AS chunk, %s AS h FROM %s%s) row_hashes GROUP BY chunk",
+		number, rowHash, a.RowSource(tableName), where)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanChunkChecksums(rows, len(chunks))
+}
+
+// IsTransientError reports deadlocks, lock wait timeouts, connection limits
+// and dropped connections, which are worth retrying
+func (a *MySQLAdapter) IsTransientError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, // ER_CON_COUNT_ERROR: too many connections
+			1205, // ER_LOCK_WAIT_TIMEOUT
+			1213, // ER_LOCK_DEADLOCK
+			2006, // CR_SERVER_GONE_ERROR
+			2013: // CR_SERVER_LOST
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, mysql.ErrInvalidConn) || isTransientNetworkError(err)
 }
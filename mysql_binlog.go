@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ChangeFeed tails the binlog from the current position with mysqlbinlog,
+// which must be installed, reading the rows events it decodes. It needs
+// binlog_format = ROW and the REPLICATION SLAVE privilege. Columns are
+// matched by position, so a table's columns must not be reordered while
+// it runs.
+func (a *MySQLAdapter) ChangeFeed(ctx context.Context, db *sql.DB, dsn string, schemas map[string]TableSchema) (<-chan RowChange, error) {
+	config, err := mysql.ParseDSN(a.normalizeDSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+	status, err := showStatus(ctx, db, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS")
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, errors.New("binary logging is disabled")
+	}
+	database := a.Database
+	if database == "" && !a.qualified() {
+		if err := db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
+			return nil, err
+		}
+	}
+
+	// The server ID identifies the connection to the source like a
+	// replica's, it must differ from the ones of the real replicas
+	args := []string{"--read-from-remote-server", "--stop-never", "--base64-output=DECODE-ROWS", "--verbose",
+		"--connection-server-id=" + strconv.Itoa(0xFFFF0000|os.Getpid()&0xFFFF),
+		"--user=" + config.User, "--start-position=" + status["Position"]}
+	if config.Net == "unix" {
+		args = append(args, "--socket="+config.Addr)
+	} else {
+		host, port, _ := strings.Cut(config.Addr, ":")
+		args = append(args, "--host="+host, "--port="+cmp.Or(port, "3306"))
+	}
+	cmd := exec.CommandContext(ctx, "mysqlbinlog", append(args, status["File"])...)
+	// The password is passed in the environment, where other users can't
+	// see it like the arguments
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+config.Passwd)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mysqlbinlog: %w", err)
+	}
+
+	changes := make(chan RowChange)
+	go func() {
+		defer close(changes)
+		send := func(change RowChange) bool {
+			select {
+			case changes <- change:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		events := binlogRowsReader{adapter: a, database: database, schemas: schemas}
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			for _, change := range events.line(scanner.Text()) {
+				if !send(change) {
+					cmd.Wait()
+					return
+				}
+			}
+		}
+		// On a line too long, mysqlbinlog would block writing the rest of
+		// its output, which nobody reads anymore, and never exit
+		scanErr := scanner.Err()
+		if scanErr != nil {
+			cmd.Process.Kill()
+		}
+		for _, change := range events.flush() {
+			if !send(change) {
+				break
+			}
+		}
+		err := cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		if scanErr != nil {
+			err = scanErr
+		}
+		if err == nil {
+			err = errors.New("ended")
+		}
+		changes <- RowChange{Err: fmt.Errorf("mysqlbinlog: %w: %s", err, strings.TrimSpace(stderr.String()))}
+	}()
+	return changes, nil
+}
+
+// binlogRowsStatement matches the first line of a rows event mysqlbinlog
+// decoded, e.g. ### UPDATE `shop`.`orders`
+var binlogRowsStatement = regexp.MustCompile("^### (?:INSERT INTO|UPDATE|DELETE FROM) `((?:[^`]|``)*)`\\.`((?:[^`]|``)*)`$")
+
+// binlogRowsReader reads the rows of the compared tables from the rows
+// events of mysqlbinlog --verbose output, e.g.
+//
+//	### UPDATE `shop`.`orders`
+//	### WHERE
+//	###   @1=1
+//	###   @2='new'
+//	### SET
+//	###   @1=1
+//	###   @2='paid'
+//
+// An update changing the key changes the rows of both keys.
+type binlogRowsReader struct {
+	adapter  *MySQLAdapter
+	database string // of the tables compared, unless qualified
+	schemas  map[string]TableSchema
+
+	table  string // the compared table the current event changes, if any
+	values map[int]string
+}
+
+// line reads a line of the output, returning the rows changed by the row
+// image it ends
+func (r *binlogRowsReader) line(line string) []RowChange {
+	if match := binlogRowsStatement.FindStringSubmatch(line); match != nil {
+		changes := r.flush()
+		database, name := strings.ReplaceAll(match[1], "``", "`"), strings.ReplaceAll(match[2], "``", "`")
+		r.table = r.adapter.qualify(database, name)
+		if _, ok := r.schemas[r.table]; !ok || (!r.adapter.qualified() && database != r.database) {
+			r.table = ""
+		}
+		return changes
+	}
+	if line == "### WHERE" || line == "### SET" || !strings.HasPrefix(line, "### ") {
+		return r.flush()
+	}
+	if r.table == "" {
+		return nil
+	}
+
+	column, value, ok := strings.Cut(strings.TrimPrefix(line, "###   @"), "=")
+	position, err := strconv.Atoi(column)
+	if !ok || err != nil {
+		return nil
+	}
+	if r.values == nil {
+		r.values = make(map[int]string)
+	}
+	r.values[position-1] = value
+	return nil
+}
+
+// flush returns the row of the row image read so far, if it has its key
+func (r *binlogRowsReader) flush() []RowChange {
+	values := r.values
+	r.values = nil
+	if r.table == "" || values == nil {
+		return nil
+	}
+
+	schema := r.schemas[r.table]
+	key := make([]string, len(schema.PrimaryKeys))
+	for i, pk := range schema.PrimaryKeys {
+		position := -1
+		for j, column := range schema.Columns {
+			if column.Name == pk {
+				position = j
+			}
+		}
+		value, ok := values[position]
+		if !ok || value == "NULL" {
+			return nil
+		}
+		key[i] = binlogValue(value, strings.Contains(schema.Columns[position].DataType, "unsigned"))
+	}
+	return []RowChange{{Table: r.table, Key: key}}
+}
+
+// binlogValue returns the text of a value as mysqlbinlog prints it: strings
+// are quoted with quotes and backslashes as \x27 and \x5c, and negative
+// integers are followed by their unsigned value, e.g. -1 (4294967295)
+func binlogValue(value string, unsigned bool) string {
+	if !strings.HasPrefix(value, "'") {
+		signed, unsignedValue, ok := strings.Cut(value, " (")
+		if ok && unsigned {
+			return strings.TrimSuffix(unsignedValue, ")")
+		}
+		return signed
+	}
+
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if c, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// When a job notifies
+const (
+	NotifyAlways      = "always"      // after every run
+	NotifyDifferences = "differences" // after runs that found differences or failed
+)
+
+// notifyTimeout bounds each webhook request, so a slow endpoint doesn't
+// hold up the server's shutdown
+const notifyTimeout = 10 * time.Second
+
+// notification is what a job's webhooks are told about a run. Generic
+// webhooks receive it as JSON, Slack and Teams as its text.
+type notification struct {
+	Job               string
+	Run               string
+	Status            string
+	Error             string `json:",omitempty"`
+	MissingTables     int
+	ExtraTables       int
+	SchemaDifferences int // tables and other objects that differ
+	DataDifferences   int // tables whose row counts, chunks or rows differ
+	FailedChecks      int
+	SkippedTables     int
+	Report            string `json:",omitempty"` // URL of the run's dashboard page
+}
+
+func newNotification(run Run, baseURL string) notification {
+	n := notification{Job: run.Job, Run: run.ID, Status: run.Status, Error: run.Error}
+	if baseURL != "" {
+		n.Report = strings.TrimRight(baseURL, "/") + "/runs/" + url.PathEscape(run.ID)
+	}
+	if s := run.Summary; s != nil {
+		n.MissingTables = len(s.MissingTables)
+		n.ExtraTables = len(s.ExtraTables)
+		n.SchemaDifferences = len(s.SchemaDifferences) + len(s.ObjectDifferences)
+		n.SkippedTables = len(s.SkippedTables)
+		tables := make(map[string]bool)
+		for table := range s.DifferentRowCounts {
+			tables[table] = true
+		}
+		for table := range s.ChunkDifferences {
+			tables[table] = true
+		}
+		for table := range s.RowDifferences {
+			tables[table] = true
+		}
+		for table := range s.StatDifferences {
+			tables[table] = true
+		}
+		n.DataDifferences = len(tables)
+		n.FailedChecks = len(s.CheckDifferences)
+	}
+	return n
+}
+
+// differs reports whether the run failed or found differences
+func (n notification) differs() bool {
+	return n.Status != RunFinished || n.MissingTables+n.ExtraTables+n.SchemaDifferences+n.DataDifferences+n.FailedChecks > 0
+}
+
+func (n notification) text() string {
+	var text string
+	switch {
+	case n.Status != RunFinished:
+		text = fmt.Sprintf("Comparison %s %s", n.Run, n.Status)
+		if n.Error != "" {
+			text += ": " + n.Error
+		}
+	case !n.differs():
+		text = fmt.Sprintf("Comparison %s found no differences", n.Run)
+	default:
+		text = fmt.Sprintf("Comparison %s found differences: %d missing tables, %d extra tables, %d schema differences, %d tables with different data, %d failed checks",
+			n.Run, n.MissingTables, n.ExtraTables, n.SchemaDifferences, n.DataDifferences, n.FailedChecks)
+	}
+	if n.SkippedTables > 0 {
+		text += fmt.Sprintf(" (%d tables skipped)", n.SkippedTables)
+	}
+	if n.Report != "" {
+		text += "\n" + n.Report
+	}
+	return text
+}
+
+// teamsWorkflow reports whether a webhook is a Teams workflow, the Power
+// Automate flows that replaced Teams' incoming webhook connectors. Their
+// URLs are on Logic Apps or Power Platform hosts, with the port.
+func teamsWorkflow(u *url.URL) bool {
+	host := u.Hostname()
+	switch {
+	case strings.HasSuffix(host, ".logic.azure.com"):
+		return strings.HasPrefix(u.Path, "/workflows/")
+	case strings.HasSuffix(host, ".api.powerplatform.com"):
+		return strings.Contains(u.Path, "/workflows/")
+	}
+	return false
+}
+
+// teamsMessage is a Teams message with the text in an Adaptive Card, the
+// payload Teams workflows post to a channel
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string          `json:"$schema"`
+	Type    string          `json:"type"`
+	Version string          `json:"version"`
+	Body    []cardTextBlock `json:"body"`
+}
+
+type cardTextBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Wrap bool   `json:"wrap"`
+}
+
+func newTeamsMessage(text string) teamsMessage {
+	return teamsMessage{Type: "message", Attachments: []teamsAttachment{{
+		ContentType: "application/vnd.microsoft.card.adaptive",
+		Content: adaptiveCard{
+			Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+			Type:    "AdaptiveCard",
+			Version: "1.4",
+			Body:    []cardTextBlock{{Type: "TextBlock", Text: text, Wrap: true}},
+		},
+	}}}
+}
+
+// sendNotification posts n to a webhook. Slack and Teams incoming webhooks,
+// recognized by their host, get a message with the notification's text,
+// Teams workflows an Adaptive Card with it, other webhooks the notification
+// itself.
+func sendNotification(ctx context.Context, webhook string, n notification) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+
+	var payload interface{} = n
+	if teamsWorkflow(u) {
+		payload = newTeamsMessage(n.text())
+	} else if u.Host == "hooks.slack.com" || strings.HasSuffix(u.Host, ".webhook.office.com") || u.Host == "outlook.office.com" {
+		payload = struct {
+			Text string `json:"text"`
+		}{n.text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Keep the URL, which holds the webhook's secret, out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("webhook %s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", u.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Plan operations, from the target's point of view
+const (
+	PlanAdd    = "+"
+	PlanChange = "~"
+	PlanDrop   = "-"
+)
+
+// maxPlanValueLength is the longest property value shown in a plan
+const maxPlanValueLength = 40
+
+// PlanAction is one change that would make the target match the source
+type PlanAction struct {
+	Op          string
+	Description string
+}
+
+func (a PlanAction) String() string {
+	return a.Op + " " + a.Description
+}
+
+// Plan summarizes the changes that would make the target match the source
+type Plan struct {
+	Actions []PlanAction
+	Add     int
+	Change  int
+	Drop    int
+}
+
+func (p *Plan) add(op, format string, args ...interface{}) {
+	p.Actions = append(p.Actions, PlanAction{Op: op, Description: fmt.Sprintf(format, args...)})
+	switch op {
+	case PlanAdd:
+		p.Add++
+	case PlanChange:
+		p.Change++
+	case PlanDrop:
+		p.Drop++
+	}
+}
+
+// buildPlan turns the differences in the summary into a plan: tables first,
+// then the schema of common tables, other objects and finally data
+func buildPlan(summary ComparisonSummary) Plan {
+	plan := Plan{}
+
+	for _, table := range summary.MissingTables {
+		plan.add(PlanAdd, "add table %s", table)
+	}
+	for _, table := range summary.ExtraTables {
+		plan.add(PlanDrop, "drop table %s", table)
+	}
+
+	tables := make([]string, 0, len(summary.SchemaDifferences))
+	for table := range summary.SchemaDifferences {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, diff := range summary.SchemaDifferences[table] {
+			plan.addDifference(diff)
+		}
+	}
+
+	for _, diff := range summary.ObjectDifferences {
+		plan.addDifference(diff)
+	}
+
+	for _, table := range summary.DifferentTables {
+		if rows, ok := summary.RowDifferences[table]; ok {
+			if rows.Deleted > 0 {
+				plan.add(PlanAdd, "insert %d rows into %s", rows.Deleted, table)
+			}
+			if rows.Changed > 0 {
+				plan.add(PlanChange, "update %d rows in %s", rows.Changed, table)
+			}
+			if rows.Inserted > 0 {
+				plan.add(PlanDrop, "delete %d rows from %s", rows.Inserted, table)
+			}
+		} else if counts, ok := summary.DifferentRowCounts[table]; ok {
+			plan.add(PlanChange, "sync data of %s (rows: %d -> %d)", table, counts.Target, counts.Source)
+		} else if _, ok := summary.ChunkDifferences[table]; ok {
+			plan.add(PlanChange, "sync data of %s", table)
+		}
+	}
+
+	return plan
+}
+
+func (p *Plan) addDifference(diff Difference) {
+	name := diff.ObjectName
+	switch {
+	case diff.Table != "" && name != "":
+		name = diff.Table + "." + name
+	case diff.Table != "":
+		name = diff.Table
+	}
+
+	switch diff.Kind {
+	case DiffMissing:
+		p.add(PlanAdd, "add %s %s", diff.ObjectType, name)
+	case DiffExtra:
+		p.add(PlanDrop, "drop %s %s", diff.ObjectType, name)
+	case DiffModified:
+		// Long values such as view definitions would drown the plan
+		if diff.Property != "" && (len(diff.Source) > maxPlanValueLength || len(diff.Target) > maxPlanValueLength) {
+			p.add(PlanChange, "modify %s %s (%s)", diff.ObjectType, name, diff.Property)
+		} else if diff.Property == "" {
+			p.add(PlanChange, "modify %s %s", diff.ObjectType, name)
+		} else {
+			p.add(PlanChange, "modify %s %s (%s: %q -> %q)", diff.ObjectType, name, diff.Property, diff.Target, diff.Source)
+		}
+	}
+}
@@ -1,17 +1,52 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-// PostgreSQLAdapter implements DatabaseAdapter for PostgreSQL
-type PostgreSQLAdapter struct{}
+// PostgreSQLAdapter implements DatabaseAdapter for PostgreSQL. It reads the
+// public schema, or Schema, unless Schemas or AllSchemas is set, in which
+// case objects are named "schema.name".
+type PostgreSQLAdapter struct {
+	Schema     string
+	Schemas    []string
+	AllSchemas bool // all schemas except the system ones
+
+	filters     rowFilters
+	expressions columnExpressions
+	keyOrders   columnExpressions // set by SetBinaryKeyOrder
+}
 
 func (a *PostgreSQLAdapter) Connect(connectionString string) (*sql.DB, error) {
-	return sql.Open("postgres", connectionString)
+	return openDB("postgres", connectionString)
+}
+
+// SetPassword returns the connection string, a URL or key=value pairs,
+// with its password replaced
+func (a *PostgreSQLAdapter) SetPassword(connectionString, password string) (string, error) {
+	if strings.HasPrefix(connectionString, "postgres://") || strings.HasPrefix(connectionString, "postgresql://") {
+		u, err := url.Parse(connectionString)
+		if err != nil {
+			return "", err
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String(), nil
+	}
+
+	// A later key overrides an earlier one
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return fmt.Sprintf("%s password='%s'", connectionString, escaped), nil
 }
 
 func (a *PostgreSQLAdapter) GetConnectStringFromURL(url string) string {
@@ -19,11 +54,110 @@ func (a *PostgreSQLAdapter) GetConnectStringFromURL(url string) string {
 	return url
 }
 
-func (a *PostgreSQLAdapter) GetTableList(db *sql.DB) ([]string, error) {
-	rows, err := db.Query(`
-		SELECT table_name
+// qualified reports whether object names include their schema
+func (a *PostgreSQLAdapter) qualified() bool {
+	return a.AllSchemas || len(a.Schemas) > 0
+}
+
+// schemaCondition is the SQL condition selecting the compared schemas in column
+func (a *PostgreSQLAdapter) schemaCondition(column string) string {
+	switch {
+	case a.AllSchemas:
+		return fmt.Sprintf(`%s <> 'information_schema' AND %s NOT LIKE 'pg\_%%'`, column, column)
+	case len(a.Schemas) > 0:
+		return fmt.Sprintf("%s IN (%s)", column, quoteList(a.Schemas, func(s string) string { return a.QuoteLiteral(s) }))
+	default:
+		return column + " = " + a.QuoteLiteral(a.defaultSchema())
+	}
+}
+
+// defaultSchema is the schema read when names aren't qualified
+func (a *PostgreSQLAdapter) defaultSchema() string {
+	if a.Schema != "" {
+		return a.Schema
+	}
+	return "public"
+}
+
+// qualify returns the name of an object in schema as it's reported
+func (a *PostgreSQLAdapter) qualify(schema, name string) string {
+	if !a.qualified() {
+		return name
+	}
+	return qualifiedName(schema, name)
+}
+
+// splitTableName returns the schema and name of a table named by qualify
+func (a *PostgreSQLAdapter) splitTableName(tableName string) (string, string) {
+	if !a.qualified() {
+		return a.defaultSchema(), tableName
+	}
+	schema, name, _ := splitQualifiedName(tableName)
+	return schema, name
+}
+
+// QuoteTable quotes a table name, including its schema if it has one or
+// isn't public
+func (a *PostgreSQLAdapter) QuoteTable(tableName string) string {
+	if !a.qualified() && a.Schema == "" {
+		return a.QuoteIdentifier(tableName)
+	}
+	schema, name := a.splitTableName(tableName)
+	return a.QuoteIdentifier(schema) + "." + a.QuoteIdentifier(name)
+}
+
+func (a *PostgreSQLAdapter) SetRowFilter(tableName, condition string) {
+	a.filters.set(tableName, condition)
+}
+
+func (a *PostgreSQLAdapter) RowSource(tableName string) string {
+	return a.filters.source(a.QuoteTable(tableName), tableName)
+}
+
+// ValueDigest digests the values of text and bytea columns, text as UTF-8
+func (a *PostgreSQLAdapter) ValueDigest(column ColumnSchema, threshold int) string {
+	col := a.QuoteIdentifier(column.Name)
+	switch column.DataType {
+	case "text", "character varying":
+		return fmt.Sprintf("CASE WHEN octet_length(%s) > %d THEN '<' || octet_length(%s) || ' bytes, sha256 ' || encode(sha256(convert_to(%s, 'UTF8')), 'hex') || '>' ELSE %s END",
+			col, threshold, col, col, col)
+	case "bytea":
+		return fmt.Sprintf("CASE WHEN octet_length(%s) > %d THEN convert_to('<' || octet_length(%s) || ' bytes, sha256 ' || encode(sha256(%s), 'hex') || '>', 'UTF8') ELSE %s END",
+			col, threshold, col, col, col)
+	}
+	return ""
+}
+
+func (a *PostgreSQLAdapter) SetColumnExpression(tableName, column, expression string) {
+	a.expressions.set(tableName, column, expression)
+}
+
+// SetBinaryKeyOrder orders a text key column in the C collation, by its
+// bytes, unless it already has it. Databases default to collations like
+// en_US.UTF-8, whose order isn't the bytes'. The key's index, in its own
+// collation, can't serve that order, so every key range query sorts the
+// rows it reads.
+func (a *PostgreSQLAdapter) SetBinaryKeyOrder(tableName string, column ColumnSchema) {
+	switch column.DataType {
+	case "text", "character varying", "character":
+	default:
+		return
+	}
+	if column.Collation == "C" || column.Collation == "POSIX" {
+		return
+	}
+	a.keyOrders.set(tableName, column.Name, a.QuoteIdentifier(column.Name)+` COLLATE "C"`)
+}
+
+func (a *PostgreSQLAdapter) KeyOrder(tableName, column string) string {
+	return a.keyOrders.keyOrder(tableName, column, a.QuoteIdentifier)
+}
+
+func (a *PostgreSQLAdapter) GetTableList(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT table_schema, table_name
 		FROM information_schema.tables
-		WHERE table_schema='public' AND table_type='BASE TABLE'
+		WHERE `+a.schemaCondition("table_schema")+` AND table_type='BASE TABLE'
 	`)
 	if err != nil {
 		return nil, err
@@ -32,35 +166,39 @@ func (a *PostgreSQLAdapter) GetTableList(db *sql.DB) ([]string, error) {
 
 	var tables []string
 	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
+		var schema, tableName string
+		if err := rows.Scan(&schema, &tableName); err != nil {
 			return nil, err
 		}
-		tables = append(tables, tableName)
+		tables = append(tables, a.qualify(schema, tableName))
 	}
 
 	return tables, nil
 }
 
-func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema, error) {
+func (a *PostgreSQLAdapter) GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error) {
 	tableSchema := TableSchema{Name: tableName}
+	schema, name := a.splitTableName(tableName)
+	regclass := a.QuoteTable(tableName)
 
 	// Get columns
-	columns, err := db.Query(`
+	columns, err := db.QueryContext(ctx, `
 		SELECT
 			column_name,
-			data_type,
+			CASE WHEN udt_name IN ('geometry', 'geography') THEN udt_name::text ELSE data_type END,
 			is_nullable,
 			column_default,
-			''::text as extra
+			''::text as extra,
+			COALESCE(collation_name, ''),
+			is_identity
 		FROM
 			information_schema.columns
 		WHERE
-			table_schema = 'public' AND
-			table_name = $1
+			table_schema = $1 AND
+			table_name = $2
 		ORDER BY
 			ordinal_position
-	`, tableName)
+	`, schema, name)
 	if err != nil {
 		return tableSchema, err
 	}
@@ -72,10 +210,14 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 		var defaultValue sql.NullString
 		var extra string
 
-		if err := columns.Scan(&col.Name, &col.DataType, &nullable, &defaultValue, &extra); err != nil {
+		var isIdentity string
+		if err := columns.Scan(&col.Name, &col.DataType, &nullable, &defaultValue, &extra, &col.Collation, &isIdentity); err != nil {
 			return tableSchema, err
 		}
 
+		// serial columns are integers defaulting to the next value of their sequence
+		col.AutoIdentity = isIdentity == "YES" || strings.HasPrefix(defaultValue.String, "nextval(")
+
 		col.Nullable = nullable
 		col.Default = defaultValue
 		col.Extra = extra
@@ -84,14 +226,14 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 	}
 
 	// Get primary keys
-	primaryKeys, err := db.Query(`
+	primaryKeys, err := db.QueryContext(ctx, `
 		SELECT a.attname
 		FROM   pg_index i
 		JOIN   pg_attribute a ON a.attrelid = i.indrelid
 								AND a.attnum = ANY(i.indkey)
 		WHERE  i.indrelid = $1::regclass
 		AND    i.indisprimary
-	`, tableName)
+	`, regclass)
 	if err != nil {
 		return tableSchema, err
 	}
@@ -113,7 +255,7 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 	}
 
 	// Get indexes
-	indexes, err := db.Query(`
+	indexes, err := db.QueryContext(ctx, `
 		SELECT
 			i.relname as index_name,
 			a.attname as column_name,
@@ -129,8 +271,8 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 			AND a.attrelid = t.oid
 			AND a.attnum = ANY(ix.indkey)
 			AND t.relkind = 'r'
-			AND t.relname = $1
-	`, tableName)
+			AND t.oid = $1::regclass
+	`, regclass)
 	if err != nil {
 		return tableSchema, err
 	}
@@ -152,11 +294,35 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 		tableSchema.Indexes = append(tableSchema.Indexes, indexSchema)
 	}
 
+	// Get unique constraints and the indexes backing them
+	uniqueConstraints, err := db.QueryContext(ctx, `
+		SELECT c.conname, a.attname, i.relname
+		FROM pg_constraint c
+		JOIN pg_class i ON i.oid = c.conindid
+		CROSS JOIN LATERAL unnest(c.conkey) WITH ORDINALITY AS k(attnum, position)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		WHERE c.contype = 'u' AND c.conrelid = $1::regclass
+		ORDER BY c.conname, k.position
+	`, regclass)
+	if err != nil {
+		return tableSchema, err
+	}
+	defer uniqueConstraints.Close()
+
+	for uniqueConstraints.Next() {
+		var constraintName, columnName, indexName string
+		if err := uniqueConstraints.Scan(&constraintName, &columnName, &indexName); err != nil {
+			return tableSchema, err
+		}
+		tableSchema.addUniqueConstraintColumn(constraintName, indexName, columnName)
+	}
+
 	// Get foreign keys
-	foreignKeys, err := db.Query(`
+	foreignKeys, err := db.QueryContext(ctx, `
 		SELECT
 			tc.constraint_name,
 			kcu.column_name,
+			ccu.table_schema AS referenced_schema,
 			ccu.table_name AS referenced_table,
 			ccu.column_name AS referenced_column
 		FROM
@@ -169,8 +335,9 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 				AND ccu.table_schema = tc.table_schema
 		WHERE
 			tc.constraint_type = 'FOREIGN KEY' AND
-			tc.table_name = $1
-	`, tableName)
+			tc.table_schema = $1 AND
+			tc.table_name = $2
+	`, schema, name)
 	if err != nil {
 		return tableSchema, err
 	}
@@ -178,67 +345,439 @@ func (a *PostgreSQLAdapter) GetTableSchema(db *sql.DB, tableName string) (TableS
 
 	for foreignKeys.Next() {
 		var fk ForeignKeySchema
-		if err := foreignKeys.Scan(&fk.Name, &fk.ColumnName, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
+		var referencedSchema string
+		if err := foreignKeys.Scan(&fk.Name, &fk.ColumnName, &referencedSchema, &fk.ReferencedTable, &fk.ReferencedColumn); err != nil {
 			return tableSchema, err
 		}
+		fk.ReferencedTable = a.qualify(referencedSchema, fk.ReferencedTable)
 		tableSchema.ForeignKeys = append(tableSchema.ForeignKeys, fk)
 	}
 
 	return tableSchema, nil
 }
 
-func (a *PostgreSQLAdapter) CompareTableDataByChecksum(sourceDB, targetDB *sql.DB, tableName string, schema TableSchema) (bool, error) {
-	fmt.Printf("Comparing data for table '%s' by hash...\n", tableName)
+func (a *PostgreSQLAdapter) GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error) {
+	// pg_get_viewdef works for views we don't own, unlike information_schema.views
+	rows, err := db.QueryContext(ctx, `
+		SELECT n.nspname, c.relname, pg_get_viewdef(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+a.schemaCondition("n.nspname")+` AND c.relkind = 'v'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// PostgreSQL doesn't have CHECKSUM TABLE, so use MD5 on all rows
-	query := fmt.Sprintf("SELECT MD5(CAST((array_agg(t.* ORDER BY %s)) AS text)) FROM %s t",
-		getOrderByClause(schema), tableName)
+	var views []ViewSchema
+	for rows.Next() {
+		var view ViewSchema
+		var schema string
+		if err := rows.Scan(&schema, &view.Name, &view.Definition); err != nil {
+			return nil, err
+		}
+		view.Name = a.qualify(schema, view.Name)
+		views = append(views, view)
+	}
 
-	var sourceHash, targetHash sql.NullString
+	return views, rows.Err()
+}
 
-	err := sourceDB.QueryRow(query).Scan(&sourceHash)
+func (a *PostgreSQLAdapter) GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error) {
+	// Functions can be overloaded, so their names include the argument types
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			n.nspname,
+			p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+			CASE p.prokind WHEN 'p' THEN 'procedure' ELSE 'function' END,
+			pg_get_functiondef(p.oid)
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE `+a.schemaCondition("n.nspname")+` AND p.prokind IN ('f', 'p')
+	`)
 	if err != nil {
-		fmt.Printf("Error getting source hash: %v\n", err)
-		return false, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var routines []RoutineSchema
+	for rows.Next() {
+		var routine RoutineSchema
+		var schema string
+		if err := rows.Scan(&schema, &routine.Name, &routine.Type, &routine.Definition); err != nil {
+			return nil, err
+		}
+		routine.Name = a.qualify(schema, routine.Name)
+		routines = append(routines, routine)
 	}
 
-	err = targetDB.QueryRow(query).Scan(&targetHash)
+	return routines, rows.Err()
+}
+
+func (a *PostgreSQLAdapter) GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error) {
+	// last_value is NULL until the sequence is first used
+	rows, err := db.QueryContext(ctx, `
+		SELECT schemaname, sequencename, increment_by, min_value, max_value,
+			COALESCE(last_value, start_value - increment_by)
+		FROM pg_sequences
+		WHERE `+a.schemaCondition("schemaname")+`
+	`)
 	if err != nil {
-		fmt.Printf("Error getting target hash: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []SequenceSchema
+	for rows.Next() {
+		var sequence SequenceSchema
+		var schema string
+		if err := rows.Scan(&schema, &sequence.Name, &sequence.Increment, &sequence.MinValue,
+			&sequence.MaxValue, &sequence.CurrentValue); err != nil {
+			return nil, err
+		}
+		sequence.Name = a.qualify(schema, sequence.Name)
+		sequences = append(sequences, sequence)
+	}
+
+	return sequences, rows.Err()
+}
+
+func (a *PostgreSQLAdapter) GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error) {
+	var types []TypeSchema
+
+	// Get enums with their labels in sort order
+	enums, err := db.QueryContext(ctx, `
+		SELECT n.nspname, t.typname, e.enumlabel
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		JOIN pg_enum e ON e.enumtypid = t.oid
+		WHERE `+a.schemaCondition("n.nspname")+`
+		ORDER BY n.nspname, t.typname, e.enumsortorder
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer enums.Close()
+
+	for enums.Next() {
+		var schema, typeName, label string
+		if err := enums.Scan(&schema, &typeName, &label); err != nil {
+			return nil, err
+		}
+		typeName = a.qualify(schema, typeName)
+		if len(types) == 0 || types[len(types)-1].Name != typeName {
+			types = append(types, TypeSchema{Name: typeName, Kind: "enum"})
+		}
+		types[len(types)-1].Labels = append(types[len(types)-1].Labels, label)
+	}
+	if err := enums.Err(); err != nil {
+		return nil, err
+	}
+
+	// Get domains
+	domains, err := db.QueryContext(ctx, `
+		SELECT
+			n.nspname,
+			t.typname,
+			format_type(t.typbasetype, t.typtypmod),
+			t.typnotnull,
+			COALESCE(t.typdefault, ''),
+			COALESCE(string_agg(pg_get_constraintdef(c.oid), ' AND ' ORDER BY c.conname), '')
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_constraint c ON c.contypid = t.oid
+		WHERE `+a.schemaCondition("n.nspname")+` AND t.typtype = 'd'
+		GROUP BY t.oid, n.nspname, t.typname, t.typbasetype, t.typtypmod, t.typnotnull, t.typdefault
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer domains.Close()
+
+	for domains.Next() {
+		domain := TypeSchema{Kind: "domain"}
+		var schema string
+		if err := domains.Scan(&schema, &domain.Name, &domain.BaseType, &domain.NotNull, &domain.Default, &domain.Constraints); err != nil {
+			return nil, err
+		}
+		domain.Name = a.qualify(schema, domain.Name)
+		types = append(types, domain)
+	}
+
+	return types, domains.Err()
+}
+
+func (a *PostgreSQLAdapter) Capabilities() Capabilities {
+	return Capabilities{Engine: "postgres", ServerChecksums: true, Snapshots: true, Replication: true, ChangeFeeds: true, ServerLoad: true, Schemas: true, Sequences: true,
+		Routines: true, Types: true, Privileges: true, MaxIdentifierLength: 63}
+}
+
+// BeginSnapshot exports the snapshot of a REPEATABLE READ transaction and
+// has every connection read in a transaction importing it. Connections
+// whose statement failed are replaced, as the error aborts the transaction.
+// It needs no lock.
+func (a *PostgreSQLAdapter) BeginSnapshot(ctx context.Context, db *sql.DB, connections int, lock bool) (func() error, error) {
+	session, err := poolSession(db)
+	if err != nil {
+		return nil, err
+	}
+	const begin = "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot string
+	if _, err = conn.ExecContext(ctx, begin); err == nil {
+		err = conn.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	session.start([]string{begin, "SET TRANSACTION SNAPSHOT " + a.QuoteLiteral(snapshot)}, true)
+	return func() error {
+		session.end()
+		_, err := conn.ExecContext(context.Background(), "COMMIT")
+		conn.Close()
+		return err
+	}, nil
+}
+
+// ReplicationPosition returns the current WAL LSN of a primary, the last
+// replayed one of a replica
+func (a *PostgreSQLAdapter) ReplicationPosition(ctx context.Context, db *sql.DB) (string, error) {
+	var lsn sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END::text
+	`).Scan(&lsn)
+	if err == nil && !lsn.Valid {
+		err = errors.New("replica hasn't replayed any WAL")
+	}
+	return lsn.String, err
+}
+
+func (a *PostgreSQLAdapter) ReplicaCaughtUp(ctx context.Context, db *sql.DB, position string) (bool, error) {
+	var caughtUp sql.NullBool
+	if err := db.QueryRowContext(ctx, "SELECT pg_last_wal_replay_lsn() >= $1::pg_lsn", position).Scan(&caughtUp); err != nil {
 		return false, err
 	}
+	if !caughtUp.Valid {
+		return false, errors.New("not a replica")
+	}
+	return caughtUp.Bool, nil
+}
+
+// ReplicaSource returns the host the WAL receiver streams from, or
+// "(archive)" for a standby restoring archived WAL
+func (a *PostgreSQLAdapter) ReplicaSource(ctx context.Context, db *sql.DB) (string, error) {
+	var inRecovery bool
+	var sender sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT pg_is_in_recovery(), (SELECT sender_host || ':' || sender_port FROM pg_stat_wal_receiver)
+	`).Scan(&inRecovery, &sender)
+	if err != nil || !inRecovery {
+		return "", err
+	}
+	return cmp.Or(sender.String, "(archive)"), nil
+}
+
+// ServerLoad counts the other client backends running a statement, and
+// the time since a replica replayed the last transaction it received,
+// zero once it replayed everything, so an idle primary isn't lag
+func (a *PostgreSQLAdapter) ServerLoad(ctx context.Context, db *sql.DB) (ServerLoad, error) {
+	var load ServerLoad
+	var lag sql.NullFloat64
+	err := db.QueryRowContext(ctx, `
+		SELECT (SELECT count(*) FROM pg_stat_activity
+		        WHERE state = 'active' AND backend_type = 'client backend' AND pid <> pg_backend_pid()),
+		       CASE WHEN NOT pg_is_in_recovery() THEN NULL
+		            WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		            ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END
+	`).Scan(&load.ActiveSessions, &lag)
+	load.Replica, load.ReplicaLag = lag.Valid, time.Duration(lag.Float64*float64(time.Second))
+	return load, err
+}
+
+func (a *PostgreSQLAdapter) GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT grantee, table_schema, table_name, privilege_type
+		FROM information_schema.role_table_grants
+		WHERE `+a.schemaCondition("table_schema")+`
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var privileges []PrivilegeSchema
+	for rows.Next() {
+		var privilege PrivilegeSchema
+		var schema string
+		if err := rows.Scan(&privilege.Grantee, &schema, &privilege.Table, &privilege.Privilege); err != nil {
+			return nil, err
+		}
+		privilege.Table = a.qualify(schema, privilege.Table)
+		privileges = append(privileges, privilege)
+	}
+
+	return privileges, rows.Err()
+}
+
+// TableChecksum hashes all rows in primary key order, as PostgreSQL doesn't
+// have CHECKSUM TABLE. Empty tables hash to an empty string.
+func (a *PostgreSQLAdapter) TableChecksum(ctx context.Context, db *sql.DB, tableName string, schema TableSchema) (string, sql.NullString, error) {
+	query := fmt.Sprintf("SELECT COALESCE(MD5(CAST((array_agg(t.* ORDER BY %s)) AS text)), '') FROM %s t",
+		getOrderByClause(schema, a.QuoteIdentifier), a.QuoteTable(tableName))
+
+	var checksum sql.NullString
+	err := db.QueryRowContext(ctx, query).Scan(&checksum)
+	return "hash", checksum, err
+}
+
+func (a *PostgreSQLAdapter) CountRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+a.RowSource(tableName)).Scan(&count)
+	return count, err
+}
 
-	if !sourceHash.Valid && !targetHash.Valid {
-		fmt.Printf("Hash not available for table '%s'\n", tableName)
-		return false, nil
+// EstimateRows reads pg_class.reltuples, as of the table's last VACUUM or
+// ANALYZE, summing those of the leaf partitions of a partitioned table, at
+// any depth. Tables never analyzed have none, -1 since PostgreSQL 14 and 0
+// before.
+func (a *PostgreSQLAdapter) EstimateRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	schema, name := a.splitTableName(tableName)
+	var rows sql.NullFloat64
+	err := db.QueryRowContext(ctx, `
+		WITH RECURSIVE tree AS (
+			SELECT c.oid, c.relkind, c.reltuples
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = $1 AND c.relname = $2
+			UNION ALL
+			SELECT p.oid, p.relkind, p.reltuples
+			FROM tree t JOIN pg_inherits i ON i.inhparent = t.oid JOIN pg_class p ON p.oid = i.inhrelid
+			WHERE t.relkind = 'p'
+		)
+		SELECT sum(reltuples) FROM tree WHERE relkind <> 'p' AND reltuples >= 0
+	`, schema, name).Scan(&rows)
+	if err != nil || !rows.Valid || rows.Float64 < 0 {
+		return -1, err
 	}
+	return int(rows.Float64), nil
+}
 
-	if sourceHash.Valid != targetHash.Valid {
-		fmt.Printf("Table '%s' has different data (hash validity differs)\n", tableName)
-		return true, nil
+// EstimateDistinct reads pg_stats.n_distinct, as of the table's last
+// ANALYZE, over all partitions of a partitioned table. A negative one is a
+// fraction of the estimated rows.
+func (a *PostgreSQLAdapter) EstimateDistinct(ctx context.Context, db *sql.DB, tableName, column string) (int64, error) {
+	schema, name := a.splitTableName(tableName)
+	var distinct float64
+	err := db.QueryRowContext(ctx, `
+		SELECT n_distinct FROM pg_stats
+		WHERE schemaname = $1 AND tablename = $2 AND attname = $3
+		ORDER BY inherited DESC
+		LIMIT 1
+	`, schema, name, column).Scan(&distinct)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	if err != nil || distinct >= 0 {
+		return int64(distinct), err
+	}
+	rows, err := a.EstimateRows(ctx, db, tableName)
+	if err != nil || rows < 0 {
+		return -1, err
 	}
+	return int64(math.Round(-distinct * float64(rows))), nil
+}
+
+// QuoteIdentifier quotes a name, doubling the quotes in it
+func (a *PostgreSQLAdapter) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
 
-	if sourceHash.String != targetHash.String {
-		fmt.Printf("Table '%s' has different data (hashes differ)\n", tableName)
-		return true, nil
-	} else {
-		fmt.Printf("Table '%s' has identical data according to hash\n", tableName)
-		return false, nil
+// QuoteLiteral renders a value read from any database as a PostgreSQL literal
+func (a *PostgreSQLAdapter) QuoteLiteral(value interface{}) string {
+	return sqlLiteral(value,
+		func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" },
+		func(b []byte) string { return `'\x` + hex.EncodeToString(b) + "'" },
+		postgresSpecialFloat,
+		"2006-01-02 15:04:05.999999-07:00")
+}
+
+// postgresSpecialFloat renders NaN and the infinities as the strings
+// PostgreSQL reads them from
+func postgresSpecialFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "'NaN'"
+	case f > 0:
+		return "'Infinity'"
+	default:
+		return "'-Infinity'"
 	}
 }
 
-func (a *PostgreSQLAdapter) CompareRowCounts(sourceDB, targetDB *sql.DB, tableName string) (int, int, error) {
-	var sourceCount, targetCount int
+func (a *PostgreSQLAdapter) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
 
-	sourceRow := sourceDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM \"%s\"", tableName))
-	if err := sourceRow.Scan(&sourceCount); err != nil {
-		return 0, 0, err
+func (a *PostgreSQLAdapter) StreamRows(ctx context.Context, db *sql.DB, tableName string, columns []string, orderBy []string, chunk Chunk, limit int) (*sql.Rows, error) {
+	where, args := keyRangeCondition(orderBy, chunk, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s", a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), where)
+	if len(orderBy) > 0 {
+		query += " ORDER BY " + quoteList(orderBy, keyOrders(a, tableName))
 	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return db.QueryContext(ctx, query, args...)
+}
 
-	targetRow := targetDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM \"%s\"", tableName))
-	if err := targetRow.Scan(&targetCount); err != nil {
-		return 0, 0, err
+// SampleRows streams the rows whose primary key falls in the sample, hashed
+// like MySQLAdapter.SampleRows, ordered by key
+func (a *PostgreSQLAdapter) SampleRows(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, percent float64) (*sql.Rows, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE ('x' || substr(md5(concat_ws('#', %s)), 1, 8))::bit(32)::bigint %% %d < $1 ORDER BY %s",
+		a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), quoteList(keyColumns, a.QuoteIdentifier),
+		sampleBuckets, quoteList(keyColumns, keyOrders(a, tableName)))
+	return db.QueryContext(ctx, query, sampleThreshold(percent))
+}
+
+func (a *PostgreSQLAdapter) GetChunkBoundary(ctx context.Context, db *sql.DB, tableName string, keyColumns []string, after []interface{}, chunkSize int) ([]interface{}, error) {
+	where, args := keyRangeCondition(keyColumns, Chunk{Lower: after}, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d",
+		quoteList(keyColumns, a.QuoteIdentifier), a.RowSource(tableName), where, quoteList(keyColumns, keyOrders(a, tableName)), chunkSize-1)
+	return scanChunkBoundary(db.QueryRowContext(ctx, query, args...), len(keyColumns))
+}
+
+func (a *PostgreSQLAdapter) ChunkChecksums(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, chunks []Chunk) ([]ChunkChecksum, error) {
+	// The rows' hashes are aggregated per chunk, the chunk number named so
+	// it can't clash with a column
+	number, where, args := chunkNumber(keyColumns, chunks, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT mudrockdbcompare_chunk, COUNT(*), COALESCE(MD5(string_agg(MD5(ROW(%s)::text), '' ORDER BY %s)), '') FROM (SELECT *, %s AS mudrockdbcompare_chunk FROM %s%s) chunked_rows GROUP BY mudrockdbcompare_chunk",
+		quoteList(columns, a.QuoteIdentifier), quoteList(keyColumns, keyOrders(a, tableName)), number, a.RowSource(tableName), where)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
+	return scanChunkChecksums(rows, len(chunks))
+}
 
-	return sourceCount, targetCount, nil
+// IsTransientError reports serialization failures, deadlocks, connection
+// limits and dropped connections, which are worth retrying
+func (a *PostgreSQLAdapter) IsTransientError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"53300", // too_many_connections
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// Class 08: connection exceptions
+		return pqErr.Code.Class() == "08"
+	}
+	return isTransientNetworkError(err)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChangeFeed decodes the WAL with a temporary logical replication slot of
+// the test_decoding plugin that comes with PostgreSQL, polled every second.
+// It needs wal_level = logical and the REPLICATION attribute. Deleted rows
+// only have keys with the default REPLICA IDENTITY.
+func (a *PostgreSQLAdapter) ChangeFeed(ctx context.Context, db *sql.DB, dsn string, schemas map[string]TableSchema) (<-chan RowChange, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slot := fmt.Sprintf("mudrockdbcompare_%d", os.Getpid())
+	if _, err := conn.ExecContext(ctx, "SELECT pg_create_logical_replication_slot($1, 'test_decoding', true)", slot); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	changes := make(chan RowChange)
+	go func() {
+		defer close(changes)
+		defer conn.Close()
+		defer conn.ExecContext(context.Background(), "SELECT pg_drop_replication_slot($1)", slot)
+
+		for {
+			lines, err := a.decodedChanges(ctx, conn, slot)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				changes <- RowChange{Err: err}
+				return
+			}
+			for _, line := range lines {
+				for _, change := range a.decodedRowChanges(line, schemas) {
+					select {
+					case changes <- change:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// decodedChanges returns the changes decoded since the last call
+func (a *PostgreSQLAdapter) decodedChanges(ctx context.Context, conn *sql.Conn, slot string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT data FROM pg_logical_slot_get_changes($1, NULL, NULL, 'include-xids', '0', 'skip-empty-xacts', '1')
+	`, slot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// decodedRowChanges returns the rows of a compared table a line of
+// test_decoding output changed, e.g.
+//
+//	table public.orders: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 status[text]:'paid'
+//
+// An update changing the key changes the rows of both keys.
+func (a *PostgreSQLAdapter) decodedRowChanges(line string, schemas map[string]TableSchema) []RowChange {
+	rest, ok := strings.CutPrefix(line, "table ")
+	if !ok {
+		return nil
+	}
+	schema, rest := decodedIdentifier(rest)
+	rest, ok = strings.CutPrefix(rest, ".")
+	if !ok {
+		return nil
+	}
+	name, rest := decodedIdentifier(rest)
+	tableName := a.qualify(schema, name)
+	tableSchema, ok := schemas[tableName]
+	if !ok || (!a.qualified() && schema != a.defaultSchema()) {
+		return nil
+	}
+	_, rest, ok = strings.Cut(rest, ": ") // the operation
+	if !ok {
+		return nil
+	}
+	_, rest, ok = strings.Cut(rest, ": ")
+	if !ok {
+		return nil
+	}
+
+	var changes []RowChange
+	for _, tuple := range decodedTuples(rest) {
+		key := make([]string, len(tableSchema.PrimaryKeys))
+		for i, pk := range tableSchema.PrimaryKeys {
+			if key[i], ok = tuple[pk]; !ok {
+				break
+			}
+		}
+		if ok {
+			changes = append(changes, RowChange{Table: tableName, Key: key})
+		}
+	}
+	return changes
+}
+
+// decodedIdentifier reads a name test_decoding quoted where needed
+func decodedIdentifier(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, ".:[")
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+	var name strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] == '"' {
+			if i+1 < len(s) && s[i+1] == '"' {
+				name.WriteByte('"')
+				i++
+				continue
+			}
+			return name.String(), s[i+1:]
+		}
+		name.WriteByte(s[i])
+	}
+	return name.String(), ""
+}
+
+// decodedTuples reads the columns of the tuples of a change, name[type]:value
+// separated by spaces, the old key and the new tuple of an update each in
+// its own tuple. Values are unquoted, null is left out.
+func decodedTuples(s string) []map[string]string {
+	tuples := []map[string]string{{}}
+	for s != "" {
+		for _, section := range []string{"old-key: ", "new-tuple: "} {
+			if rest, ok := strings.CutPrefix(s, section); ok {
+				if len(tuples[len(tuples)-1]) > 0 {
+					tuples = append(tuples, map[string]string{})
+				}
+				s = rest
+			}
+		}
+
+		var name string
+		name, s = decodedIdentifier(s)
+		if !strings.HasPrefix(s, "[") {
+			break
+		}
+		end := strings.Index(s, "]:")
+		if end < 0 {
+			break
+		}
+		s = s[end+2:]
+
+		var value string
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			value, s = b.String(), s[min(i+1, len(s)):]
+		} else {
+			value, s, _ = strings.Cut(s, " ")
+		}
+		if quoted || value != "null" {
+			tuples[len(tuples)-1][name] = value
+		}
+		s = strings.TrimPrefix(s, " ")
+	}
+	return tuples
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// defaultProfilesFile is where --profile looks for profiles, in the
+// current directory, e.g. at the root of a repository the team shares
+const defaultProfilesFile = "mudrockdbcompare.yaml"
+
+// loadProfile reads the profile named name from a YAML list of profiles
+// under a top-level "profiles" key. Besides its name, a profile has the
+// database type, source and target of the command line's arguments, and
+// any other option named like on the command line:
+//
+//	profiles:
+//	  - name: staging-vs-prod
+//	    type: mysql
+//	    source: vault:secret/data/staging-db
+//	    target: vault:secret/data/prod-db
+//	    row-diff: true
+//	    timestamp-tolerance: 1s
+//	    report: staging-vs-prod.json
+func loadProfile(filename, name string) (yamlEntry, error) {
+	entries, err := readYAMLList(filename, "profiles")
+	if err != nil {
+		return yamlEntry{}, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Fields["name"] == name {
+			return entry, nil
+		}
+		names = append(names, entry.Fields["name"])
+	}
+	return yamlEntry{}, fmt.Errorf("%s: no profile '%s', it has: %s", filename, name, strings.Join(names, ", "))
+}
+
+// applyProfile sets the options of a profile read from filename that
+// weren't given on the command line, which override it, and returns the
+// arguments: those of the command line if there are any, otherwise the
+// profile's type, source and target. Options that can be repeated take a
+// comma-separated list.
+func applyProfile(filename string, profile yamlEntry, args []string) ([]string, error) {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for _, key := range profile.Keys {
+		value := profile.Fields[key]
+		switch key {
+		case "name", "type", "source", "target":
+			continue
+		case "profile", "profiles":
+			return nil, fmt.Errorf("%s:%d: a profile can't select another one", filename, profile.Lines[key])
+		}
+		f := flag.Lookup(key)
+		if f == nil {
+			return nil, fmt.Errorf("%s:%d: unknown option '%s'", filename, profile.Lines[key], key)
+		}
+		if given[key] {
+			continue
+		}
+		values := []string{value}
+		if _, ok := f.Value.(*stringList); ok {
+			values = strings.Split(value, ",")
+		}
+		for _, value := range values {
+			if err := flag.Set(key, strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", filename, profile.Lines[key], key, err)
+			}
+		}
+	}
+
+	if len(args) > 0 {
+		return args, nil
+	}
+	for _, key := range []string{"type", "source", "target"} {
+		if value, ok := profile.Fields[key]; ok {
+			args = append(args, value)
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const progressBarWidth = 30
+
+// progressBar renders a single, continuously redrawn status line on a
+// terminal. Other events are printed above it by printEvent.
+type progressBar struct {
+	total   int
+	done    int
+	rows    int64
+	current string
+	started time.Time
+	drawn   bool
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// start begins drawing the bar once the number of tables to compare is known
+func (p *progressBar) start(total int) {
+	p.total = total
+	p.started = time.Now()
+	p.render()
+}
+
+// handle consumes comparison events, replacing the plain progress lines
+func (p *progressBar) handle(event Event) {
+	switch event.Type {
+	case EventProgress:
+		return
+	case EventTableStarted:
+		p.current = event.Table
+		p.render()
+		return
+	case EventTableFinished:
+		p.done++
+		p.rows += event.RowsScanned
+		p.current = ""
+		if event.Err == nil {
+			p.render()
+			return
+		}
+	}
+
+	p.clear()
+	printEvent(event)
+	p.render()
+}
+
+// finish leaves the final state of the bar on screen
+func (p *progressBar) finish() {
+	if p.drawn {
+		fmt.Println()
+		p.drawn = false
+	}
+}
+
+func (p *progressBar) clear() {
+	if p.drawn {
+		fmt.Print("\r\033[K")
+		p.drawn = false
+	}
+}
+
+func (p *progressBar) render() {
+	if p.total == 0 {
+		return
+	}
+
+	filled := p.done * progressBarWidth / p.total
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	elapsed := time.Since(p.started)
+	line := fmt.Sprintf("[%s] %d/%d tables", bar, p.done, p.total)
+	if p.done > 0 && elapsed > 0 {
+		rate := float64(p.done) / elapsed.Seconds()
+		remaining := time.Duration(float64(elapsed) / float64(p.done) * float64(p.total-p.done))
+		line += fmt.Sprintf(" | %.1f tables/s | %s rows | ETA %s",
+			rate, formatCount(p.rows), remaining.Round(time.Second))
+	}
+	if p.current != "" {
+		line += " | " + p.current
+	}
+
+	fmt.Print("\r\033[K" + line)
+	p.drawn = true
+}
+
+// formatCount abbreviates large counts, e.g. 1234567 as 1.2M
+func formatCount(n int64) string {
+	switch {
+	case n >= 1_000_000_000:
+		return fmt.Sprintf("%.1fG", float64(n)/1_000_000_000)
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fK", float64(n)/1_000)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// compareResultValues orders two values of a result set, numerically if
+// both are numbers. Numbers within tolerance, relative to the larger one,
+// are equal.
+func compareResultValues(a, b string, tolerance float64) int {
+	if withinTolerance(a, b, tolerance) {
+		return 0
+	}
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(a, b)
+}
+
+func compareResultRows(a, b []string, tolerance float64) int {
+	for i := range a {
+		if c := compareResultValues(a[i], b[i], tolerance); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// resultDiff is a line of the diff of two result sets: a row only in the
+// source, only in the target, or, in ordered results, a row that differs
+// between them
+type resultDiff struct {
+	position       int // row number, in ordered results
+	source, target []string
+}
+
+// diffResults compares two result sets with the same columns. Ordered
+// results are compared row by row, others as sorted multisets.
+func diffResults(source, target [][]string, ordered bool, tolerance float64) []resultDiff {
+	var diffs []resultDiff
+	if ordered {
+		for i := 0; i < max(len(source), len(target)); i++ {
+			switch {
+			case i >= len(target):
+				diffs = append(diffs, resultDiff{position: i + 1, source: source[i]})
+			case i >= len(source):
+				diffs = append(diffs, resultDiff{position: i + 1, target: target[i]})
+			case compareResultRows(source[i], target[i], tolerance) != 0:
+				diffs = append(diffs, resultDiff{position: i + 1, source: source[i], target: target[i]})
+			}
+		}
+		return diffs
+	}
+
+	sortRows := func(rows [][]string) {
+		slices.SortFunc(rows, func(a, b []string) int { return compareResultRows(a, b, 0) })
+	}
+	sortRows(source)
+	sortRows(target)
+	i, j := 0, 0
+	for i < len(source) || j < len(target) {
+		switch {
+		case i < len(source) && j < len(target) && compareResultRows(source[i], target[j], tolerance) == 0:
+			i++
+			j++
+		case j == len(target) || (i < len(source) && compareResultRows(source[i], target[j], 0) < 0):
+			diffs = append(diffs, resultDiff{source: source[i]})
+			i++
+		default:
+			diffs = append(diffs, resultDiff{target: target[j]})
+			j++
+		}
+	}
+	return diffs
+}
+
+func formatResultRow(row []string) string {
+	return "(" + strings.Join(row, ", ") + ")"
+}
+
+func printQueryUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("Usage: mudrockdbcompare query [options] --sql QUERY [db-type] [source-connection-string] [target-connection-string]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare query --sql \"SELECT status, COUNT(*) FROM orders GROUP BY status\" mysql \"user:password@localhost:3306/dbname1\" \"user:password@localhost:3306/dbname2\"")
+		fmt.Println("  mudrockdbcompare query sqlite db1.db db2.db --sql \"SELECT id, total FROM orders WHERE id < 100\" --tolerance 0.001")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+}
+
+// runQuery runs the query subcommand: it runs the same statement on both
+// databases and prints the differences between the result sets. It exits
+// with status 3 when they differ.
+func runQuery(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	query := fs.String("sql", "", "the statement to run on both databases")
+	targetQuery := fs.String("target-sql", "", "the statement to run on the target instead of --sql, when the dialects differ")
+	targetType := fs.String("target-type", "", "database type of the target when it differs from the source")
+	ordered := fs.Bool("ordered", false, "compare the rows in the order returned, for statements with an ORDER BY, instead of sorting them")
+	tolerance := fs.Float64("tolerance", 0, "let numbers differ by this fraction of the larger one, e.g. 0.001")
+	promptPasswords := fs.Bool("prompt-passwords", false, "ask for the source and target passwords instead of reading them from the connection strings or "+sourcePasswordEnv+"/"+targetPasswordEnv)
+	logLevel := fs.String("log-level", "warn", "log verbosity: debug (includes every SQL statement), info or warn")
+	noColor := fs.Bool("no-color", false, "don't color the output, which is colored by default when it's a terminal")
+	fs.Usage = printQueryUsage(fs)
+
+	// Options may also follow the connection strings
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 3 || *query == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *tolerance < 0 {
+		fmt.Fprintln(os.Stderr, "--tolerance can't be negative")
+		os.Exit(2)
+	}
+	if err := setupLogging(*logLevel, "text"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setupColor(*noColor)
+
+	adapter, err := GetAdapter(positional[0])
+	if err != nil {
+		fatal("Invalid database type", err)
+	}
+	targetAdapter := adapter
+	if *targetType != "" {
+		if targetAdapter, err = GetAdapter(*targetType); err != nil {
+			fatal("Invalid target database type", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results [2]checkResult
+	sides := []struct {
+		name, env, config, query string
+		adapter                  DatabaseAdapter
+	}{
+		{"Source", sourcePasswordEnv, positional[1], *query, adapter},
+		{"Target", targetPasswordEnv, positional[2], cmp.Or(*targetQuery, *query), targetAdapter},
+	}
+	for i, side := range sides {
+		config, err := resolveSecret(ctx, side.config)
+		if err != nil {
+			fatal("Failed to resolve "+strings.ToLower(side.name)+" connection string", err)
+		}
+		dsn, err := applyPassword(side.adapter, side.adapter.GetConnectStringFromURL(config), side.name, side.env, *promptPasswords)
+		if err != nil {
+			fatal("Failed to read "+strings.ToLower(side.name)+" password", err)
+		}
+		db, err := side.adapter.Connect(dsn)
+		if err != nil {
+			fatal("Failed to connect to "+strings.ToLower(side.name)+" database", err)
+		}
+		defer db.Close()
+		if results[i], err = runCheckQuery(ctx, db, side.query); err != nil {
+			fatal("Failed to run the query on the "+strings.ToLower(side.name), err)
+		}
+	}
+	source, target := results[0], results[1]
+
+	fmt.Printf("Source: %d rows, target: %d rows\n", len(source.rows), len(target.rows))
+	if len(source.columns) != len(target.columns) {
+		fmt.Println(colored(colorRed, fmt.Sprintf("The results have different columns: source=(%s), target=(%s)",
+			strings.Join(source.columns, ", "), strings.Join(target.columns, ", "))))
+		os.Exit(3)
+	}
+
+	diffs := diffResults(source.rows, target.rows, *ordered, *tolerance)
+	if len(diffs) == 0 {
+		fmt.Println("The results are the same.")
+		return
+	}
+	fmt.Println("  " + formatResultRow(source.columns))
+	var sourceOnly, targetOnly, changed int
+	for _, diff := range diffs {
+		prefix := ""
+		if diff.position > 0 {
+			prefix = fmt.Sprintf("row %d: ", diff.position)
+		}
+		switch {
+		case diff.target == nil:
+			sourceOnly++
+			fmt.Println(colored(colorRed, "- "+prefix+formatResultRow(diff.source)))
+		case diff.source == nil:
+			targetOnly++
+			fmt.Println(colored(colorGreen, "+ "+prefix+formatResultRow(diff.target)))
+		default:
+			changed++
+			fmt.Println(colored(colorYellow, "~ "+prefix+"source="+formatResultRow(diff.source)+", target="+formatResultRow(diff.target)))
+		}
+	}
+	fmt.Printf("The results differ: %d rows only in source, %d only in target", sourceOnly, targetOnly)
+	if *ordered {
+		fmt.Printf(", %d changed", changed)
+	}
+	fmt.Println()
+	os.Exit(3)
+}
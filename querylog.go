@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// QueryLogEntry is a statement as --query-log records it, one JSON object
+// per line. Side names the database it ran on, e.g. "source", "target 2"
+// or "eu-1 target" for a shard. Seconds lasts until its rows were read,
+// Rows is the number read, or affected by a statement changing data.
+type QueryLogEntry struct {
+	Time    time.Time
+	Side    string `json:",omitempty"`
+	SQL     string
+	Args    []string `json:",omitempty"`
+	Seconds float64
+	Rows    int64
+	Error   string `json:",omitempty"`
+}
+
+// queryLog, while a file is open, records every statement executed through
+// the pools of openDB
+var queryLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	encoder *json.Encoder
+	err     error // first write error, returned by closeQueryLog
+}
+
+// openQueryLog starts recording statements to the end of a file, which is
+// created if it doesn't exist, so the runs of an audit trail add up
+func openQueryLog(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	queryLog.f, queryLog.encoder, queryLog.err = f, json.NewEncoder(f), nil
+	return nil
+}
+
+// closeQueryLog stops recording statements
+func closeQueryLog() error {
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	if queryLog.f == nil {
+		return nil
+	}
+	err := queryLog.f.Close()
+	if queryLog.err != nil {
+		err = queryLog.err
+	}
+	queryLog.f, queryLog.encoder = nil, nil
+	return err
+}
+
+// nameSession names the database of a pool in the query log. A pool
+// reading both databases is named after both.
+func nameSession(db *sql.DB, name string) {
+	s, err := poolSession(db)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.name != "" && s.name != name {
+		name = s.name + " and " + name
+	}
+	s.name = name
+}
+
+// logQuery records a statement of a pool's session that ran from start,
+// unless it wasn't run because the driver prepares it instead
+func logQuery(s *session, start time.Time, query string, args []driver.NamedValue, rows int64, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	if queryLog.f == nil || queryLog.err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	entry := QueryLogEntry{Time: start, Side: s.name, SQL: query, Seconds: time.Since(start).Seconds(), Rows: rows}
+	s.mu.Unlock()
+	for _, arg := range args {
+		entry.Args = append(entry.Args, explainValue(arg.Value))
+	}
+	if err != nil && err != io.EOF {
+		entry.Error = err.Error()
+	}
+	queryLog.err = queryLog.encoder.Encode(entry)
+}
+
+// rowsAffected returns the number of rows a statement changed, 0 if the
+// driver doesn't tell
+func rowsAffected(result driver.Result) int64 {
+	if result == nil {
+		return 0
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
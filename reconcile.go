@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Reconciliation collects the statements that make the target match the
+// source: the DDL creating, altering and dropping tables and indexes, see
+// reconcileSchema, and the INSERT, UPDATE and DELETE statements of the rows
+// found by the row diff. Statements are written in the target's dialect.
+type Reconciliation struct {
+	adapter DatabaseAdapter
+	schema  []ReconcileStep // DDL, run before the rows' statements
+	tables  map[string]*tableReconciliation
+}
+
+type tableReconciliation struct {
+	references []string // tables referenced by foreign keys
+	deletes    []string
+	updates    []string
+	inserts    []string
+}
+
+// ReconcileStep is the statements for one table, in the order they must run
+type ReconcileStep struct {
+	Table       string
+	Statements  []string
+	Destructive bool // the statements drop or delete data
+	Inserts     int  // the last Inserts statements insert rows, which can collide with the rows the table's deletes remove
+	Schema      bool // the statements are DDL
+}
+
+func newReconciliation(targetAdapter DatabaseAdapter) *Reconciliation {
+	return &Reconciliation{adapter: targetAdapter, tables: make(map[string]*tableReconciliation)}
+}
+
+// table starts over the statements for a table and returns the row handler
+// that collects them. A nil Reconciliation returns a nil handler.
+func (r *Reconciliation) table(schema TableSchema) rowHandler {
+	if r == nil {
+		return nil
+	}
+
+	t := &tableReconciliation{references: foreignKeyReferences(schema)}
+	r.tables[schema.Name] = t
+
+	return func(diff RowDifference, columns []string, source, target []interface{}) {
+		switch diff.Kind {
+		case RowDeleted:
+			t.inserts = append(t.inserts, r.insertStatement(schema.Name, columns, source))
+		case RowInserted:
+			t.deletes = append(t.deletes, r.deleteStatement(schema, columns, target))
+		case RowChanged:
+			t.updates = append(t.updates, r.updateStatement(schema, columns, diff.Columns, source))
+		}
+	}
+}
+
+// drop forgets the statements for a table whose row diff didn't complete
+func (r *Reconciliation) drop(tableName string) {
+	if r != nil {
+		delete(r.tables, tableName)
+	}
+}
+
+func (r *Reconciliation) insertStatement(table string, columns []string, values []interface{}) string {
+	names := make([]string, len(columns))
+	literals := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = r.adapter.QuoteIdentifier(col)
+		literals[i] = r.adapter.QuoteLiteral(values[i])
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
+		r.adapter.QuoteTable(table), strings.Join(names, ", "), strings.Join(literals, ", "))
+}
+
+func (r *Reconciliation) updateStatement(schema TableSchema, columns, differing []string, values []interface{}) string {
+	assignments := make([]string, 0, len(differing))
+	for i, col := range columns {
+		if contains(differing, col) {
+			assignments = append(assignments, fmt.Sprintf("%s = %s", r.adapter.QuoteIdentifier(col), r.adapter.QuoteLiteral(values[i])))
+		}
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+		r.adapter.QuoteTable(schema.Name), strings.Join(assignments, ", "), r.keyCondition(schema, columns, values))
+}
+
+func (r *Reconciliation) deleteStatement(schema TableSchema, columns []string, values []interface{}) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s;", r.adapter.QuoteTable(schema.Name), r.keyCondition(schema, columns, values))
+}
+
+func (r *Reconciliation) keyCondition(schema TableSchema, columns []string, values []interface{}) string {
+	conditions := make([]string, 0, len(schema.PrimaryKeys))
+	for _, pk := range schema.PrimaryKeys {
+		for i, col := range columns {
+			if col == pk {
+				conditions = append(conditions, fmt.Sprintf("%s = %s", r.adapter.QuoteIdentifier(col), r.adapter.QuoteLiteral(values[i])))
+			}
+		}
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// reconcileSchema collects the DDL that makes the target's tables match the
+// source's: creating the missing tables with their indexes and adding the
+// missing columns and indexes, then, as destructive steps, dropping the
+// extra indexes, columns and tables. Columns whose definitions differ
+// aren't altered, and the rows of the tables created, or the values of the
+// columns added, are only filled in by comparing again after applying.
+func (r *Reconciliation) reconcileSchema(summary *ComparisonSummary) {
+	quote := r.adapter.QuoteIdentifier
+
+	missing := make(map[string][]string, len(summary.MissingTables))
+	for _, table := range summary.MissingTables {
+		missing[table] = foreignKeyReferences(summary.SourceSchemas[table])
+	}
+	for _, table := range dependencyOrder(missing) {
+		schema := summary.SourceSchemas[table]
+		statements := []string{strings.Join(tableDDL(schema, r.adapter, r.adapter.QuoteTable, false, false), "\n")}
+		for _, idx := range createdIndexes(schema) {
+			statements = append(statements, createIndexStatement(idx, r.adapter.QuoteTable(table), quote))
+		}
+		r.schema = append(r.schema, ReconcileStep{Table: table, Statements: statements, Schema: true})
+	}
+
+	var drops []ReconcileStep
+	for _, table := range summary.CommonTables {
+		source, target := summary.SourceSchemas[table], summary.TargetSchemas[table]
+		quotedTable := r.adapter.QuoteTable(table)
+		var adds, removes []string
+		for _, col := range source.Columns {
+			if !hasColumn(target, col.Name) {
+				adds = append(adds, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", quotedTable, columnDDL(col, r.adapter, false)))
+			}
+		}
+		sourceIndexes, targetIndexes := createdIndexes(source), createdIndexes(target)
+		for _, idx := range sourceIndexes {
+			if !hasIndex(targetIndexes, idx.name) {
+				adds = append(adds, createIndexStatement(idx, quotedTable, quote))
+			}
+		}
+		for _, idx := range targetIndexes {
+			if !hasIndex(sourceIndexes, idx.name) {
+				removes = append(removes, r.dropIndexStatement(table, idx.name))
+			}
+		}
+		for _, col := range target.Columns {
+			if !hasColumn(source, col.Name) {
+				removes = append(removes, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", quotedTable, quote(col.Name)))
+			}
+		}
+		if len(adds) > 0 {
+			r.schema = append(r.schema, ReconcileStep{Table: table, Statements: adds, Schema: true})
+		}
+		if len(removes) > 0 {
+			drops = append(drops, ReconcileStep{Table: table, Statements: removes, Destructive: true, Schema: true})
+		}
+	}
+
+	extra := make(map[string][]string, len(summary.ExtraTables))
+	for _, table := range summary.ExtraTables {
+		extra[table] = foreignKeyReferences(summary.TargetSchemas[table])
+	}
+	order := dependencyOrder(extra)
+	for i := len(order) - 1; i >= 0; i-- {
+		drops = append(drops, ReconcileStep{Table: order[i], Statements: []string{"DROP TABLE " + r.adapter.QuoteTable(order[i]) + ";"}, Destructive: true, Schema: true})
+	}
+	r.schema = append(r.schema, drops...)
+}
+
+// dropIndexStatement drops an index of a table: MySQL's DROP INDEX names the
+// table, the others' the index, in the table's schema
+func (r *Reconciliation) dropIndexStatement(table, index string) string {
+	quotedTable := r.adapter.QuoteTable(table)
+	if _, ok := r.adapter.(*MySQLAdapter); ok {
+		return fmt.Sprintf("DROP INDEX %s ON %s;", r.adapter.QuoteIdentifier(index), quotedTable)
+	}
+	for _, name := range []string{table, table[strings.LastIndex(table, ".")+1:]} {
+		if schema, ok := strings.CutSuffix(quotedTable, r.adapter.QuoteIdentifier(name)); ok {
+			return fmt.Sprintf("DROP INDEX %s%s;", schema, r.adapter.QuoteIdentifier(index))
+		}
+	}
+	return fmt.Sprintf("DROP INDEX %s;", r.adapter.QuoteIdentifier(index))
+}
+
+// createdIndexes returns the indexes of a table that CREATE INDEX creates,
+// leaving out the primary key's and SQLite's automatic ones, which CREATE
+// TABLE creates
+func createdIndexes(schema TableSchema) []tableIndex {
+	var indexes []tableIndex
+	for _, idx := range groupIndexes(schema) {
+		primary := idx.unique && len(idx.columns) == len(schema.PrimaryKeys)
+		for _, col := range idx.columns {
+			primary = primary && contains(schema.PrimaryKeys, col)
+		}
+		if !primary && !strings.HasPrefix(idx.name, "sqlite_autoindex_") {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+func hasColumn(schema TableSchema, name string) bool {
+	for _, col := range schema.Columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIndex(indexes []tableIndex, name string) bool {
+	for _, idx := range indexes {
+		if idx.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignKeyReferences returns the other tables a table's foreign keys
+// reference
+func foreignKeyReferences(schema TableSchema) []string {
+	var references []string
+	for _, fk := range schema.ForeignKeys {
+		if fk.ReferencedTable != schema.Name && !contains(references, fk.ReferencedTable) {
+			references = append(references, fk.ReferencedTable)
+		}
+	}
+	return references
+}
+
+// Steps returns the DDL, then the rows' statements grouped by table in
+// foreign key order: deletes from referencing tables before the tables they
+// reference, then updates and inserts of referenced tables before the
+// tables referencing them
+func (r *Reconciliation) Steps() []ReconcileStep {
+	if r == nil {
+		return nil
+	}
+
+	steps := append([]ReconcileStep{}, r.schema...)
+	references := make(map[string][]string, len(r.tables))
+	for name, t := range r.tables {
+		references[name] = t.references
+	}
+	order := dependencyOrder(references)
+	for i := len(order) - 1; i >= 0; i-- {
+		if deletes := r.tables[order[i]].deletes; len(deletes) > 0 {
+			steps = append(steps, ReconcileStep{Table: order[i], Statements: deletes, Destructive: true})
+		}
+	}
+	for _, table := range order {
+		t := r.tables[table]
+		if statements := append(slices.Clip(t.updates), t.inserts...); len(statements) > 0 {
+			steps = append(steps, ReconcileStep{Table: table, Statements: statements, Inserts: len(t.inserts)})
+		}
+	}
+	return steps
+}
+
+// dependencyOrder sorts tables, given with the tables they reference, so
+// that referenced tables come before the tables referencing them. Tables in
+// a reference cycle keep name order.
+func dependencyOrder(references map[string][]string) []string {
+	names := make([]string, 0, len(references))
+	for name := range references {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	order := make([]string, 0, len(names))
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, ref := range references[name] {
+			if _, ok := references[ref]; ok {
+				visit(ref)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+// WriteScript writes the statements as a SQL script run in one transaction
+func (r *Reconciliation) WriteScript(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Makes the target's tables and data match the source, generated by %s\n", versionString())
+	b.WriteString("BEGIN;\n")
+	for _, step := range r.Steps() {
+		fmt.Fprintf(&b, "\n-- %s\n", step.Table)
+		for _, stmt := range step.Statements {
+			b.WriteString(stmt)
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\nCOMMIT;\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// writeReport writes a run as JSON, in the format of the server's result
+// files, so either can be given to report diff
+func writeReport(path string, run Run) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readReport(path string) (Run, error) {
+	var run Run
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return run, err
+	}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return run, fmt.Errorf("%s: %w", path, err)
+	}
+	if run.Summary == nil {
+		return run, fmt.Errorf("%s: not a comparison report, or the run has no results", path)
+	}
+	return run, nil
+}
+
+// reportDiff is how the differences of two reports relate
+type reportDiff struct {
+	New        []Difference    // only in the new report
+	Fixed      []Difference    // only in the old report
+	Changed    [][2]Difference // the same object differing in another way, old and new
+	Persisting []Difference    // in both reports
+}
+
+// differenceKey identifies the object and property a difference is about,
+// regardless of the values, e.g. a table's row count
+func differenceKey(d Difference) string {
+	return d.Table + "\x00" + d.ObjectType + "\x00" + d.ObjectName + "\x00" + d.Kind + "\x00" + d.Property
+}
+
+func diffReports(before, after ComparisonSummary) reportDiff {
+	var result reportDiff
+
+	// Identical differences persist
+	remaining := make(map[string]int)
+	for _, d := range before.Differences() {
+		remaining[d.Message]++
+	}
+	var added []Difference
+	for _, d := range after.Differences() {
+		if remaining[d.Message] > 0 {
+			remaining[d.Message]--
+			result.Persisting = append(result.Persisting, d)
+		} else {
+			added = append(added, d)
+		}
+	}
+	oldByKey := make(map[string][]Difference)
+	var oldKeys []string
+	for _, d := range before.Differences() {
+		if remaining[d.Message] == 0 {
+			continue
+		}
+		remaining[d.Message]--
+		key := differenceKey(d)
+		if oldByKey[key] == nil {
+			oldKeys = append(oldKeys, key)
+		}
+		oldByKey[key] = append(oldByKey[key], d)
+	}
+
+	// Others about the same object changed, the rest are new or fixed
+	for _, d := range added {
+		key := differenceKey(d)
+		if previous := oldByKey[key]; len(previous) > 0 {
+			result.Changed = append(result.Changed, [2]Difference{previous[0], d})
+			oldByKey[key] = previous[1:]
+		} else {
+			result.New = append(result.New, d)
+		}
+	}
+	for _, key := range oldKeys {
+		result.Fixed = append(result.Fixed, oldByKey[key]...)
+	}
+	return result
+}
+
+// runReport runs the report subcommand
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	showPersisting := fs.Bool("persisting", false, "also list the differences found in both reports, not only their number")
+	noColor := fs.Bool("no-color", false, "don't color the output, which is colored by default when it's a terminal")
+	fs.Usage = func() {
+		fmt.Println("Usage: mudrockdbcompare report diff [options] OLD.json NEW.json")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare report diff results/nightly-20250301T000000Z.json results/nightly-20250302T000000Z.json")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "diff" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	setupColor(*noColor)
+
+	before, err := readReport(fs.Arg(0))
+	if err != nil {
+		fatal("Failed to read the old report", err)
+	}
+	after, err := readReport(fs.Arg(1))
+	if err != nil {
+		fatal("Failed to read the new report", err)
+	}
+	for i, run := range []Run{before, after} {
+		if run.Status != RunFinished {
+			slog.Warn("The run didn't finish, its differences may be incomplete", "report", fs.Arg(i), "status", run.Status)
+		}
+	}
+	if before.Version != after.Version {
+		slog.Warn("The reports were produced by different versions", "old", cmp.Or(before.Version, "unknown"), "new", cmp.Or(after.Version, "unknown"))
+	}
+
+	result := diffReports(*before.Summary, *after.Summary)
+
+	fmt.Printf("\n=== New differences (%d) ===\n", len(result.New))
+	for _, d := range result.New {
+		fmt.Println(colored(colorGreen, "+ "+d.String()))
+	}
+	fmt.Printf("\n=== Changed differences (%d) ===\n", len(result.Changed))
+	for _, pair := range result.Changed {
+		fmt.Println(colored(colorYellow, "~ "+pair[1].String()))
+		fmt.Printf("  was: %s\n", pair[0])
+	}
+	fmt.Printf("\n=== Fixed differences (%d) ===\n", len(result.Fixed))
+	for _, d := range result.Fixed {
+		fmt.Println(colored(colorRed, "- "+d.String()))
+	}
+	fmt.Printf("\n=== Persisting differences (%d) ===\n", len(result.Persisting))
+	if *showPersisting {
+		for _, d := range result.Persisting {
+			fmt.Printf("  %s\n", d)
+		}
+	}
+
+	if len(result.New)+len(result.Changed) > 0 {
+		os.Exit(3)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportFileName replaces the characters that aren't safe in file names
+var reportFileName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// writeReportDir writes a Markdown file per table with differences to dir,
+// so each table's owners get only their own, and an index.md linking them
+// with what produced the run
+func writeReportDir(dir string, run Run) error {
+	summary := *run.Summary
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	byTable := make(map[string][]Difference)
+	var others []Difference
+	for _, diff := range summary.Differences() {
+		if diff.Table == "" {
+			others = append(others, diff)
+		} else {
+			byTable[diff.Table] = append(byTable[diff.Table], diff)
+		}
+	}
+	for table := range summary.SkippedTables {
+		if byTable[table] == nil {
+			byTable[table] = []Difference{}
+		}
+	}
+	tables := make([]string, 0, len(byTable))
+	for table := range byTable {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	header := fmt.Sprintf("Source: %s  \nTarget: %s  \nCompared: %s to %s  \nBy: %s\n",
+		databaseDescription(summary.SourceInfo), databaseDescription(summary.TargetInfo),
+		run.Started.Format(time.RFC3339), run.Finished.Format(time.RFC3339), run.Version)
+
+	var index strings.Builder
+	index.WriteString("# Comparison report\n\n" + header)
+	if summary.Interrupted {
+		index.WriteString("\nThe comparison was interrupted, not all tables were compared.\n")
+	}
+	fmt.Fprintf(&index, "\n## Tables (%d)\n\n", len(tables))
+	if len(tables) == 0 {
+		index.WriteString("No table differs.\n")
+	}
+
+	files := make(map[string]bool)
+	for _, table := range tables {
+		// Names differing only in unsafe characters get a suffix
+		name := reportFileName.ReplaceAllString(table, "_")
+		for i := 2; files[strings.ToLower(name)] || strings.EqualFold(name, "index"); i++ {
+			name = fmt.Sprintf("%s_%d", reportFileName.ReplaceAllString(table, "_"), i)
+		}
+		files[strings.ToLower(name)] = true
+
+		content := tableReport(table, byTable[table], summary, header)
+		if err := os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0o644); err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("%d differences", len(byTable[table]))
+		if reason, skipped := summary.SkippedTables[table]; skipped {
+			line = "skipped (" + reason + ")"
+		} else if len(byTable[table]) == 1 {
+			line = byTable[table][0].Message
+		}
+		if size, ok := summary.SourceInfo.table(table); ok {
+			line += " (" + formatSize(size.DataSize+size.IndexSize) + ")"
+		} else if size, ok := summary.TargetInfo.table(table); ok {
+			line += " (" + formatSize(size.DataSize+size.IndexSize) + ")"
+		}
+		fmt.Fprintf(&index, "- [%s](%s.md): %s\n", table, name, line)
+	}
+
+	if len(others) > 0 {
+		fmt.Fprintf(&index, "\n## Other database objects (%d)\n\n", len(others))
+		for _, diff := range others {
+			fmt.Fprintf(&index, "- %s\n", diff.Message)
+		}
+	}
+
+	if run.Options != nil {
+		options, err := json.MarshalIndent(run.Options, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&index, "\n## Options\n\n```json\n%s\n```\n", options)
+	}
+	if len(run.Flags) > 0 {
+		flags, err := json.MarshalIndent(run.Flags, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&index, "\n## Flags\n\n```json\n%s\n```\n", flags)
+	}
+	return os.WriteFile(filepath.Join(dir, "index.md"), []byte(index.String()), 0o644)
+}
+
+// tableReport is the Markdown report of one table
+func tableReport(table string, diffs []Difference, summary ComparisonSummary, header string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Table %s\n\n%s", table, header)
+
+	if reason, skipped := summary.SkippedTables[table]; skipped {
+		fmt.Fprintf(&b, "\nThe table was skipped (%s), its data wasn't compared.\n", reason)
+		if errs := summary.errorsOf(table); len(errs) > 0 {
+			b.WriteString("\n## Errors\n\n")
+			for _, tableError := range errs {
+				fmt.Fprintf(&b, "- %s\n\n  ```\n  %s\n  ```\n", tableError, strings.ReplaceAll(tableError.Cause(), "\n", "\n  "))
+			}
+		}
+	}
+
+	sourceSize, inSource := summary.SourceInfo.table(table)
+	targetSize, inTarget := summary.TargetInfo.table(table)
+	if inSource || inTarget {
+		b.WriteString("\n## Size\n\n")
+		if inSource {
+			fmt.Fprintf(&b, "- Source: %s\n", sourceSize)
+		}
+		if inTarget {
+			fmt.Fprintf(&b, "- Target: %s\n", targetSize)
+		}
+	}
+
+	var schema []Difference
+	for _, diff := range diffs {
+		if diff.ObjectType != "data" {
+			schema = append(schema, diff)
+		}
+	}
+	if len(schema) > 0 {
+		b.WriteString("\n## Schema differences\n\n")
+		for _, diff := range schema {
+			fmt.Fprintf(&b, "- %s\n", diff.Message)
+		}
+	}
+
+	if counts, ok := summary.DifferentRowCounts[table]; ok {
+		b.WriteString("\n## Row counts\n\n| Source | Target | Delta |\n| ---: | ---: | ---: |\n")
+		fmt.Fprintf(&b, "| %d | %d | %+d |\n", counts.Source, counts.Target, counts.Target-counts.Source)
+	}
+
+	if result, ok := summary.ChunkDifferences[table]; ok {
+		fmt.Fprintf(&b, "\n## Differing chunks\n\n%d of %d chunks differ:\n\n", len(result.DifferentChunks), result.TotalChunks)
+		for _, chunk := range result.DifferentChunks {
+			fmt.Fprintf(&b, "- chunk %d %s\n", chunk.Index, result.rangeString(chunk))
+		}
+	}
+
+	if diffs := summary.StatDifferences[table]; len(diffs) > 0 {
+		b.WriteString("\n## Column stats\n\n| Column | Stat | Source | Target |\n| --- | --- | ---: | ---: |\n")
+		for _, diff := range diffs {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", markdownCell(diff.ObjectName), diff.Property, markdownCell(diff.Source), markdownCell(diff.Target))
+		}
+	}
+
+	if result, ok := summary.RowDifferences[table]; ok {
+		fmt.Fprintf(&b, "\n## Differing rows\n\n%d inserted, %d deleted, %d changed", result.Inserted, result.Deleted, result.Changed)
+		if total := result.Inserted + result.Deleted + result.Changed; total > len(result.Rows) {
+			fmt.Fprintf(&b, ", a sample of %d:", len(result.Rows))
+		}
+		b.WriteString("\n\n| Row | Primary key | Column | Source | Target |\n| --- | --- | --- | --- | --- |\n")
+		for _, row := range result.Rows {
+			key := markdownCell(result.keyString(row))
+			if len(row.Columns) == 0 || len(row.Source) != len(row.Columns) {
+				// Changed columns without values, or a whole row
+				fmt.Fprintf(&b, "| %s | %s | %s | | |\n", row.Kind, key, markdownCell(strings.Join(row.Columns, ", ")))
+				continue
+			}
+			kind := row.Kind
+			for i, col := range row.Columns {
+				fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", kind, key, markdownCell(col), markdownCell(row.Source[i]), markdownCell(row.Target[i]))
+				kind, key = "", ""
+			}
+		}
+	}
+	return b.String()
+}
+
+// markdownCell escapes a value for a Markdown table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run statuses
+const (
+	RunRunning  = "running"
+	RunFinished = "finished"
+	RunFailed   = "failed"
+)
+
+// Run is one comparison run by the server
+type Run struct {
+	ID       string
+	Job      string
+	Status   string
+	Started  time.Time
+	Finished time.Time
+	Error    string             `json:",omitempty"`
+	Summary  *ComparisonSummary `json:",omitempty"`
+
+	// What produced the run: the binary's versionString, the options
+	// compared with and the flags given, on the command line or by a
+	// profile, connection strings without their password
+	Version string            `json:",omitempty"`
+	Options *CompareOptions   `json:",omitempty"`
+	Flags   map[string]string `json:",omitempty"`
+}
+
+// resultStore keeps runs as one JSON file each in a directory, and in
+// memory for lookups
+type resultStore struct {
+	dir string
+
+	mu   sync.Mutex
+	runs map[string]Run
+}
+
+// openResultStore creates dir if needed and loads the runs saved in it.
+// Runs that were still running when the server stopped are marked failed.
+func openResultStore(dir string) (*resultStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &resultStore{dir: dir, runs: make(map[string]Run)}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var run Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if run.Status == RunRunning {
+			run.Status = RunFailed
+			run.Error = "the server stopped during the run"
+		}
+		s.runs[run.ID] = run
+	}
+	return s, nil
+}
+
+// newRun returns a run of job starting now, with an ID unique in the store
+func (s *resultStore) newRun(job string) Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	started := time.Now().UTC()
+	id := job + "-" + started.Format("20060102T150405Z")
+	for i := 2; ; i++ {
+		if _, exists := s.runs[id]; !exists {
+			break
+		}
+		id = fmt.Sprintf("%s-%s-%d", job, started.Format("20060102T150405Z"), i)
+	}
+	run := Run{ID: id, Job: job, Status: RunRunning, Started: started, Version: versionString()}
+	s.runs[id] = run
+	return run
+}
+
+// save writes a run to its file, replacing it atomically
+func (s *resultStore) save(run Run) error {
+	s.mu.Lock()
+	s.runs[run.ID] = run
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, run.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *resultStore) get(id string) (Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	return run, ok
+}
+
+// list returns the runs of job, or of all jobs when it's empty, newest first
+func (s *resultStore) list(job string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []Run{}
+	for _, run := range s.runs {
+		if job == "" || run.Job == job {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].Started.Equal(runs[j].Started) {
+			return runs[i].Started.After(runs[j].Started)
+		}
+		return strings.Compare(runs[i].ID, runs[j].ID) > 0
+	})
+	return runs
+}
+
+// prune deletes the runs of each job beyond the newest keepRuns and those
+// started more than keepDays ago, either limit being off when 0. Running
+// runs are kept. It returns the number of runs deleted.
+func (s *resultStore) prune(keepRuns, keepDays int) (int, error) {
+	var cutoff time.Time
+	if keepDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -keepDays)
+	}
+
+	kept := make(map[string]int) // job -> runs kept
+	pruned := 0
+	for _, run := range s.list("") {
+		if run.Status == RunRunning {
+			continue
+		}
+		if (keepRuns == 0 || kept[run.Job] < keepRuns) && (cutoff.IsZero() || !run.Started.Before(cutoff)) {
+			kept[run.Job]++
+			continue
+		}
+
+		err := os.Remove(filepath.Join(s.dir, run.ID+".json"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return pruned, err
+		}
+		s.mu.Lock()
+		delete(s.runs, run.ID)
+		s.mu.Unlock()
+		pruned++
+	}
+	return pruned, nil
+}
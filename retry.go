@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how operations failing with a transient error, such
+// as a deadlock or a dropped connection, are retried
+type RetryPolicy struct {
+	Attempts   int           // retries after the first try, 0 disables retrying
+	Backoff    time.Duration // delay before the first retry, doubled after each one
+	MaxBackoff time.Duration
+}
+
+// isTransientNetworkError reports connection problems that any driver can run into
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	// Other network errors, like a host that doesn't resolve or a
+	// certificate that doesn't verify, won't go away by retrying
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (c *Comparison) isTransientError(err error) bool {
+	return c.Adapter.IsTransientError(err) || c.targetAdapter().IsTransientError(err)
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// transient, or runs out of attempts
+func (c *Comparison) retry(ctx context.Context, operation string, fn func() error) error {
+	policy := c.Options.Retry
+	delay := policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > policy.Attempts || ctx.Err() != nil || !c.isTransientError(err) {
+			return err
+		}
+
+		c.emit(Event{Type: EventWarning, Err: err,
+			Message: fmt.Sprintf("Transient error during %s, retrying in %s (attempt %d of %d)",
+				operation, delay, attempt, policy.Attempts)})
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxRowDifferences caps how many differing rows are kept per table.
+// Counts in RowDiffResult always cover every row.
+const maxRowDifferences = 100
+
+// maxValueLength caps the length of the values kept of changed rows
+const maxValueLength = 200
+
+// defaultDigestThreshold is the size in bytes over which the row diff reads
+// values of large columns as digests
+const defaultDigestThreshold = 65536
+
+// rowCursor holds the current row of a result set while merge-joining.
+// With nextPage set, the result set is read in pages of pageSize rows with
+// keyset pagination: when a full page ends, nextPage returns the rows after
+// the key of its last row, converted by keyTypes. No query then runs longer
+// than a page takes.
+type rowCursor struct {
+	rows   *sql.Rows
+	values []interface{}
+
+	nextPage   func(after []interface{}) (*sql.Rows, error)
+	pageSize   int
+	keyIndexes []int
+	keyTypes   []string
+	read       int // rows read from the current page
+
+	// The step and database of the errors of reading, when the cursor
+	// reads one side of a comparison
+	phase, side string
+}
+
+func (c *rowCursor) next() (ok bool, err error) {
+	if c.side != "" {
+		defer func() { err = inPhase(c.phase, c.side, err) }()
+	}
+	for !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			return false, err
+		}
+		if c.nextPage == nil || c.read < c.pageSize {
+			return false, nil
+		}
+		after := make([]interface{}, len(c.keyIndexes))
+		for i, index := range c.keyIndexes {
+			after[i] = keyValue(c.values[index], c.keyTypes[i])
+		}
+		c.rows.Close()
+		rows, err := c.nextPage(after)
+		if err != nil {
+			return false, err
+		}
+		c.rows, c.read = rows, 0
+	}
+	c.read++
+
+	dest := make([]interface{}, len(c.values))
+	for i := range c.values {
+		dest[i] = &c.values[i]
+	}
+	if err := c.rows.Scan(dest...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// close closes the current page's result set
+func (c *rowCursor) close() error {
+	return c.rows.Close()
+}
+
+// rowHandler is called for every differing row with the compared columns and
+// the row's values on each side, nil for the side the row is missing from.
+// The value slices are reused once it returns.
+type rowHandler func(diff RowDifference, columns []string, source, target []interface{})
+
+// rowHandlers combines the non-nil handlers into one, nil if there are none
+func rowHandlers(handlers ...rowHandler) rowHandler {
+	var set []rowHandler
+	for _, h := range handlers {
+		if h != nil {
+			set = append(set, h)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	return func(diff RowDifference, columns []string, source, target []interface{}) {
+		for _, h := range set {
+			h(diff, columns, source, target)
+		}
+	}
+}
+
+// rowComparisonColumns returns the columns present on both sides, after checking
+// that the table can be compared row by row
+func rowComparisonColumns(sourceSchema, targetSchema TableSchema) ([]string, error) {
+	if len(sourceSchema.PrimaryKeys) == 0 {
+		return nil, fmt.Errorf("table '%s' has no primary key", sourceSchema.Name)
+	}
+	if !compareStringSlices(sourceSchema.PrimaryKeys, targetSchema.PrimaryKeys) {
+		return nil, fmt.Errorf("table '%s' has different primary keys in source and target", sourceSchema.Name)
+	}
+
+	columns := commonColumns(sourceSchema, targetSchema)
+	for _, pk := range sourceSchema.PrimaryKeys {
+		if !contains(columns, pk) {
+			return nil, fmt.Errorf("primary key column '%s.%s' not found in both databases", sourceSchema.Name, pk)
+		}
+	}
+
+	return columns, nil
+}
+
+// commonColumns returns the columns present on both sides, in source order.
+// Only those are compared, schema differences are reported separately.
+func commonColumns(sourceSchema, targetSchema TableSchema) []string {
+	targetColumns := make(map[string]bool)
+	for _, col := range targetSchema.Columns {
+		targetColumns[col.Name] = true
+	}
+
+	columns := []string{}
+	for _, col := range sourceSchema.Columns {
+		if targetColumns[col.Name] {
+			columns = append(columns, col.Name)
+		}
+	}
+	return columns
+}
+
+// compareTableRows streams both tables ordered by primary key and merge-joins
+// them, reporting rows that were inserted, deleted or changed in the target.
+// When chunks are given only rows inside those key ranges are compared.
+// onRow, when set, is called for every differing row.
+// Rows are read options.PageSize at a time, or all at once if it's zero, and
+// up to options.Parallel chunks are compared at the same time. The chunks
+// compared are recorded in progress, the ones it already has aren't
+// compared again.
+func compareTableRows(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, sourceSchema, targetSchema TableSchema, chunks []Chunk, options CompareOptions, onRow rowHandler, progress *tableProgress) (RowDiffResult, error) {
+	result := RowDiffResult{Table: sourceSchema.Name, PrimaryKey: sourceSchema.PrimaryKeys}
+
+	columns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		return result, err
+	}
+
+	keyIndexes := make([]int, len(sourceSchema.PrimaryKeys))
+	for i, pk := range sourceSchema.PrimaryKeys {
+		keyIndexes[i] = indexOf(columns, pk)
+	}
+	values := valueColumns(columns, sourceSchema, targetSchema, options)
+
+	if len(chunks) == 0 {
+		chunks = []Chunk{{}}
+	}
+
+	// Chunks compared at the same time take turns calling onRow
+	if onRow != nil && options.Parallel > 1 {
+		var mu sync.Mutex
+		handler := onRow
+		onRow = func(diff RowDifference, columns []string, source, target []interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			handler(diff, columns, source, target)
+		}
+	}
+
+	result.add(progress.comparedRows())
+	var pending []Chunk
+	var positions []int
+	for i, chunk := range chunks {
+		if !progress.rangeCompared(i) {
+			pending = append(pending, chunk)
+			positions = append(positions, i)
+		}
+	}
+
+	results := make([]RowDiffResult, len(pending))
+	err = forEachChunk(ctx, pending, options.Parallel, func(ctx context.Context, i int, chunk Chunk) error {
+		err := compareChunkRows(ctx, sourceAdapter, targetAdapter, sourceDB, targetDB, sourceSchema, columns, keyIndexes, values, chunk, options.PageSize, &results[i], onRow)
+		if err == nil {
+			progress.finishRange(positions[i], results[i])
+		}
+		return err
+	})
+	for _, chunkResult := range results {
+		result.add(chunkResult)
+	}
+	return result, err
+}
+
+func compareChunkRows(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, schema TableSchema, columns []string, keyIndexes []int, values []columnValues, chunk Chunk, pageSize int, result *RowDiffResult, onRow rowHandler) error {
+	keyTypes := make([]string, len(schema.PrimaryKeys))
+	for i, pk := range schema.PrimaryKeys {
+		for _, col := range schema.Columns {
+			if col.Name == pk {
+				keyTypes[i] = col.DataType
+			}
+		}
+	}
+	cursor := func(adapter DatabaseAdapter, db *sql.DB, side string) (*rowCursor, error) {
+		rows, err := adapter.StreamRows(ctx, db, schema.Name, columns, schema.PrimaryKeys, chunk, pageSize)
+		if err != nil {
+			return nil, inPhase("rows", side, err)
+		}
+		c := &rowCursor{rows: rows, values: make([]interface{}, len(columns)), phase: "rows", side: side}
+		if pageSize > 0 {
+			c.pageSize, c.keyIndexes, c.keyTypes = pageSize, keyIndexes, keyTypes
+			c.nextPage = func(after []interface{}) (*sql.Rows, error) {
+				if err := pauseAfterChunk(ctx); err != nil {
+					return nil, err
+				}
+				return adapter.StreamRows(ctx, db, schema.Name, columns, schema.PrimaryKeys, Chunk{Lower: after, Upper: chunk.Upper}, pageSize)
+			}
+		}
+		return c, nil
+	}
+
+	source, err := cursor(sourceAdapter, sourceDB, "source")
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	target, err := cursor(targetAdapter, targetDB, "target")
+	if err != nil {
+		return err
+	}
+	defer target.close()
+
+	return mergeRows(source, target, columns, keyIndexes, values, result, onRow)
+}
+
+// compareSampleRows merge-joins the rows in the same sample of percent of
+// both tables, chosen by the adapters' SampleRows
+func compareSampleRows(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, sourceSchema, targetSchema TableSchema, percent float64, options CompareOptions, onRow rowHandler) (RowDiffResult, error) {
+	result := RowDiffResult{Table: sourceSchema.Name, PrimaryKey: sourceSchema.PrimaryKeys, SamplePercent: percent}
+
+	columns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		return result, err
+	}
+	keyIndexes := make([]int, len(sourceSchema.PrimaryKeys))
+	for i, pk := range sourceSchema.PrimaryKeys {
+		keyIndexes[i] = indexOf(columns, pk)
+	}
+
+	sourceRows, err := sourceAdapter.SampleRows(ctx, sourceDB, sourceSchema.Name, columns, sourceSchema.PrimaryKeys, percent)
+	if err != nil {
+		return result, inPhase("sample", "source", err)
+	}
+	defer sourceRows.Close()
+
+	targetRows, err := targetAdapter.SampleRows(ctx, targetDB, targetSchema.Name, columns, sourceSchema.PrimaryKeys, percent)
+	if err != nil {
+		return result, inPhase("sample", "target", err)
+	}
+	defer targetRows.Close()
+
+	err = mergeRows(&rowCursor{rows: sourceRows, values: make([]interface{}, len(columns)), phase: "sample", side: "source"},
+		&rowCursor{rows: targetRows, values: make([]interface{}, len(columns)), phase: "sample", side: "target"}, columns, keyIndexes,
+		valueColumns(columns, sourceSchema, targetSchema, options), &result, onRow)
+	return result, err
+}
+
+// mergeRows merge-joins two result sets ordered by primary key, comparing
+// the values of rows on both sides as values says
+func mergeRows(source, target *rowCursor, columns []string, keyIndexes []int, values []columnValues, result *RowDiffResult, onRow rowHandler) error {
+	// A side whose keys go backwards orders them differently than they're
+	// compared in, the merge would report its rows as deleted and inserted
+	last := make([]interface{}, len(columns))
+	next := func(cursor *rowCursor) (bool, error) {
+		copy(last, cursor.values)
+		ok, err := cursor.next()
+		if ok && compareKeys(cursor.values, last, keyIndexes, values) < 0 {
+			err = fmt.Errorf("keys out of order: (%s) after (%s), the database doesn't order the key by its bytes",
+				strings.Join(formatKey(cursor.values, keyIndexes), ", "), strings.Join(formatKey(last, keyIndexes), ", "))
+			if cursor.side != "" {
+				err = inPhase(cursor.phase, cursor.side, err)
+			}
+		}
+		return ok, err
+	}
+
+	sourceOK, err := source.next()
+	if err != nil {
+		return err
+	}
+	targetOK, err := target.next()
+	if err != nil {
+		return err
+	}
+
+	for sourceOK || targetOK {
+		result.Compared++
+		cmp := 0
+		switch {
+		case !targetOK:
+			cmp = -1
+		case !sourceOK:
+			cmp = 1
+		default:
+			cmp = compareKeys(source.values, target.values, keyIndexes, values)
+		}
+
+		switch {
+		case cmp < 0:
+			diff := RowDifference{Kind: RowDeleted, PrimaryKey: formatKey(source.values, keyIndexes)}
+			result.Deleted++
+			result.addRow(diff)
+			if onRow != nil {
+				onRow(diff, columns, source.values, nil)
+			}
+			sourceOK, err = next(source)
+		case cmp > 0:
+			diff := RowDifference{Kind: RowInserted, PrimaryKey: formatKey(target.values, keyIndexes)}
+			result.Inserted++
+			result.addRow(diff)
+			if onRow != nil {
+				onRow(diff, columns, nil, target.values)
+			}
+			targetOK, err = next(target)
+		default:
+			differing := []string{}
+			var sourceValues, targetValues []string
+			for i, col := range columns {
+				if !values[i].equal(source.values[i], target.values[i]) {
+					differing = append(differing, col)
+					sourceValues = append(sourceValues, truncate(values[i].format(source.values[i]), maxValueLength))
+					targetValues = append(targetValues, truncate(values[i].format(target.values[i]), maxValueLength))
+				}
+			}
+			if len(differing) > 0 {
+				diff := RowDifference{Kind: RowChanged, PrimaryKey: formatKey(source.values, keyIndexes), Columns: differing,
+					Source: sourceValues, Target: targetValues}
+				result.Changed++
+				result.addRow(diff)
+				if onRow != nil {
+					onRow(diff, columns, source.values, target.values)
+				}
+			}
+
+			sourceOK, err = next(source)
+			if err == nil {
+				targetOK, err = next(target)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RowDiffResult) addRow(diff RowDifference) {
+	if len(r.Rows) < maxRowDifferences {
+		r.Rows = append(r.Rows, diff)
+	}
+}
+
+// add adds the rows compared in another key range of the table
+func (r *RowDiffResult) add(other RowDiffResult) {
+	r.Inserted += other.Inserted
+	r.Deleted += other.Deleted
+	r.Changed += other.Changed
+	r.Compared += other.Compared
+	for _, diff := range other.Rows {
+		r.addRow(diff)
+	}
+}
+
+// HasDifferences reports whether any row differs
+func (r RowDiffResult) HasDifferences() bool {
+	return r.Inserted+r.Deleted+r.Changed > 0
+}
+
+// mismatchRate is the share of the compared rows that differ, for samples
+// the estimated share of the table's rows
+func (r RowDiffResult) mismatchRate() float64 {
+	if r.Compared == 0 {
+		return 0
+	}
+	return float64(r.Inserted+r.Deleted+r.Changed) / float64(r.Compared)
+}
+
+// keyString renders the primary key of a differing row as "col=value, col=value"
+func (r RowDiffResult) keyString(diff RowDifference) string {
+	parts := make([]string, len(diff.PrimaryKey))
+	for i, value := range diff.PrimaryKey {
+		parts[i] = fmt.Sprintf("%s=%s", r.PrimaryKey[i], value)
+	}
+	key := strings.Join(parts, ", ")
+	if diff.Copies > 1 {
+		key += fmt.Sprintf(" (%d copies)", diff.Copies)
+	}
+	return key
+}
+
+// sideBySide renders the differing values of a changed row as aligned
+// column, source and target columns, with a header line. Values are cut at
+// width runes and put on one line. Results read from before values were
+// kept have none.
+func (diff RowDifference) sideBySide(width int) []string {
+	if len(diff.Columns) == 0 || len(diff.Source) != len(diff.Columns) || len(diff.Target) != len(diff.Columns) {
+		return nil
+	}
+	oneLine := strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", " ")
+	rows := [][3]string{{"column", "source", "target"}}
+	for i, col := range diff.Columns {
+		rows = append(rows, [3]string{col,
+			truncate(oneLine.Replace(diff.Source[i]), width), truncate(oneLine.Replace(diff.Target[i]), width)})
+	}
+	var widths [2]int
+	for _, row := range rows {
+		widths[0] = max(widths[0], utf8.RuneCountInString(row[0]))
+		widths[1] = max(widths[1], utf8.RuneCountInString(row[1]))
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = pad(row[0], widths[0]) + "  " + pad(row[1], widths[1]) + "  " + row[2]
+	}
+	return lines
+}
+
+// pad right-pads s with spaces to width runes
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-utf8.RuneCountInString(s)))
+}
+
+func formatKey(values []interface{}, keyIndexes []int) []string {
+	key := make([]string, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		key[i] = formatValue(values[idx])
+	}
+	return key
+}
+
+// compareKeys orders two rows by their keys the way the database does, see
+// columnValues.key
+func compareKeys(a, b []interface{}, keyIndexes []int, values []columnValues) int {
+	for _, idx := range keyIndexes {
+		if cmp := compareKeyValues(values[idx].key(a[idx]), values[idx].key(b[idx]), values[idx].numeric); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareKeyValues orders two primary key values the way the databases sort them
+// for the common key types: numerically for the values of numeric columns, by
+// time for timestamps and bytewise for everything else, so that text of
+// digits sorts as text does. NULLs sort first. The adapters order text keys
+// by their bytes for it, see configureKeyOrders.
+func compareKeyValues(a, b interface{}, numeric bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+
+	sa, sb := formatValue(a), formatValue(b)
+
+	if numeric {
+		// Drivers return integers as int64 or as text depending on the protocol
+		if ia, err := strconv.ParseInt(sa, 10, 64); err == nil {
+			if ib, err := strconv.ParseInt(sb, 10, 64); err == nil {
+				return cmp.Compare(ia, ib)
+			}
+		}
+		if ra, ok := parseDecimal(a); ok {
+			if rb, ok := parseDecimal(b); ok {
+				return ra.Cmp(rb)
+			}
+		}
+	}
+
+	return bytes.Compare([]byte(sa), []byte(sb))
+}
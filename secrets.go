@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Connection strings with these prefixes name a secret holding the real one
+const (
+	vaultPrefix             = "vault:"
+	awsSecretsManagerPrefix = "aws-sm:"
+	defaultSecretField      = "dsn"
+)
+
+// resolveSecret returns the connection string stored in the secret named by
+// a vault:path or aws-sm:secret-id connection string, and any other
+// connection string unchanged. A #field suffix selects the field of the
+// secret holding the connection string, "dsn" by default.
+func resolveSecret(ctx context.Context, connStr string) (string, error) {
+	switch {
+	case strings.HasPrefix(connStr, vaultPrefix):
+		path, field := splitSecretField(strings.TrimPrefix(connStr, vaultPrefix))
+		return readVaultSecret(ctx, path, field)
+	case strings.HasPrefix(connStr, awsSecretsManagerPrefix):
+		secretID, field := splitSecretField(strings.TrimPrefix(connStr, awsSecretsManagerPrefix))
+		return readAWSSecret(ctx, secretID, field)
+	default:
+		return connStr, nil
+	}
+}
+
+func splitSecretField(name string) (string, string) {
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, defaultSecretField
+}
+
+// readVaultSecret reads a field of a KV secret (version 1 or 2) through
+// the Vault HTTP API, using VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE
+func readVaultSecret(ctx context.Context, path, field string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set to read vault: secrets")
+	}
+
+	url := strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", resp.Status, path)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+
+	// KV version 2 nests the secret's fields in data.data
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	return value, nil
+}
+
+// readAWSSecret reads a secret from AWS Secrets Manager. It goes through
+// the aws CLI so every way of providing credentials (profiles, SSO,
+// instance roles) works. A secret that isn't a JSON object is used as is.
+func readAWSSecret(ctx context.Context, secretID, field string) (string, error) {
+	cmd := exec.CommandContext(ctx, "aws", "secretsmanager", "get-secret-value",
+		"--secret-id", secretID, "--query", "SecretString", "--output", "text")
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("aws secretsmanager get-secret-value failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(out))
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(secret), &fields) != nil {
+		return secret, nil
+	}
+
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("aws secret %s has no field %q", secretID, field)
+	}
+	return value, nil
+}
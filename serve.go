@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveJob is a named comparison the server runs on a schedule
+type serveJob struct {
+	Name       string
+	Schedule   string
+	Type       string
+	TargetType string
+	Source     string
+	Target     string
+	Options    CompareOptions
+	Notify     []string // webhook URLs
+	NotifyOn   string
+	Window     string // when the data may be compared, if restricted
+
+	schedule *cronSchedule
+	window   *timeWindow
+}
+
+// jobName restricts job names to what's safe in file names and URLs
+var jobName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// loadJobs reads the server's config file, a YAML list of jobs under a
+// top-level "jobs" key:
+//
+//	jobs:
+//	  - name: prod-vs-dr
+//	    schedule: "*/30 * * * *"
+//	    type: mysql
+//	    source: vault:secret/data/prod-db
+//	    target: vault:secret/data/dr-db
+//	    row-diff: true
+func loadJobs(filename string) ([]*serveJob, error) {
+	entries, err := readYAMLList(filename, "jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*serveJob{}
+	names := make(map[string]bool)
+	for i, entry := range entries {
+		job := &serveJob{NotifyOn: NotifyAlways, Options: CompareOptions{ApproxTolerance: 5, Parallel: 1, DigestThreshold: defaultDigestThreshold, Retry: RetryPolicy{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second}}}
+		for _, key := range entry.Keys {
+			if err := job.set(key, entry.Fields[key]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, entry.Lines[key], err)
+			}
+		}
+
+		switch {
+		case job.Name == "":
+			return nil, fmt.Errorf("%s: job %d has no name", filename, i+1)
+		case !jobName.MatchString(job.Name):
+			return nil, fmt.Errorf("%s: job name '%s' may only contain letters, digits, '.', '_' and '-'", filename, job.Name)
+		case names[job.Name]:
+			return nil, fmt.Errorf("%s: duplicate job '%s'", filename, job.Name)
+		case job.schedule == nil:
+			return nil, fmt.Errorf("%s: job '%s' has no schedule", filename, job.Name)
+		case job.Type == "" || job.Source == "" || job.Target == "":
+			return nil, fmt.Errorf("%s: job '%s' needs a type, source and target", filename, job.Name)
+		case job.Options.Reconcile:
+			return nil, fmt.Errorf("%s: job '%s': reconciliation isn't supported by the server", filename, job.Name)
+		}
+		if err := job.Options.validate(); err != nil {
+			return nil, fmt.Errorf("%s: job '%s': %w", filename, job.Name, err)
+		}
+		names[job.Name] = true
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// set sets a job's field from the config file, named like the command line option
+func (j *serveJob) set(key, value string) error {
+	var err error
+	switch key {
+	case "name":
+		j.Name = value
+	case "schedule":
+		j.Schedule = value
+		j.schedule, err = parseCron(value)
+	case "type":
+		j.Type = value
+		_, err = GetAdapter(value)
+	case "window":
+		j.Window = value
+		j.window, err = parseTimeWindow(value)
+	case "target-type":
+		j.TargetType = value
+		_, err = GetAdapter(value)
+	case "source":
+		j.Source = value
+	case "target":
+		j.Target = value
+	case "row-diff":
+		j.Options.RowDiff, err = strconv.ParseBool(value)
+	case "chunk-size":
+		j.Options.ChunkSize, err = strconv.Atoi(value)
+	case "page-size":
+		j.Options.PageSize, err = strconv.Atoi(value)
+	case "parallel":
+		j.Options.Parallel, err = strconv.Atoi(value)
+	case "timestamp-tolerance":
+		j.Options.TimestampTolerance, err = time.ParseDuration(value)
+	case "decimal-scale":
+		j.Options.DecimalScale, err = strconv.Atoi(value)
+	case "geometry-tolerance":
+		j.Options.GeometryTolerance, err = strconv.ParseFloat(value, 64)
+	case "digest-threshold":
+		j.Options.DigestThreshold, err = strconv.Atoi(value)
+	case "string-compare":
+		j.Options.StringCompare = value
+		if value != StringCompareBinary && value != StringCompareCollation {
+			err = fmt.Errorf("must be binary or collation")
+		}
+	case "unicode-normalize":
+		j.Options.UnicodeNormalize = value
+		if _, ok := unicodeForms[value]; !ok && value != "" {
+			err = fmt.Errorf("must be nfc or nfkc")
+		}
+	case "trim-trailing-whitespace":
+		j.Options.TrimTrailingWhitespace, err = strconv.ParseBool(value)
+	case "ignore-char-padding":
+		j.Options.IgnoreCharPadding, err = strconv.ParseBool(value)
+	case "wait-for-replica":
+		j.Options.WaitForReplica, err = time.ParseDuration(value)
+	case "consistent":
+		j.Options.Consistent, err = strconv.ParseBool(value)
+	case "consistent-lock":
+		j.Options.ConsistentLock, err = strconv.ParseBool(value)
+	case "table-sizes":
+		j.Options.TableSizes, err = strconv.ParseBool(value)
+	case "strict-column-order":
+		j.Options.StrictColumnOrder, err = strconv.ParseBool(value)
+	case "ignore-collation":
+		j.Options.IgnoreCollation, err = strconv.ParseBool(value)
+	case "sequence-values":
+		j.Options.SequenceValues, err = strconv.ParseBool(value)
+	case "sequence-tolerance":
+		j.Options.SequenceTolerance, err = strconv.ParseInt(value, 10, 64)
+	case "compare-privileges":
+		j.Options.ComparePrivileges, err = strconv.ParseBool(value)
+	case "ignore-grantee-host":
+		j.Options.IgnoreGranteeHost, err = strconv.ParseBool(value)
+	case "query-timeout":
+		j.Options.QueryTimeout, err = time.ParseDuration(value)
+	case "table-timeout":
+		j.Options.TableTimeout, err = time.ParseDuration(value)
+	case "approx-counts":
+		j.Options.ApproxCounts, err = strconv.ParseBool(value)
+	case "approx-tolerance":
+		j.Options.ApproxTolerance, err = strconv.ParseFloat(value, 64)
+	case "max-qps":
+		j.Options.MaxQPS, err = strconv.ParseFloat(value, 64)
+	case "sleep-between-chunks":
+		j.Options.SleepBetweenChunks, err = time.ParseDuration(value)
+	case "max-active-sessions":
+		j.Options.MaxActiveSessions, err = strconv.Atoi(value)
+	case "max-replica-lag":
+		j.Options.MaxReplicaLag, err = time.ParseDuration(value)
+	case "max-memory":
+		j.Options.MaxMemory, err = parseSize(value)
+	case "retries":
+		j.Options.Retry.Attempts, err = strconv.Atoi(value)
+	case "suppress":
+		j.Options.Suppressions, err = loadSuppressions(value)
+	case "soft-delete-column":
+		j.Options.SoftDeleteColumns = nil
+		for _, column := range strings.Split(value, ",") {
+			j.Options.SoftDeleteColumns = append(j.Options.SoftDeleteColumns, strings.TrimSpace(column))
+		}
+	case "type-equivalences":
+		j.Options.TypeEquivalences, err = loadTypeEquivalences(value)
+	case "checks":
+		j.Options.Checks, err = loadChecks(value)
+	case "notify":
+		j.Notify = nil
+		for _, webhook := range strings.Split(value, ",") {
+			webhook = strings.TrimSpace(webhook)
+			if u, parseErr := url.Parse(webhook); parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("invalid notify: '%s' is not an http or https URL", webhook)
+			}
+			j.Notify = append(j.Notify, webhook)
+		}
+	case "notify-on":
+		if value != NotifyAlways && value != NotifyDifferences {
+			return fmt.Errorf("invalid notify-on '%s': must be %s or %s", value, NotifyAlways, NotifyDifferences)
+		}
+		j.NotifyOn = value
+	default:
+		return fmt.Errorf("unknown key '%s'", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
+
+// compare runs the job's comparison once
+func (j *serveJob) compare(ctx context.Context) (ComparisonSummary, error) {
+	adapter, err := GetAdapter(j.Type)
+	if err != nil {
+		return ComparisonSummary{}, err
+	}
+	targetAdapter := adapter
+	if j.TargetType != "" && j.TargetType != j.Type {
+		if targetAdapter, err = GetAdapter(j.TargetType); err != nil {
+			return ComparisonSummary{}, err
+		}
+	}
+
+	sourceDB, sourceConnStr, err := connectJobDatabase(ctx, adapter, j.Source)
+	if err != nil {
+		return ComparisonSummary{}, fmt.Errorf("failed to connect to source database: %w", err)
+	}
+	defer sourceDB.Close()
+	targetDB, targetConnStr, err := connectJobDatabase(ctx, targetAdapter, j.Target)
+	if err != nil {
+		return ComparisonSummary{}, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+	defer targetDB.Close()
+
+	comparison := &Comparison{
+		Adapter:       adapter,
+		SourceDB:      sourceDB,
+		TargetDB:      targetDB,
+		SourceConnStr: sourceConnStr,
+		TargetConnStr: targetConnStr,
+		Options:       j.Options,
+		OnEvent:       j.logEvent,
+	}
+	if targetAdapter != adapter {
+		comparison.TargetAdapter = targetAdapter
+	}
+
+	return comparison.compareInWindow(ctx, j.window, slog.With("job", j.Name))
+}
+
+func connectJobDatabase(ctx context.Context, adapter DatabaseAdapter, config string) (*sql.DB, string, error) {
+	config, err := resolveSecret(ctx, config)
+	if err != nil {
+		return nil, "", err
+	}
+	connStr := adapter.GetConnectStringFromURL(config)
+	db, err := connectReader(adapter, connStr)
+	return db, connStr, err
+}
+
+func (j *serveJob) logEvent(event Event) {
+	switch event.Type {
+	case EventWarning:
+		slog.Warn(event.Message, "job", j.Name, "error", event.Err)
+	case EventPhase:
+		slog.Debug(event.Message, "job", j.Name)
+	case EventTableFinished:
+		if event.Err != nil {
+			slog.Warn("Table not compared", "job", j.Name, "table", event.Table, "error", event.Err)
+		}
+	}
+}
+
+// server runs the jobs on their schedules and keeps their results
+type server struct {
+	jobs    []*serveJob
+	store   *resultStore
+	baseURL string // the dashboard's URL, for links in notifications
+	history string // file to record the runs in, if set
+	token   string // required of HTTP requests, if set
+
+	// The Host headers HTTP requests may have, any when empty
+	hosts []string
+
+	// Retention of the results and history, 0 for no limit
+	keepRuns, keepDays int
+
+	mu      sync.Mutex
+	running map[string]bool // job names
+	wg      sync.WaitGroup
+}
+
+// start runs a job in the background unless it's still running. It
+// returns the new run.
+func (s *server) start(ctx context.Context, job *serveJob) (Run, bool) {
+	s.mu.Lock()
+	if s.running[job.Name] {
+		s.mu.Unlock()
+		return Run{}, false
+	}
+	s.running[job.Name] = true
+	s.mu.Unlock()
+
+	run := s.store.newRun(job.Name)
+	run.Options = &job.Options
+	if err := s.store.save(run); err != nil {
+		slog.Error("Failed to save the run", "job", job.Name, "error", err)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, job.Name)
+			s.mu.Unlock()
+		}()
+
+		slog.Info("Comparison started", "job", job.Name, "run", run.ID)
+		summary, err := job.compare(ctx)
+		run.Finished = time.Now().UTC()
+		switch {
+		case err != nil:
+			run.Status = RunFailed
+			run.Error = err.Error()
+			slog.Error("Comparison failed", "job", job.Name, "run", run.ID, "error", err)
+		case summary.Interrupted:
+			run.Status = RunFailed
+			run.Error = "interrupted"
+			run.Summary = &summary
+		default:
+			run.Status = RunFinished
+			run.Summary = &summary
+			slog.Info("Comparison finished", "job", job.Name, "run", run.ID,
+				"schema_differences", summary.HasSchemaDifferences(), "data_differences", summary.HasDataDifferences())
+		}
+		if err := s.store.save(run); err != nil {
+			slog.Error("Failed to save the run", "job", job.Name, "run", run.ID, "error", err)
+		}
+		if s.history != "" {
+			recordHistory(s.history, run)
+		}
+		s.notify(ctx, job, run)
+	}()
+	return run, true
+}
+
+// prune deletes the runs in the results and history beyond the retention,
+// only those of jobs in the history, which the command line records in too
+func (s *server) prune() {
+	if s.keepRuns == 0 && s.keepDays == 0 {
+		return
+	}
+	pruned, err := s.store.prune(s.keepRuns, s.keepDays)
+	if err != nil {
+		slog.Warn("Failed to prune the results", "error", err)
+	} else if pruned > 0 {
+		slog.Info("Pruned the results", "runs", pruned)
+	}
+
+	if s.history == "" {
+		return
+	}
+	h, err := openHistory(s.history)
+	if err != nil {
+		slog.Warn("Failed to open the history", "file", s.history, "error", err)
+		return
+	}
+	defer h.Close()
+	historyPruned, err := h.prune(s.keepRuns, s.keepDays, true)
+	if err != nil {
+		slog.Warn("Failed to prune the history", "file", s.history, "error", err)
+	} else if historyPruned > 0 {
+		slog.Info("Pruned the history", "runs", historyPruned)
+	}
+}
+
+// notify posts a finished run to the job's webhooks
+func (s *server) notify(ctx context.Context, job *serveJob, run Run) {
+	n := newNotification(run, s.baseURL)
+	if job.NotifyOn == NotifyDifferences && !n.differs() {
+		return
+	}
+	// Still report runs cancelled by the server stopping
+	ctx = context.WithoutCancel(ctx)
+	for _, webhook := range job.Notify {
+		if err := sendNotification(ctx, webhook, n); err != nil {
+			slog.Warn("Failed to send notification", "job", job.Name, "run", run.ID, "error", err)
+		}
+	}
+}
+
+// schedule starts the jobs due at the start of every minute until ctx is
+// cancelled
+func (s *server) schedule(ctx context.Context) {
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		// Long-running servers prune daily, not only on startup
+		if next.Hour() == 0 && next.Minute() == 0 {
+			s.prune()
+		}
+
+		for _, job := range s.jobs {
+			if !job.schedule.matches(next) {
+				continue
+			}
+			if _, started := s.start(ctx, job); !started {
+				slog.Warn("Skipping scheduled run, the previous one is still running", "job", job.Name)
+			}
+		}
+	}
+}
+
+// runServe runs the serve subcommand
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	config := fs.String("config", "mudrockdbcompare.yaml", "file describing the comparison jobs")
+	resultsDir := fs.String("results", "results", "directory to store the results of the runs in")
+	historyFile := fs.String("history", defaultHistoryFile(), "file to also record the runs in, empty to not record them")
+	keepRuns := fs.Int("keep-runs", 0, "keep this many of the newest runs of each job in the results and history, deleting older ones (0 keeps all)")
+	keepDays := fs.Int("keep-days", 0, "keep the runs of this many days in the results and history, deleting older ones (0 keeps all)")
+	listen := fs.String("listen", "localhost:8080", "address to serve the HTTP API on, empty to disable it")
+	baseURL := fs.String("url", "", "URL the dashboard is reached at, for links in notifications (default http://ADDRESS of --listen)")
+	logLevel := fs.String("log-level", "info", "log verbosity: debug (includes every SQL statement), info or warn")
+	logFormat := fs.String("log-format", "text", "log format: text or json")
+	fs.Usage = func() {
+		fmt.Println("Usage: mudrockdbcompare serve [options]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare serve --config jobs.yaml --results /var/lib/mudrockdbcompare")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	jobs, err := loadJobs(*config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *keepRuns < 0 || *keepDays < 0 {
+		fmt.Fprintln(os.Stderr, "--keep-runs and --keep-days must not be negative")
+		os.Exit(2)
+	}
+	store, err := openResultStore(*resultsDir)
+	if err != nil {
+		fatal("Failed to open the results directory", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *baseURL == "" && *listen != "" {
+		*baseURL = "http://" + *listen
+	}
+	token := os.Getenv(apiTokenEnv)
+	if *listen != "" && token == "" && !loopbackAddress(*listen) {
+		fmt.Fprintf(os.Stderr, "--listen %s is reachable from other hosts, set %s to require a token of HTTP requests\n", *listen, apiTokenEnv)
+		os.Exit(2)
+	}
+	s := &server{jobs: jobs, store: store, baseURL: *baseURL, history: *historyFile, token: token,
+		keepRuns: *keepRuns, keepDays: *keepDays, running: make(map[string]bool)}
+	s.prune()
+	if *listen != "" {
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			fatal("Failed to listen", err)
+		}
+		s.hosts = allowedHosts(*listen, listener.Addr(), *baseURL)
+		httpServer := &http.Server{Handler: s.routes(ctx)}
+		go func() {
+			if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server failed", "error", err)
+			}
+		}()
+		defer httpServer.Shutdown(context.Background())
+	}
+
+	slog.Info("Server started", "jobs", len(jobs), "results", *resultsDir, "listen", *listen)
+	s.schedule(ctx)
+
+	slog.Info("Stopping, waiting for running comparisons to be cancelled")
+	s.wg.Wait()
+}
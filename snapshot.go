@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// Snapshot is the schema of a database saved to a file, to compare live
+// databases against later
+type Snapshot struct {
+	Type       string // database type, as given on the command line
+	CreatedAt  time.Time
+	Tables     map[string]TableSchema
+	Views      []ViewSchema
+	Routines   []RoutineSchema
+	Sequences  []SequenceSchema
+	Types      []TypeSchema
+	Privileges []PrivilegeSchema `json:",omitempty"`
+}
+
+// takeSnapshot reads the schema of a database
+func takeSnapshot(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, dbType string, privileges bool) (Snapshot, error) {
+	snapshot := Snapshot{Type: dbType, CreatedAt: time.Now().UTC()}
+
+	tables, err := adapter.GetTableList(ctx, db)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to get tables: %w", err)
+	}
+	if snapshot.Tables, err = getAllTableSchemas(ctx, adapter, db, tables); err != nil {
+		return snapshot, fmt.Errorf("failed to get schemas: %w", err)
+	}
+	if snapshot.Views, err = adapter.GetViews(ctx, db); err != nil {
+		return snapshot, fmt.Errorf("failed to get views: %w", err)
+	}
+	if snapshot.Routines, err = adapter.GetRoutines(ctx, db); err != nil {
+		return snapshot, fmt.Errorf("failed to get routines: %w", err)
+	}
+	if snapshot.Sequences, err = adapter.GetSequences(ctx, db); err != nil {
+		return snapshot, fmt.Errorf("failed to get sequences: %w", err)
+	}
+	if snapshot.Types, err = adapter.GetTypes(ctx, db); err != nil {
+		return snapshot, fmt.Errorf("failed to get types: %w", err)
+	}
+	if privileges {
+		if snapshot.Privileges, err = adapter.GetPrivileges(ctx, db); err != nil {
+			return snapshot, fmt.Errorf("failed to get privileges: %w", err)
+		}
+	}
+
+	// Keep the file stable between runs, so it diffs well in git
+	sort.Slice(snapshot.Views, func(i, j int) bool { return snapshot.Views[i].Name < snapshot.Views[j].Name })
+	sort.Slice(snapshot.Routines, func(i, j int) bool { return snapshot.Routines[i].Name < snapshot.Routines[j].Name })
+	sort.Slice(snapshot.Sequences, func(i, j int) bool { return snapshot.Sequences[i].Name < snapshot.Sequences[j].Name })
+	sort.Slice(snapshot.Types, func(i, j int) bool { return snapshot.Types[i].Name < snapshot.Types[j].Name })
+	return snapshot, nil
+}
+
+func writeSnapshot(path string, snapshot Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readSnapshot(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("%s: %w", path, err)
+	}
+	if snapshot.Type == "" {
+		return snapshot, fmt.Errorf("%s: not a snapshot, it has no database type", path)
+	}
+	return snapshot, nil
+}
+
+// SnapshotAdapter presents a saved snapshot as the source of a comparison.
+// It has a schema but no data.
+type SnapshotAdapter struct {
+	SQLiteAdapter
+	Snapshot Snapshot
+}
+
+// Connect returns an empty database, the schema is the snapshot's
+func (a *SnapshotAdapter) Connect(connectionString string) (*sql.DB, error) {
+	return openDB("sqlite", ":memory:")
+}
+
+func (a *SnapshotAdapter) GetTableList(ctx context.Context, db *sql.DB) ([]string, error) {
+	tables := make([]string, 0, len(a.Snapshot.Tables))
+	for name := range a.Snapshot.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+func (a *SnapshotAdapter) GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error) {
+	schema, ok := a.Snapshot.Tables[tableName]
+	if !ok {
+		return TableSchema{Name: tableName}, fmt.Errorf("table '%s' is not in the snapshot", tableName)
+	}
+	return schema, nil
+}
+
+func (a *SnapshotAdapter) GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error) {
+	return a.Snapshot.Views, nil
+}
+
+func (a *SnapshotAdapter) GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error) {
+	return a.Snapshot.Routines, nil
+}
+
+func (a *SnapshotAdapter) GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error) {
+	return a.Snapshot.Sequences, nil
+}
+
+func (a *SnapshotAdapter) GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error) {
+	return a.Snapshot.Types, nil
+}
+
+func (a *SnapshotAdapter) GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error) {
+	return a.Snapshot.Privileges, nil
+}
+
+// connectDatabase connects a subcommand to its one database, exiting on
+// errors. It returns the database and the connection string to report.
+func connectDatabase(ctx context.Context, adapter DatabaseAdapter, config string, promptPassword bool) (*sql.DB, string) {
+	db, connStr, err := openDatabase(ctx, adapter, config, promptPassword)
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+	return db, connStr
+}
+
+// openDatabase is connectDatabase returning its errors
+func openDatabase(ctx context.Context, adapter DatabaseAdapter, config string, promptPassword bool) (*sql.DB, string, error) {
+	config, err := resolveSecret(ctx, config)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve connection string: %w", err)
+	}
+	connStr := adapter.GetConnectStringFromURL(config)
+	dsn, err := applyPassword(adapter, connStr, "Database", targetPasswordEnv, promptPassword)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read password: %w", err)
+	}
+	db, err := adapter.Connect(dsn)
+	return db, connStr, err
+}
+
+func printSnapshotUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("Usage: mudrockdbcompare snapshot save [options] --out FILE [db-type] [connection-string]")
+		fmt.Println("       mudrockdbcompare snapshot verify [options] FILE [connection-string]")
+		fmt.Println("Examples:")
+		fmt.Println("  mudrockdbcompare snapshot save --out schema.json mysql \"user:password@localhost:3306/dbname\"")
+		fmt.Println("  mudrockdbcompare snapshot verify schema.json \"user:password@localhost:3306/dbname\"")
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+}
+
+// runSnapshot runs the snapshot subcommand: save writes the schema of a
+// database to a file, verify compares a database against such a file and
+// exits with status 3 when they differ
+func runSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("out", "", "with save, the file to write the snapshot to")
+	logLevel := fs.String("log-level", "info", "log verbosity: debug (includes every SQL statement), info or warn")
+	logFormat := fs.String("log-format", "text", "log format: text or json")
+	promptPasswords := fs.Bool("prompt-passwords", false, "ask for the password instead of reading it from the connection string or "+targetPasswordEnv)
+	comparePrivileges := fs.Bool("compare-privileges", false, "also save or verify the privileges granted on the database and its tables")
+	ignoreGranteeHost := fs.Bool("ignore-grantee-host", false, "with verify and --compare-privileges, compare MySQL grantees by user name, whichever host they connect from")
+	strictColumnOrder := fs.Bool("strict-column-order", false, "with verify, also report columns at a different position")
+	ignoreCollation := fs.Bool("ignore-collation", false, "with verify, don't report charset and collation differences")
+	sequenceValues := fs.Bool("sequence-values", false, "with verify, also compare the current values of sequences and auto-increment counters")
+	sequenceTolerance := fs.Int64("sequence-tolerance", 0, "with --sequence-values, allow current values to differ by up to this much")
+	suppressFile := fs.String("suppress", "", "with verify, YAML file of accepted differences to leave out of the summary")
+	noColor := fs.Bool("no-color", false, "with verify, don't color the output, which is colored by default when it's a terminal")
+	fs.Usage = printSnapshotUsage(fs)
+
+	if len(args) == 0 || (args[0] != "save" && args[0] != "verify") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 || (command == "save" && *out == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setupColor(*noColor)
+
+	var suppressions []Suppression
+	if *suppressFile != "" {
+		var err error
+		if suppressions, err = loadSuppressions(*suppressFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// save reads a database of the given type, verify one of the
+	// snapshot's type
+	dbType := fs.Arg(0)
+	var snapshot Snapshot
+	if command == "verify" {
+		var err error
+		if snapshot, err = readSnapshot(fs.Arg(0)); err != nil {
+			fatal("Failed to read the snapshot", err)
+		}
+		dbType = snapshot.Type
+	}
+	adapter, err := GetAdapter(dbType)
+	if err != nil {
+		fatal("Invalid database type", err)
+	}
+
+	ctx := context.Background()
+	db, connStr := connectDatabase(ctx, adapter, fs.Arg(1), *promptPasswords)
+	defer db.Close()
+
+	if command == "save" {
+		snapshot, err := takeSnapshot(ctx, adapter, db, dbType, *comparePrivileges)
+		if err != nil {
+			fatal("Failed to read the schema", err)
+		}
+		if err := writeSnapshot(*out, snapshot); err != nil {
+			fatal("Failed to write the snapshot", err)
+		}
+		slog.Info("Saved snapshot", "file", *out, "tables", len(snapshot.Tables))
+		return
+	}
+
+	snapshotAdapter := &SnapshotAdapter{Snapshot: snapshot}
+	snapshotDB, err := snapshotAdapter.Connect(fs.Arg(0))
+	if err != nil {
+		fatal("Failed to open the snapshot", err)
+	}
+	defer snapshotDB.Close()
+
+	comparison := &Comparison{
+		Adapter:       snapshotAdapter,
+		TargetAdapter: adapter,
+		SourceDB:      snapshotDB,
+		TargetDB:      db,
+		SourceConnStr: fs.Arg(0),
+		TargetConnStr: connStr,
+		Options: CompareOptions{
+			StrictColumnOrder: *strictColumnOrder,
+			IgnoreCollation:   *ignoreCollation,
+			SequenceValues:    *sequenceValues,
+			SequenceTolerance: *sequenceTolerance,
+			ComparePrivileges: *comparePrivileges,
+			IgnoreGranteeHost: *ignoreGranteeHost,
+			Suppressions:      suppressions,
+		},
+		OnEvent: printEvent,
+	}
+	summary, err := comparison.Introspect(ctx)
+	if err != nil {
+		fatal("Failed to read schemas", err)
+	}
+	summary.SchemaOnly = true
+
+	fmt.Println("\n=== Database Information ===")
+	fmt.Printf("Source: snapshot %s, taken %s, Tables: %d\n", fs.Arg(0), snapshot.CreatedAt.Format(time.RFC3339), len(snapshot.Tables))
+	fmt.Printf("Target: %s, Database: %s, Tables: %d, Size: %s\n",
+		summary.TargetInfo.Host, summary.TargetInfo.DatabaseName, summary.TargetInfo.TableCount, formatSize(summary.TargetInfo.TotalSize))
+	printSummary(summary)
+
+	if summary.HasSchemaDifferences() {
+		slog.Warn("The database has drifted from the snapshot")
+		os.Exit(3)
+	}
+}
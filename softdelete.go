@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// softDeleteColumn returns the soft delete column of a table among the
+// --soft-delete-column values: one given as TABLE.COLUMN, where TABLE is a
+// glob pattern, before one given for every table
+func softDeleteColumn(columns []string, tableName string) string {
+	var column string
+	for _, value := range columns {
+		dot := strings.LastIndex(value, ".")
+		if dot < 0 {
+			if column == "" {
+				column = value
+			}
+			continue
+		}
+		if ok, _ := path.Match(value[:dot], tableName); ok {
+			return value[dot+1:]
+		}
+	}
+	return column
+}
+
+// softDeleteCondition matches the rows that aren't soft-deleted: those
+// where a flag is false or NULL, or any other column, like a deletion
+// time, is NULL. A false bit is written B'0' for MySQL and PostgreSQL,
+// which can't compare a bit with FALSE, and 0 for SQLite, which also holds
+// the data of dumps.
+func softDeleteCondition(column ColumnSchema, adapter DatabaseAdapter) string {
+	quoted := adapter.QuoteIdentifier(column.Name)
+	t := strings.ToLower(column.DataType)
+	switch {
+	case t == "bit" || t == "bit(1)":
+		falseBit := "0"
+		if engine := adapter.Capabilities().Engine; engine == "mysql" || engine == "postgres" {
+			falseBit = "B'0'"
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s = %s)", quoted, quoted, falseBit)
+	case strings.Contains(t, "bool") || t == "tinyint(1)":
+		return fmt.Sprintf("(%s IS NULL OR %s = FALSE)", quoted, quoted)
+	}
+	return quoted + " IS NULL"
+}
+
+// configureSoftDeletes makes the adapters leave out the soft-deleted rows of
+// the tables that have a soft delete column on both sides
+func (c *Comparison) configureSoftDeletes(summary *ComparisonSummary) {
+	if len(c.Options.SoftDeleteColumns) == 0 {
+		return
+	}
+	for _, tableName := range summary.CommonTables {
+		name := softDeleteColumn(c.Options.SoftDeleteColumns, tableName)
+		if name == "" {
+			continue
+		}
+		var sourceColumn, targetColumn *ColumnSchema
+		for i, col := range summary.SourceSchemas[tableName].Columns {
+			if col.Name == name {
+				sourceColumn = &summary.SourceSchemas[tableName].Columns[i]
+			}
+		}
+		for i, col := range summary.TargetSchemas[tableName].Columns {
+			if col.Name == name {
+				targetColumn = &summary.TargetSchemas[tableName].Columns[i]
+			}
+		}
+
+		switch {
+		case sourceColumn == nil && targetColumn == nil:
+			continue
+		case sourceColumn == nil || targetColumn == nil:
+			c.emit(Event{Type: EventWarning, Table: tableName,
+				Message: fmt.Sprintf("Soft delete column '%s' of table '%s' is only on one side, comparing all rows", name, tableName)})
+			continue
+		}
+		c.Adapter.SetRowFilter(tableName, softDeleteCondition(*sourceColumn, c.Adapter))
+		c.targetAdapter().SetRowFilter(tableName, softDeleteCondition(*targetColumn, c.targetAdapter()))
+	}
+}
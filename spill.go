@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+)
+
+// hashCountSize is roughly what a count of hashCounts takes in memory: the
+// hash, the count and the map's overhead
+const hashCountSize = 64
+
+// mergeFanIn is the most runs merged at once, each an open file
+const mergeFanIn = 64
+
+// hashCounts counts row hashes, dropping those whose count gets back to
+// zero. With a memory limit, the counts are spilled to a temporary file,
+// a run sorted by hash, whenever they would take more, and each merges the
+// runs back. The memory then stays bounded whatever the number of distinct
+// rows, at the cost of writing each count out once and reading it back,
+// and once more each time mergeFanIn runs are merged into one.
+type hashCounts struct {
+	counts     map[rowHash]int
+	maxEntries int      // counts held in memory before spilling, zero for no limit
+	runs       []string // the files of the runs, closed
+}
+
+// newHashCounts returns counts held in at most maxMemory bytes, zero for
+// no limit
+func newHashCounts(maxMemory int64) *hashCounts {
+	h := &hashCounts{counts: make(map[rowHash]int)}
+	if maxMemory > 0 {
+		h.maxEntries = int(max(1, maxMemory/hashCountSize))
+	}
+	return h
+}
+
+func (h *hashCounts) add(hash rowHash, sign int) error {
+	if h.counts[hash] += sign; h.counts[hash] == 0 {
+		delete(h.counts, hash)
+	}
+	if h.maxEntries > 0 && len(h.counts) >= h.maxEntries {
+		return h.spill()
+	}
+	return nil
+}
+
+// sorted returns the hashes in memory in order
+func (h *hashCounts) sorted() []rowHash {
+	hashes := make([]rowHash, 0, len(h.counts))
+	for hash := range h.counts {
+		hashes = append(hashes, hash)
+	}
+	slices.SortFunc(hashes, func(a, b rowHash) int { return bytes.Compare(a[:], b[:]) })
+	return hashes
+}
+
+// spill writes the counts in memory to a new run and empties them. Once
+// there are mergeFanIn runs, they're merged into one, so that merging never
+// opens more files than that.
+func (h *hashCounts) spill() error {
+	run, err := writeRun(func(write func(rowHash, int)) error {
+		for _, hash := range h.sorted() {
+			write(hash, h.counts[hash])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.runs = append(h.runs, run)
+	clear(h.counts)
+	if len(h.runs) < mergeFanIn {
+		return nil
+	}
+
+	merged, err := writeRun(func(write func(rowHash, int)) error {
+		return mergeRuns(h.runs, write)
+	})
+	if err != nil {
+		return err
+	}
+	removeRuns(h.runs)
+	h.runs = []string{merged}
+	return nil
+}
+
+// each calls fn with every hash whose count isn't zero, in hash order
+func (h *hashCounts) each(fn func(hash rowHash, count int)) error {
+	if len(h.runs) == 0 {
+		for _, hash := range h.sorted() {
+			fn(hash, h.counts[hash])
+		}
+		return nil
+	}
+
+	if len(h.counts) > 0 {
+		if err := h.spill(); err != nil {
+			return err
+		}
+	}
+	return mergeRuns(h.runs, fn)
+}
+
+// close removes the runs
+func (h *hashCounts) close() {
+	removeRuns(h.runs)
+	h.runs = nil
+}
+
+// writeRun writes the counts fill writes, in hash order, to a new run and
+// returns its file. A run is a sequence of hashes, each followed by its
+// count as a varint.
+func writeRun(fill func(write func(hash rowHash, count int)) error) (string, error) {
+	f, err := os.CreateTemp("", "mudrockdbcompare-*.run")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	var count []byte
+	err = fill(func(hash rowHash, n int) {
+		w.Write(hash[:])
+		count = binary.AppendVarint(count[:0], int64(n))
+		w.Write(count)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// mergeRuns calls fn with every hash of the runs whose counts don't add up
+// to zero, in hash order
+func mergeRuns(names []string, fn func(hash rowHash, count int)) error {
+	runs := make(runHeap, 0, len(names))
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := &runReader{r: bufio.NewReader(f)}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			runs = append(runs, r)
+		}
+	}
+	heap.Init(&runs)
+
+	// The counts of a hash in several runs add up
+	for len(runs) > 0 {
+		hash, count := runs[0].hash, 0
+		for len(runs) > 0 && runs[0].hash == hash {
+			count += runs[0].count
+			ok, err := runs[0].next()
+			if err != nil {
+				return err
+			}
+			if ok {
+				heap.Fix(&runs, 0)
+			} else {
+				heap.Pop(&runs)
+			}
+		}
+		if count != 0 {
+			fn(hash, count)
+		}
+	}
+	return nil
+}
+
+// removeRuns deletes the files of runs
+func removeRuns(names []string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// runReader reads a run's counts in order
+type runReader struct {
+	r     *bufio.Reader
+	hash  rowHash
+	count int
+}
+
+func (r *runReader) next() (bool, error) {
+	if _, err := io.ReadFull(r.r, r.hash[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	count, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return false, err
+	}
+	r.count = int(count)
+	return true, nil
+}
+
+// runHeap orders runs by their current hash, for merging them
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return bytes.Compare(h[i].hash[:], h[j].hash[:]) < 0 }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -1,18 +1,78 @@
 package main
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 // SQLiteAdapter implements DatabaseAdapter for SQLite
-type SQLiteAdapter struct{}
+type SQLiteAdapter struct {
+	filters     rowFilters
+	expressions columnExpressions
+	keyOrders   columnExpressions // set by SetBinaryKeyOrder
+}
+
+// SQLite has no MD5, so SampleRows hashes keys with this function, nor
+// SHA-256 for ValueDigest
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("mudrockdbcompare_sample_bucket", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			sum := md5.Sum([]byte(formatValue(args[0])))
+			return int64(binary.BigEndian.Uint32(sum[:4]) % sampleBuckets), nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("mudrockdbcompare_digest", 2,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			var value []byte
+			switch v := args[0].(type) {
+			case string:
+				value = []byte(v)
+			case []byte:
+				value = v
+			default:
+				return args[0], nil
+			}
+			if threshold, _ := args[1].(int64); int64(len(value)) <= threshold {
+				return args[0], nil
+			}
+			return fmt.Sprintf("<%d bytes, sha256 %x>", len(value), sha256.Sum256(value)), nil
+		})
+}
 
 func (a *SQLiteAdapter) Connect(connectionString string) (*sql.DB, error) {
-	return sql.Open("sqlite", connectionString)
+	return openDB("sqlite", connectionString)
+}
+
+// sqliteFileExists checks that the file of a SQLite connection string
+// exists, opening a missing one would create it. Other adapters' connection
+// strings aren't checked.
+func sqliteFileExists(adapter DatabaseAdapter, connectionString string) error {
+	if _, ok := adapter.(*SQLiteAdapter); !ok {
+		return nil
+	}
+	path, _, _ := strings.Cut(strings.TrimPrefix(connectionString, "file:"), "?")
+	_, err := os.Stat(path)
+	return err
+}
+
+// SetPassword returns the connection string unchanged, SQLite databases
+// have no password
+func (a *SQLiteAdapter) SetPassword(connectionString, password string) (string, error) {
+	return connectionString, nil
 }
 
 func (a *SQLiteAdapter) GetConnectStringFromURL(url string) string {
@@ -22,8 +82,8 @@ func (a *SQLiteAdapter) GetConnectStringFromURL(url string) string {
 	return url
 }
 
-func (a *SQLiteAdapter) GetTableList(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
+func (a *SQLiteAdapter) GetTableList(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
 	if err != nil {
 		return nil, err
 	}
@@ -41,11 +101,11 @@ func (a *SQLiteAdapter) GetTableList(db *sql.DB) ([]string, error) {
 	return tables, nil
 }
 
-func (a *SQLiteAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchema, error) {
+func (a *SQLiteAdapter) GetTableSchema(ctx context.Context, db *sql.DB, tableName string) (TableSchema, error) {
 	tableSchema := TableSchema{Name: tableName}
 
 	// Get columns and schema
-	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	rows, err := db.QueryContext(ctx, "PRAGMA table_info("+a.QuoteIdentifier(tableName)+")")
 	if err != nil {
 		return tableSchema, err
 	}
@@ -81,8 +141,18 @@ func (a *SQLiteAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchem
 		tableSchema.Columns = append(tableSchema.Columns, col)
 	}
 
+	// A single INTEGER PRIMARY KEY column is an alias of the rowid, which
+	// SQLite assigns automatically
+	if len(tableSchema.PrimaryKeys) == 1 {
+		for i, col := range tableSchema.Columns {
+			if col.Name == tableSchema.PrimaryKeys[0] && strings.EqualFold(col.DataType, "INTEGER") {
+				tableSchema.Columns[i].AutoIdentity = true
+			}
+		}
+	}
+
 	// Get indexes
-	indexes, err := db.Query(fmt.Sprintf("PRAGMA index_list(%s)", tableName))
+	indexes, err := db.QueryContext(ctx, "PRAGMA index_list("+a.QuoteIdentifier(tableName)+")")
 	if err != nil {
 		return tableSchema, err
 	}
@@ -99,7 +169,7 @@ func (a *SQLiteAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchem
 		}
 
 		// Get columns in this index
-		indexCols, err := db.Query(fmt.Sprintf("PRAGMA index_info(%s)", indexName))
+		indexCols, err := db.QueryContext(ctx, "PRAGMA index_info("+a.QuoteIdentifier(indexName)+")")
 		if err != nil {
 			return tableSchema, err
 		}
@@ -120,11 +190,16 @@ func (a *SQLiteAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchem
 			}
 
 			tableSchema.Indexes = append(tableSchema.Indexes, indexSchema)
+
+			// SQLite creates an automatic index for each UNIQUE constraint
+			if origin == "u" {
+				tableSchema.addUniqueConstraintColumn(indexName, indexName, colName)
+			}
 		}
 	}
 
 	// Get foreign keys
-	fkeys, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", tableName))
+	fkeys, err := db.QueryContext(ctx, "PRAGMA foreign_key_list("+a.QuoteIdentifier(tableName)+")")
 	if err != nil {
 		return tableSchema, err
 	}
@@ -152,60 +227,337 @@ func (a *SQLiteAdapter) GetTableSchema(db *sql.DB, tableName string) (TableSchem
 	return tableSchema, nil
 }
 
-func (a *SQLiteAdapter) CompareTableDataByChecksum(sourceDB, targetDB *sql.DB, tableName string, schema TableSchema) (bool, error) {
-	fmt.Printf("Comparing data for table '%s'...\n", tableName)
+func (a *SQLiteAdapter) GetViews(ctx context.Context, db *sql.DB) ([]ViewSchema, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, sql FROM sqlite_master WHERE type='view'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []ViewSchema
+	for rows.Next() {
+		var view ViewSchema
+		if err := rows.Scan(&view.Name, &view.Definition); err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+
+	return views, rows.Err()
+}
+
+// GetRoutines returns nothing, SQLite has no stored procedures or functions
+func (a *SQLiteAdapter) GetRoutines(ctx context.Context, db *sql.DB) ([]RoutineSchema, error) {
+	return nil, nil
+}
+
+// GetSequences returns the counters of AUTOINCREMENT tables
+func (a *SQLiteAdapter) GetSequences(ctx context.Context, db *sql.DB) ([]SequenceSchema, error) {
+	// sqlite_sequence only exists once a table with AUTOINCREMENT was created
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='sqlite_sequence'").Scan(&count)
+	if err != nil || count == 0 {
+		return nil, err
+	}
 
-	// SQLite doesn't have a built-in checksum function
-	// Instead, we can compare row counts and then sample a few rows if needed
-	sourceCount, targetCount, err := a.CompareRowCounts(sourceDB, targetDB, tableName)
+	rows, err := db.QueryContext(ctx, "SELECT name, seq FROM sqlite_sequence")
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if sourceCount != targetCount {
-		fmt.Printf("Table '%s' has different row counts: source=%d, target=%d\n",
-			tableName, sourceCount, targetCount)
-		return true, nil
+	var sequences []SequenceSchema
+	for rows.Next() {
+		var sequence SequenceSchema
+		if err := rows.Scan(&sequence.Name, &sequence.CurrentValue); err != nil {
+			return nil, err
+		}
+		sequence.Table = sequence.Name
+		sequences = append(sequences, sequence)
 	}
 
-	// If row counts are the same, check the total changes by summarizing all values
-	query := fmt.Sprintf("SELECT total(rowid) FROM %s", tableName)
+	return sequences, rows.Err()
+}
+
+// GetTypes returns nothing, SQLite has no user-defined types
+func (a *SQLiteAdapter) GetTypes(ctx context.Context, db *sql.DB) ([]TypeSchema, error) {
+	return nil, nil
+}
+
+// Capabilities reports SQLite's: rows are hashed as they're read, and
+// sequences are the AUTOINCREMENT counters
+func (a *SQLiteAdapter) Capabilities() Capabilities {
+	return Capabilities{Engine: "sqlite", Sequences: true}
+}
+
+// BeginSnapshot isn't supported, Capabilities doesn't report Snapshots
+func (a *SQLiteAdapter) BeginSnapshot(ctx context.Context, db *sql.DB, connections int, lock bool) (func() error, error) {
+	return nil, errors.New("consistent snapshots aren't supported on sqlite")
+}
+
+// ReplicationPosition isn't supported, SQLite has no replication
+func (a *SQLiteAdapter) ReplicationPosition(ctx context.Context, db *sql.DB) (string, error) {
+	return "", errors.New("replication isn't supported on sqlite")
+}
+
+func (a *SQLiteAdapter) ReplicaCaughtUp(ctx context.Context, db *sql.DB, position string) (bool, error) {
+	return false, errors.New("replication isn't supported on sqlite")
+}
+
+func (a *SQLiteAdapter) ReplicaSource(ctx context.Context, db *sql.DB) (string, error) {
+	return "", errors.New("replication isn't supported on sqlite")
+}
+
+func (a *SQLiteAdapter) ChangeFeed(ctx context.Context, db *sql.DB, dsn string, schemas map[string]TableSchema) (<-chan RowChange, error) {
+	return nil, errors.New("change feeds aren't supported on sqlite")
+}
 
-	var sourceSum, targetSum int64
+func (a *SQLiteAdapter) ServerLoad(ctx context.Context, db *sql.DB) (ServerLoad, error) {
+	return ServerLoad{}, errors.New("sqlite has no server to check the load of")
+}
 
-	err = sourceDB.QueryRow(query).Scan(&sourceSum)
+// GetPrivileges returns nothing, SQLite has no users or grants
+func (a *SQLiteAdapter) GetPrivileges(ctx context.Context, db *sql.DB) ([]PrivilegeSchema, error) {
+	return nil, nil
+}
+
+// TableChecksum hashes all rows on our side like ChunkChecksums, in primary
+// key order, or for a table without one in the order of all its columns.
+// Columns are hashed in name order. Empty tables hash to an empty string.
+func (a *SQLiteAdapter) TableChecksum(ctx context.Context, db *sql.DB, tableName string, schema TableSchema) (string, sql.NullString, error) {
+	columns := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		columns[i] = col.Name
+	}
+	slices.Sort(columns)
+	orderBy := schema.PrimaryKeys
+	if len(orderBy) == 0 {
+		orderBy = columns
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s ORDER BY %s",
+		quoteList(columns, a.QuoteIdentifier), a.QuoteTable(tableName), quoteList(orderBy, keyOrders(a, tableName))))
 	if err != nil {
-		fmt.Printf("Error getting source sum: %v\n", err)
-		return false, err
+		return "hash", sql.NullString{}, err
 	}
+	checksum, err := hashRows(rows, len(columns))
+	return "hash", sql.NullString{String: checksum.Hash, Valid: err == nil}, err
+}
 
-	err = targetDB.QueryRow(query).Scan(&targetSum)
+func (a *SQLiteAdapter) CountRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+a.RowSource(tableName)).Scan(&count)
+	return count, err
+}
+
+// EstimateRows adds up the cells of the table's leaf pages from the dbstat
+// virtual table, which reads the pages without decoding the rows, or reads
+// the row count ANALYZE left in sqlite_stat1 if SQLite was built without it
+func (a *SQLiteAdapter) EstimateRows(ctx context.Context, db *sql.DB, tableName string) (int, error) {
+	var rows sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT SUM(ncell) FROM dbstat WHERE name = ? AND pagetype = 'leaf'", tableName).Scan(&rows)
+	if err == nil && rows.Valid {
+		return int(rows.Int64), nil
+	}
+
+	var stat string
+	err = db.QueryRowContext(ctx, "SELECT stat FROM sqlite_stat1 WHERE tbl = ? ORDER BY idx IS NOT NULL LIMIT 1", tableName).Scan(&stat)
+	if err != nil {
+		// No sqlite_stat1 table before the first ANALYZE
+		return -1, nil
+	}
+	count, err := strconv.Atoi(strings.Fields(stat + " -1")[0])
 	if err != nil {
-		fmt.Printf("Error getting target sum: %v\n", err)
-		return false, err
+		return -1, nil
 	}
+	return count, nil
+}
+
+// EstimateDistinct reads the statistics ANALYZE left in sqlite_stat1 for
+// an index the column comes first in: its rows divided by the rows per
+// value of its first column
+func (a *SQLiteAdapter) EstimateDistinct(ctx context.Context, db *sql.DB, tableName, column string) (int64, error) {
+	var stat string
+	err := db.QueryRowContext(ctx, `
+		SELECT s.stat FROM sqlite_stat1 s JOIN pragma_index_info(s.idx) i
+		WHERE s.tbl = ? AND i.seqno = 0 AND i.name = ?
+		LIMIT 1
+	`, tableName, column).Scan(&stat)
+	if err != nil {
+		// No sqlite_stat1 table before the first ANALYZE
+		return -1, nil
+	}
+	fields := strings.Fields(stat)
+	if len(fields) < 2 {
+		return -1, nil
+	}
+	rows, errRows := strconv.ParseInt(fields[0], 10, 64)
+	perValue, errPerValue := strconv.ParseInt(fields[1], 10, 64)
+	if errRows != nil || errPerValue != nil || perValue <= 0 {
+		return -1, nil
+	}
+	return (rows + perValue/2) / perValue, nil
+}
+
+// QuoteIdentifier quotes a name, doubling the quotes in it
+func (a *SQLiteAdapter) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteTable quotes a table name
+func (a *SQLiteAdapter) QuoteTable(tableName string) string {
+	return a.QuoteIdentifier(tableName)
+}
+
+func (a *SQLiteAdapter) SetRowFilter(tableName, condition string) {
+	a.filters.set(tableName, condition)
+}
+
+func (a *SQLiteAdapter) RowSource(tableName string) string {
+	return a.filters.source(a.QuoteTable(tableName), tableName)
+}
 
-	if sourceSum != targetSum {
-		fmt.Printf("Table '%s' has different data (row sums differ)\n", tableName)
-		return true, nil
-	} else {
-		fmt.Printf("Table '%s' has likely identical data (same row count and sums)\n", tableName)
-		return false, nil
+// ValueDigest digests the values of columns of text or blob affinity, or
+// none, with mudrockdbcompare_digest
+func (a *SQLiteAdapter) ValueDigest(column ColumnSchema, threshold int) string {
+	t := strings.ToUpper(column.DataType)
+	if t != "" && !strings.Contains(t, "CHAR") && !strings.Contains(t, "CLOB") && !strings.Contains(t, "TEXT") && !strings.Contains(t, "BLOB") {
+		return ""
 	}
+	return fmt.Sprintf("mudrockdbcompare_digest(%s, %d)", a.QuoteIdentifier(column.Name), threshold)
 }
 
-func (a *SQLiteAdapter) CompareRowCounts(sourceDB, targetDB *sql.DB, tableName string) (int, int, error) {
-	var sourceCount, targetCount int
+func (a *SQLiteAdapter) SetColumnExpression(tableName, column, expression string) {
+	a.expressions.set(tableName, column, expression)
+}
 
-	sourceRow := sourceDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM \"%s\"", tableName))
-	if err := sourceRow.Scan(&sourceCount); err != nil {
-		return 0, 0, err
+// SetBinaryKeyOrder orders a text key column with the BINARY collation, by
+// its bytes, rather than one it's declared with, like NOCASE. An index of
+// the column in BINARY, the default, still serves the order.
+func (a *SQLiteAdapter) SetBinaryKeyOrder(tableName string, column ColumnSchema) {
+	if !isTextType(column.DataType) {
+		return
 	}
+	a.keyOrders.set(tableName, column.Name, a.QuoteIdentifier(column.Name)+" COLLATE BINARY")
+}
 
-	targetRow := targetDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM \"%s\"", tableName))
-	if err := targetRow.Scan(&targetCount); err != nil {
-		return 0, 0, err
+func (a *SQLiteAdapter) KeyOrder(tableName, column string) string {
+	return a.keyOrders.keyOrder(tableName, column, a.QuoteIdentifier)
+}
+
+// QuoteLiteral renders a value read from any database as a SQLite literal
+func (a *SQLiteAdapter) QuoteLiteral(value interface{}) string {
+	return sqlLiteral(value,
+		func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" },
+		func(b []byte) string { return "X'" + hex.EncodeToString(b) + "'" },
+		sqliteSpecialFloat,
+		"2006-01-02 15:04:05.999999999-07:00")
+}
+
+// sqliteSpecialFloat renders the infinities as numbers too large for a
+// REAL, which SQLite reads as infinite, and NaN as NULL, which SQLite
+// stores it as
+func sqliteSpecialFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NULL"
+	case f > 0:
+		return "9e999"
+	default:
+		return "-9e999"
 	}
+}
 
-	return sourceCount, targetCount, nil
+func (a *SQLiteAdapter) placeholder(int) string {
+	return "?"
+}
+
+func (a *SQLiteAdapter) StreamRows(ctx context.Context, db *sql.DB, tableName string, columns []string, orderBy []string, chunk Chunk, limit int) (*sql.Rows, error) {
+	where, args := keyRangeCondition(orderBy, chunk, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s", a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), where)
+	if len(orderBy) > 0 {
+		query += " ORDER BY " + quoteList(orderBy, keyOrders(a, tableName))
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+// SampleRows streams the rows whose primary key falls in the sample, hashed
+// like MySQLAdapter.SampleRows, ordered by key
+func (a *SQLiteAdapter) SampleRows(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, percent float64) (*sql.Rows, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE mudrockdbcompare_sample_bucket(concat_ws('#', %s)) < ? ORDER BY %s",
+		a.expressions.selectList(tableName, columns, a.QuoteIdentifier), a.RowSource(tableName), quoteList(keyColumns, a.QuoteIdentifier),
+		quoteList(keyColumns, keyOrders(a, tableName)))
+	return db.QueryContext(ctx, query, sampleThreshold(percent))
+}
+
+func (a *SQLiteAdapter) GetChunkBoundary(ctx context.Context, db *sql.DB, tableName string, keyColumns []string, after []interface{}, chunkSize int) ([]interface{}, error) {
+	where, args := keyRangeCondition(keyColumns, Chunk{Lower: after}, keyOrders(a, tableName), a.placeholder)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d",
+		quoteList(keyColumns, a.QuoteIdentifier), a.RowSource(tableName), where, quoteList(keyColumns, keyOrders(a, tableName)), chunkSize-1)
+	return scanChunkBoundary(db.QueryRowContext(ctx, query, args...), len(keyColumns))
+}
+
+// ChunkChecksums checksums the chunks one by one, see chunkChecksum
+func (a *SQLiteAdapter) ChunkChecksums(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, chunks []Chunk) ([]ChunkChecksum, error) {
+	checksums := make([]ChunkChecksum, len(chunks))
+	for i, chunk := range chunks {
+		var err error
+		if checksums[i], err = a.chunkChecksum(ctx, db, tableName, columns, keyColumns, chunk); err != nil {
+			return nil, err
+		}
+	}
+	return checksums, nil
+}
+
+func (a *SQLiteAdapter) chunkChecksum(ctx context.Context, db *sql.DB, tableName string, columns []string, keyColumns []string, chunk Chunk) (ChunkChecksum, error) {
+	// SQLite has no hash functions, so hash the chunk's rows on our side.
+	// The database is a local file, so this costs no network traffic.
+	rows, err := a.StreamRows(ctx, db, tableName, columns, keyColumns, chunk, 0)
+	if err != nil {
+		return ChunkChecksum{}, err
+	}
+	return hashRows(rows, len(columns))
+}
+
+// hashRows reads rows and hashes their values in order, see writeRow
+func hashRows(rows *sql.Rows, columns int) (ChunkChecksum, error) {
+	defer rows.Close()
+
+	var checksum ChunkChecksum
+	hash := md5.New()
+	cursor := &rowCursor{rows: rows, values: make([]interface{}, columns)}
+	for {
+		ok, err := cursor.next()
+		if err != nil {
+			return checksum, err
+		}
+		if !ok {
+			break
+		}
+
+		checksum.Rows++
+		writeRow(hash, cursor.values)
+	}
+
+	if checksum.Rows > 0 {
+		checksum.Hash = hex.EncodeToString(hash.Sum(nil))
+	}
+	return checksum, nil
+}
+
+// IsTransientError reports a database locked by another connection, which
+// is worth retrying
+func (a *SQLiteAdapter) IsTransientError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// The low byte of an extended result code is the primary code
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+	}
+	return false
 }
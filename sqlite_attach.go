@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// attachedSQLite is a source SQLite database with the target attached to
+// it as "target", so rows can be compared inside SQLite. It holds two
+// connections, one for each side's differing rows, as a database is
+// attached to a connection.
+type attachedSQLite struct {
+	db             *sql.DB
+	source, target *sql.Conn
+	sourceAdapter  *SQLiteAdapter
+	targetAdapter  *SQLiteAdapter
+}
+
+// attachSQLite attaches the target to the source when both are SQLite
+// database files, and returns nil otherwise
+func (c *Comparison) attachSQLite(ctx context.Context) (*attachedSQLite, error) {
+	sourceAdapter, ok := c.Adapter.(*SQLiteAdapter)
+	if !ok {
+		return nil, nil
+	}
+	targetAdapter, ok := c.targetAdapter().(*SQLiteAdapter)
+	if !ok {
+		return nil, nil
+	}
+	for _, connStr := range []string{c.SourceConnStr, c.TargetConnStr} {
+		if connStr == "" || strings.Contains(connStr, ":memory:") || strings.Contains(connStr, "mode=memory") {
+			return nil, nil
+		}
+	}
+
+	db, err := sourceAdapter.Connect(c.SourceConnStr)
+	if err != nil {
+		return nil, err
+	}
+	nameSession(db, "source with the target attached")
+	a := &attachedSQLite{db: db, sourceAdapter: sourceAdapter, targetAdapter: targetAdapter}
+	for _, conn := range []**sql.Conn{&a.source, &a.target} {
+		if *conn, err = db.Conn(ctx); err == nil {
+			_, err = (*conn).ExecContext(ctx, "ATTACH DATABASE ? AS target", c.TargetConnStr)
+		}
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *attachedSQLite) close() {
+	for _, conn := range []*sql.Conn{a.source, a.target} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	a.db.Close()
+}
+
+// compareAttachedRows compares the rows of a table like compareTableRows,
+// but SQLite finds the rows that differ, with EXCEPT both ways, and only
+// those are merge-joined by primary key. Rows differing only in ways the
+// comparison normalizes away are read but not reported. Like
+// compareTableRows, the chunks compared are recorded in progress.
+func compareAttachedRows(ctx context.Context, a *attachedSQLite, sourceSchema, targetSchema TableSchema, chunks []Chunk, options CompareOptions, onRow rowHandler, progress *tableProgress) (RowDiffResult, error) {
+	result := RowDiffResult{Table: sourceSchema.Name, PrimaryKey: sourceSchema.PrimaryKeys}
+
+	columns, err := rowComparisonColumns(sourceSchema, targetSchema)
+	if err != nil {
+		return result, err
+	}
+	keyIndexes := make([]int, len(sourceSchema.PrimaryKeys))
+	for i, pk := range sourceSchema.PrimaryKeys {
+		keyIndexes[i] = indexOf(columns, pk)
+	}
+	values := valueColumns(columns, sourceSchema, targetSchema, options)
+
+	quote := a.sourceAdapter.QuoteIdentifier
+	sourceTable := a.sourceAdapter.filters.source("main."+quote(sourceSchema.Name), sourceSchema.Name)
+	targetTable := a.targetAdapter.filters.source("target."+quote(targetSchema.Name), targetSchema.Name)
+	sourceColumns := a.sourceAdapter.expressions.selectList(sourceSchema.Name, columns, quote)
+	targetColumns := a.targetAdapter.expressions.selectList(targetSchema.Name, columns, quote)
+	keys := quoteList(sourceSchema.PrimaryKeys, keyOrders(a.sourceAdapter, sourceSchema.Name))
+
+	if len(chunks) == 0 {
+		chunks = []Chunk{{}}
+	}
+	result.add(progress.comparedRows())
+	for i, chunk := range chunks {
+		if progress.rangeCompared(i) {
+			continue
+		}
+		where, args := keyRangeCondition(sourceSchema.PrimaryKeys, chunk, keyOrders(a.sourceAdapter, sourceSchema.Name), a.sourceAdapter.placeholder)
+		bothArgs := append(append([]interface{}{}, args...), args...)
+
+		var chunkResult RowDiffResult
+		var sourceRows int
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", sourceTable, where)
+		if err := a.source.QueryRowContext(ctx, query, args...).Scan(&sourceRows); err != nil {
+			return result, fmt.Errorf("source: %w", err)
+		}
+
+		query = fmt.Sprintf("SELECT %s FROM %s%s EXCEPT SELECT %s FROM %s%s ORDER BY %s",
+			sourceColumns, sourceTable, where, targetColumns, targetTable, where, keys)
+		rows, err := a.source.QueryContext(ctx, query, bothArgs...)
+		if err != nil {
+			return result, fmt.Errorf("source: %w", err)
+		}
+		source := &rowCursor{rows: rows, values: make([]interface{}, len(columns))}
+
+		query = fmt.Sprintf("SELECT %s FROM %s%s EXCEPT SELECT %s FROM %s%s ORDER BY %s",
+			targetColumns, targetTable, where, sourceColumns, sourceTable, where, keys)
+		rows, err = a.target.QueryContext(ctx, query, bothArgs...)
+		if err != nil {
+			source.close()
+			return result, fmt.Errorf("target: %w", err)
+		}
+		target := &rowCursor{rows: rows, values: make([]interface{}, len(columns))}
+
+		err = mergeRows(source, target, columns, keyIndexes, values, &chunkResult, onRow)
+		source.close()
+		target.close()
+		if err != nil {
+			return result, err
+		}
+		// Only the differing rows were merged, every source row and every
+		// row only the target has were compared
+		chunkResult.Compared = sourceRows + chunkResult.Inserted
+		result.add(chunkResult)
+		progress.finishRange(i, chunkResult)
+	}
+	return result, nil
+}
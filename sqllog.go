@@ -0,0 +1,628 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errQueryTimeout = errors.New("query timeout exceeded")
+
+type queryTimeoutKey struct{}
+
+// withQueryTimeout makes every statement executed with ctx, including
+// reading its rows, fail with errQueryTimeout after timeout
+func withQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+// statementContext applies the query timeout carried by ctx, if any
+func statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, _ := ctx.Value(queryTimeoutKey{}).(time.Duration)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// statementError reports errors caused by the query timeout as errQueryTimeout
+func statementError(parent, ctx context.Context, err error) error {
+	if err == nil || err == driver.ErrSkip || err == io.EOF {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		timeout, _ := ctx.Value(queryTimeoutKey{}).(time.Duration)
+		return fmt.Errorf("%w (%s): %v", errQueryTimeout, timeout, err)
+	}
+	return err
+}
+
+// openDB works like sql.Open, but wraps the driver so every statement
+// executed through the returned pool is logged at debug level, printed
+// when explained with withExplain, and honors the query timeout set with
+// withQueryTimeout.
+func openDB(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+
+	connector := &wrappedConnector{driver: d, dsn: dsn, session: &session{}, killStatement: killStatements[driverName]}
+	if dc, ok := d.(driver.DriverContext); ok {
+		connector.connector, err = dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db = sql.OpenDB(connector)
+	sessions.Store(db, connector.session)
+	return db, nil
+}
+
+// session is what the new connections of a pool run before their first
+// statement. Connections of an earlier session are closed rather than
+// reused, as are connections whose statements failed if errors end the
+// session's transaction.
+type session struct {
+	mu         sync.Mutex
+	generation int
+	statements []string
+	dropFailed bool
+	err        error    // new connections fail with it
+	name       string   // the database the pool reads, in the query log
+	utc        bool     // the pool reads MySQL's TIMESTAMP columns in UTC, see connectReader
+	closers    []func() // run when the pool is closed, see closeWith
+
+	// The connections of a sealed session still open, and the function
+	// limiting the pool to them when one is closed
+	sealed func(open int)
+	open   int
+
+	// When the next statement may run under the max QPS, and the load of
+	// the server, closing resumed when it falls back under the limits, see
+	// wait
+	nextStatement time.Time
+	pressure      int
+	resumed       chan struct{}
+}
+
+// sessions are the sessions of the pools openDB returned
+var sessions sync.Map
+
+// poolSession returns the session of a pool openDB returned
+func poolSession(db *sql.DB) (*session, error) {
+	s, ok := sessions.Load(db)
+	if !ok {
+		return nil, errors.New("connection pool has no sessions")
+	}
+	return s.(*session), nil
+}
+
+// closeWith runs f when a pool openDB returned is closed, after its idle
+// connections
+func closeWith(db *sql.DB, f func()) {
+	s, err := poolSession(db)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers = append(s.closers, f)
+}
+
+// start begins a session whose connections run statements
+func (s *session) start(statements []string, dropFailed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.statements, s.dropFailed, s.err = statements, dropFailed, nil
+	s.sealed, s.open = nil, 0
+}
+
+// seal makes new connections fail with err, leaving the open ones, of
+// which there are open, valid. When one of those is closed, e.g. after its
+// statement timed out, limit is called with the number left, to keep the
+// pool from opening another instead of waiting for them.
+func (s *session) seal(err error, open int, limit func(open int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err, s.open, s.sealed = err, open, limit
+}
+
+// closed records that a connection of generation was closed
+func (s *session) closed(generation int) {
+	s.mu.Lock()
+	limit := s.sealed
+	if limit == nil || generation != s.generation || s.open == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.open--
+	open := s.open
+	s.mu.Unlock()
+	if open > 0 {
+		limit(open)
+	}
+}
+
+// end goes back to connections without session statements
+func (s *session) end() {
+	s.start(nil, false)
+}
+
+// readsUTC reports whether the pool reads MySQL's TIMESTAMP columns in UTC
+func (s *session) readsUTC() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.utc
+}
+
+func (s *session) current() (int, []string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation, s.statements, s.dropFailed, s.err
+}
+
+// logStatement logs a statement of a pool's session at debug level, and
+// prints it when ctx explains statements
+func logStatement(ctx context.Context, s *session, query string, args []driver.NamedValue) {
+	explainStatement(ctx, s, query, args)
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = formatValue(arg.Value)
+	}
+	slog.DebugContext(ctx, "Executing SQL", "query", query, "args", values)
+}
+
+// killStatements return the statement stopping what a connection runs, for
+// the drivers that only drop the connection when a statement is cancelled,
+// which leaves it running on the server until it returns rows. It's
+// executed on a connection of its own.
+var killStatements = map[string]func(ctx context.Context, conn driver.Conn) (string, error){
+	"mysql": mysqlKillStatement,
+}
+
+// killTimeout is how long stopping a cancelled statement may take
+const killTimeout = 10 * time.Second
+
+type wrappedConnector struct {
+	driver        driver.Driver
+	connector     driver.Connector // nil if the driver has no DriverContext
+	dsn           string
+	session       *session
+	killStatement func(ctx context.Context, conn driver.Conn) (string, error)
+}
+
+// connect opens a connection of the wrapped driver
+func (c *wrappedConnector) connect(ctx context.Context) (driver.Conn, error) {
+	if c.connector != nil {
+		return c.connector.Connect(ctx)
+	}
+	return c.driver.Open(c.dsn)
+}
+
+// kill runs the statement stopping a cancelled one on a connection of its
+// own, outside the session
+func (c *wrappedConnector) kill(statement string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	conn, err := c.connect(ctx)
+	if err == nil {
+		defer conn.Close()
+		if execer, ok := conn.(driver.ExecerContext); ok {
+			_, err = execer.ExecContext(ctx, statement, nil)
+		}
+	}
+	if err != nil {
+		slog.Warn("Couldn't stop a cancelled statement on the server", "statement", statement, "error", err)
+	}
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The session's statements run even when the statements of ctx are
+	// only explained
+	ctx = explainRunning(ctx)
+	generation, statements, dropFailed, err := c.session.current()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	wrapped := &wrappedConn{Conn: conn, connector: c, session: c.session, generation: generation, dropFailed: dropFailed}
+	if c.killStatement != nil {
+		if wrapped.kill, err = c.killStatement(ctx, conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	for _, statement := range statements {
+		if err := wrapped.exec(ctx, statement); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return wrapped, nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close is called by DB.Close, and forgets the pool's session
+func (c *wrappedConnector) Close() error {
+	sessions.Range(func(db, s any) bool {
+		if s == c.session {
+			sessions.Delete(db)
+		}
+		return true
+	})
+	var err error
+	if closer, ok := c.connector.(io.Closer); ok {
+		err = closer.Close()
+	}
+	c.session.mu.Lock()
+	closers := c.session.closers
+	c.session.closers = nil
+	c.session.mu.Unlock()
+	for _, f := range closers {
+		f()
+	}
+	return err
+}
+
+// wrappedConn forwards every optional driver interface database/sql looks
+// for, returning driver.ErrSkip where the wrapped connection lacks one.
+type wrappedConn struct {
+	driver.Conn
+	connector  *wrappedConnector
+	kill       string // the statement stopping what the connection runs, if the driver needs one
+	session    *session
+	generation int
+	dropFailed bool
+	failed     bool // a statement failed
+	waited     bool // the statement database/sql prepares next already waited its turn
+}
+
+// exec runs a statement without arguments, preparing it if the driver
+// can't execute it directly
+func (c *wrappedConn) exec(ctx context.Context, query string) error {
+	_, err := c.ExecContext(ctx, query, nil)
+	if err != driver.ErrSkip {
+		return err
+	}
+	stmt, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.(*wrappedStmt).ExecContext(ctx, nil)
+	return err
+}
+
+func (c *wrappedConn) Close() error {
+	c.session.closed(c.generation)
+	return c.Conn.Close()
+}
+
+// killOnCancel has the statement the connection runs stopped by the kill
+// statement when ctx is done before the returned function is called
+func (c *wrappedConn) killOnCancel(ctx context.Context) func() bool {
+	if c.kill == "" {
+		return func() bool { return true }
+	}
+	return context.AfterFunc(ctx, func() { c.connector.kill(c.kill) })
+}
+
+// fail records a statement's error, returning it
+func (c *wrappedConn) fail(err error) error {
+	if err != nil && err != driver.ErrSkip && err != io.EOF {
+		c.failed = true
+	}
+	return err
+}
+
+// current reports whether the connection belongs to its pool's session
+func (c *wrappedConn) current() bool {
+	generation, _, _, _ := c.session.current()
+	return generation == c.generation && !(c.dropFailed && c.failed)
+}
+
+func (c *wrappedConn) QueryContext(parent context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if !explainRuns(parent) {
+		logStatement(parent, c.session, query, args)
+		return nil, errExplained
+	}
+	if err := c.session.wait(parent); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := statementContext(parent)
+	stop := c.killOnCancel(ctx)
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	// ErrSkip means database/sql will prepare the statement instead, which logs it
+	if err != driver.ErrSkip {
+		logStatement(ctx, c.session, query, args)
+	}
+	c.waited = err == driver.ErrSkip
+	if err != nil {
+		stop()
+		cancel()
+		err = c.fail(statementError(parent, ctx, err))
+		logQuery(c.session, start, query, args, 0, err)
+		return nil, err
+	}
+	return &wrappedRows{Rows: rows, conn: c, parent: parent, ctx: ctx, cancel: cancel, stop: stop, start: start, query: query, args: args}, nil
+}
+
+func (c *wrappedConn) ExecContext(parent context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if !explainRuns(parent) {
+		logStatement(parent, c.session, query, args)
+		return nil, errExplained
+	}
+	if err := c.session.wait(parent); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := statementContext(parent)
+	defer cancel()
+	defer c.killOnCancel(ctx)()
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		logStatement(ctx, c.session, query, args)
+	}
+	c.waited = err == driver.ErrSkip
+	err = c.fail(statementError(parent, ctx, err))
+	logQuery(c.session, start, query, args, rowsAffected(result), err)
+	return result, err
+}
+
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else if opts.ReadOnly || sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		// Like database/sql, rather than start a transaction without them
+		return nil, errors.New("driver doesn't support transaction options: " + beginStatement(opts))
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	logQuery(c.session, start, beginStatement(opts), nil, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedTx{Tx: tx, conn: c}, nil
+}
+
+// beginStatement describes the start of a transaction in the query log,
+// e.g. BEGIN READ ONLY
+func beginStatement(opts driver.TxOptions) string {
+	statement := "BEGIN"
+	if level := sql.IsolationLevel(opts.Isolation); level != sql.LevelDefault {
+		statement += " ISOLATION LEVEL " + strings.ToUpper(level.String())
+	}
+	if opts.ReadOnly {
+		statement += " READ ONLY"
+	}
+	return statement
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if !c.current() {
+		return driver.ErrBadConn
+	}
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) IsValid() bool {
+	if !c.current() {
+		return false
+	}
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *wrappedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type wrappedStmt struct {
+	driver.Stmt
+	conn  *wrappedConn
+	query string
+}
+
+func (s *wrappedStmt) QueryContext(parent context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if !explainRuns(parent) {
+		logStatement(parent, s.conn.session, s.query, args)
+		return nil, errExplained
+	}
+	if err := s.wait(parent); err != nil {
+		return nil, err
+	}
+	logStatement(parent, s.conn.session, s.query, args)
+
+	ctx, cancel := statementContext(parent)
+	stop := s.conn.killOnCancel(ctx)
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedToValues(args))
+	}
+	if err != nil {
+		stop()
+		cancel()
+		err = s.conn.fail(statementError(parent, ctx, err))
+		logQuery(s.conn.session, start, s.query, args, 0, err)
+		return nil, err
+	}
+	return &wrappedRows{Rows: rows, conn: s.conn, parent: parent, ctx: ctx, cancel: cancel, stop: stop, start: start, query: s.query, args: args}, nil
+}
+
+func (s *wrappedStmt) ExecContext(parent context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if !explainRuns(parent) {
+		logStatement(parent, s.conn.session, s.query, args)
+		return nil, errExplained
+	}
+	if err := s.wait(parent); err != nil {
+		return nil, err
+	}
+	logStatement(parent, s.conn.session, s.query, args)
+
+	ctx, cancel := statementContext(parent)
+	defer cancel()
+	defer s.conn.killOnCancel(ctx)()
+	start := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedToValues(args))
+	}
+	err = s.conn.fail(statementError(parent, ctx, err))
+	logQuery(s.conn.session, start, s.query, args, rowsAffected(result), err)
+	return result, err
+}
+
+// wait waits for the statement's turn under the max QPS, unless it's the
+// one the connection couldn't run directly, which already waited
+func (s *wrappedStmt) wait(ctx context.Context) error {
+	if s.conn.waited {
+		s.conn.waited = false
+		return nil
+	}
+	return s.conn.session.wait(ctx)
+}
+
+func (s *wrappedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func namedToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// wrappedTx records the end of a transaction in the query log, which the
+// driver doesn't run as a statement
+type wrappedTx struct {
+	driver.Tx
+	conn *wrappedConn
+}
+
+func (t *wrappedTx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	logQuery(t.conn.session, start, "COMMIT", nil, 0, err)
+	return err
+}
+
+func (t *wrappedTx) Rollback() error {
+	start := time.Now()
+	err := t.Tx.Rollback()
+	logQuery(t.conn.session, start, "ROLLBACK", nil, 0, err)
+	return err
+}
+
+// wrappedRows keeps the statement's timeout running until the rows are closed
+type wrappedRows struct {
+	driver.Rows
+	conn   *wrappedConn
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   func() bool // stops killing the statement on cancel, see killOnCancel
+
+	// The statement as the query log records it once the rows are closed
+	start time.Time
+	query string
+	args  []driver.NamedValue
+	read  int64
+	err   error
+}
+
+func (r *wrappedRows) Next(dest []driver.Value) error {
+	err := r.conn.fail(statementError(r.parent, r.ctx, r.Rows.Next(dest)))
+	if err == nil {
+		r.read++
+	} else if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *wrappedRows) Close() error {
+	r.stop()
+	err := r.Rows.Close()
+	r.cancel()
+	logQuery(r.conn.session, r.start, r.query, r.args, r.read, r.err)
+	return err
+}
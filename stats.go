@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColumnStats are aggregates of a column's values. Min, Max and Distinct
+// are only computed for orderable types, Sum for numeric ones; the others
+// are invalid. Distinct is the database's estimate, invalid if its
+// statistics have none for the column.
+type ColumnStats struct {
+	Count    int64 // non-NULL values
+	Nulls    int64
+	Min      sql.NullString
+	Max      sql.NullString
+	Sum      sql.NullString
+	Distinct sql.NullInt64
+}
+
+// distinctTolerance is how much the distinct estimates of the two sides may
+// differ at least, as a fraction of the larger one: each database estimates
+// from its own sample of the table
+const distinctTolerance = 0.1
+
+// statTypes classifies a column's data type by its name: numeric types get
+// a sum, orderable ones a minimum, maximum and distinct count. Types without
+// an order in every database, like JSON, binary, booleans, spatial types
+// and intervals, get neither.
+func statTypes(dataType string) (numeric, orderable bool) {
+	if isNumericType(dataType) {
+		return true, true
+	}
+	switch typeName(dataType) {
+	case "char", "character", "varchar", "nchar", "nvarchar", "bpchar", "text", "tinytext", "mediumtext",
+		"longtext", "clob", "citext", "date", "time", "timetz", "datetime", "timestamp", "timestamptz", "year":
+		return false, true
+	}
+	// SQLite columns may have no declared type
+	return false, dataType == ""
+}
+
+// tableColumnStats computes the stats of the given columns in one query,
+// and reads the distinct estimates of the orderable ones from the
+// database's statistics
+func tableColumnStats(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, schema TableSchema, columns []string) (map[string]*ColumnStats, error) {
+	types := make(map[string]string)
+	for _, col := range schema.Columns {
+		types[col.Name] = col.DataType
+	}
+
+	var rows int64
+	stats := make(map[string]*ColumnStats)
+	selects := []string{"COUNT(*)"}
+	dest := []interface{}{&rows}
+	var orderable []string
+	for _, col := range columns {
+		s := &ColumnStats{}
+		stats[col] = s
+		quoted := adapter.QuoteIdentifier(col)
+		numeric, ordered := statTypes(types[col])
+
+		selects = append(selects, "COUNT("+quoted+")")
+		dest = append(dest, &s.Count)
+		if ordered {
+			selects = append(selects, "MIN("+quoted+")", "MAX("+quoted+")")
+			dest = append(dest, &s.Min, &s.Max)
+			orderable = append(orderable, col)
+		}
+		if numeric {
+			selects = append(selects, "SUM("+quoted+")")
+			dest = append(dest, &s.Sum)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), adapter.RowSource(schema.Name))
+	if err := db.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		return nil, err
+	}
+	for _, s := range stats {
+		s.Nulls = rows - s.Count
+	}
+	for _, col := range orderable {
+		distinct, err := adapter.EstimateDistinct(ctx, db, schema.Name, col)
+		if err != nil {
+			return nil, err
+		}
+		stats[col].Distinct = sql.NullInt64{Int64: distinct, Valid: distinct >= 0}
+	}
+	return stats, nil
+}
+
+// compareColumnStats reports the stats of a column that differ by more
+// than tolerance, relative to the larger value, the distinct estimates by
+// more than distinctTolerance. Minimums and maximums are compared as the
+// column's values, e.g. timestamps as instants, and other values that
+// aren't numbers must be equal. Between different database types, the
+// minimum and maximum of strings depend on the collations and aren't
+// compared.
+func compareColumnStats(tableName, column string, source, target *ColumnStats, values columnValues, tolerance float64, crossEngine bool) []Difference {
+	avg := func(s *ColumnStats) sql.NullString {
+		sum, err := strconv.ParseFloat(s.Sum.String, 64)
+		if !s.Sum.Valid || err != nil || s.Count == 0 {
+			return sql.NullString{}
+		}
+		return sql.NullString{String: strconv.FormatFloat(sum/float64(s.Count), 'g', -1, 64), Valid: true}
+	}
+	distinct := func(s *ColumnStats) sql.NullString {
+		return sql.NullString{String: strconv.FormatInt(s.Distinct.Int64, 10), Valid: s.Distinct.Valid}
+	}
+	sourceMin, sourceMax, targetMin, targetMax := source.Min, source.Max, target.Min, target.Max
+	if crossEngine && !values.timestamp && !values.numeric {
+		sourceMin, sourceMax, targetMin, targetMax = sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}
+	}
+	stats := []struct {
+		name           string
+		source, target sql.NullString
+		tolerance      float64
+	}{
+		{"count", sql.NullString{String: strconv.FormatInt(source.Count, 10), Valid: true}, sql.NullString{String: strconv.FormatInt(target.Count, 10), Valid: true}, tolerance},
+		{"nulls", sql.NullString{String: strconv.FormatInt(source.Nulls, 10), Valid: true}, sql.NullString{String: strconv.FormatInt(target.Nulls, 10), Valid: true}, tolerance},
+		{"min", sourceMin, targetMin, tolerance},
+		{"max", sourceMax, targetMax, tolerance},
+		{"sum", source.Sum, target.Sum, tolerance},
+		{"avg", avg(source), avg(target), tolerance},
+		{"distinct", distinct(source), distinct(target), max(tolerance, distinctTolerance)},
+	}
+
+	differences := []Difference{}
+	for _, stat := range stats {
+		// Only compare what was computed on both sides, an empty table
+		// has no minimum
+		if !stat.source.Valid || !stat.target.Valid || withinTolerance(stat.source.String, stat.target.String, stat.tolerance) ||
+			values.equal(stat.source.String, stat.target.String) {
+			continue
+		}
+		differences = append(differences, Difference{
+			Table: tableName, ObjectType: "data", ObjectName: column, Kind: DiffModified,
+			Property: stat.name, Source: stat.source.String, Target: stat.target.String,
+			Message: fmt.Sprintf("Column '%s.%s' has different %s: source=%s, target=%s",
+				tableName, column, stat.name, stat.source.String, stat.target.String),
+		})
+	}
+	return differences
+}
+
+// withinTolerance reports whether two values are equal or, if both are
+// numbers, differ by at most tolerance relative to the larger one
+func withinTolerance(source, target string, tolerance float64) bool {
+	if source == target {
+		return true
+	}
+	a, errA := strconv.ParseFloat(source, 64)
+	b, errB := strconv.ParseFloat(target, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// compareTableStats compares the column stats of a table on both sides
+func (c *Comparison) compareTableStats(ctx context.Context, summary *ComparisonSummary, sourceSchema, targetSchema TableSchema) error {
+	tableName := sourceSchema.Name
+	targetColumns := make(map[string]bool)
+	for _, col := range targetSchema.Columns {
+		targetColumns[col.Name] = true
+	}
+	var columns []string
+	for _, col := range sourceSchema.Columns {
+		if targetColumns[col.Name] {
+			columns = append(columns, col.Name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	values := valueColumns(columns, sourceSchema, targetSchema, c.Options)
+
+	var sourceStats, targetStats map[string]*ColumnStats
+	err := c.retry(ctx, "column stats of "+tableName, func() (err error) {
+		if sourceStats, err = tableColumnStats(ctx, c.Adapter, c.SourceDB, sourceSchema, columns); err != nil {
+			return err
+		}
+		targetStats, err = tableColumnStats(ctx, c.targetAdapter(), c.TargetDB, targetSchema, columns)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var differences []Difference
+	for i, col := range columns {
+		for _, diff := range compareColumnStats(tableName, col, sourceStats[col], targetStats[col], values[i], c.Options.StatsTolerance, c.crossEngine()) {
+			if c.suppress(summary, diff) {
+				continue
+			}
+			differences = append(differences, diff)
+			c.emit(Event{Type: EventDifferenceFound, Table: tableName, Message: diff.Message})
+		}
+	}
+	if len(differences) > 0 {
+		summary.StatDifferences[tableName] = differences
+		summary.addDifferentTable(tableName)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// Suppression is an accepted difference. A difference is suppressed when it
+// matches every field that is set: Table and Column are glob patterns
+// matched against the table and column names, Pattern is a regular
+// expression matched against the difference's message.
+type Suppression struct {
+	Table   string
+	Column  string
+	Pattern string
+	Reason  string
+
+	pattern *regexp.Regexp
+}
+
+// SuppressedDifference is a difference left out of the summary
+type SuppressedDifference struct {
+	Difference
+	Reason string
+}
+
+func (s Suppression) matches(diff Difference) bool {
+	if s.Table != "" {
+		if ok, _ := path.Match(s.Table, diff.Table); !ok {
+			return false
+		}
+	}
+	if s.Column != "" {
+		// Column stats are data differences of a column
+		if diff.ObjectType != "column" && !(diff.ObjectType == "data" && diff.ObjectName != "") {
+			return false
+		}
+		if ok, _ := path.Match(s.Column, diff.ObjectName); !ok {
+			return false
+		}
+	}
+	if s.pattern != nil && !s.pattern.MatchString(diff.Message) {
+		return false
+	}
+	return true
+}
+
+// suppress reports whether diff is accepted by one of the suppressions and
+// if so records it in the summary
+func (c *Comparison) suppress(summary *ComparisonSummary, diff Difference) bool {
+	for _, s := range c.Options.Suppressions {
+		if s.matches(diff) {
+			summary.Suppressed = append(summary.Suppressed, SuppressedDifference{Difference: diff, Reason: s.Reason})
+			return true
+		}
+	}
+	return false
+}
+
+// suppressAll returns the differences that aren't suppressed
+func (c *Comparison) suppressAll(summary *ComparisonSummary, diffs []Difference) []Difference {
+	kept := diffs[:0]
+	for _, diff := range diffs {
+		if !c.suppress(summary, diff) {
+			kept = append(kept, diff)
+		}
+	}
+	return kept
+}
+
+// suppressTables removes the suppressed missing and extra tables and schema
+// differences from the summary
+func (c *Comparison) suppressTables(summary *ComparisonSummary) {
+	missing := summary.MissingTables[:0]
+	for _, table := range summary.MissingTables {
+		if !c.suppress(summary, Difference{Table: table, ObjectType: "table", Kind: DiffMissing,
+			Message: fmt.Sprintf("Table '%s' exists in source but not in target", table)}) {
+			missing = append(missing, table)
+		}
+	}
+	summary.MissingTables = missing
+
+	extra := summary.ExtraTables[:0]
+	for _, table := range summary.ExtraTables {
+		if !c.suppress(summary, Difference{Table: table, ObjectType: "table", Kind: DiffExtra,
+			Message: fmt.Sprintf("Table '%s' exists in target but not in source", table)}) {
+			extra = append(extra, table)
+		}
+	}
+	summary.ExtraTables = extra
+
+	for table, diffs := range summary.SchemaDifferences {
+		if diffs = c.suppressAll(summary, diffs); len(diffs) > 0 {
+			summary.SchemaDifferences[table] = diffs
+		} else {
+			delete(summary.SchemaDifferences, table)
+		}
+	}
+}
+
+// loadSuppressions reads a suppressions file. It is YAML, limited to a list
+// of entries with the keys table, column, pattern and reason, optionally
+// under a top-level "suppress" key:
+//
+//	suppress:
+//	  - table: audit_log
+//	    reason: append-only, cleaned up separately per environment
+//	  - table: users
+//	    column: last_login_*
+//	  - pattern: "different collation"
+func loadSuppressions(filename string) ([]Suppression, error) {
+	entries, err := readYAMLList(filename, "suppress")
+	if err != nil {
+		return nil, err
+	}
+
+	suppressions := []Suppression{}
+	for i, entry := range entries {
+		var s Suppression
+		for _, key := range entry.Keys {
+			value := entry.Fields[key]
+			switch key {
+			case "table":
+				s.Table = value
+			case "column":
+				s.Column = value
+			case "pattern":
+				s.Pattern = value
+				if s.pattern, err = regexp.Compile(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid pattern: %w", filename, entry.Lines[key], err)
+				}
+			case "reason":
+				s.Reason = value
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key '%s', expected table, column, pattern or reason", filename, entry.Lines[key], key)
+			}
+		}
+		if s.Table == "" && s.Column == "" && s.Pattern == "" {
+			return nil, fmt.Errorf("%s: entry %d needs a table, column or pattern", filename, i+1)
+		}
+		suppressions = append(suppressions, s)
+	}
+	return suppressions, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tapTest is one test point of TAP output
+type tapTest struct {
+	name        string
+	ok          bool
+	skip        string // reason the test was skipped, if it was
+	diagnostics []string
+}
+
+// tapTests turns a summary into test points: per table whether it exists
+// on both sides, and then its schema, row count and data, followed by one
+// for the other database objects. The test point a table's error is in
+// fails, those of a table that timed out are skipped, and after an
+// interrupt the tables not compared yet have no row count and data ones.
+func tapTests(summary ComparisonSummary) []tapTest {
+	var uncompared []string
+	if summary.Interrupted && summary.TotalTablesChecked < len(summary.CommonTables) {
+		uncompared = summary.CommonTables[summary.TotalTablesChecked:]
+	}
+
+	tables := append(append(append([]string{}, summary.CommonTables...), summary.MissingTables...), summary.ExtraTables...)
+	// Tables whose schema couldn't be read are in none of them
+	for table := range summary.SkippedTables {
+		if !contains(tables, table) {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	var tests []tapTest
+	for _, table := range tables {
+		switch {
+		case contains(summary.MissingTables, table):
+			tests = append(tests, tapTest{name: "table " + table + " exists", diagnostics: []string{"exists in source but not in target"}})
+			continue
+		case contains(summary.ExtraTables, table):
+			tests = append(tests, tapTest{name: "table " + table + " exists", diagnostics: []string{"exists in target but not in source"}})
+			continue
+		case !contains(summary.CommonTables, table):
+			reason := summary.SkippedTables[table]
+			schema := tapTest{name: "table " + table + " schema", ok: reason != "error"}
+			if schema.ok {
+				schema.skip = reason
+			}
+			for _, tableError := range summary.errorsOf(table) {
+				schema.diagnostics = append(schema.diagnostics, tableError.String(), tableError.Cause())
+			}
+			tests = append(tests, schema)
+			continue
+		}
+
+		schema := tapTest{name: "table " + table + " schema", ok: len(summary.SchemaDifferences[table]) == 0}
+		for _, diff := range summary.SchemaDifferences[table] {
+			schema.diagnostics = append(schema.diagnostics, diff.Message)
+		}
+		tests = append(tests, schema)
+		if summary.SchemaOnly || contains(uncompared, table) {
+			continue
+		}
+
+		skipped := summary.SkippedTables[table]
+		rowCount := tapTest{name: "table " + table + " rowcount", ok: true}
+		if counts, ok := summary.DifferentRowCounts[table]; ok {
+			rowCount.ok = false
+			rowCount.diagnostics = []string{rowCountDifference(table, counts.Source, counts.Target, summary.EstimatedRowCounts[table]).Message}
+		}
+		data := tapTest{name: "table " + table + " data", ok: true}
+		if skipped != "error" {
+			rowCount.skip, data.skip = skipped, skipped
+		}
+		if result, ok := summary.ChunkDifferences[table]; ok {
+			data.ok = false
+			data.diagnostics = append(data.diagnostics, chunkDifference(result).Message)
+		}
+		if result, ok := summary.RowDifferences[table]; ok {
+			data.ok = false
+			data.diagnostics = append(data.diagnostics, rowDifference(result).Message)
+		}
+		for _, diff := range summary.StatDifferences[table] {
+			data.ok = false
+			data.diagnostics = append(data.diagnostics, diff.Message)
+		}
+		for _, tableError := range summary.errorsOf(table) {
+			failed := &data
+			if tableError.Phase == "row counts" {
+				failed = &rowCount
+				if tableError.Reason == "error" {
+					data.skip = "row counts failed"
+				}
+			}
+			if tableError.Reason == "error" {
+				failed.ok = false
+			}
+			failed.diagnostics = append(failed.diagnostics, tableError.String(), tableError.Cause())
+		}
+		tests = append(tests, rowCount, data)
+	}
+
+	objects := tapTest{name: "other database objects", ok: len(summary.ObjectDifferences) == 0}
+	for _, diff := range summary.ObjectDifferences {
+		objects.diagnostics = append(objects.diagnostics, diff.Message)
+	}
+	tests = append(tests, objects)
+
+	// One test per failed check, the ones that passed aren't in the summary
+	for _, diff := range summary.CheckDifferences {
+		tests = append(tests, tapTest{name: "check " + diff.ObjectName, diagnostics: []string{diff.Message}})
+	}
+	return tests
+}
+
+// printTAP prints the summary of a run in the Test Anything Protocol,
+// after comments on what produced it, e.g.
+//
+//	# mudrockdbcompare v1.4.0 (commit 1a2b3c4d5e6f), 2026-10-16T08:00:00Z to 2026-10-16T08:01:12Z
+//	# options: {"RowDiff":false,...}
+//	1..3
+//	ok 1 - table users schema
+//	not ok 2 - table users rowcount
+//	# Table 'users' has different row counts: source=10, target=9
+//	ok 3 - table users data # SKIP timeout
+func printTAP(run Run) {
+	summary := *run.Summary
+	tests := tapTests(summary)
+	fmt.Printf("# %s, %s to %s\n", run.Version, run.Started.Format(time.RFC3339), run.Finished.Format(time.RFC3339))
+	if options, err := json.Marshal(run.Options); err == nil {
+		fmt.Printf("# options: %s\n", options)
+	}
+	if flags, err := json.Marshal(run.Flags); err == nil && len(run.Flags) > 0 {
+		fmt.Printf("# flags: %s\n", flags)
+	}
+	fmt.Printf("1..%d\n", len(tests))
+	for i, test := range tests {
+		status := "ok"
+		if !test.ok {
+			status = "not ok"
+		}
+		line := fmt.Sprintf("%s %d - %s", status, i+1, test.name)
+		if test.skip != "" {
+			line += " # SKIP " + test.skip
+		}
+		fmt.Println(line)
+		for _, diagnostic := range test.diagnostics {
+			fmt.Println("# " + strings.ReplaceAll(diagnostic, "\n", "\n# "))
+		}
+	}
+	if summary.Interrupted {
+		fmt.Println("Bail out! Interrupted, not all tables were compared")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The side a difference between source and target is attributed to, by
+// comparing both with their common base
+const (
+	ChangedInSource = "source" // the target still matches the base
+	ChangedInTarget = "target" // the source still matches the base
+	ChangedInBoth   = "both"   // neither matches the base, they diverged
+)
+
+// ThreeWayResult attributes the differences between source and target to
+// the side that changed since the base. Differences neither side has with
+// the base, e.g. within a tolerance of one but not the other, are left out.
+type ThreeWayResult struct {
+	Base   string                     // the base's host and database, or snapshot file
+	Schema []AttributedDifference     // tables and other objects
+	Rows   map[string][]AttributedRow // by table, of the row diffs
+}
+
+type AttributedDifference struct {
+	Difference
+	ChangedIn string
+}
+
+type AttributedRow struct {
+	RowDifference
+	ChangedIn string
+}
+
+// attribution returns the side that changed given which sides differ
+// from the base
+func attribution(sourceChanged, targetChanged bool) string {
+	switch {
+	case sourceChanged && targetChanged:
+		return ChangedInBoth
+	case sourceChanged:
+		return ChangedInSource
+	case targetChanged:
+		return ChangedInTarget
+	default:
+		return ""
+	}
+}
+
+// schemaDifferenceKey identifies the object and property a schema
+// difference is about, whichever side it's seen from
+func schemaDifferenceKey(d Difference) string {
+	return strings.Join([]string{d.ObjectType, d.Table, d.ObjectName, d.Property}, "\x00")
+}
+
+// schemaDifferences returns the differences of a summary's tables and
+// other objects, leaving out those of their data and the checks
+func (s ComparisonSummary) schemaDifferences() []Difference {
+	diffs := []Difference{}
+	for _, diff := range s.Differences() {
+		if diff.ObjectType != "data" && diff.ObjectType != "check" {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// openBase opens the base of a three-way comparison: a snapshot file, ending
+// in .json, which has a schema but no data, or a database of the source's
+// type, read like the source. It returns the base's adapter and database,
+// and whether it has data.
+func openBase(ctx context.Context, config string, adapter DatabaseAdapter, dbType string, prompt bool) (DatabaseAdapter, *sql.DB, bool, error) {
+	if strings.HasSuffix(config, ".json") {
+		snapshot, err := readSnapshot(config)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if snapshot.Type != dbType {
+			return nil, nil, false, fmt.Errorf("%s is a snapshot of a %s database, not %s", config, snapshot.Type, dbType)
+		}
+		snapshotAdapter := &SnapshotAdapter{Snapshot: snapshot}
+		db, err := snapshotAdapter.Connect(config)
+		return snapshotAdapter, db, false, err
+	}
+
+	config, err := resolveSecret(ctx, config)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	baseAdapter := cloneAdapter(adapter)
+	if isDumpFile(config) {
+		baseAdapter = &DumpAdapter{Dialect: dbType}
+	}
+	dsn, err := applyPassword(baseAdapter, baseAdapter.GetConnectStringFromURL(config), "Base", basePasswordEnv, prompt)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	db, err := connectReader(baseAdapter, dsn)
+	return baseAdapter, db, true, err
+}
+
+// AttributeChanges compares the base, read with baseAdapter from baseDB,
+// with the source and the target, and attributes the differences in the
+// summary to the side that changed since. The rows of the row diffs are
+// looked up in the base too, unless it has no data, like a snapshot.
+func (c *Comparison) AttributeChanges(ctx context.Context, summary *ComparisonSummary, baseAdapter DatabaseAdapter, baseDB *sql.DB, baseConnStr string, baseHasData bool) error {
+	ctx = withQueryTimeout(ctx, c.Options.QueryTimeout)
+	ctx = withThrottle(ctx, c.Options)
+	options := c.Options
+	options.WaitForReplica, options.Consistent, options.Reconcile = 0, false, false
+	options.Suppressions, options.Checks = nil, nil
+
+	// Each side is compared with the base by a comparison of its own, whose
+	// adapters read the rows as they are, without the digests and filters
+	// of this one
+	c.emit(Event{Type: EventPhase, Message: "Comparing the source and the target with the base..."})
+	sides := []*Comparison{
+		{TargetAdapter: cloneAdapter(c.Adapter), TargetDB: c.SourceDB, TargetConnStr: c.SourceConnStr},
+		{TargetAdapter: cloneAdapter(c.targetAdapter()), TargetDB: c.TargetDB, TargetConnStr: c.TargetConnStr},
+	}
+	summaries := make([]ComparisonSummary, len(sides))
+	for i, side := range sides {
+		side.Adapter, side.SourceDB, side.SourceConnStr = baseAdapter, baseDB, baseConnStr
+		side.Options, side.OnEvent = options, c.OnEvent
+		var err error
+		summaries[i], err = side.Introspect(ctx)
+		side.endSnapshots()
+		if err != nil {
+			return err
+		}
+	}
+
+	result := &ThreeWayResult{Base: databaseDescription(connectionInfo(baseConnStr)), Rows: make(map[string][]AttributedRow)}
+	changed := make([]map[string]bool, 2)
+	for i, side := range summaries {
+		changed[i] = make(map[string]bool)
+		for _, diff := range side.schemaDifferences() {
+			changed[i][schemaDifferenceKey(diff)] = true
+		}
+	}
+	for _, diff := range summary.schemaDifferences() {
+		key := schemaDifferenceKey(diff)
+		if side := attribution(changed[0][key], changed[1][key]); side != "" {
+			result.Schema = append(result.Schema, AttributedDifference{Difference: diff, ChangedIn: side})
+		}
+	}
+
+	if baseHasData {
+		tables := make([]string, 0, len(summary.RowDifferences))
+		for table := range summary.RowDifferences {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			rows, err := attributeRows(ctx, summary.RowDifferences[table], sides, summaries)
+			if err != nil {
+				c.emit(Event{Type: EventWarning, Table: table, Message: "Couldn't look up the differing rows of " + table + " in the base", Err: err})
+				continue
+			}
+			if len(rows) > 0 {
+				result.Rows[table] = rows
+			}
+		}
+	}
+	summary.ThreeWay = result
+	return nil
+}
+
+// attributeRows attributes the differing rows of a table by comparing
+// them on each side with the base's rows of the same keys, with the
+// comparisons of the base with each side and their summaries
+func attributeRows(ctx context.Context, result RowDiffResult, sides []*Comparison, summaries []ComparisonSummary) ([]AttributedRow, error) {
+	// Rows of tables without a primary key have no identity to look up
+	if result.NoPrimaryKey || result.SamplePercent > 0 || len(result.Rows) == 0 {
+		return nil, nil
+	}
+	baseSchema, ok := summaries[0].SourceSchemas[result.Table]
+	if !ok {
+		return nil, nil // created since the base
+	}
+
+	keys := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		keys[i] = row.PrimaryKey
+	}
+	changed := make([]map[string]bool, len(sides))
+	for i, side := range sides {
+		sideSchema, ok := summaries[i].TargetSchemas[result.Table]
+		if !ok {
+			continue
+		}
+		columns, err := rowComparisonColumns(baseSchema, sideSchema)
+		if err != nil {
+			return nil, err
+		}
+		keyIndexes := make([]int, len(result.PrimaryKey))
+		for j, pk := range result.PrimaryKey {
+			if keyIndexes[j] = indexOf(columns, pk); keyIndexes[j] < 0 {
+				return nil, fmt.Errorf("the base has no column %s", pk)
+			}
+		}
+
+		base, err := readKeyedRows(ctx, side.Adapter, side.SourceDB, result.Table, columns, result.PrimaryKey, keys)
+		if err != nil {
+			return nil, fmt.Errorf("base: %w", err)
+		}
+		rows, err := readKeyedRows(ctx, side.TargetAdapter, side.TargetDB, result.Table, columns, result.PrimaryKey, keys)
+		if err != nil {
+			base.close()
+			return nil, err
+		}
+		diff := RowDiffResult{Table: result.Table, PrimaryKey: result.PrimaryKey}
+		err = mergeRows(base, rows, columns, keyIndexes, valueColumns(columns, baseSchema, sideSchema, side.Options), &diff, nil)
+		base.close()
+		rows.close()
+		if err != nil {
+			return nil, err
+		}
+		changed[i] = make(map[string]bool)
+		for _, row := range diff.Rows {
+			changed[i][strings.Join(row.PrimaryKey, "\x00")] = true
+		}
+	}
+
+	var attributed []AttributedRow
+	for _, row := range result.Rows {
+		key := strings.Join(row.PrimaryKey, "\x00")
+		if side := attribution(changed[0][key], changed[1][key]); side != "" {
+			attributed = append(attributed, AttributedRow{RowDifference: row, ChangedIn: side})
+		}
+	}
+	return attributed, nil
+}
+
+// printThreeWay prints the differences attributed to each side
+func printThreeWay(summary ComparisonSummary) {
+	result := summary.ThreeWay
+	fmt.Println("\n=== Changes Since the Base ===")
+	fmt.Printf("Base: %s\n", result.Base)
+	if len(result.Schema) == 0 && len(result.Rows) == 0 {
+		fmt.Println("No differences could be attributed.")
+		return
+	}
+
+	for _, side := range []struct{ changedIn, title string }{
+		{ChangedInSource, "Changed only in the source:"},
+		{ChangedInTarget, "Changed only in the target:"},
+		{ChangedInBoth, "Changed in both (diverged):"},
+	} {
+		var lines []string
+		for _, diff := range result.Schema {
+			if diff.ChangedIn == side.changedIn {
+				lines = append(lines, "- "+diff.Message)
+			}
+		}
+		tables := make([]string, 0, len(result.Rows))
+		for table := range result.Rows {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			rowResult := summary.RowDifferences[table]
+			for _, row := range result.Rows[table] {
+				if row.ChangedIn == side.changedIn {
+					lines = append(lines, fmt.Sprintf("- %s %s: %s", table, rowResult.keyString(row.RowDifference), row.Kind))
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		color := colorYellow
+		if side.changedIn == ChangedInBoth {
+			color = colorRed
+		}
+		fmt.Println(side.title)
+		for _, line := range lines {
+			fmt.Println(colored(color, line))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type throttleKey struct{}
+
+// throttle bounds the load a comparison puts on the databases
+type throttle struct {
+	maxQPS float64       // statements per second sent to each database, zero for no limit
+	pause  time.Duration // after each chunk, batch of chunk checksums and page of rows
+}
+
+// withThrottle makes every statement executed with ctx wait for its turn
+// to keep each database under the options' MaxQPS and the load monitorLoad
+// watches, and pauseAfterChunk wait their SleepBetweenChunks
+func withThrottle(ctx context.Context, options CompareOptions) context.Context {
+	return context.WithValue(ctx, throttleKey{}, throttle{maxQPS: options.MaxQPS, pause: options.SleepBetweenChunks})
+}
+
+// The pressure monitorLoad finds a database server under
+const (
+	loadNormal    = iota
+	loadHigh      // over three quarters of a limit, statements are slowed down
+	loadOverLimit // statements wait for the load to fall back under the limits
+)
+
+// loadCheckInterval is how often monitorLoad checks the load, and
+// loadSlowdown the time between the statements of a database under high
+// load
+const (
+	loadCheckInterval = 5 * time.Second
+	loadSlowdown      = time.Second
+)
+
+// wait waits until a statement of the session's pool may run under the
+// throttle carried by ctx, if any: while the server is over the load
+// limits, and then spaced to stay under the max QPS, or slowed down under
+// high load. The statements are spaced evenly rather than sent in bursts,
+// whichever comparison or connection of the pool runs them.
+func (s *session) wait(ctx context.Context) error {
+	t, ok := ctx.Value(throttleKey{}).(throttle)
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	for s.pressure == loadOverLimit {
+		resumed := s.resumed
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+		s.mu.Lock()
+	}
+	var interval time.Duration
+	if t.maxQPS > 0 {
+		interval = time.Duration(float64(time.Second) / t.maxQPS)
+	}
+	if s.pressure == loadHigh {
+		interval = max(interval, loadSlowdown)
+	}
+	now := time.Now()
+	at := s.nextStatement
+	if at.Before(now) {
+		at = now
+	}
+	s.nextStatement = at.Add(interval)
+	s.mu.Unlock()
+	return sleepContext(ctx, at.Sub(now))
+}
+
+// setPressure sets the load the session's statements wait for
+func (s *session) setPressure(pressure int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pressure == s.pressure {
+		return
+	}
+	if pressure == loadOverLimit {
+		s.resumed = make(chan struct{})
+	} else if s.pressure == loadOverLimit {
+		close(s.resumed)
+	}
+	s.pressure = pressure
+}
+
+// pressure returns how close load is to the limits of the options
+func (o CompareOptions) pressure(load ServerLoad) int {
+	over := func(value, limit float64) bool { return limit > 0 && value > limit }
+	lag := float64(load.ReplicaLag)
+	if !load.Replica {
+		lag = 0
+	}
+	sessions, maxSessions, maxLag := float64(load.ActiveSessions), float64(o.MaxActiveSessions), float64(o.MaxReplicaLag)
+	switch {
+	case over(sessions, maxSessions) || over(lag, maxLag):
+		return loadOverLimit
+	case over(sessions, maxSessions*3/4) || over(lag, maxLag*3/4):
+		return loadHigh
+	default:
+		return loadNormal
+	}
+}
+
+// monitorLoad checks the load of the servers being compared every
+// loadCheckInterval until the returned function is called, and slows
+// down their statements under high load or holds them back while over
+// the limits of the options. Servers whose load can't be checked aren't
+// monitored. Its queries are run with ctx, which must not be throttled.
+func (c *Comparison) monitorLoad(ctx context.Context) func() {
+	if c.Options.MaxActiveSessions <= 0 && c.Options.MaxReplicaLag <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	sides := []struct {
+		name    string
+		adapter DatabaseAdapter
+		db      *sql.DB
+	}{{"source", c.Adapter, c.SourceDB}}
+	if c.TargetDB != c.SourceDB {
+		sides = append(sides, struct {
+			name    string
+			adapter DatabaseAdapter
+			db      *sql.DB
+		}{"target", c.targetAdapter(), c.TargetDB})
+	}
+	monitors := 0
+	for _, side := range sides {
+		s, err := poolSession(side.db)
+		if !side.adapter.Capabilities().ServerLoad || err != nil {
+			continue
+		}
+		monitors++
+		go func() {
+			defer func() { done <- struct{}{} }()
+			defer s.setPressure(loadNormal)
+			pressure := loadNormal
+			for {
+				load, err := side.adapter.ServerLoad(ctx, side.db)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					slog.Warn("Couldn't check the load, not adapting to it", "database", side.name, "error", err)
+					return
+				}
+
+				previous := pressure
+				pressure = c.Options.pressure(load)
+				attrs := []any{"database", side.name, "active_sessions", load.ActiveSessions}
+				if load.Replica {
+					attrs = append(attrs, "replica_lag", load.ReplicaLag.String())
+				}
+				switch {
+				case pressure == loadOverLimit && previous != loadOverLimit:
+					slog.Warn("The server is over the load limits, pausing until it recovers", attrs...)
+				case pressure == loadHigh && previous == loadNormal:
+					slog.Info(fmt.Sprintf("The server is under high load, slowing down to a statement per %s", loadSlowdown), attrs...)
+				case pressure < previous:
+					slog.Info("The server's load went down, resuming", attrs...)
+				}
+				s.setPressure(pressure)
+
+				if sleepContext(ctx, loadCheckInterval) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	return func() {
+		cancel()
+		for range monitors {
+			<-done
+		}
+	}
+}
+
+// pauseAfterChunk waits the pause between chunks carried by ctx, if any
+func pauseAfterChunk(ctx context.Context) error {
+	t, _ := ctx.Value(throttleKey{}).(throttle)
+	return sleepContext(ctx, t.pause)
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// tuiLine is a line of the detail pane. Lines with a difference can be
+// acknowledged, the others are details of the difference above them.
+type tuiLine struct {
+	text string
+	diff *Difference
+}
+
+// tuiTable is an entry of the table pane with its differences
+type tuiTable struct {
+	name  string
+	lines []tuiLine
+}
+
+// tui browses the differences of a summary on the terminal: a pane listing
+// the tables with differences and one with the selected table's details.
+// Differences acknowledged in it are added to a suppressions file on exit.
+type tui struct {
+	tables       []tuiTable
+	filter       string
+	filtering    bool  // typing a filter
+	visible      []int // indexes of the tables matching the filter
+	table, line  int   // selected, in visible and the table's lines
+	tableTop     int   // first visible row of each pane
+	lineTop      int
+	detailPane   bool // the detail pane has the focus
+	acknowledged map[*Difference]bool
+	width        int
+	height       int
+}
+
+func newTUI(summary ComparisonSummary) *tui {
+	t := &tui{acknowledged: make(map[*Difference]bool)}
+	for _, diff := range summary.Differences() {
+		diff := diff
+		name := diff.Table
+		if name == "" {
+			name = "(other objects)"
+		}
+		if len(t.tables) == 0 || t.tables[len(t.tables)-1].name != name {
+			t.tables = append(t.tables, tuiTable{name: name})
+		}
+		table := &t.tables[len(t.tables)-1]
+		table.lines = append(table.lines, tuiLine{text: diff.Message, diff: &diff})
+
+		// Row and chunk details of data differences
+		switch diff.Property {
+		case "rows":
+			result := summary.RowDifferences[diff.Table]
+			for _, row := range result.Rows {
+				text := fmt.Sprintf("    %-8s %s", row.Kind, result.keyString(row))
+				if row.Kind == RowChanged {
+					text += fmt.Sprintf(" (columns: %s)", strings.Join(row.Columns, ", "))
+				}
+				table.lines = append(table.lines, tuiLine{text: text})
+				for _, value := range row.sideBySide(30) {
+					table.lines = append(table.lines, tuiLine{text: "             " + value})
+				}
+			}
+		case "chunks":
+			result := summary.ChunkDifferences[diff.Table]
+			for _, chunk := range result.DifferentChunks {
+				table.lines = append(table.lines, tuiLine{text: fmt.Sprintf("    chunk %d %s", chunk.Index, result.rangeString(chunk))})
+			}
+		}
+	}
+	for table, reason := range summary.SkippedTables {
+		lines := []tuiLine{{text: "Skipped (" + reason + ")"}}
+		for _, tableError := range summary.errorsOf(table) {
+			lines = append(lines, tuiLine{text: "  " + tableError.String()}, tuiLine{text: "    " + tableError.Cause()})
+		}
+		t.tables = append(t.tables, tuiTable{name: table, lines: lines})
+	}
+	sort.SliceStable(t.tables, func(i, j int) bool { return t.tables[i].name < t.tables[j].name })
+	t.applyFilter()
+	return t
+}
+
+// applyFilter shows the tables whose name or differences contain the filter
+func (t *tui) applyFilter() {
+	t.visible = t.visible[:0]
+	filter := strings.ToLower(t.filter)
+	for i, table := range t.tables {
+		match := strings.Contains(strings.ToLower(table.name), filter)
+		for _, line := range table.lines {
+			match = match || strings.Contains(strings.ToLower(line.text), filter)
+		}
+		if match {
+			t.visible = append(t.visible, i)
+		}
+	}
+	t.table, t.line, t.tableTop, t.lineTop = 0, 0, 0, 0
+}
+
+func (t *tui) selected() *tuiTable {
+	if len(t.visible) == 0 {
+		return nil
+	}
+	return &t.tables[t.visible[t.table]]
+}
+
+// run shows the TUI until the user quits
+func (t *tui) run() error {
+	fd := int(os.Stdin.Fd())
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("the terminal can't be put in raw mode: %w", err)
+	}
+	// Alternate screen, hidden cursor
+	fmt.Print("\033[?1049h\033[?25l")
+	defer func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		term.Restore(fd, saved)
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		t.render()
+		key, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		if !t.handle(key) {
+			return nil
+		}
+	}
+}
+
+// readKey reads a key press, naming the special keys
+func readKey(in *bufio.Reader) (string, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case 3:
+		return "ctrl-c", nil
+	case '\r', '\n':
+		return "enter", nil
+	case '\t':
+		return "tab", nil
+	case 127, 8:
+		return "backspace", nil
+	case 27:
+		if in.Buffered() == 0 {
+			return "esc", nil
+		}
+		seq := []byte{}
+		for in.Buffered() > 0 {
+			b, _ := in.ReadByte()
+			seq = append(seq, b)
+			if len(seq) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+				break
+			}
+		}
+		switch string(seq) {
+		case "[A", "OA":
+			return "up", nil
+		case "[B", "OB":
+			return "down", nil
+		case "[C", "OC":
+			return "right", nil
+		case "[D", "OD":
+			return "left", nil
+		case "[5~":
+			return "pgup", nil
+		case "[6~":
+			return "pgdown", nil
+		}
+		return "", nil
+	}
+	return string(r), nil
+}
+
+// handle acts on a key, returning false to quit
+func (t *tui) handle(key string) bool {
+	if t.filtering {
+		switch key {
+		case "enter":
+			t.filtering = false
+		case "esc", "ctrl-c":
+			t.filtering = false
+			t.filter = ""
+		case "backspace":
+			if t.filter != "" {
+				_, size := utf8.DecodeLastRuneInString(t.filter)
+				t.filter = t.filter[:len(t.filter)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				t.filter += key
+			}
+		}
+		t.applyFilter()
+		return true
+	}
+
+	page := max(t.height-4, 1)
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case "tab", "left", "right", "h", "l":
+		t.detailPane = !t.detailPane && t.selected() != nil
+	case "up", "k":
+		t.move(-1)
+	case "down", "j":
+		t.move(1)
+	case "pgup":
+		t.move(-page)
+	case "pgdown":
+		t.move(page)
+	case "/":
+		t.filtering = true
+		t.filter = ""
+		t.detailPane = false
+	case "esc":
+		t.filter = ""
+		t.applyFilter()
+	case "a", " ":
+		if table := t.selected(); t.detailPane && table != nil {
+			if diff := table.lines[t.line].diff; diff != nil {
+				t.acknowledged[diff] = !t.acknowledged[diff]
+			}
+		}
+	}
+	return true
+}
+
+func (t *tui) move(delta int) {
+	if t.detailPane {
+		if table := t.selected(); table != nil {
+			t.line = min(max(t.line+delta, 0), len(table.lines)-1)
+		}
+		return
+	}
+	t.table = min(max(t.table+delta, 0), max(len(t.visible)-1, 0))
+	t.line, t.lineTop = 0, 0
+}
+
+// scroll returns the first row to show of a pane of rows rows so that
+// selected is visible
+func scroll(top, selected, rows int) int {
+	if selected < top {
+		return selected
+	}
+	if selected >= top+rows {
+		return selected - rows + 1
+	}
+	return top
+}
+
+// fit cuts or pads s to width columns
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) > width {
+		runes := []rune(s)
+		return string(runes[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+func (t *tui) render() {
+	t.width, t.height = 80, 24
+	if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 && height > 0 {
+		t.width, t.height = width, height
+	}
+	rows := max(t.height-3, 1)
+	leftWidth := min(max(t.width/4, 16), 40)
+	rightWidth := t.width - leftWidth - 3
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	acknowledged := 0
+	for _, ok := range t.acknowledged {
+		if ok {
+			acknowledged++
+		}
+	}
+	header := fmt.Sprintf(" %d tables, %d differences acknowledged", len(t.tables), acknowledged)
+	if t.filter != "" {
+		header += fmt.Sprintf(", %d matching '%s'", len(t.visible), t.filter)
+	}
+	b.WriteString("\033[7m" + fit(header, t.width) + "\033[0m\r\n")
+
+	t.tableTop = scroll(t.tableTop, t.table, rows)
+	table := t.selected()
+	if table != nil {
+		t.lineTop = scroll(t.lineTop, t.line, rows)
+	}
+	for row := 0; row < rows; row++ {
+		left := ""
+		if i := t.tableTop + row; i < len(t.visible) {
+			left = fit(" "+t.tables[t.visible[i]].name, leftWidth)
+			if i == t.table {
+				if t.detailPane {
+					left = "\033[1m" + left + "\033[0m"
+				} else {
+					left = "\033[7m" + left + "\033[0m"
+				}
+			}
+		} else {
+			left = fit("", leftWidth)
+		}
+
+		right := ""
+		if table != nil {
+			if i := t.lineTop + row; i < len(table.lines) {
+				line := table.lines[i]
+				prefix := "    "
+				if line.diff != nil {
+					prefix = "[ ] "
+					if t.acknowledged[line.diff] {
+						prefix = "[x] "
+					}
+				}
+				right = fit(prefix+line.text, rightWidth)
+				switch {
+				case t.detailPane && i == t.line:
+					right = "\033[7m" + right + "\033[0m"
+				case line.diff != nil && !t.acknowledged[line.diff]:
+					right = colored(kindColor(line.diff.Kind), right)
+				}
+			}
+		}
+		b.WriteString(left + " │ " + right + "\r\n")
+	}
+
+	status := " ↑↓ move  tab switch pane  / filter  a acknowledge  q quit"
+	if t.filtering {
+		status = " filter: " + t.filter + "▏ (enter to apply, esc to clear)"
+	}
+	b.WriteString("\033[7m" + fit(status, t.width) + "\033[0m")
+	fmt.Print(b.String())
+}
+
+// defaultAcknowledgeFile is where differences acknowledged in the TUI are
+// added when there's no --suppress file
+const defaultAcknowledgeFile = "mudrockdbcompare-suppress.yaml"
+
+// browseDifferences runs the TUI and adds the differences acknowledged in
+// it to the suppressions file
+func browseDifferences(summary ComparisonSummary, suppressFile string) {
+	t := newTUI(summary)
+	if err := t.run(); err != nil {
+		fatal("Failed to run the TUI", err)
+	}
+	suppressions := t.acknowledgedSuppressions()
+	if len(suppressions) == 0 {
+		return
+	}
+	if err := appendSuppressions(suppressFile, suppressions); err != nil {
+		fatal("Failed to save the acknowledged differences", err)
+	}
+	fmt.Printf("Acknowledged %d differences in %s, use --suppress %s to leave them out of later comparisons\n",
+		len(suppressions), suppressFile, suppressFile)
+}
+
+// acknowledgedSuppressions returns a suppression for each acknowledged
+// difference: of its column for the differences of a column, else of its
+// table, or its object's message, with the numbers in it matching any
+// number so that the difference stays acknowledged when they change
+func (t *tui) acknowledgedSuppressions() []Suppression {
+	var suppressions []Suppression
+	reason := "acknowledged on " + time.Now().Format("2006-01-02")
+	for _, table := range t.tables {
+		for _, line := range table.lines {
+			if line.diff == nil || !t.acknowledged[line.diff] {
+				continue
+			}
+			diff := line.diff
+			suppression := Suppression{Table: globEscape.Replace(diff.Table), Reason: reason}
+			if diff.ObjectType == "column" || (diff.ObjectType == "data" && diff.ObjectName != "") {
+				suppression.Column = globEscape.Replace(diff.ObjectName)
+			} else {
+				suppression.Pattern = "^" + anyNumber.ReplaceAllString(regexp.QuoteMeta(diff.Message), `[0-9.]+`) + "$"
+			}
+			if !slices.Contains(suppressions, suppression) {
+				suppressions = append(suppressions, suppression)
+			}
+		}
+	}
+	return suppressions
+}
+
+// anyNumber matches the numbers of a message, e.g. of row counts
+var anyNumber = regexp.MustCompile(`[0-9]+(\\\.[0-9]+)?`)
+
+// globEscape escapes the characters path.Match treats specially
+var globEscape = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// appendSuppressions adds suppressions to the end of a suppressions file,
+// creating it if it doesn't exist
+func appendSuppressions(filename string, suppressions []Suppression) error {
+	var b strings.Builder
+	existing, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || (err == nil && len(strings.TrimSpace(string(existing))) == 0):
+		b.WriteString("suppress:\n")
+	case err != nil:
+		return err
+	case len(existing) > 0 && existing[len(existing)-1] != '\n':
+		b.WriteString("\n")
+	}
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	for _, s := range suppressions {
+		key := "-"
+		for _, field := range []struct{ name, value string }{{"table", s.Table}, {"column", s.Column}, {"pattern", s.Pattern}, {"reason", s.Reason}} {
+			if field.value != "" {
+				fmt.Fprintf(&b, "  %s %s: %s\n", key, field.name, quote(field.value))
+				key = " "
+			}
+		}
+	}
+	return appendFile(filename, b.String())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// TypeEquivalence is a pair of column types that compare as equal, one on
+// either side. Type and Equals are matched case-insensitively against the
+// data types: as glob patterns with * and ?, e.g. "varchar(*)", and as they
+// are otherwise. Brackets are always literal, as in "integer[]".
+type TypeEquivalence struct {
+	Type   string
+	Equals string
+}
+
+func (e TypeEquivalence) matches(a, b string) bool {
+	match := func(pattern, dataType string) bool {
+		dataType = strings.TrimSpace(dataType)
+		if !strings.ContainsAny(pattern, "*?") {
+			return strings.EqualFold(pattern, dataType)
+		}
+		pattern = strings.NewReplacer(`\`, `\\`, `[`, `\[`).Replace(strings.ToLower(pattern))
+		ok, _ := path.Match(pattern, strings.ToLower(dataType))
+		return ok
+	}
+	return (match(e.Type, a) && match(e.Equals, b)) || (match(e.Type, b) && match(e.Equals, a))
+}
+
+// equivalentTypes reports whether two differing column types are made equal
+// by one of the equivalences
+func equivalentTypes(equivalences []TypeEquivalence, sourceType, targetType string) bool {
+	for _, e := range equivalences {
+		if e.matches(sourceType, targetType) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTypeEquivalences reads a type equivalences file. It is YAML, limited
+// to a list of entries with the keys type and equals, optionally under a
+// top-level "type-equivalences" key:
+//
+//	type-equivalences:
+//	  - type: tinyint(1)
+//	    equals: boolean
+//	  - type: timestamp with time zone
+//	    equals: datetime*
+func loadTypeEquivalences(filename string) ([]TypeEquivalence, error) {
+	entries, err := readYAMLList(filename, "type-equivalences")
+	if err != nil {
+		return nil, err
+	}
+
+	equivalences := []TypeEquivalence{}
+	for i, entry := range entries {
+		var e TypeEquivalence
+		for _, key := range entry.Keys {
+			value := entry.Fields[key]
+			switch key {
+			case "type":
+				e.Type = value
+			case "equals":
+				e.Equals = value
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key '%s', expected type or equals", filename, entry.Lines[key], key)
+			}
+		}
+		if e.Type == "" || e.Equals == "" {
+			return nil, fmt.Errorf("%s: entry %d needs a type and equals", filename, i+1)
+		}
+		equivalences = append(equivalences, e)
+	}
+	return equivalences, nil
+}
@@ -1,19 +1,220 @@
 package main
 
-import "database/sql"
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+)
 
 type DatabaseInfo struct {
 	Host         string
 	DatabaseName string
 	TableCount   int
 	TotalSize    int64 // in bytes
+
+	// How far the database was in its replication stream when the
+	// comparison started, see DatabaseAdapter.ReplicationPosition
+	ReplicationPosition string `json:",omitempty"`
+
+	// The sizes of the tables, largest first, where the database reports them
+	Tables []TableInfo `json:",omitempty"`
+}
+
+// TableInfo is the size of a table as the database reports it
+type TableInfo struct {
+	Name          string
+	DataSize      int64 // in bytes
+	IndexSize     int64 // in bytes
+	EstimatedRows int64 // from the database's statistics, -1 if it has none
+}
+
+func (t TableInfo) String() string {
+	s := fmt.Sprintf("%s (data %s, indexes %s)", formatSize(t.DataSize+t.IndexSize), formatSize(t.DataSize), formatSize(t.IndexSize))
+	if t.EstimatedRows >= 0 {
+		s += fmt.Sprintf(", ~%d rows", t.EstimatedRows)
+	}
+	return s
+}
+
+// table returns the size of a table, if it's known
+func (info DatabaseInfo) table(name string) (TableInfo, bool) {
+	for _, table := range info.Tables {
+		if table.Name == name {
+			return table, true
+		}
+	}
+	return TableInfo{}, false
 }
 
 type ComparisonSummary struct {
+	SourceInfo         DatabaseInfo
+	TargetInfo         DatabaseInfo
+	SourceSchemas      map[string]TableSchema `json:"-"`
+	TargetSchemas      map[string]TableSchema `json:"-"`
+	MissingTables      []string
+	ExtraTables        []string
+	CommonTables       []string
+	SchemaDifferences  map[string][]Difference
 	DifferentTables    []string
 	DifferentRowCounts map[string]struct{ Source, Target int }
+	EstimatedRowCounts map[string]bool `json:",omitempty"` // tables whose different row counts are estimates, see CompareOptions.ApproxCounts
+	RowDifferences     map[string]RowDiffResult
+	ChunkDifferences   map[string]ChunkResult
+	StatDifferences    map[string][]Difference // column stats and distributions that differ
+	CheckDifferences   []Difference            // custom SQL checks whose results differ
+	ObjectDifferences  []Difference            // views, routines, sequences, types, privileges and other objects that aren't tables
+	SkippedTables      map[string]string       // table -> reason it wasn't compared
+	Errors             []TableError            `json:",omitempty"` // the errors that made it skip tables
+	Reconciliation     *Reconciliation         `json:"-"`          // set when CompareOptions.Reconcile is
+	Suppressed         []SuppressedDifference
+	ThreeWay           *ThreeWayResult `json:",omitempty"` // set when compared with a base
 	TotalTablesChecked int
 	SchemaOnly         bool
+	Interrupted        bool
+}
+
+// TableError is an error that made the comparison skip a table, instead of
+// stopping it
+type TableError struct {
+	Table    string
+	Reason   string // as in SkippedTables: "timeout" or "error"
+	Phase    string `json:",omitempty"` // the step that failed: "schema", "row counts", "column stats", "distributions", "sample", "chunk checksums", "checksums" or "rows"
+	Side     string `json:",omitempty"` // "source" or "target" when only that database failed
+	Error    string
+	SQLError string `json:",omitempty"` // the error as the database returned it, if it did
+}
+
+// String describes where the comparison of the table failed, e.g.
+// "orders: skipped (timeout) while reading the target's row counts"
+func (e TableError) String() string {
+	s := fmt.Sprintf("%s: skipped (%s)", e.Table, e.Reason)
+	switch {
+	case e.Phase != "" && e.Side != "":
+		s += fmt.Sprintf(" while reading the %s's %s", e.Side, e.Phase)
+	case e.Phase != "":
+		s += " while reading the " + e.Phase
+	}
+	return s
+}
+
+// Cause is the error as the database returned it if it did, or as the
+// comparison ran into it
+func (e TableError) Cause() string {
+	return cmp.Or(e.SQLError, e.Error)
+}
+
+// Difference kinds, named like missingTables/extraTables in compareDatabases
+const (
+	DiffMissing  = "missing"  // object exists in source but not in target
+	DiffExtra    = "extra"    // object exists in target but not in source
+	DiffModified = "modified" // object exists in both with different properties
+)
+
+// Difference describes one schema difference between source and target
+type Difference struct {
+	Table      string
+	ObjectType string // "table", "column", "primary key", "unique constraint", "index", "foreign key", "view", "procedure", "function", "sequence", "enum", "domain" or "privilege"
+	ObjectName string
+	Kind       string
+	Property   string // the property that differs, for modified objects
+	Source     string
+	Target     string
+	Message    string
+}
+
+func (d Difference) String() string {
+	return d.Message
+}
+
+// ChecksumResult is the outcome of comparing whole-table checksums
+type ChecksumResult struct {
+	Table          string
+	Method         string // what was compared, e.g. "checksum" or "hash"
+	SourceChecksum sql.NullString
+	TargetChecksum sql.NullString
+	Different      bool
+}
+
+// Row difference kinds, seen from the target's point of view
+const (
+	RowInserted = "inserted" // row exists in target but not in source
+	RowDeleted  = "deleted"  // row exists in source but not in target
+	RowChanged  = "changed"  // row exists in both with different values
+)
+
+type RowDifference struct {
+	Kind       string
+	PrimaryKey []string // formatted values, in TableSchema.PrimaryKeys order
+	Columns    []string // differing columns, only set for changed rows
+
+	// Formatted values of the differing columns on each side, in Columns
+	// order, cut at maxValueLength
+	Source []string `json:",omitempty"`
+	Target []string `json:",omitempty"`
+
+	// Copies of the row missing from the other side, when more than one,
+	// for tables without a primary key
+	Copies int `json:",omitempty"`
+}
+
+type RowDiffResult struct {
+	Table      string
+	PrimaryKey []string
+	Inserted   int
+	Deleted    int
+	Changed    int
+	Rows       []RowDifference // capped at maxRowDifferences
+
+	// Rows compared, counting a row on both sides once
+	Compared int
+	// Set when only a sample of this percentage of the rows was compared
+	SamplePercent float64 `json:",omitempty"`
+	// Set when the table has no primary key. Its rows were compared as
+	// multisets of whole rows, PrimaryKey holding every column, so a
+	// changed row is reported as deleted and inserted.
+	NoPrimaryKey bool `json:",omitempty"`
+}
+
+type ViewSchema struct {
+	Name       string
+	Definition string
+}
+
+type RoutineSchema struct {
+	Name       string // includes the argument types where overloading is possible
+	Type       string // "procedure" or "function"
+	Definition string
+}
+
+// SequenceSchema is a sequence, or the auto-increment counter of Table
+type SequenceSchema struct {
+	Name         string
+	Table        string // set for auto-increment counters
+	Increment    int64
+	MinValue     int64
+	MaxValue     int64
+	CurrentValue int64 // the last value handed out
+}
+
+// TypeSchema is a user-defined PostgreSQL enum or domain type
+type TypeSchema struct {
+	Name string
+	Kind string // "enum" or "domain"
+
+	Labels []string // enum labels in sort order
+
+	BaseType    string // the rest describes domains
+	NotNull     bool
+	Default     string
+	Constraints string // CHECK constraints, ordered by name
+}
+
+// PrivilegeSchema is a privilege granted on a table, or on the whole
+// database when Table is empty
+type PrivilegeSchema struct {
+	Grantee   string
+	Table     string
+	Privilege string
 }
 
 type TableSchema struct {
@@ -22,6 +223,20 @@ type TableSchema struct {
 	Indexes     []IndexSchema
 	ForeignKeys []ForeignKeySchema
 	PrimaryKeys []string
+
+	Options TableOptions
+
+	// Unique constraints, whose backing indexes are left out of Indexes
+	// when comparing
+	UniqueConstraints []UniqueConstraintSchema
+}
+
+// TableOptions are storage options of MySQL tables, empty for other databases
+type TableOptions struct {
+	Engine    string
+	Charset   string
+	Collation string
+	RowFormat string
 }
 
 type ColumnSchema struct {
@@ -31,6 +246,17 @@ type ColumnSchema struct {
 	Key      string
 	Default  sql.NullString
 	Extra    string
+
+	// The expression of a MySQL generated column, empty for other columns
+	Generated string
+
+	// Empty for non-text columns and databases that don't report them
+	Charset   string
+	Collation string
+
+	// Values are generated by the database: MySQL AUTO_INCREMENT, Postgres
+	// serial or identity columns and SQLite INTEGER PRIMARY KEY
+	AutoIdentity bool
 }
 
 type IndexSchema struct {
@@ -39,9 +265,35 @@ type IndexSchema struct {
 	NonUnique  int
 }
 
+type UniqueConstraintSchema struct {
+	Name    string
+	Columns []string
+	Index   string // the index enforcing the constraint
+}
+
 type ForeignKeySchema struct {
 	Name             string
 	ColumnName       string
 	ReferencedTable  string
 	ReferencedColumn string
 }
+
+// Chunk is the primary key range (Lower, Upper] of a table. A nil bound is
+// open, so the zero Chunk covers the whole table.
+type Chunk struct {
+	Index int
+	Lower []interface{}
+	Upper []interface{}
+}
+
+type ChunkChecksum struct {
+	Rows int
+	Hash string
+}
+
+type ChunkResult struct {
+	Table           string
+	PrimaryKey      []string
+	TotalChunks     int
+	DifferentChunks []Chunk
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// writeRow writes a row's values to a hash, length-prefixed so column
+// boundaries and NULLs are unambiguous
+func writeRow(w io.Writer, values []interface{}) {
+	for _, value := range values {
+		if value == nil {
+			io.WriteString(w, "-1:")
+		} else {
+			text := formatValue(value)
+			fmt.Fprintf(w, "%d:%s", len(text), text)
+		}
+	}
+}
+
+// rowHash identifies a row of a table without a primary key by all its values
+type rowHash [md5.Size]byte
+
+func hashRow(values []interface{}) rowHash {
+	h := md5.New()
+	writeRow(h, values)
+	var sum rowHash
+	h.Sum(sum[:0])
+	return sum
+}
+
+// countRowHashes adds sign to the count of the hash of every row of a
+// table and returns the number of rows read
+func countRowHashes(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName string, columns []string, values []columnValues, counts *hashCounts, sign int) (int, error) {
+	rows, err := adapter.StreamRows(ctx, db, tableName, columns, nil, Chunk{}, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cursor := &rowCursor{rows: rows, values: make([]interface{}, len(columns))}
+	read := 0
+	for {
+		ok, err := cursor.next()
+		if err != nil {
+			return read, err
+		}
+		if !ok {
+			return read, nil
+		}
+		read++
+		if err := counts.add(hashRow(normalizeRow(cursor.values, values)), sign); err != nil {
+			return read, err
+		}
+	}
+}
+
+// findRows reads a table again for the values of the rows with the wanted
+// hashes, formatted and cut at maxValueLength
+func findRows(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tableName string, columns []string, values []columnValues, wanted map[rowHash][]string) error {
+	rows, err := adapter.StreamRows(ctx, db, tableName, columns, nil, Chunk{}, 0)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cursor := &rowCursor{rows: rows, values: make([]interface{}, len(columns))}
+	for missing := len(wanted); missing > 0; {
+		ok, err := cursor.next()
+		if !ok || err != nil {
+			return err
+		}
+		hash := hashRow(normalizeRow(cursor.values, values))
+		if row, ok := wanted[hash]; ok && row == nil {
+			wanted[hash] = formatKey(cursor.values, indexes(len(columns)))
+			for i, value := range wanted[hash] {
+				wanted[hash][i] = truncate(value, maxValueLength)
+			}
+			missing--
+		}
+	}
+	return nil
+}
+
+func indexes(n int) []int {
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// compareUnkeyedRows compares the rows of a table without a primary key as
+// multisets: it counts the hashes of the whole rows on both sides, so a row
+// the source has twice and the target once is one deleted row. Rows can't
+// be matched up, a changed row is a deleted and an inserted one. The
+// counts are held in memory, one per distinct row, up to the options'
+// MaxMemory, and spilled to temporary files past it. Values are normalized
+// before hashing, but tolerances can't apply.
+func compareUnkeyedRows(ctx context.Context, sourceAdapter, targetAdapter DatabaseAdapter, sourceDB, targetDB *sql.DB, sourceSchema, targetSchema TableSchema, options CompareOptions) (RowDiffResult, error) {
+	columns := commonColumns(sourceSchema, targetSchema)
+	values := valueColumns(columns, sourceSchema, targetSchema, options)
+	result := RowDiffResult{Table: sourceSchema.Name, PrimaryKey: columns, NoPrimaryKey: true}
+
+	counts := newHashCounts(options.MaxMemory)
+	defer counts.close()
+	sourceRows, err := countRowHashes(ctx, sourceAdapter, sourceDB, sourceSchema.Name, columns, values, counts, 1)
+	if err != nil {
+		return result, inPhase("rows", "source", fmt.Errorf("source: %w", err))
+	}
+	targetRows, err := countRowHashes(ctx, targetAdapter, targetDB, targetSchema.Name, columns, values, counts, -1)
+	if err != nil {
+		return result, inPhase("rows", "target", fmt.Errorf("target: %w", err))
+	}
+
+	// Report the differing rows in hash order, the databases return them in
+	// no particular one
+	type hashCount struct {
+		hash  rowHash
+		count int
+	}
+	var shown []hashCount
+	err = counts.each(func(hash rowHash, count int) {
+		if count > 0 {
+			result.Deleted += count
+		} else {
+			result.Inserted -= count
+		}
+		if len(shown) < maxRowDifferences {
+			shown = append(shown, hashCount{hash, count})
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Compared = (sourceRows + targetRows + result.Deleted + result.Inserted) / 2
+
+	sourceWanted, targetWanted := make(map[rowHash][]string), make(map[rowHash][]string)
+	for _, row := range shown {
+		if row.count > 0 {
+			sourceWanted[row.hash] = nil
+		} else {
+			targetWanted[row.hash] = nil
+		}
+	}
+	if len(sourceWanted) > 0 {
+		if err := findRows(ctx, sourceAdapter, sourceDB, sourceSchema.Name, columns, values, sourceWanted); err != nil {
+			return result, fmt.Errorf("source: %w", err)
+		}
+	}
+	if len(targetWanted) > 0 {
+		if err := findRows(ctx, targetAdapter, targetDB, targetSchema.Name, columns, values, targetWanted); err != nil {
+			return result, fmt.Errorf("target: %w", err)
+		}
+	}
+
+	for _, row := range shown {
+		diff := RowDifference{Kind: RowDeleted, PrimaryKey: sourceWanted[row.hash]}
+		copies := row.count
+		if copies < 0 {
+			diff.Kind, diff.PrimaryKey, copies = RowInserted, targetWanted[row.hash], -copies
+		}
+		if diff.PrimaryKey == nil {
+			continue // changed since it was counted
+		}
+		if copies > 1 {
+			diff.Copies = copies
+		}
+		result.addRow(diff)
+	}
+	return result, nil
+}
@@ -1,10 +1,24 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"math"
+	"os"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	delimiterLine = regexp.MustCompile(`(?im)^\s*delimiter\s+\S+\s*$`)
+	definerClause = regexp.MustCompile(`(?i)\bdefiner\s*=\s*\S+\s+`)
 )
 
 func contains(slice []string, item string) bool {
@@ -16,6 +30,263 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// indexOf returns the position of item in slice, -1 if it isn't in it
+func indexOf(slice []string, item string) int {
+	for i, a := range slice {
+		if a == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// truncate cuts s to at most limit runes, marking the cut with an ellipsis
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// quoteList quotes each identifier and joins them for use in a column list
+func quoteList(names []string, quote func(string) string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// qualifiedName is the name of a table in a schema or database as it's
+// reported, "schema.name". A part with a dot or a double quote in it is
+// double-quoted, with its quotes doubled, so splitQualifiedName can tell
+// where the schema ends.
+func qualifiedName(schema, name string) string {
+	quote := func(part string) string {
+		if strings.ContainsAny(part, `."`) {
+			return `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+		}
+		return part
+	}
+	return quote(schema) + "." + quote(name)
+}
+
+// splitQualifiedName splits a name made by qualifiedName into its schema
+// and name, ok is false when it has no schema
+func splitQualifiedName(qualified string) (schema, name string, ok bool) {
+	unquote := func(part string) string {
+		if len(part) >= 2 && part[0] == '"' && part[len(part)-1] == '"' {
+			return strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+		}
+		return part
+	}
+	end := strings.Index(qualified, ".")
+	if strings.HasPrefix(qualified, `"`) {
+		// The closing quote is the first one not doubled
+		for i := 1; i < len(qualified); i++ {
+			if qualified[i] != '"' {
+				continue
+			}
+			if i+1 < len(qualified) && qualified[i+1] == '"' {
+				i++
+				continue
+			}
+			end = -1
+			if i+1 < len(qualified) && qualified[i+1] == '.' {
+				end = i + 1
+			}
+			break
+		}
+	}
+	if end < 0 {
+		return "", unquote(qualified), false
+	}
+	return unquote(qualified[:end]), unquote(qualified[end+1:]), true
+}
+
+// normalizeDefinition makes SQL definitions comparable regardless of case
+// and formatting: it lowercases them, collapses whitespace, drops it next
+// to punctuation and removes a trailing semicolon. Quoted literals and
+// identifiers are kept as they are.
+func normalizeDefinition(definition string) string {
+	var normalized strings.Builder
+	space := false // whitespace since the last byte written
+	write := func(token string) {
+		if space && normalized.Len() > 0 {
+			last := normalized.String()[normalized.Len()-1]
+			if !isSQLPunctuation(last) && !isSQLPunctuation(token[0]) {
+				normalized.WriteByte(' ')
+			}
+		}
+		space = false
+		normalized.WriteString(token)
+	}
+
+	for start := 0; start < len(definition); {
+		end := start
+		for end < len(definition) && !isSQLQuote(definition[end]) {
+			end++
+		}
+		unquoted := strings.ToLower(definition[start:end])
+		space = space || len(strings.TrimLeftFunc(unquoted, unicode.IsSpace)) < len(unquoted)
+		for i, word := range strings.Fields(unquoted) {
+			space = space || i > 0
+			write(word)
+		}
+		space = space || len(strings.TrimRightFunc(unquoted, unicode.IsSpace)) < len(unquoted)
+		if end == len(definition) {
+			break
+		}
+		start = sqlQuoteEnd(definition, end)
+		write(definition[end:start])
+	}
+
+	return strings.TrimSuffix(normalized.String(), ";")
+}
+
+func isSQLQuote(c byte) bool {
+	return c == '\'' || c == '"' || c == '`'
+}
+
+// sqlQuoteEnd returns the end of the quoted literal or identifier starting
+// at definition[start], after its closing quote. Doubled quotes and, in
+// strings, backslash escapes don't close it. An unclosed quote runs to the
+// end of the definition.
+func sqlQuoteEnd(definition string, start int) int {
+	quote := definition[start]
+	for i := start + 1; i < len(definition); i++ {
+		switch definition[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(definition) && definition[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(definition)
+}
+
+// normalizeRoutineDefinition also ignores comments, client DELIMITER
+// statements with the delimiters they introduce, and the DEFINER, which
+// usually differs between environments
+func normalizeRoutineDefinition(definition string) string {
+	definition = stripSQLComments(definition)
+
+	// The delimiter only ends the definition, the body's statements end
+	// with semicolons
+	if match := delimiterLine.FindString(definition); match != "" {
+		delimiter := strings.Fields(match)[1]
+		definition = delimiterLine.ReplaceAllString(definition, " ")
+		definition = strings.TrimSuffix(strings.TrimSpace(definition), delimiter)
+	}
+	definition = definerClause.ReplaceAllString(definition, "")
+
+	return normalizeDefinition(definition)
+}
+
+// stripSQLComments replaces the -- and /* */ comments of a definition
+// with a space, leaving quoted literals and identifiers that look like
+// comments alone
+func stripSQLComments(definition string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(definition); {
+		switch {
+		case isSQLQuote(definition[i]):
+			end := sqlQuoteEnd(definition, i)
+			stripped.WriteString(definition[i:end])
+			i = end
+		case strings.HasPrefix(definition[i:], "--"):
+			end := strings.IndexByte(definition[i:], '\n')
+			if end < 0 {
+				end = len(definition) - i
+			}
+			stripped.WriteByte(' ')
+			i += end
+		case strings.HasPrefix(definition[i:], "/*"):
+			end := strings.Index(definition[i+2:], "*/")
+			if end < 0 {
+				end = len(definition) - i - 4
+			}
+			stripped.WriteByte(' ')
+			i += end + 4
+		default:
+			stripped.WriteByte(definition[i])
+			i++
+		}
+	}
+	return stripped.String()
+}
+
+func isSQLPunctuation(c byte) bool {
+	return strings.IndexByte("(),;=<>+-*/|", c) >= 0
+}
+
+// sqlLiteral renders a value scanned from any database as a SQL literal of
+// the dialect described by the functions: quoteString quotes text,
+// hexBytes renders binary data that isn't valid UTF-8 and specialFloat
+// renders NaN and the infinities
+func sqlLiteral(value interface{}, quoteString func(string) string, hexBytes func([]byte) string, specialFloat func(float64) string, timeLayout string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return specialFloat(v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return specialFloat(float64(v))
+		}
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case time.Time:
+		return quoteString(v.Format(timeLayout))
+	case []byte:
+		if !utf8.Valid(v) {
+			return hexBytes(v)
+		}
+		return quoteString(string(v))
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+// parseSize parses a size in bytes, optionally followed by KB, MB or GB,
+// e.g. 512MB
+func parseSize(s string) (int64, error) {
+	number, unit := strings.TrimSpace(s), int64(1)
+	for _, suffix := range []struct {
+		name string
+		size int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}} {
+		if strings.HasSuffix(strings.ToUpper(number), suffix.name) {
+			number, unit = strings.TrimSpace(number[:len(number)-len(suffix.name)]), suffix.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected e.g. 512MB", s)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return n * unit, nil
+}
+
 func formatSize(bytes int64) string {
 	const (
 		KB = 1024
@@ -70,28 +341,33 @@ func compareStringSlices(a, b []string) bool {
 	return true
 }
 
-func GetDatabaseInfo(adapter DatabaseAdapter, db *sql.DB, connectionString string) (DatabaseInfo, error) {
+// connectionInfo returns the host and database name of a connection
+// string, without its credentials
+func connectionInfo(connectionString string) DatabaseInfo {
 	info := DatabaseInfo{}
+	connectionString = redactConnStr(connectionString)
 
 	// Extract host and database name from connection string
 	// This is a simplified approach - in practice you'd need proper connection string parsing
-	if strings.Contains(connectionString, "@") {
-		parts := strings.Split(connectionString, "@")
-		if len(parts) > 1 {
-			hostPart := strings.Split(parts[1], "/")
-			info.Host = hostPart[0]
-			if len(hostPart) > 1 {
-				info.DatabaseName = strings.Split(hostPart[1], "?")[0]
-			}
+	if at := strings.LastIndex(connectionString, "@"); at >= 0 {
+		hostPart := strings.Split(connectionString[at+1:], "/")
+		info.Host = hostPart[0]
+		if len(hostPart) > 1 {
+			info.DatabaseName = strings.Split(hostPart[1], "?")[0]
 		}
 	} else {
 		// For SQLite
 		info.Host = "local"
 		info.DatabaseName = connectionString
 	}
+	return info
+}
+
+func GetDatabaseInfo(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, connectionString string, sqliteSizes bool) (DatabaseInfo, error) {
+	info := connectionInfo(connectionString)
 
 	// Get table count
-	tables, err := adapter.GetTableList(db)
+	tables, err := adapter.GetTableList(ctx, db)
 	if err != nil {
 		return info, err
 	}
@@ -99,37 +375,129 @@ func GetDatabaseInfo(adapter DatabaseAdapter, db *sql.DB, connectionString strin
 
 	// Try to estimate database size
 	// This is database specific, so we'll need to handle each type
-	switch adapter.(type) {
+	switch a := adapter.(type) {
 	case *MySQLAdapter:
+		// The databases read aren't necessarily the connection's
+		switch {
+		case a.Database != "":
+			info.DatabaseName = a.Database
+		case a.AllDatabases:
+			info.DatabaseName = "(all databases)"
+		case len(a.Databases) > 0:
+			info.DatabaseName = strings.Join(a.Databases, ", ")
+		}
 		var size int64
-		err := db.QueryRow("SELECT SUM(data_length + index_length) FROM information_schema.tables WHERE table_schema = DATABASE()").Scan(&size)
+		err := db.QueryRowContext(ctx, "SELECT SUM(data_length + index_length) FROM information_schema.tables WHERE "+a.schemaCondition("table_schema")).Scan(&size)
 		if err == nil {
 			info.TotalSize = size
 		}
 	case *PostgreSQLAdapter:
+		if a.Schema != "" {
+			info.DatabaseName += ", schema " + a.Schema
+		}
 		var size int64
-		err := db.QueryRow("SELECT pg_database_size(current_database())").Scan(&size)
+		err := db.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&size)
 		if err == nil {
 			info.TotalSize = size
 		}
+	case *DumpAdapter:
+		info.Host = a.Dialect + " dump"
+		if stat, err := os.Stat(connectionString); err == nil {
+			info.TotalSize = stat.Size()
+		}
 	case *SQLiteAdapter:
 		var size int64
-		err := db.QueryRow("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size)
+		err := db.QueryRowContext(ctx, "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size)
 		if err == nil {
 			info.TotalSize = size
 		}
 	}
 
+	// Like the total size, the table sizes are only informative
+	if sizes, err := tableSizes(ctx, adapter, db, tables, sqliteSizes); err == nil {
+		info.Tables = sizes
+	}
 	return info, nil
 }
 
+// largestTables is the number of the largest tables printed with the
+// database information
+const largestTables = 3
+
+// tableSizes returns the sizes of the tables the database reports, largest
+// first: the data and index lengths and row estimates of MySQL's
+// information_schema.tables, PostgreSQL's relation sizes and reltuples, and,
+// with sqliteSizes, the pages and cells SQLite's dbstat counts, reading every
+// page of the file
+func tableSizes(ctx context.Context, adapter DatabaseAdapter, db *sql.DB, tables []string, sqliteSizes bool) ([]TableInfo, error) {
+	var query string
+	qualify := func(schema, name string) string { return name }
+	switch a := adapter.(type) {
+	case *MySQLAdapter:
+		query = `SELECT table_schema, table_name, COALESCE(data_length, 0), COALESCE(index_length, 0), COALESCE(table_rows, -1)
+			FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND ` + a.schemaCondition("table_schema")
+		qualify = a.qualify
+	case *PostgreSQLAdapter:
+		query = `SELECT n.nspname, c.relname, pg_table_size(c.oid), pg_indexes_size(c.oid), GREATEST(c.reltuples, -1)::bigint
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND ` + a.schemaCondition("n.nspname")
+		qualify = a.qualify
+	case *SQLiteAdapter:
+		if !sqliteSizes {
+			return nil, nil
+		}
+		query = `SELECT 'main', m.tbl_name,
+				SUM(CASE WHEN m.type = 'table' THEN s.pgsize ELSE 0 END),
+				SUM(CASE WHEN m.type = 'index' THEN s.pgsize ELSE 0 END),
+				SUM(CASE WHEN m.type = 'table' AND s.pagetype = 'leaf' THEN s.ncell ELSE 0 END)
+			FROM dbstat s JOIN sqlite_master m ON m.name = s.name
+			GROUP BY m.tbl_name`
+	default:
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	listed := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		listed[table] = true
+	}
+	sizes := []TableInfo{}
+	for rows.Next() {
+		var schema, name string
+		var table TableInfo
+		if err := rows.Scan(&schema, &name, &table.DataSize, &table.IndexSize, &table.EstimatedRows); err != nil {
+			return nil, err
+		}
+		if table.Name = qualify(schema, name); listed[table.Name] {
+			sizes = append(sizes, table)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if a, b := sizes[i].DataSize+sizes[i].IndexSize, sizes[j].DataSize+sizes[j].IndexSize; a != b {
+			return a > b
+		}
+		return sizes[i].Name < sizes[j].Name
+	})
+	return sizes, rows.Err()
+}
+
 func compareValues(v1, v2 interface{}) bool {
 	// Special case for []byte (typically strings in SQL)
 	if b1, ok1 := v1.([]byte); ok1 {
 		if b2, ok2 := v2.([]byte); ok2 {
 			return string(b1) == string(b2)
 		}
-		return false
+	}
+
+	// Drivers scan the same value into different types, e.g. MySQL returns
+	// numbers as []byte unless the query has arguments
+	if v1 != nil && v2 != nil && reflect.TypeOf(v1) != reflect.TypeOf(v2) {
+		return formatValue(v1) == formatValue(v2)
 	}
 
 	return reflect.DeepEqual(v1, v2)
@@ -147,21 +515,27 @@ func formatValue(v interface{}) string {
 	return fmt.Sprintf("%v", v)
 }
 
-// Helper function to get ORDER BY clause for PostgreSQL MD5 hash
-func getOrderByClause(schema TableSchema) string {
+// getOrderByClause returns the quoted primary key columns of a table, or
+// all its columns if it has no primary key
+func getOrderByClause(schema TableSchema, quote func(string) string) string {
 	if len(schema.PrimaryKeys) > 0 {
-		// Use primary keys if available
-		orderCols := make([]string, len(schema.PrimaryKeys))
-		for i, col := range schema.PrimaryKeys {
-			orderCols[i] = "\"" + col + "\""
-		}
-		return strings.Join(orderCols, ", ")
-	} else {
-		// Fallback to all columns
-		orderCols := make([]string, len(schema.Columns))
-		for i, col := range schema.Columns {
-			orderCols[i] = "\"" + col.Name + "\""
-		}
-		return strings.Join(orderCols, ", ")
+		return quoteList(schema.PrimaryKeys, quote)
+	}
+	columns := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		columns[i] = col.Name
+	}
+	return quoteList(columns, quote)
+}
+
+// validateIdentifier rejects names read from a database that can't be
+// quoted into a query: empty ones and those with a NUL character
+func validateIdentifier(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("name %q contains a NUL character", name)
 	}
+	return nil
 }
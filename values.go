@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	StringCompareBinary    = "binary"
+	StringCompareCollation = "collation"
+)
+
+// unicodeForms are the Unicode normalization forms of --unicode-normalize
+var unicodeForms = map[string]norm.Form{"nfc": norm.NFC, "nfkc": norm.NFKC}
+
+// timestampLayouts are the text forms of date and time values the drivers
+// return, e.g. MySQL without parseTime. Fractional seconds are optional.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// isTimestampType reports whether a column type holds dates or timestamps
+func isTimestampType(dataType string) bool {
+	t := strings.ToLower(dataType)
+	return strings.Contains(t, "timestamp") || strings.Contains(t, "datetime") || t == "date"
+}
+
+// isDecimalType reports whether a column type holds exact decimal numbers
+func isDecimalType(dataType string) bool {
+	t := strings.ToLower(dataType)
+	return strings.Contains(t, "decimal") || strings.Contains(t, "numeric")
+}
+
+// typeName is the name a column type starts with, in lower case and without
+// its size or modifiers: int for "INT(11) UNSIGNED", character for
+// "character varying(20)"
+func typeName(dataType string) string {
+	t, _, _ := strings.Cut(strings.ToLower(dataType), "(")
+	name, _, _ := strings.Cut(strings.TrimSpace(t), " ")
+	return name
+}
+
+// isNumericType reports whether a column type holds numbers, integers,
+// decimals or floats, which sort numerically
+func isNumericType(dataType string) bool {
+	switch typeName(dataType) {
+	case "int", "integer", "tinyint", "smallint", "mediumint", "bigint", "int2", "int4", "int8",
+		"serial", "smallserial", "bigserial", "real", "float", "float4", "float8", "double",
+		"decimal", "numeric", "number":
+		return true
+	}
+	return false
+}
+
+// isIntegerType reports whether a column type holds integers
+func isIntegerType(dataType string) bool {
+	switch typeName(dataType) {
+	case "int", "integer", "tinyint", "smallint", "mediumint", "bigint", "int2", "int4", "int8",
+		"serial", "smallserial", "bigserial":
+		return true
+	}
+	return false
+}
+
+// parseDecimal reads a decimal value, which drivers return as text or,
+// for SQLite, as a float or integer
+func parseDecimal(v interface{}) (*big.Rat, bool) {
+	var text string
+	switch v := v.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case float64:
+		// The shortest text that reads back as the float, 1.1 rather than
+		// its binary approximation
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return nil, false
+	}
+	return new(big.Rat).SetString(strings.TrimSpace(text))
+}
+
+// isTextType reports whether a column type holds character strings
+func isTextType(dataType string) bool {
+	t := strings.ToLower(dataType)
+	return strings.Contains(t, "char") || strings.Contains(t, "text") || strings.Contains(t, "clob")
+}
+
+// isCharType reports whether a column type is a fixed-length, blank-padded
+// CHAR(n) rather than a VARCHAR
+func isCharType(dataType string) bool {
+	t, _, _ := strings.Cut(strings.ToLower(dataType), "(")
+	switch strings.TrimSpace(t) {
+	case "char", "character", "nchar", "bpchar":
+		return true
+	}
+	return false
+}
+
+// isCaseInsensitive reports whether a collation compares strings ignoring
+// case, like MySQL's utf8mb4_0900_ai_ci or SQLite's NOCASE
+func isCaseInsensitive(collation string) bool {
+	c := strings.ToLower(collation)
+	return strings.HasSuffix(c, "_ci") || c == "nocase"
+}
+
+// key returns a key value as the databases order it, see
+// configureKeyOrders: CHAR(n) values without their padding, which the
+// engines don't all return
+func (c columnValues) key(v interface{}) interface{} {
+	if !c.trimPadding {
+		return v
+	}
+	var text string
+	switch v := v.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return v
+	}
+	return strings.TrimRight(text, " ")
+}
+
+// parseTimestamp reads a date or timestamp value. Text without a time zone
+// is taken as UTC.
+func parseTimestamp(v interface{}) (time.Time, bool) {
+	var text string
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return time.Time{}, false
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// columnValues normalizes and compares the values of a column in row diffs,
+// by the column's type on both sides
+type columnValues struct {
+	// Dates and timestamps are compared as instants in UTC, equal within
+	// timestampTolerance
+	timestamp          bool
+	timestampTolerance time.Duration
+
+	// Decimals are compared as numbers, so 1.50 equals 1.5, rounded to
+	// decimalScale places if it's positive
+	decimal      bool
+	decimalScale int
+
+	// Numbers, decimals included, sort numerically as keys
+	numeric bool
+
+	// Spatial values are decoded from the engines' binary forms and
+	// compared point by point, equal within geometryTolerance
+	geometry          bool
+	geometryTolerance float64
+
+	// With --string-compare collation, strings of a case-insensitive
+	// collation on either side compare equal ignoring case. Keys are
+	// matched by their bytes all the same.
+	ignoreCase bool
+
+	// Strings are brought to unicodeForm if normalizeUnicode is set, so
+	// composed and decomposed accents compare equal
+	normalizeUnicode bool
+	unicodeForm      norm.Form
+
+	// Trailing whitespace is dropped with trimWhitespace, trailing spaces,
+	// CHAR(n) padding, with trimPadding, which also applies to keys
+	trimWhitespace bool
+	trimPadding    bool
+}
+
+// valueColumns returns how the values of each compared column are compared
+func valueColumns(columns []string, sourceSchema, targetSchema TableSchema, options CompareOptions) []columnValues {
+	byName := func(schema TableSchema) map[string]ColumnSchema {
+		m := make(map[string]ColumnSchema, len(schema.Columns))
+		for _, col := range schema.Columns {
+			m[col.Name] = col
+		}
+		return m
+	}
+	sourceColumns, targetColumns := byName(sourceSchema), byName(targetSchema)
+
+	values := make([]columnValues, len(columns))
+	for i, name := range columns {
+		source, target := sourceColumns[name], targetColumns[name]
+		switch {
+		case isTimestampType(source.DataType) || isTimestampType(target.DataType):
+			values[i] = columnValues{timestamp: true, timestampTolerance: options.TimestampTolerance}
+		case isDecimalType(source.DataType) || isDecimalType(target.DataType):
+			values[i] = columnValues{decimal: true, decimalScale: options.DecimalScale, numeric: true}
+		case isNumericType(source.DataType) || isNumericType(target.DataType):
+			values[i] = columnValues{numeric: true}
+		case isGeometryType(source.DataType) || isGeometryType(target.DataType):
+			values[i] = columnValues{geometry: true, geometryTolerance: options.GeometryTolerance}
+		case isTextType(source.DataType) || isTextType(target.DataType) ||
+			isCaseInsensitive(source.Collation) || isCaseInsensitive(target.Collation):
+			ci := isCaseInsensitive(source.Collation) || isCaseInsensitive(target.Collation)
+			form, ok := unicodeForms[options.UnicodeNormalize]
+			values[i] = columnValues{ignoreCase: ci && options.StringCompare == StringCompareCollation,
+				normalizeUnicode: ok, unicodeForm: form, trimWhitespace: options.TrimTrailingWhitespace,
+				trimPadding: options.IgnoreCharPadding && (isCharType(source.DataType) || isCharType(target.DataType))}
+		}
+	}
+	return values
+}
+
+// normalize returns the form of a value that compares equal to the same
+// value read from the other database, e.g. a timestamp in UTC or a decimal
+// as the fraction it's equal to
+func (c columnValues) normalize(v interface{}) interface{} {
+	switch {
+	case c.timestamp:
+		if t, ok := parseTimestamp(v); ok {
+			return t.UTC()
+		}
+	case c.decimal:
+		if r, ok := parseDecimal(v); ok {
+			if c.decimalScale > 0 {
+				r.SetString(r.FloatString(c.decimalScale))
+			}
+			return r.RatString()
+		}
+	case c.geometry:
+		if g, ok := parseGeometry(v); ok {
+			return g.wkt()
+		}
+	case c.normalizeUnicode || c.ignoreCase || c.trimWhitespace || c.trimPadding:
+		var text string
+		switch v := v.(type) {
+		case []byte:
+			text = string(v)
+		case string:
+			text = v
+		default:
+			return v
+		}
+		if c.trimWhitespace {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		} else if c.trimPadding {
+			text = strings.TrimRight(text, " ")
+		}
+		if c.normalizeUnicode {
+			text = c.unicodeForm.String(text)
+		}
+		if c.ignoreCase {
+			text = strings.ToUpper(text)
+		}
+		return text
+	}
+	return v
+}
+
+// format renders a value for reports, spatial values as WKT
+func (c columnValues) format(v interface{}) string {
+	if c.geometry {
+		if g, ok := parseGeometry(v); ok {
+			if g.srid != 0 {
+				return fmt.Sprintf("SRID=%d;%s", g.srid, g.wkt())
+			}
+			return g.wkt()
+		}
+	}
+	return formatValue(v)
+}
+
+func (c columnValues) equal(source, target interface{}) bool {
+	if c.geometry {
+		a, okA := parseGeometry(source)
+		b, okB := parseGeometry(target)
+		if okA && okB {
+			return equalGeometries(a, b, c.geometryTolerance)
+		}
+	}
+	source, target = c.normalize(source), c.normalize(target)
+	if a, ok := source.(time.Time); ok {
+		if b, ok := target.(time.Time); ok {
+			d := a.Sub(b)
+			return d <= c.timestampTolerance && -d <= c.timestampTolerance
+		}
+	}
+	return compareValues(source, target)
+}
+
+// textNormalized reports whether row diffs compare strings other than byte by
+// byte, which checksums can't
+func (o CompareOptions) textNormalized() bool {
+	return o.StringCompare == StringCompareCollation || o.UnicodeNormalize != "" || o.TrimTrailingWhitespace || o.IgnoreCharPadding
+}
+
+// normalizeRow returns a row's values normalized for hashing
+func normalizeRow(values []interface{}, columns []columnValues) []interface{} {
+	normalized := make([]interface{}, len(values))
+	for i, v := range values {
+		normalized[i] = columns[i].normalize(v)
+	}
+	return normalized
+}
+
+// configureKeyOrders makes the adapters order the text keys of the tables
+// by their bytes, the order the row diff and the chunk boundaries compare
+// them in, rather than by collations, which differ between databases and
+// from the bytes'. The same key read from both sides sorts alike then.
+func (c *Comparison) configureKeyOrders(summary *ComparisonSummary) {
+	for _, tableName := range summary.CommonTables {
+		for _, schema := range []struct {
+			adapter DatabaseAdapter
+			table   TableSchema
+		}{{c.Adapter, summary.SourceSchemas[tableName]}, {c.targetAdapter(), summary.TargetSchemas[tableName]}} {
+			for _, col := range schema.table.Columns {
+				if contains(schema.table.PrimaryKeys, col.Name) {
+					schema.adapter.SetBinaryKeyOrder(tableName, col)
+				}
+			}
+		}
+	}
+}
+
+// configureDigests makes the adapters read the values of large text and
+// binary columns over DigestThreshold bytes as digests, where both sides
+// can. Row diffs then compare and report those by size and digest rather
+// than holding them in memory. Reconciliation needs the whole values.
+func (c *Comparison) configureDigests(summary *ComparisonSummary) {
+	if c.Options.DigestThreshold <= 0 || c.Options.Reconcile {
+		return
+	}
+	for _, tableName := range summary.CommonTables {
+		sourceSchema, targetSchema := summary.SourceSchemas[tableName], summary.TargetSchemas[tableName]
+		for _, sourceColumn := range sourceSchema.Columns {
+			i := slices.IndexFunc(targetSchema.Columns, func(col ColumnSchema) bool { return col.Name == sourceColumn.Name })
+			if i < 0 || contains(sourceSchema.PrimaryKeys, sourceColumn.Name) {
+				continue
+			}
+			source := c.Adapter.ValueDigest(sourceColumn, c.Options.DigestThreshold)
+			target := c.targetAdapter().ValueDigest(targetSchema.Columns[i], c.Options.DigestThreshold)
+			// One adapter reading both sides can only read a column one way
+			if source == "" || target == "" || (c.TargetAdapter == nil && source != target) {
+				continue
+			}
+			c.Adapter.SetColumnExpression(tableName, sourceColumn.Name, source)
+			c.targetAdapter().SetColumnExpression(tableName, sourceColumn.Name, target)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The options --verify-replica turns on, unless given
+const (
+	replicaChunkSize    = 10000
+	replicaWait         = 5 * time.Minute
+	replicaRecheckDelay = 30 * time.Second
+)
+
+// presetVerifyReplica turns on the options of --verify-replica that weren't
+// given on the command line
+func presetVerifyReplica(chunkSize *int, waitForReplica, recheckDelay *time.Duration) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["chunk-size"] {
+		*chunkSize = replicaChunkSize
+	}
+	if !set["wait-for-replica"] {
+		*waitForReplica = replicaWait
+	}
+	if !set["recheck-delay"] {
+		*recheckDelay = replicaRecheckDelay
+	}
+}
+
+// detectReplica returns the host the target replicates from, failing when
+// it isn't a replica
+func detectReplica(ctx context.Context, c *Comparison) (string, error) {
+	if c.crossEngine() || c.TargetDB == c.SourceDB {
+		return "", errors.New("the target must be a separate database of the same type as the source")
+	}
+	capabilities := c.targetAdapter().Capabilities()
+	if !capabilities.Replication {
+		return "", fmt.Errorf("replication isn't supported on %s", capabilities.Engine)
+	}
+	source, err := c.targetAdapter().ReplicaSource(ctx, c.TargetDB)
+	if err != nil {
+		return "", err
+	}
+	if source == "" {
+		return "", errors.New("the target isn't a replica")
+	}
+	return source, nil
+}
+
+// dataDifferentTables returns the tables whose data differs
+func (s ComparisonSummary) dataDifferentTables() []string {
+	set := make(map[string]bool)
+	for table := range s.DifferentRowCounts {
+		set[table] = true
+	}
+	for table := range s.ChunkDifferences {
+		set[table] = true
+	}
+	for table := range s.RowDifferences {
+		set[table] = true
+	}
+	for table := range s.StatDifferences {
+		set[table] = true
+	}
+	tables := make([]string, 0, len(set))
+	for table := range set {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Recheck compares the data of the tables whose data differed again after
+// delay, having waited for the replica with WaitForReplica, and keeps only
+// the differences that persist: those of changes the replica hadn't
+// applied yet go away. It returns the tables that no longer differ.
+func (c *Comparison) Recheck(ctx context.Context, summary *ComparisonSummary, delay time.Duration) []string {
+	tables := summary.dataDifferentTables()
+	if len(tables) == 0 || summary.Interrupted {
+		return nil
+	}
+	c.emit(Event{Type: EventPhase, Message: fmt.Sprintf("Rechecking %d tables in %s...", len(tables), delay)})
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(delay):
+	}
+	c.replicationPositions(ctx)
+
+	// The checks and the checkpoint are of the whole comparison, and the
+	// differing rows were already written
+	recheck := *c
+	recheck.Options.Checks = nil
+	recheck.Checkpoint = nil
+	recheck.DiffRows = nil
+	again := ComparisonSummary{
+		SourceSchemas:      summary.SourceSchemas,
+		TargetSchemas:      summary.TargetSchemas,
+		CommonTables:       tables,
+		DifferentRowCounts: make(map[string]struct{ Source, Target int }),
+		RowDifferences:     make(map[string]RowDiffResult),
+		ChunkDifferences:   make(map[string]ChunkResult),
+		StatDifferences:    make(map[string][]Difference),
+		SkippedTables:      make(map[string]string),
+	}
+	recheck.CompareData(ctx, &again)
+	if again.Interrupted {
+		summary.Interrupted = true
+		return nil
+	}
+
+	var resolved []string
+	for _, table := range tables {
+		delete(summary.DifferentRowCounts, table)
+		delete(summary.ChunkDifferences, table)
+		delete(summary.RowDifferences, table)
+		delete(summary.StatDifferences, table)
+		if counts, ok := again.DifferentRowCounts[table]; ok {
+			summary.DifferentRowCounts[table] = counts
+		}
+		if result, ok := again.ChunkDifferences[table]; ok {
+			summary.ChunkDifferences[table] = result
+		}
+		if result, ok := again.RowDifferences[table]; ok {
+			summary.RowDifferences[table] = result
+		}
+		if diffs, ok := again.StatDifferences[table]; ok {
+			summary.StatDifferences[table] = diffs
+		}
+		if reason, ok := again.SkippedTables[table]; ok {
+			summary.SkippedTables[table] = reason
+		}
+		if !contains(again.DifferentTables, table) && len(summary.SchemaDifferences[table]) == 0 {
+			summary.DifferentTables = slices.DeleteFunc(summary.DifferentTables, func(t string) bool { return t == table })
+			resolved = append(resolved, table)
+		}
+	}
+	summary.Errors = append(summary.Errors, again.Errors...)
+	if summary.Reconciliation != nil {
+		summary.Reconciliation = again.Reconciliation
+	}
+	return resolved
+}
+
+// printReplicaVerdict prints the tables whose differences were replication
+// lag and the verdict, and reports whether the replica passed
+func printReplicaVerdict(summary ComparisonSummary, replicaSource string, resolved []string) bool {
+	fmt.Println("\n=== Replica Verification ===")
+	fmt.Printf("Target replicates from %s\n", replicaSource)
+	if len(resolved) > 0 {
+		fmt.Printf("Differed only until the replica caught up: %s\n", strings.Join(resolved, ", "))
+	}
+	persistent := summary.dataDifferentTables()
+	if len(persistent) > 0 {
+		fmt.Println(colored(colorRed, "Data still differs after the recheck: "+strings.Join(persistent, ", ")))
+	}
+	if summary.HasSchemaDifferences() {
+		fmt.Println(colored(colorRed, "The schemas differ"))
+	}
+	if len(summary.SkippedTables) > 0 {
+		fmt.Println(colored(colorRed, fmt.Sprintf("%d tables were skipped", len(summary.SkippedTables))))
+	}
+	if summary.Interrupted {
+		fmt.Println(colored(colorRed, "Interrupted, not all tables were compared"))
+	}
+
+	passed := len(persistent) == 0 && !summary.HasSchemaDifferences() && len(summary.SkippedTables) == 0 && !summary.Interrupted
+	if passed {
+		fmt.Println(colored(colorGreen, "\nVerdict: PASS, the replica matches the source"))
+	} else {
+		fmt.Println(colored(colorRed, "\nVerdict: FAIL, the replica doesn't match the source"))
+	}
+	return passed
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// restoreChunkSize is the --chunk-size of --verify-restore, unless given
+const restoreChunkSize = 10000
+
+// restoreCheck is one check of a restore verification with the
+// differences that fail it
+type restoreCheck struct {
+	name     string
+	failures []string
+}
+
+// presetVerifyRestore turns on the checks of --verify-restore whose
+// options weren't given on the command line
+func presetVerifyRestore(chunkSize *int, sequenceValues *bool) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["chunk-size"] {
+		*chunkSize = restoreChunkSize
+	}
+	if !set["sequence-values"] {
+		*sequenceValues = true
+	}
+}
+
+// restoreChecks sorts the differences of a summary into the checks of a
+// restore: schema, row counts, checksums and sequences. Skipped tables fail
+// the checksums, their data wasn't verified.
+func restoreChecks(summary ComparisonSummary) []restoreCheck {
+	schema := restoreCheck{name: "schema"}
+	rowCounts := restoreCheck{name: "row counts"}
+	checksums := restoreCheck{name: "checksums"}
+	sequences := restoreCheck{name: "sequences and auto-increment counters"}
+
+	for _, diff := range summary.Differences() {
+		switch {
+		case diff.ObjectType == "sequence":
+			sequences.failures = append(sequences.failures, diff.Message)
+		case diff.Property == "row count":
+			rowCounts.failures = append(rowCounts.failures, diff.Message)
+		case diff.ObjectType == "data":
+			checksums.failures = append(checksums.failures, diff.Message)
+		default:
+			schema.failures = append(schema.failures, diff.Message)
+		}
+	}
+	var skipped []string
+	for table, reason := range summary.SkippedTables {
+		skipped = append(skipped, fmt.Sprintf("Table '%s' was skipped (%s)", table, reason))
+	}
+	sort.Strings(skipped)
+	checksums.failures = append(checksums.failures, skipped...)
+	return []restoreCheck{schema, rowCounts, checksums, sequences}
+}
+
+// printVerdict prints the outcome of each check of a restore and the
+// verdict, and reports whether the restore passed. An interrupted
+// comparison can't pass.
+func printVerdict(summary ComparisonSummary) bool {
+	fmt.Println("\n=== Restore Verification ===")
+	passed := !summary.Interrupted
+	for _, check := range restoreChecks(summary) {
+		if len(check.failures) == 0 {
+			fmt.Println(colored(colorGreen, "PASS "+check.name))
+			continue
+		}
+		passed = false
+		fmt.Println(colored(colorRed, "FAIL "+check.name+" ("+strconv.Itoa(len(check.failures))+")"))
+		for _, failure := range check.failures {
+			fmt.Println("  - " + failure)
+		}
+	}
+	if summary.Interrupted {
+		fmt.Println(colored(colorRed, "FAIL interrupted, not all tables were compared"))
+	}
+
+	if passed {
+		fmt.Println(colored(colorGreen, "\nVerdict: PASS, the restore matches the source"))
+	} else {
+		fmt.Println(colored(colorRed, "\nVerdict: FAIL, the restore doesn't match the source"))
+	}
+	return passed
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// The version, commit and build date of the binary, set when building a
+// release with
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, the version and commit are read from what the Go toolchain
+// records of the module and the checkout it was built from, with the
+// commit's time instead of the build date.
+var version, commit, buildDate string
+
+// versionString describes the binary, e.g.
+// "mudrockdbcompare v1.4.0 (commit 1a2b3c4d5e6f, built 2026-10-16T08:00:00Z)"
+func versionString() string {
+	v, c := version, commit
+	committed := ""
+	modified := false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				committed = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true" && commit == ""
+			}
+		}
+	}
+
+	var details []string
+	if c != "" {
+		c = c[:min(len(c), 12)]
+		if modified {
+			c += "-dirty"
+		}
+		details = append(details, "commit "+c)
+	}
+	switch {
+	case buildDate != "":
+		details = append(details, "built "+buildDate)
+	case committed != "" && commit == "":
+		details = append(details, "committed "+committed)
+	}
+	s := "mudrockdbcompare " + cmp.Or(v, "(devel)")
+	if len(details) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(details, ", "))
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// watchStateVersion is the version of the results in watch state files.
+// It changes with what summaryResult hashes, states of another version
+// have a result that isn't comparable. Version 1 files have no version.
+const watchStateVersion = 2
+
+// watchState is what watch mode remembers between runs. It's kept in a
+// file, so a restarted watch doesn't report the same result again.
+type watchState struct {
+	Version int
+	Result  string    // hash of the differences found
+	Checked time.Time // when the last run finished
+	Changed time.Time // when the result last changed
+}
+
+func readWatchState(path string) (watchState, error) {
+	var state watchState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%s: %w", path, err)
+	}
+	return state, nil
+}
+
+func writeWatchState(path string, state watchState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// summaryResult hashes the differences in a summary, independent of the
+// order they were found in
+func summaryResult(summary ComparisonSummary) (string, error) {
+	var lines []string
+	for _, diff := range summary.Differences() {
+		lines = append(lines, diff.Message)
+	}
+	for table, reason := range summary.SkippedTables {
+		lines = append(lines, fmt.Sprintf("%s: skipped (%s)", table, reason))
+	}
+	sort.Strings(lines)
+	return fingerprint(lines)
+}
+
+// logEvent logs only the warnings of a run, for modes that report the
+// differences once the comparison is done
+func logEvent(event Event) {
+	switch event.Type {
+	case EventWarning:
+		slog.Warn(event.Message, "error", event.Err)
+	case EventPhase:
+		slog.Debug(event.Message)
+	}
+}
+
+// watch compares the databases every interval until ctx is cancelled and
+// prints the summary whenever the result differs from the previous run's,
+// as recorded in stateFile. Failed runs are logged and retried at the next
+// interval. With a window, the data is only compared while it's open, see
+// compareInWindow.
+func watch(ctx context.Context, c *Comparison, interval time.Duration, stateFile string, window *timeWindow) error {
+	c.OnEvent = logEvent
+	state, err := readWatchState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	for {
+		slog.Info("Comparing databases")
+		summary, err := c.compareInWindow(ctx, window, slog.Default())
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			slog.Error("Comparison failed, retrying at the next interval", "error", err)
+		} else {
+			result, err := summaryResult(summary)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			if state.Version != watchStateVersion && state.Result != "" {
+				// The result was hashed differently, it's taken as unchanged
+				slog.Info("Watch state is from another version, comparing results from this run on", "file", stateFile)
+				state.Result = result
+			}
+			state.Version = watchStateVersion
+			if result != state.Result {
+				fmt.Printf("\n=== Result changed at %s ===\n", now.Format(time.RFC3339))
+				printSummary(summary)
+				state.Result = result
+				state.Changed = now
+			} else {
+				slog.Info("No change", "since", state.Changed.Format(time.RFC3339))
+			}
+			state.Checked = now
+			if err := writeWatchState(stateFile, state); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
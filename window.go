@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily window of local time, such as 01:00-05:00, which
+// may span midnight, such as 22:00-04:00
+type timeWindow struct {
+	start, end int // minutes since midnight
+}
+
+// parseTimeWindow parses a window given as HH:MM-HH:MM
+func parseTimeWindow(s string) (*timeWindow, error) {
+	startText, endText, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", s)
+	}
+	var w timeWindow
+	for _, bound := range []struct {
+		text    string
+		minutes *int
+	}{{startText, &w.start}, {endText, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", s)
+		}
+		*bound.minutes = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid window %q: it starts when it ends", s)
+	}
+	return &w, nil
+}
+
+func (w *timeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// at returns the time of day minutes after midnight of t's day, or of the
+// days after
+func at(t time.Time, minutes, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, minutes/60, minutes%60, 0, 0, t.Location())
+}
+
+// contains reports whether the window is open at t
+func (w *timeWindow) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minutes >= w.start && minutes < w.end
+	}
+	return minutes >= w.start || minutes < w.end
+}
+
+// opens returns when the window next opens after t, or t if it's open
+func (w *timeWindow) opens(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	if start := at(t, w.start, 0); start.After(t) {
+		return start
+	}
+	return at(t, w.start, 1)
+}
+
+// closes returns when the window open at t closes
+func (w *timeWindow) closes(t time.Time) time.Time {
+	if end := at(t, w.end, 0); end.After(t) {
+		return end
+	}
+	return at(t, w.end, 1)
+}
+
+// compareInWindow compares the databases like Introspect and CompareData,
+// but with a window, compares the data only while it's open. The schemas
+// are compared right away; outside the window, the data comparison waits
+// for it to open and pauses when it closes, to resume in the next one with
+// the tables already compared kept in a checkpoint. The schemas are compared
+// again before each part, in case they changed meanwhile. A table compared
+// when the window closes resumes in the next one after its chunks already
+// compared. The waits and pauses are logged with logger.
+func (c *Comparison) compareInWindow(ctx context.Context, window *timeWindow, logger *slog.Logger) (ComparisonSummary, error) {
+	if window == nil {
+		summary, err := c.Introspect(ctx)
+		if err == nil {
+			c.CompareData(ctx, &summary)
+		}
+		return summary, err
+	}
+
+	checkpoint, err := openCheckpoint("", c, false)
+	if err != nil {
+		return ComparisonSummary{}, err
+	}
+	c.Checkpoint = checkpoint
+	defer func() { c.Checkpoint = nil }()
+
+	for {
+		summary, err := c.Introspect(ctx)
+		if err != nil {
+			return summary, err
+		}
+		now := time.Now()
+		if opens := window.opens(now); opens.After(now) {
+			c.endSnapshots()
+			logger.Info("Waiting for the window to compare the data", "window", window.String(), "opens", opens.Format(time.RFC3339))
+			if err := sleepContext(ctx, opens.Sub(now)); err != nil {
+				summary.Interrupted = true
+				return summary, nil
+			}
+			continue
+		}
+
+		windowCtx, cancel := context.WithDeadline(ctx, window.closes(now))
+		c.CompareData(windowCtx, &summary)
+		cancel()
+		if !summary.Interrupted || ctx.Err() != nil {
+			return summary, nil
+		}
+		logger.Info("The window closed, pausing the data comparison", "window", window.String(),
+			"tables_compared", len(checkpoint.Tables), "tables", len(summary.CommonTables))
+	}
+}
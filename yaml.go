@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// yamlEntry is an entry of a YAML list of flat mappings
+type yamlEntry struct {
+	Fields map[string]string
+	Keys   []string       // the keys in the order they appear
+	Lines  map[string]int // the line of each key
+}
+
+// readYAMLList reads a file holding a list of flat mappings, optionally
+// under the top-level key given. It supports the part of YAML such files
+// need: "- " entries with "key: value" lines, quoted values and comments.
+func readYAMLList(filename, key string) ([]yamlEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []yamlEntry{}
+	var current *yamlEntry
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(stripYAMLComment(scanner.Text()))
+		if line == "" || line == key+":" || line == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			entries = append(entries, yamlEntry{Fields: map[string]string{}, Lines: map[string]int{}})
+			current = &entries[len(entries)-1]
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+			if line == "" {
+				continue
+			}
+		}
+		if current == nil {
+			return nil, fmt.Errorf("%s:%d: expected a list entry starting with '-'", filename, lineNumber)
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected 'key: value'", filename, lineNumber)
+		}
+		value, err := unquoteYAML(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, lineNumber, err)
+		}
+		name = strings.TrimSpace(name)
+		if _, exists := current.Fields[name]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate key '%s'", filename, lineNumber, name)
+		}
+		current.Fields[name] = value
+		current.Keys = append(current.Keys, name)
+		current.Lines[name] = lineNumber
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// stripYAMLComment removes a '#' comment that isn't inside quotes
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	default:
+		return value, nil
+	}
+}